@@ -1,6 +1,8 @@
 package error
 
 import (
+	"time"
+
 	"github.com/werdna521/userland/api/error/client"
 	"github.com/werdna521/userland/api/error/internal"
 )
@@ -52,6 +54,20 @@ func NewUnprocessableEntityError(fields map[string]string) client.UnprocessableE
 	}
 }
 
+func NewTooManyRequestsError(msg string, retryAfter time.Duration) client.TooManyRequestsError {
+	return client.TooManyRequestsError{
+		Msg:        msg,
+		RetryAfter: retryAfter,
+	}
+}
+
+func NewOAuthError(code string, msg string) client.OAuthError {
+	return client.OAuthError{
+		Code: code,
+		Msg:  msg,
+	}
+}
+
 func NewInternalServerError() internal.InternalServerError {
 	return internal.InternalServerError{}
 }
@@ -0,0 +1,21 @@
+package client
+
+import "net/http"
+
+// OAuthError is an error of the OAuth 2.0 endpoints. Code is one of the error
+// codes defined in RFC 6749, e.g. invalid_request or invalid_grant.
+type OAuthError struct {
+	Code string
+	Msg  string
+}
+
+func (e OAuthError) Error() string {
+	return e.Msg
+}
+
+func (e OAuthError) StatusCode() int {
+	if e.Code == "invalid_client" {
+		return http.StatusUnauthorized
+	}
+	return http.StatusBadRequest
+}
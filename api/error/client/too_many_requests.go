@@ -0,0 +1,19 @@
+package client
+
+import (
+	"net/http"
+	"time"
+)
+
+type TooManyRequestsError struct {
+	Msg        string
+	RetryAfter time.Duration
+}
+
+func (e TooManyRequestsError) Error() string {
+	return e.Msg
+}
+
+func (e TooManyRequestsError) StatusCode() int {
+	return http.StatusTooManyRequests
+}
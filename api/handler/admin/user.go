@@ -0,0 +1,205 @@
+package admin
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/service"
+)
+
+var userStatuses = map[string]bool{
+	repository.UserStatusActive:      true,
+	repository.UserStatusUnverified:  true,
+	repository.UserStatusDeactivated: true,
+	repository.UserStatusDeleted:     true,
+}
+
+type user struct {
+	ID            string     `json:"id"`
+	Email         string     `json:"email"`
+	Fullname      string     `json:"fullname"`
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+}
+
+func toUser(u *repository.User) *user {
+	res := &user{
+		ID:        u.ID,
+		Email:     u.Email,
+		Status:    u.Status(),
+		CreatedAt: u.CreatedAt,
+	}
+	if u.UserBio != nil {
+		res.Fullname = u.UserBio.Fullname
+	}
+	if u.DeactivatedAt.Valid {
+		res.DeactivatedAt = &u.DeactivatedAt.Time
+	}
+	if u.DeletedAt.Valid {
+		res.DeletedAt = &u.DeletedAt.Time
+	}
+
+	return res
+}
+
+type listUsersRequest struct {
+	Query  string
+	Status string
+	Cursor string
+	Limit  string
+}
+
+func toListUsersRequest(params url.Values) *listUsersRequest {
+	return &listUsersRequest{
+		Query:  params.Get("q"),
+		Status: params.Get("status"),
+		Cursor: params.Get("cursor"),
+		Limit:  params.Get("limit"),
+	}
+}
+
+func toUserFilter(req *listUsersRequest) (*repository.UserFilter, map[string]string) {
+	fields := map[string]string{}
+
+	limit, ok := request.ParseLimit(req.Limit)
+	if !ok {
+		fields["limit"] = "limit must be a number between 1 and 100"
+	}
+
+	if req.Status != "" && !userStatuses[req.Status] {
+		fields["status"] = "status must be one of active, unverified, deactivated and deleted"
+	}
+
+	if len(fields) > 0 {
+		return nil, fields
+	}
+
+	return &repository.UserFilter{
+		Query:  req.Query,
+		Status: req.Status,
+		Before: req.Cursor,
+		Limit:  limit,
+	}, nil
+}
+
+type listUsersResponse struct {
+	Success bool    `json:"success"`
+	Users   []*user `json:"users"`
+	// NextCursor is only set when there may be more users after this page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ListUsers lists the users newest first, searched by email and full name.
+// deleted users are only listed with status=deleted.
+func ListUsers(ads service.AdminService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := toListUsersRequest(r.URL.Query())
+
+		f, fields := toUserFilter(req)
+		if fields != nil {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		users, err := ads.ListUsers(ctx, f)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		res := &listUsersResponse{
+			Success: true,
+			Users:   []*user{},
+		}
+		for _, u := range users {
+			res.Users = append(res.Users, toUser(u))
+		}
+		if len(users) == f.Limit {
+			res.NextCursor = users[len(users)-1].ID
+		}
+
+		response.OK(w, res).JSON()
+	}
+}
+
+type bio struct {
+	Location string `json:"location"`
+	Bio      string `json:"bio"`
+	Web      string `json:"web"`
+	Picture  string `json:"picture"`
+}
+
+type session struct {
+	ID           string    `json:"id"`
+	Client       string    `json:"client"`
+	IP           string    `json:"ip"`
+	UserAgent    string    `json:"user_agent"`
+	LastSeenIP   string    `json:"last_seen_ip"`
+	Country      string    `json:"country,omitempty"`
+	City         string    `json:"city,omitempty"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type getUserResponse struct {
+	Success bool `json:"success"`
+	*user
+	Bio      *bio       `json:"bio"`
+	Locale   string     `json:"locale"`
+	Roles    []string   `json:"roles"`
+	Sessions []*session `json:"sessions"`
+}
+
+// GetUser shows a user along with their bio, roles and active sessions
+func GetUser(ads service.AdminService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ud, err := ads.GetUser(ctx, chi.URLParam(r, "id"))
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		u := ud.User
+		res := &getUserResponse{
+			Success: true,
+			user:    toUser(u),
+			Bio: &bio{
+				Location: u.UserBio.Location,
+				Bio:      u.UserBio.Bio,
+				Web:      u.UserBio.Web,
+				Picture:  u.UserBio.Picture,
+			},
+			Locale:   u.Locale,
+			Roles:    ud.Roles,
+			Sessions: []*session{},
+		}
+		for _, s := range ud.Sessions {
+			us := &session{
+				ID:           s.ID,
+				Client:       s.Client,
+				IP:           s.IP,
+				UserAgent:    s.UserAgent,
+				LastSeenIP:   s.LastSeenIP,
+				LastActiveAt: s.LastActiveAt,
+				CreatedAt:    s.CreatedAt,
+			}
+			if s.Location != nil {
+				us.Country = s.Location.Country
+				us.City = s.Location.City
+			}
+			res.Sessions = append(res.Sessions, us)
+		}
+
+		response.OK(w, res).JSON()
+	}
+}
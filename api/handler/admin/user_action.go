@@ -0,0 +1,65 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/service"
+)
+
+type userActionResponse struct {
+	Success bool `json:"success"`
+}
+
+// userAction is an action an admin takes on the user of the id in the path
+type userAction func(ctx context.Context, userID string) e.Error
+
+func handleUserAction(action userAction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := action(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &userActionResponse{
+			Success: true,
+		}).JSON()
+	}
+}
+
+// DeactivateUser keeps the user from logging in and ends their sessions
+func DeactivateUser(ads service.AdminService) http.HandlerFunc {
+	return handleUserAction(ads.DeactivateUser)
+}
+
+func ReactivateUser(ads service.AdminService) http.HandlerFunc {
+	return handleUserAction(ads.ReactivateUser)
+}
+
+// ForceEmailVerification makes the user verify their email again before they
+// can log in
+func ForceEmailVerification(ads service.AdminService) http.HandlerFunc {
+	return handleUserAction(ads.ForceEmailVerification)
+}
+
+// SendPasswordReset mails the user a password reset link
+func SendPasswordReset(ads service.AdminService) http.HandlerFunc {
+	return handleUserAction(ads.SendPasswordReset)
+}
+
+func EndAllSessions(ads service.AdminService) http.HandlerFunc {
+	return handleUserAction(ads.EndAllSessions)
+}
+
+// DeleteUser soft deletes the user, RestoreUser brings them back
+func DeleteUser(ads service.AdminService) http.HandlerFunc {
+	return handleUserAction(ads.DeleteUser)
+}
+
+func RestoreUser(ads service.AdminService) http.HandlerFunc {
+	return handleUserAction(ads.RestoreUser)
+}
@@ -0,0 +1,193 @@
+package audit
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/service"
+)
+
+type auditEvent struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	ActorID   string                 `json:"actor_id,omitempty"`
+	UserID    string                 `json:"user_id,omitempty"`
+	SessionID string                 `json:"session_id,omitempty"`
+	IP        string                 `json:"ip"`
+	UserAgent string                 `json:"user_agent"`
+	Metadata  map[string]interface{} `json:"metadata"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+type listAuditEventsResponse struct {
+	Success bool          `json:"success"`
+	Events  []*auditEvent `json:"events"`
+	// NextCursor is only set when there may be more events after this page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func toListAuditEventsResponse(
+	events []*repository.AuditEvent,
+	limit int,
+) *listAuditEventsResponse {
+	res := &listAuditEventsResponse{
+		Success: true,
+		Events:  []*auditEvent{},
+	}
+	for _, ae := range events {
+		res.Events = append(res.Events, &auditEvent{
+			ID:        ae.ID,
+			Type:      ae.Type,
+			ActorID:   ae.ActorID.String,
+			UserID:    ae.UserID.String,
+			SessionID: ae.SessionID,
+			IP:        ae.IP,
+			UserAgent: ae.UserAgent,
+			Metadata:  ae.Metadata,
+			CreatedAt: ae.CreatedAt,
+		})
+	}
+	if len(events) == limit {
+		res.NextCursor = events[len(events)-1].ID
+	}
+
+	return res
+}
+
+// parseTime reads an optional RFC 3339 time
+func parseTime(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, true
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+type listActivityRequest struct {
+	Cursor string
+	Limit  string
+}
+
+func toListActivityRequest(params url.Values) *listActivityRequest {
+	return &listActivityRequest{
+		Cursor: params.Get("cursor"),
+		Limit:  params.Get("limit"),
+	}
+}
+
+// ListActivity lists the audit events of the current user, newest first
+func ListActivity(aus service.AuditService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := toListActivityRequest(r.URL.Query())
+
+		limit, ok := request.ParseLimit(req.Limit)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(map[string]string{
+				"limit": "limit must be a number between 1 and 100",
+			})).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		events, err := aus.ListUserActivity(ctx, at.UserID, req.Cursor, limit)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, toListAuditEventsResponse(events, limit)).JSON()
+	}
+}
+
+type listAuditEventsRequest struct {
+	UserID string
+	Type   string
+	From   string
+	To     string
+	Cursor string
+	Limit  string
+}
+
+func toListAuditEventsRequest(params url.Values) *listAuditEventsRequest {
+	return &listAuditEventsRequest{
+		UserID: params.Get("user_id"),
+		Type:   params.Get("type"),
+		From:   params.Get("from"),
+		To:     params.Get("to"),
+		Cursor: params.Get("cursor"),
+		Limit:  params.Get("limit"),
+	}
+}
+
+func toAuditEventFilter(
+	req *listAuditEventsRequest,
+) (*repository.AuditEventFilter, map[string]string) {
+	fields := map[string]string{}
+
+	limit, ok := request.ParseLimit(req.Limit)
+	if !ok {
+		fields["limit"] = "limit must be a number between 1 and 100"
+	}
+
+	from, ok := parseTime(req.From)
+	if !ok {
+		fields["from"] = "from must be an RFC 3339 time"
+	}
+
+	to, ok := parseTime(req.To)
+	if !ok {
+		fields["to"] = "to must be an RFC 3339 time"
+	}
+
+	if len(fields) > 0 {
+		return nil, fields
+	}
+
+	return &repository.AuditEventFilter{
+		UserID: req.UserID,
+		Type:   req.Type,
+		From:   from,
+		To:     to,
+		Before: req.Cursor,
+		Limit:  limit,
+	}, nil
+}
+
+// ListAuditEvents lists the audit events of every user, filtered by user,
+// type and time range
+func ListAuditEvents(aus service.AuditService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := toListAuditEventsRequest(r.URL.Query())
+
+		f, fields := toAuditEventFilter(req)
+		if fields != nil {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		events, err := aus.ListAuditEvents(ctx, f)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, toListAuditEventsResponse(events, f.Limit)).JSON()
+	}
+}
@@ -20,6 +20,7 @@ type loginRequest struct {
 type loginResponse struct {
 	Success     bool             `json:"success"`
 	RequireTFA  bool             `json:"require_tfa"`
+	TFAToken    string           `json:"tfa_token,omitempty"`
 	AccessToken *jwt.AccessToken `json:"access_token"`
 }
 
@@ -61,15 +62,23 @@ func Login(as service.AuthService) http.HandlerFunc {
 			Email:    req.Email,
 			Password: req.Password,
 		}
-		at, err := as.Login(ctx, u, clientID)
+		at, c, err := as.Login(ctx, u, clientID)
 		if err != nil {
 			response.Error(w, err.(e.Error)).JSON()
 			return
 		}
 
+		if c != nil {
+			response.OK(w, &loginResponse{
+				Success:    true,
+				RequireTFA: true,
+				TFAToken:   c.Token,
+			}).JSON()
+			return
+		}
+
 		response.OK(w, &loginResponse{
-			Success: true,
-			// TODO: implement tfa properly after everything else is done :)
+			Success:     true,
 			RequireTFA:  false,
 			AccessToken: at,
 		}).JSON()
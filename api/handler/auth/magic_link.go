@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/api/validator"
+	"github.com/werdna521/userland/service"
+)
+
+type requestMagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+type requestMagicLinkResponse struct {
+	Success bool `json:"success"`
+}
+
+func validateRequestMagicLinkRequest(req *requestMagicLinkRequest) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidateEmail(req.Email)
+	if !ok {
+		fields["email"] = errMsg
+	}
+
+	return fields, len(fields) == 0
+}
+
+func RequestMagicLink(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientID := r.Header.Get("X-API-ClientID")
+
+		req := &requestMagicLinkRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateRequestMagicLinkRequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		err = as.RequestMagicLink(ctx, req.Email, clientID)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &requestMagicLinkResponse{
+			Success: true,
+		}).JSON()
+	}
+}
+
+type redeemMagicLinkRequest struct {
+	Token string `json:"token"`
+}
+
+func validateRedeemMagicLinkRequest(req *redeemMagicLinkRequest) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidateToken(req.Token)
+	if !ok {
+		fields["token"] = errMsg
+	}
+
+	return fields, len(fields) == 0
+}
+
+// RedeemMagicLink has to be called by the same client that requested the link
+func RedeemMagicLink(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientID := r.Header.Get("X-API-ClientID")
+
+		req := &redeemMagicLinkRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateRedeemMagicLinkRequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, c, err := as.RedeemMagicLink(ctx, req.Token, clientID)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		if c != nil {
+			response.OK(w, &loginResponse{
+				Success:    true,
+				RequireTFA: true,
+				TFAToken:   c.Token,
+			}).JSON()
+			return
+		}
+
+		response.OK(w, &loginResponse{
+			Success:     true,
+			RequireTFA:  false,
+			AccessToken: at,
+		}).JSON()
+	}
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/api/validator"
+	"github.com/werdna521/userland/service"
+)
+
+type beginPasskeyLoginResponse struct {
+	Success    bool        `json:"success"`
+	CeremonyID string      `json:"ceremony_id"`
+	Options    interface{} `json:"options"`
+}
+
+// BeginPasskeyLogin returns the options the client should pass to
+// navigator.credentials.get()
+func BeginPasskeyLogin(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientID := r.Header.Get("X-API-ClientID")
+
+		ctx := r.Context()
+		c, err := as.BeginPasskeyLogin(ctx, clientID)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &beginPasskeyLoginResponse{
+			Success:    true,
+			CeremonyID: c.CeremonyID,
+			Options:    c.Options,
+		}).JSON()
+	}
+}
+
+type finishPasskeyLoginRequest struct {
+	CeremonyID string          `json:"ceremony_id"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+func validateFinishPasskeyLoginRequest(req *finishPasskeyLoginRequest) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidateToken(req.CeremonyID)
+	if !ok {
+		fields["ceremony_id"] = errMsg
+	}
+
+	if len(req.Credential) == 0 {
+		fields["credential"] = "credential is required"
+	}
+
+	return fields, len(fields) == 0
+}
+
+// FinishPasskeyLogin takes the result of navigator.credentials.get()
+func FinishPasskeyLogin(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &finishPasskeyLoginRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateFinishPasskeyLoginRequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := as.FinishPasskeyLogin(ctx, req.CeremonyID, req.Credential)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &loginResponse{
+			Success:     true,
+			RequireTFA:  false,
+			AccessToken: at,
+		}).JSON()
+	}
+}
@@ -7,6 +7,7 @@ import (
 	e "github.com/werdna521/userland/api/error"
 	"github.com/werdna521/userland/api/response"
 	"github.com/werdna521/userland/api/validator"
+	"github.com/werdna521/userland/config"
 	"github.com/werdna521/userland/service"
 )
 
@@ -67,7 +68,10 @@ type resetPasswordResponse struct {
 	Success bool `json:"success"`
 }
 
-func validateResetPasswordRequest(req *resetPasswordRequest) (map[string]string, bool) {
+func validateResetPasswordRequest(
+	req *resetPasswordRequest,
+	policy config.Password,
+) (map[string]string, bool) {
 	fields := map[string]string{}
 
 	errMsg, ok := validator.ValidateToken(req.Token)
@@ -75,7 +79,7 @@ func validateResetPasswordRequest(req *resetPasswordRequest) (map[string]string,
 		fields["token"] = errMsg
 	}
 
-	errMsg, ok = validator.ValidatePassword(req.Password)
+	errMsg, ok = validator.ValidatePassword(req.Password, policy)
 	if !ok {
 		fields["password"] = errMsg
 	}
@@ -88,7 +92,7 @@ func validateResetPasswordRequest(req *resetPasswordRequest) (map[string]string,
 	return fields, len(fields) == 0
 }
 
-func ResetPassword(au service.AuthService) http.HandlerFunc {
+func ResetPassword(au service.AuthService, policy config.Password) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &resetPasswordRequest{}
 		err := json.NewDecoder(r.Body).Decode(req)
@@ -97,7 +101,7 @@ func ResetPassword(au service.AuthService) http.HandlerFunc {
 			return
 		}
 
-		fields, ok := validateResetPasswordRequest(req)
+		fields, ok := validateResetPasswordRequest(req, policy)
 		if !ok {
 			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
 			return
@@ -7,6 +7,7 @@ import (
 	e "github.com/werdna521/userland/api/error"
 	"github.com/werdna521/userland/api/response"
 	"github.com/werdna521/userland/api/validator"
+	"github.com/werdna521/userland/config"
 	"github.com/werdna521/userland/repository"
 	"github.com/werdna521/userland/service"
 )
@@ -16,13 +17,17 @@ type registerRequest struct {
 	Email           string `json:"email"`
 	Password        string `json:"password"`
 	PasswordConfirm string `json:"password_confirm"`
+	Locale          string `json:"locale"`
 }
 
 type registerResponse struct {
 	Success bool `json:"success"`
 }
 
-func validateRegisterRequest(req *registerRequest) (map[string]string, bool) {
+func validateRegisterRequest(
+	req *registerRequest,
+	policy config.Password,
+) (map[string]string, bool) {
 	fields := map[string]string{}
 
 	errMsg, ok := validator.ValidateFullname(req.Fullname)
@@ -35,7 +40,7 @@ func validateRegisterRequest(req *registerRequest) (map[string]string, bool) {
 		fields["email"] = errMsg
 	}
 
-	errMsg, ok = validator.ValidatePassword(req.Password)
+	errMsg, ok = validator.ValidatePassword(req.Password, policy)
 	if !ok {
 		fields["password"] = errMsg
 	}
@@ -45,10 +50,15 @@ func validateRegisterRequest(req *registerRequest) (map[string]string, bool) {
 		fields["password_confirm"] = errMsg
 	}
 
+	errMsg, ok = validator.ValidateLocale(req.Locale)
+	if !ok {
+		fields["locale"] = errMsg
+	}
+
 	return fields, len(fields) == 0
 }
 
-func Register(as service.AuthService) http.HandlerFunc {
+func Register(as service.AuthService, policy config.Password) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &registerRequest{}
 		err := json.NewDecoder(r.Body).Decode(req)
@@ -57,7 +67,7 @@ func Register(as service.AuthService) http.HandlerFunc {
 			return
 		}
 
-		fields, ok := validateRegisterRequest(req)
+		fields, ok := validateRegisterRequest(req, policy)
 		if !ok {
 			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
 			return
@@ -66,13 +76,14 @@ func Register(as service.AuthService) http.HandlerFunc {
 		u := &repository.User{
 			Email:    req.Email,
 			Password: req.Password,
+			Locale:   req.Locale,
 			UserBio: &repository.UserBio{
 				Fullname: req.Fullname,
 			},
 		}
 
 		ctx := r.Context()
-		err = as.Register(ctx, u)
+		err = as.Register(ctx, u, false)
 		if err != nil {
 			response.Error(w, err.(e.Error)).JSON()
 			return
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/service"
+)
+
+type startSocialLoginResponse struct {
+	Success bool   `json:"success"`
+	URL     string `json:"url"`
+}
+
+// StartSocialLogin returns the provider URL the client should send the user to
+func StartSocialLogin(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientID := r.Header.Get("X-API-ClientID")
+		provider := chi.URLParam(r, "provider")
+
+		ctx := r.Context()
+		url, err := as.StartSocialLogin(ctx, provider, clientID)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &startSocialLoginResponse{
+			Success: true,
+			URL:     url,
+		}).JSON()
+	}
+}
+
+type socialLoginCallbackRequest struct {
+	State string
+	Code  string
+	Error string
+}
+
+type identityResponse struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type linkIdentityCallbackResponse struct {
+	Success  bool              `json:"success"`
+	Identity *identityResponse `json:"identity"`
+}
+
+func toSocialLoginCallbackRequest(params url.Values) *socialLoginCallbackRequest {
+	return &socialLoginCallbackRequest{
+		State: params.Get("state"),
+		Code:  params.Get("code"),
+		Error: params.Get("error"),
+	}
+}
+
+func validateSocialLoginCallbackRequest(req *socialLoginCallbackRequest) bool {
+	return req.State != "" && req.Code != ""
+}
+
+// SocialLoginCallback is where providers redirect back to, for both logging in
+// and linking a provider to an account
+func SocialLoginCallback(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := toSocialLoginCallbackRequest(r.URL.Query())
+		if req.Error != "" {
+			response.Error(w, e.NewUnauthorizedError("sign in was cancelled at the provider")).JSON()
+			return
+		}
+
+		ok := validateSocialLoginCallbackRequest(req)
+		if !ok {
+			response.Error(w, e.NewBadRequestError("bad request")).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		provider := chi.URLParam(r, "provider")
+		res, err := as.CompleteSocialLogin(ctx, provider, req.State, req.Code)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		if res.LinkedIdentity != nil {
+			response.OK(w, &linkIdentityCallbackResponse{
+				Success: true,
+				Identity: &identityResponse{
+					Provider:  res.LinkedIdentity.Provider,
+					Email:     res.LinkedIdentity.Email,
+					CreatedAt: res.LinkedIdentity.CreatedAt,
+				},
+			}).JSON()
+			return
+		}
+
+		if res.TFAChallenge != nil {
+			response.OK(w, &loginResponse{
+				Success:    true,
+				RequireTFA: true,
+				TFAToken:   res.TFAChallenge.Token,
+			}).JSON()
+			return
+		}
+
+		response.OK(w, &loginResponse{
+			Success:     true,
+			RequireTFA:  false,
+			AccessToken: res.AccessToken,
+		}).JSON()
+	}
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/api/validator"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/service"
+)
+
+// either code or recovery_code has to be filled
+type verifyTFARequest struct {
+	TFAToken     string `json:"tfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type verifyTFAResponse struct {
+	Success     bool             `json:"success"`
+	AccessToken *jwt.AccessToken `json:"access_token"`
+}
+
+func validateVerifyTFARequest(req *verifyTFARequest) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidateToken(req.TFAToken)
+	if !ok {
+		fields["tfa_token"] = errMsg
+	}
+
+	if req.RecoveryCode != "" {
+		errMsg, ok = validator.ValidateRecoveryCode(req.RecoveryCode)
+		if !ok {
+			fields["recovery_code"] = errMsg
+		}
+	} else {
+		errMsg, ok = validator.ValidateTFACode(req.Code)
+		if !ok {
+			fields["code"] = errMsg
+		}
+	}
+
+	return fields, len(fields) == 0
+}
+
+func VerifyTFA(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientID := r.Header.Get("X-API-ClientID")
+
+		req := &verifyTFARequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateVerifyTFARequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		var at *jwt.AccessToken
+		if req.RecoveryCode != "" {
+			at, err = as.VerifyTFARecoveryCode(ctx, req.TFAToken, clientID, req.RecoveryCode)
+		} else {
+			at, err = as.VerifyTFA(ctx, req.TFAToken, clientID, req.Code)
+		}
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &verifyTFAResponse{
+			Success:     true,
+			AccessToken: at,
+		}).JSON()
+	}
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/service"
+)
+
+type unlockAccountRequest struct {
+	Token string
+}
+
+type unlockAccountResponse struct {
+	Success bool `json:"success"`
+}
+
+func toUnlockAccountRequest(params url.Values) *unlockAccountRequest {
+	return &unlockAccountRequest{
+		Token: params.Get("token"),
+	}
+}
+
+func validateUnlockAccountRequest(req *unlockAccountRequest) bool {
+	return req.Token != ""
+}
+
+func UnlockAccount(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := toUnlockAccountRequest(r.URL.Query())
+
+		ok := validateUnlockAccountRequest(req)
+		if !ok {
+			response.Error(w, e.NewBadRequestError("bad request")).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		err := as.UnlockAccount(ctx, req.Token)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &unlockAccountResponse{
+			Success: true,
+		}).JSON()
+	}
+}
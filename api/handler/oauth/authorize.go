@@ -0,0 +1,122 @@
+package oauth
+
+import (
+	"net/http"
+	"net/url"
+
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/error/client"
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/service"
+)
+
+const responseTypeCode = "code"
+
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+func toAuthorizeRequest(params url.Values) *authorizeRequest {
+	return &authorizeRequest{
+		ResponseType:        params.Get("response_type"),
+		ClientID:            params.Get("client_id"),
+		RedirectURI:         params.Get("redirect_uri"),
+		Scope:               params.Get("scope"),
+		State:               params.Get("state"),
+		Nonce:               params.Get("nonce"),
+		CodeChallenge:       params.Get("code_challenge"),
+		CodeChallengeMethod: params.Get("code_challenge_method"),
+	}
+}
+
+func validateAuthorizeRequest(req *authorizeRequest) bool {
+	return req.ClientID != "" && req.RedirectURI != ""
+}
+
+// Authorize implements the authorization endpoint of the authorization code
+// flow. the user has to be signed in already, either with the browser session
+// cookie set by /me/session/browser or with a bearer token. there's no consent
+// screen, clients are registered by us.
+func Authorize(oas service.OAuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := toAuthorizeRequest(r.URL.Query())
+
+		ok := validateAuthorizeRequest(req)
+		if !ok {
+			response.Error(w, e.NewOAuthError("invalid_request", "client_id and redirect_uri are required")).JSON()
+			return
+		}
+
+		// until the redirect uri has been checked, errors can't be sent to it
+		ctx := r.Context()
+		oc, err := oas.GetAuthorizationClient(ctx, req.ClientID, req.RedirectURI)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		if req.ResponseType != responseTypeCode {
+			redirectWithError(w, r, req, e.NewOAuthError("unsupported_response_type", "only code response type is supported"))
+			return
+		}
+
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			redirectWithError(w, r, req, e.NewOAuthError("login_required", "user is not signed in"))
+			return
+		}
+
+		code, err := oas.Authorize(ctx, at, oc, &repository.AuthorizationCode{
+			RedirectURI:         req.RedirectURI,
+			Scope:               req.Scope,
+			Nonce:               req.Nonce,
+			CodeChallenge:       req.CodeChallenge,
+			CodeChallengeMethod: req.CodeChallengeMethod,
+		})
+		if err != nil {
+			redirectWithError(w, r, req, err)
+			return
+		}
+
+		redirect(w, r, req, url.Values{"code": {code}})
+	}
+}
+
+func redirectWithError(w http.ResponseWriter, r *http.Request, req *authorizeRequest, err e.Error) {
+	params := url.Values{}
+
+	oauthErr, ok := err.(client.OAuthError)
+	if !ok {
+		oauthErr = e.NewOAuthError("server_error", "internal server error")
+	}
+	params.Set("error", oauthErr.Code)
+	params.Set("error_description", oauthErr.Msg)
+
+	redirect(w, r, req, params)
+}
+
+func redirect(w http.ResponseWriter, r *http.Request, req *authorizeRequest, params url.Values) {
+	// the redirect uri has been matched against the registered ones, so it
+	// parses fine
+	u, _ := url.Parse(req.RedirectURI)
+
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
@@ -0,0 +1,392 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/werdna521/userland/api/handler/session"
+	"github.com/werdna521/userland/api/middleware"
+	"github.com/werdna521/userland/config"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/postgres"
+	"github.com/werdna521/userland/repository/redis"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/service"
+)
+
+const (
+	testClientID    = "client"
+	testRedirectURI = "https://client.example.com/callback"
+	testUserID      = "user-1"
+	testSessionID   = "session-1"
+)
+
+type fakeSessionRepository struct {
+	redis.SessionRepository
+	sessions        map[string]*repository.Session
+	browserSessions map[string]*repository.BrowserSession
+}
+
+func (r *fakeSessionRepository) CreateSession(
+	ctx context.Context,
+	s *repository.Session,
+	expiresIn time.Duration,
+) error {
+	r.sessions[s.ID] = s
+	return nil
+}
+
+func (r *fakeSessionRepository) GetSession(
+	ctx context.Context,
+	userID string,
+	sessionID string,
+) (*repository.Session, error) {
+	s, ok := r.sessions[sessionID]
+	if !ok || s.UserID != userID {
+		return nil, repository.NewNotFoundError()
+	}
+	return s, nil
+}
+
+func (r *fakeSessionRepository) GetSessionWatermark(
+	ctx context.Context,
+	userID string,
+) (*repository.SessionWatermark, error) {
+	return nil, repository.NewNotFoundError()
+}
+
+func (r *fakeSessionRepository) TouchSession(
+	ctx context.Context,
+	userID string,
+	sessionID string,
+	ip string,
+) error {
+	return nil
+}
+
+func (r *fakeSessionRepository) AddUserSessionToIndex(
+	ctx context.Context,
+	s *repository.Session,
+	expiresIn time.Duration,
+) error {
+	return nil
+}
+
+func (r *fakeSessionRepository) UpdateSessionExpiryTime(
+	ctx context.Context,
+	s *repository.Session,
+	expiresIn time.Duration,
+) error {
+	return nil
+}
+
+func (r *fakeSessionRepository) CreateAccessToken(
+	ctx context.Context,
+	at *repository.AccessToken,
+	expiresIn time.Duration,
+) error {
+	return nil
+}
+
+func (r *fakeSessionRepository) CreateRefreshToken(
+	ctx context.Context,
+	rt *repository.RefreshToken,
+	expiresIn time.Duration,
+) error {
+	return nil
+}
+
+func (r *fakeSessionRepository) CreateBrowserSession(
+	ctx context.Context,
+	bs *repository.BrowserSession,
+	expiresIn time.Duration,
+) error {
+	r.browserSessions[bs.Token] = bs
+	return nil
+}
+
+func (r *fakeSessionRepository) GetBrowserSession(
+	ctx context.Context,
+	token string,
+) (*repository.BrowserSession, error) {
+	bs, ok := r.browserSessions[token]
+	if !ok {
+		return nil, repository.NewNotFoundError()
+	}
+	return bs, nil
+}
+
+type fakeTokenRepository struct {
+	redis.TokenRepository
+	codes map[string]*repository.AuthorizationCode
+}
+
+func (r *fakeTokenRepository) CreateAuthorizationCode(
+	ctx context.Context,
+	c *repository.AuthorizationCode,
+) error {
+	r.codes[c.Code] = c
+	return nil
+}
+
+func (r *fakeTokenRepository) ConsumeAuthorizationCode(
+	ctx context.Context,
+	code string,
+) (*repository.AuthorizationCode, error) {
+	c, ok := r.codes[code]
+	if !ok {
+		return nil, repository.NewNotFoundError()
+	}
+	delete(r.codes, code)
+	return c, nil
+}
+
+type fakeOAuthClientRepository struct {
+	postgres.OAuthClientRepository
+}
+
+func (r *fakeOAuthClientRepository) GetOAuthClientByID(
+	ctx context.Context,
+	clientID string,
+) (*repository.OAuthClient, error) {
+	if clientID != testClientID {
+		return nil, repository.NewNotFoundError()
+	}
+	// a public client, PKCE is all it has
+	return &repository.OAuthClient{
+		ID:           testClientID,
+		Name:         "Client",
+		RedirectURIs: []string{testRedirectURI},
+	}, nil
+}
+
+type fakeUserRepository struct {
+	postgres.UserRepository
+}
+
+func (r *fakeUserRepository) GetUserByID(ctx context.Context, userID string) (*repository.User, error) {
+	return &repository.User{ID: userID, Email: "john@example.com", IsActive: true}, nil
+}
+
+type fakeRoleRepository struct {
+	postgres.RoleRepository
+}
+
+func (r *fakeRoleRepository) GetRolesByUserID(ctx context.Context, userID string) ([]string, error) {
+	return []string{}, nil
+}
+
+type authorizeTest struct {
+	sr      *fakeSessionRepository
+	ss      service.SessionService
+	handler http.Handler
+}
+
+func newAuthorizeTest(t *testing.T) *authorizeTest {
+	jwt.SetKeyManager(jwt.NewKeyManager([]byte("secret")))
+
+	cfg := &config.Config{
+		Server: config.Server{BaseURL: "http://localhost:3000"},
+		Tokens: config.Tokens{
+			AccessToken:    time.Minute,
+			RefreshToken:   time.Hour,
+			IDToken:        time.Minute,
+			BrowserSession: time.Hour,
+		},
+	}
+
+	// the user already signed in on userland itself
+	at := &authorizeTest{
+		sr: &fakeSessionRepository{
+			sessions: map[string]*repository.Session{
+				testSessionID: {ID: testSessionID, UserID: testUserID},
+			},
+			browserSessions: map[string]*repository.BrowserSession{},
+		},
+	}
+	at.ss = service.NewBaseSessionService(at.sr, &fakeRoleRepository{}, nil, nil, nil, cfg)
+	oas := service.NewBaseOAuthService(
+		&fakeUserRepository{},
+		&fakeOAuthClientRepository{},
+		&fakeRoleRepository{},
+		&fakeTokenRepository{codes: map[string]*repository.AuthorizationCode{}},
+		at.sr,
+		at.ss,
+		service.SessionLimits{},
+		cfg,
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle("/oauth/authorize", middleware.ReadBrowserSession(at.sr)(Authorize(oas)))
+	mux.Handle("/oauth/token", Token(oas))
+	at.handler = mux
+
+	return at
+}
+
+// browserSessionCookie asks for the cookie the way the first-party app does,
+// with the access token of its session
+func (at *authorizeTest) browserSessionCookie(t *testing.T) *http.Cookie {
+	ctx := context.WithValue(context.Background(), middleware.AccessTokenCtxKey, &jwt.AccessToken{
+		UserID:    testUserID,
+		SessionID: testSessionID,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/me/session/browser", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	session.CreateBrowserSession(at.ss, time.Hour)(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("failed to create browser session: %d %s", rec.Code, rec.Body.String())
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == middleware.BrowserSessionCookieName {
+			return c
+		}
+	}
+	t.Fatal("no browser session cookie was set")
+	return nil
+}
+
+// authorize sends the browser to the authorization endpoint and returns the
+// query the client gets redirected back with
+func (at *authorizeTest) authorize(t *testing.T, cookie *http.Cookie, challenge string) url.Values {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testClientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"openid email"},
+		"state":                 {"state"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+params.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	rec := httptest.NewRecorder()
+	at.handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected a redirect, got %d %s", rec.Code, rec.Body.String())
+	}
+	u, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("failed to parse redirect: %v", err)
+	}
+	if u.Scheme+"://"+u.Host+u.Path != testRedirectURI {
+		t.Fatalf("expected a redirect to %s, got %s", testRedirectURI, u)
+	}
+	if u.Query().Get("state") != "state" {
+		t.Fatalf("expected the state to come back, got %q", u.Query().Get("state"))
+	}
+	return u.Query()
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestBrowserSessionCookie(t *testing.T) {
+	at := newAuthorizeTest(t)
+	c := at.browserSessionCookie(t)
+
+	if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("expected an HttpOnly, Secure, SameSite=Lax cookie, got %+v", c)
+	}
+	if c.Path != "/oauth/authorize" {
+		t.Fatalf("expected the cookie to be scoped to /oauth/authorize, got %q", c.Path)
+	}
+}
+
+func TestAuthorizationCodeFlowWithBrowserSession(t *testing.T) {
+	at := newAuthorizeTest(t)
+	verifier := "a-code-verifier-long-enough-to-be-a-real-one-0123456789"
+
+	query := at.authorize(t, at.browserSessionCookie(t), codeChallenge(verifier))
+	code := query.Get("code")
+	if code == "" {
+		t.Fatalf("expected a code, got %v", query)
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"client_id":     {testClientID},
+		"code_verifier": {verifier},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	at.handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("failed to exchange code: %d %s", rec.Code, rec.Body.String())
+	}
+	res := &tokenResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(res); err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	if res.IDToken == "" || res.RefreshToken == "" {
+		t.Fatalf("expected an id token and a refresh token, got %+v", res)
+	}
+
+	token, isValid, err := jwt.ParseAccessToken(res.AccessToken)
+	if !isValid || err != nil {
+		t.Fatalf("expected a valid access token, got %v", err)
+	}
+	if token.UserID != testUserID {
+		t.Fatalf("expected an access token for %s, got %s", testUserID, token.UserID)
+	}
+	s := at.sr.sessions[token.SessionID]
+	if s == nil || s.OAuthClientID != testClientID {
+		t.Fatalf("expected a session granted to %s, got %+v", testClientID, s)
+	}
+
+	// the code is good for a single exchange
+	req = httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	at.handler.ServeHTTP(rec, req)
+	if rec.Code == http.StatusOK {
+		t.Fatal("expected the code to be used up")
+	}
+}
+
+func TestAuthorizeRequiresBrowserSession(t *testing.T) {
+	at := newAuthorizeTest(t)
+
+	query := at.authorize(t, nil, codeChallenge("verifier"))
+	if query.Get("error") != "login_required" {
+		t.Fatalf("expected login_required without a cookie, got %v", query)
+	}
+
+	query = at.authorize(t, &http.Cookie{
+		Name:  middleware.BrowserSessionCookieName,
+		Value: "forged",
+	}, codeChallenge("verifier"))
+	if query.Get("error") != "login_required" {
+		t.Fatalf("expected login_required with an unknown cookie, got %v", query)
+	}
+}
+
+func TestAuthorizeRejectsBrowserSessionOfEndedSession(t *testing.T) {
+	at := newAuthorizeTest(t)
+	c := at.browserSessionCookie(t)
+
+	delete(at.sr.sessions, testSessionID)
+
+	query := at.authorize(t, c, codeChallenge("verifier"))
+	if query.Get("error") != "login_required" {
+		t.Fatalf("expected login_required once the session ended, got %v", query)
+	}
+}
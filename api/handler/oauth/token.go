@@ -0,0 +1,130 @@
+package oauth
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/service"
+)
+
+const (
+	grantTypeAuthorizationCode = "authorization_code"
+	grantTypeRefreshToken      = "refresh_token"
+)
+
+type tokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	ClientID     string
+	ClientSecret string
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// toTokenRequest reads the form body. client credentials may come either from
+// HTTP basic auth or from the form itself.
+func toTokenRequest(r *http.Request) *tokenRequest {
+	req := &tokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+	}
+
+	if clientID, clientSecret, ok := r.BasicAuth(); ok {
+		// RFC 6749 requires basic auth credentials to be form encoded first
+		req.ClientID, _ = url.QueryUnescape(clientID)
+		req.ClientSecret, _ = url.QueryUnescape(clientSecret)
+	}
+
+	return req
+}
+
+func validateTokenRequest(req *tokenRequest) bool {
+	if req.ClientID == "" {
+		return false
+	}
+
+	switch req.GrantType {
+	case grantTypeAuthorizationCode:
+		return req.Code != "" && req.RedirectURI != "" && req.CodeVerifier != ""
+	case grantTypeRefreshToken:
+		return req.RefreshToken != ""
+	default:
+		return true
+	}
+}
+
+func Token(oas service.OAuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			response.Error(w, e.NewOAuthError("invalid_request", "cannot decode request body")).JSON()
+			return
+		}
+
+		req := toTokenRequest(r)
+
+		ok := validateTokenRequest(req)
+		if !ok {
+			response.Error(w, e.NewOAuthError("invalid_request", "missing required parameters")).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		var (
+			tokens   *service.OAuthTokens
+			tokenErr e.Error
+		)
+		switch req.GrantType {
+		case grantTypeAuthorizationCode:
+			tokens, tokenErr = oas.ExchangeAuthorizationCode(
+				ctx,
+				req.ClientID,
+				req.ClientSecret,
+				req.Code,
+				req.RedirectURI,
+				req.CodeVerifier,
+			)
+		case grantTypeRefreshToken:
+			tokens, tokenErr = oas.RefreshOAuthTokens(
+				ctx,
+				req.ClientID,
+				req.ClientSecret,
+				req.RefreshToken,
+			)
+		default:
+			tokenErr = e.NewOAuthError("unsupported_grant_type", "unsupported grant type")
+		}
+		if tokenErr != nil {
+			response.Error(w, tokenErr).JSON()
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		response.OK(w, &tokenResponse{
+			AccessToken:  tokens.AccessToken.Value,
+			TokenType:    tokens.AccessToken.Type,
+			ExpiresIn:    int64(time.Until(tokens.AccessToken.ExpiredAt).Seconds()),
+			RefreshToken: tokens.RefreshToken.Value,
+			IDToken:      tokens.IDToken,
+			Scope:        tokens.Scope,
+		}).JSON()
+	}
+}
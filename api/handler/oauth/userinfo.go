@@ -0,0 +1,37 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/service"
+)
+
+type userInfoResponse struct {
+	Subject string `json:"sub"`
+	*jwt.UserClaims
+}
+
+func UserInfo(oas service.OAuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		uc, err := oas.GetUserInfo(ctx, at)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &userInfoResponse{
+			Subject:    at.UserID,
+			UserClaims: uc,
+		}).JSON()
+	}
+}
@@ -0,0 +1,357 @@
+package organization
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/api/validator"
+	"github.com/werdna521/userland/config"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/service"
+)
+
+type invitation struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy *string   `json:"invited_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func toInvitation(i *repository.Invitation) *invitation {
+	inv := &invitation{
+		ID:        i.ID,
+		Email:     i.Email,
+		Role:      i.Role,
+		ExpiresAt: i.ExpiresAt,
+		CreatedAt: i.CreatedAt,
+	}
+	if i.InvitedBy.Valid {
+		inv.InvitedBy = &i.InvitedBy.String
+	}
+
+	return inv
+}
+
+type createInvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type createInvitationResponse struct {
+	Success    bool        `json:"success"`
+	Invitation *invitation `json:"invitation"`
+}
+
+func validateCreateInvitationRequest(req *createInvitationRequest) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidateEmail(req.Email)
+	if !ok {
+		fields["email"] = errMsg
+	}
+
+	errMsg, ok = validator.ValidateOrgRole(req.Role)
+	if !ok {
+		fields["role"] = errMsg
+	}
+
+	return fields, len(fields) == 0
+}
+
+// CreateInvitation invites someone into the organization by email, they don't
+// need an account to be invited
+func CreateInvitation(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &createInvitationRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateCreateInvitationRequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		i, err := ors.CreateInvitation(ctx, at.UserID, chi.URLParam(r, "id"), req.Email, req.Role)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &createInvitationResponse{
+			Success:    true,
+			Invitation: toInvitation(i),
+		}).JSON()
+	}
+}
+
+type listInvitationsResponse struct {
+	Success     bool          `json:"success"`
+	Invitations []*invitation `json:"invitations"`
+}
+
+func ListInvitations(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		invitations, err := ors.ListInvitations(ctx, at.UserID, chi.URLParam(r, "id"))
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		res := &listInvitationsResponse{
+			Success:     true,
+			Invitations: []*invitation{},
+		}
+		for _, i := range invitations {
+			res.Invitations = append(res.Invitations, toInvitation(i))
+		}
+
+		response.OK(w, res).JSON()
+	}
+}
+
+type resendInvitationResponse struct {
+	Success bool `json:"success"`
+}
+
+func ResendInvitation(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		err = ors.ResendInvitation(
+			ctx,
+			at.UserID,
+			chi.URLParam(r, "id"),
+			chi.URLParam(r, "invitationID"),
+		)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &resendInvitationResponse{
+			Success: true,
+		}).JSON()
+	}
+}
+
+type revokeInvitationResponse struct {
+	Success bool `json:"success"`
+}
+
+func RevokeInvitation(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		err = ors.RevokeInvitation(
+			ctx,
+			at.UserID,
+			chi.URLParam(r, "id"),
+			chi.URLParam(r, "invitationID"),
+		)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &revokeInvitationResponse{
+			Success: true,
+		}).JSON()
+	}
+}
+
+type getInvitationRequest struct {
+	Token string
+}
+
+func toGetInvitationRequest(params url.Values) *getInvitationRequest {
+	return &getInvitationRequest{
+		Token: params.Get("token"),
+	}
+}
+
+type invitedOrganization struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type invitationDetail struct {
+	Organization *invitedOrganization `json:"organization"`
+	Email        string               `json:"email"`
+	Role         string               `json:"role"`
+	ExpiresAt    time.Time            `json:"expires_at"`
+	// HasAccount tells the client whether to ask for the details of a new
+	// account before accepting
+	HasAccount bool `json:"has_account"`
+}
+
+type getInvitationResponse struct {
+	Success    bool              `json:"success"`
+	Invitation *invitationDetail `json:"invitation"`
+}
+
+// GetInvitation shows the invitee what they were invited to, it's opened from
+// the invitation link
+func GetInvitation(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := toGetInvitationRequest(r.URL.Query())
+
+		if req.Token == "" {
+			response.Error(w, e.NewBadRequestError("bad request")).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		d, err := ors.GetInvitation(ctx, req.Token)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		i := d.Invitation
+		response.OK(w, &getInvitationResponse{
+			Success: true,
+			Invitation: &invitationDetail{
+				Organization: &invitedOrganization{
+					ID:   i.Organization.ID,
+					Name: i.Organization.Name,
+				},
+				Email:      i.Email,
+				Role:       i.Role,
+				ExpiresAt:  i.ExpiresAt,
+				HasAccount: d.HasAccount,
+			},
+		}).JSON()
+	}
+}
+
+// the account fields are only needed when the invited email has no account
+type acceptInvitationRequest struct {
+	Token           string `json:"token"`
+	Fullname        string `json:"fullname"`
+	Password        string `json:"password"`
+	PasswordConfirm string `json:"password_confirm"`
+	Locale          string `json:"locale"`
+}
+
+type acceptInvitationResponse struct {
+	Success bool `json:"success"`
+}
+
+func (req *acceptInvitationRequest) signsUp() bool {
+	return req.Fullname != "" || req.Password != "" || req.PasswordConfirm != ""
+}
+
+func validateAcceptInvitationRequest(
+	req *acceptInvitationRequest,
+	policy config.Password,
+) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidateToken(req.Token)
+	if !ok {
+		fields["token"] = errMsg
+	}
+
+	if !req.signsUp() {
+		return fields, len(fields) == 0
+	}
+
+	errMsg, ok = validator.ValidateFullname(req.Fullname)
+	if !ok {
+		fields["fullname"] = errMsg
+	}
+
+	errMsg, ok = validator.ValidatePassword(req.Password, policy)
+	if !ok {
+		fields["password"] = errMsg
+	}
+
+	errMsg, ok = validator.ValidatePasswordConfirm(req.Password, req.PasswordConfirm)
+	if !ok {
+		fields["password_confirm"] = errMsg
+	}
+
+	errMsg, ok = validator.ValidateLocale(req.Locale)
+	if !ok {
+		fields["locale"] = errMsg
+	}
+
+	return fields, len(fields) == 0
+}
+
+// AcceptInvitation joins the organization of the invitation, signing the
+// invitee up first when they don't have an account
+func AcceptInvitation(ors service.OrganizationService, policy config.Password) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &acceptInvitationRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateAcceptInvitationRequest(req, policy)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		var u *repository.User
+		if req.signsUp() {
+			u = &repository.User{
+				Password: req.Password,
+				Locale:   req.Locale,
+				UserBio: &repository.UserBio{
+					Fullname: req.Fullname,
+				},
+			}
+		}
+
+		ctx := r.Context()
+		err = ors.AcceptInvitation(ctx, req.Token, u)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &acceptInvitationResponse{
+			Success: true,
+		}).JSON()
+	}
+}
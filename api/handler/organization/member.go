@@ -0,0 +1,206 @@
+package organization
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/api/validator"
+	"github.com/werdna521/userland/service"
+)
+
+type member struct {
+	UserID   string    `json:"user_id"`
+	Email    string    `json:"email"`
+	Fullname string    `json:"fullname"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type listMembersResponse struct {
+	Success bool      `json:"success"`
+	Members []*member `json:"members"`
+}
+
+func ListMembers(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		memberships, err := ors.ListMembers(ctx, at.UserID, chi.URLParam(r, "id"))
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		res := &listMembersResponse{
+			Success: true,
+			Members: []*member{},
+		}
+		for _, m := range memberships {
+			res.Members = append(res.Members, &member{
+				UserID:   m.UserID,
+				Email:    m.User.Email,
+				Fullname: m.User.UserBio.Fullname,
+				Role:     m.Role,
+				JoinedAt: m.CreatedAt,
+			})
+		}
+
+		response.OK(w, res).JSON()
+	}
+}
+
+type addMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type addMemberResponse struct {
+	Success bool `json:"success"`
+}
+
+func validateAddMemberRequest(req *addMemberRequest) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidateEmail(req.Email)
+	if !ok {
+		fields["email"] = errMsg
+	}
+
+	errMsg, ok = validator.ValidateOrgRole(req.Role)
+	if !ok {
+		fields["role"] = errMsg
+	}
+
+	return fields, len(fields) == 0
+}
+
+// AddMember adds a registered user to the organization by their email
+func AddMember(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &addMemberRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateAddMemberRequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		err = ors.AddMember(ctx, at.UserID, chi.URLParam(r, "id"), req.Email, req.Role)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &addMemberResponse{
+			Success: true,
+		}).JSON()
+	}
+}
+
+type updateMemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type updateMemberRoleResponse struct {
+	Success bool `json:"success"`
+}
+
+func validateUpdateMemberRoleRequest(req *updateMemberRoleRequest) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidateOrgRole(req.Role)
+	if !ok {
+		fields["role"] = errMsg
+	}
+
+	return fields, len(fields) == 0
+}
+
+func UpdateMemberRole(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &updateMemberRoleRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateUpdateMemberRoleRequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		err = ors.UpdateMemberRole(
+			ctx,
+			at.UserID,
+			chi.URLParam(r, "id"),
+			chi.URLParam(r, "userID"),
+			req.Role,
+		)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &updateMemberRoleResponse{
+			Success: true,
+		}).JSON()
+	}
+}
+
+type removeMemberResponse struct {
+	Success bool `json:"success"`
+}
+
+// RemoveMember takes a member out of the organization, members can remove
+// themselves to leave it
+func RemoveMember(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		err = ors.RemoveMember(ctx, at.UserID, chi.URLParam(r, "id"), chi.URLParam(r, "userID"))
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &removeMemberResponse{
+			Success: true,
+		}).JSON()
+	}
+}
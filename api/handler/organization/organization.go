@@ -0,0 +1,214 @@
+package organization
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/api/validator"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/service"
+)
+
+type organization struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Role is the role of the current user in the organization
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func toOrganization(m *repository.Membership) *organization {
+	return &organization{
+		ID:        m.Organization.ID,
+		Name:      m.Organization.Name,
+		Role:      m.Role,
+		CreatedAt: m.Organization.CreatedAt,
+	}
+}
+
+type organizationRequest struct {
+	Name string `json:"name"`
+}
+
+func validateOrganizationRequest(req *organizationRequest) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidateOrganizationName(req.Name)
+	if !ok {
+		fields["name"] = errMsg
+	}
+
+	return fields, len(fields) == 0
+}
+
+type createOrganizationResponse struct {
+	Success      bool          `json:"success"`
+	Organization *organization `json:"organization"`
+}
+
+// CreateOrganization creates an organization owned by the current user
+func CreateOrganization(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &organizationRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateOrganizationRequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		o, err := ors.CreateOrganization(ctx, at.UserID, req.Name)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &createOrganizationResponse{
+			Success: true,
+			Organization: toOrganization(&repository.Membership{
+				Role:         repository.OrgRoleOwner,
+				Organization: o,
+			}),
+		}).JSON()
+	}
+}
+
+type listOrganizationsResponse struct {
+	Success       bool            `json:"success"`
+	Organizations []*organization `json:"organizations"`
+}
+
+// ListOrganizations lists the organizations the current user is a member of
+func ListOrganizations(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		memberships, err := ors.ListOrganizations(ctx, at.UserID)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		res := &listOrganizationsResponse{
+			Success:       true,
+			Organizations: []*organization{},
+		}
+		for _, m := range memberships {
+			res.Organizations = append(res.Organizations, toOrganization(m))
+		}
+
+		response.OK(w, res).JSON()
+	}
+}
+
+type getOrganizationResponse struct {
+	Success      bool          `json:"success"`
+	Organization *organization `json:"organization"`
+}
+
+func GetOrganization(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		m, err := ors.GetOrganization(ctx, at.UserID, chi.URLParam(r, "id"))
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &getOrganizationResponse{
+			Success:      true,
+			Organization: toOrganization(m),
+		}).JSON()
+	}
+}
+
+type updateOrganizationResponse struct {
+	Success bool `json:"success"`
+}
+
+func UpdateOrganization(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &organizationRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateOrganizationRequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		err = ors.UpdateOrganization(ctx, at.UserID, chi.URLParam(r, "id"), req.Name)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &updateOrganizationResponse{
+			Success: true,
+		}).JSON()
+	}
+}
+
+type deleteOrganizationResponse struct {
+	Success bool `json:"success"`
+}
+
+func DeleteOrganization(ors service.OrganizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		err = ors.DeleteOrganization(ctx, at.UserID, chi.URLParam(r, "id"))
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &deleteOrganizationResponse{
+			Success: true,
+		}).JSON()
+	}
+}
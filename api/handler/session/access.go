@@ -10,8 +10,9 @@ import (
 )
 
 type generateAccessTokenResponse struct {
-	Success     bool             `json:"success"`
-	AccessToken *jwt.AccessToken `json:"accessToken"`
+	Success      bool              `json:"success"`
+	AccessToken  *jwt.AccessToken  `json:"accessToken"`
+	RefreshToken *jwt.RefreshToken `json:"refreshToken"`
 }
 
 func GenerateAccessToken(ss service.SessionService) http.HandlerFunc {
@@ -23,15 +24,16 @@ func GenerateAccessToken(ss service.SessionService) http.HandlerFunc {
 			return
 		}
 
-		at, err := ss.GenerateAccessToken(ctx, rt)
+		at, newRT, err := ss.GenerateAccessToken(ctx, rt)
 		if err != nil {
 			response.Error(w, err).JSON()
 			return
 		}
 
 		response.OK(w, &generateAccessTokenResponse{
-			Success:     true,
-			AccessToken: at,
+			Success:      true,
+			AccessToken:  at,
+			RefreshToken: newRT,
 		}).JSON()
 	}
 }
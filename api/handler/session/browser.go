@@ -0,0 +1,49 @@
+package session
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/werdna521/userland/api/middleware"
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/service"
+)
+
+type createBrowserSessionResponse struct {
+	Success bool `json:"success"`
+}
+
+// CreateBrowserSession sets the cookie the browser signs in with on
+// /oauth/authorize. the first-party app calls it, with credentials, before
+// sending the browser over to an OAuth client's authorization request.
+func CreateBrowserSession(ss service.SessionService, ttl time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		bs, err := ss.CreateBrowserSession(ctx, at)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     middleware.BrowserSessionCookieName,
+			Value:    bs.Token,
+			Path:     middleware.BrowserSessionCookiePath,
+			MaxAge:   int(ttl.Seconds()),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		response.OK(w, &createBrowserSessionResponse{
+			Success: true,
+		}).JSON()
+	}
+}
@@ -0,0 +1,52 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/service"
+)
+
+type switchOrganizationRequest struct {
+	OrganizationID string `json:"organizationId"`
+}
+
+type switchOrganizationResponse struct {
+	Success     bool             `json:"success"`
+	AccessToken *jwt.AccessToken `json:"accessToken"`
+}
+
+// SwitchOrganization changes the active organization of the current session,
+// an empty organizationId leaves the session without one
+func SwitchOrganization(ss service.SessionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &switchOrganizationRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		newAT, err := ss.SwitchOrganization(ctx, at, req.OrganizationID)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &switchOrganizationResponse{
+			Success:     true,
+			AccessToken: newAT,
+		}).JSON()
+	}
+}
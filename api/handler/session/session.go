@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/werdna521/userland/api/request"
 	"github.com/werdna521/userland/api/response"
 	"github.com/werdna521/userland/repository"
@@ -16,10 +17,16 @@ type listSessionsResponse struct {
 }
 
 type userSession struct {
-	IsCurrent bool      `json:"isCurrent"`
-	Client    *client   `json:"client"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID           string     `json:"id"`
+	IsCurrent    bool       `json:"isCurrent"`
+	Client       *client    `json:"client"`
+	IP           string     `json:"ip"`
+	UserAgent    *userAgent `json:"userAgent"`
+	LastSeenIP   string     `json:"lastSeenIP"`
+	Location     *location  `json:"location,omitempty"`
+	LastActiveAt time.Time  `json:"lastActiveAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
 }
 
 type client struct {
@@ -27,7 +34,19 @@ type client struct {
 	Name string `json:"name"`
 }
 
-// TODO: maybe store IP address in session (only if enough time)
+type userAgent struct {
+	Raw     string `json:"raw"`
+	Browser string `json:"browser"`
+	OS      string `json:"os"`
+	Device  string `json:"device"`
+}
+
+type location struct {
+	City        string `json:"city,omitempty"`
+	Country     string `json:"country"`
+	CountryCode string `json:"countryCode"`
+}
+
 func ListSessions(ss service.SessionService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -46,13 +65,30 @@ func ListSessions(ss service.SessionService) http.HandlerFunc {
 		userSessions := []*userSession{}
 		for _, s := range sessions {
 			us := &userSession{
+				ID:        s.ID,
 				IsCurrent: s.ID == at.SessionID,
 				Client: &client{
 					ID:   s.ID,
 					Name: s.Client,
 				},
-				CreatedAt: s.CreatedAt,
-				UpdatedAt: s.UpdatedAt,
+				IP: s.IP,
+				UserAgent: &userAgent{
+					Raw:     s.UserAgent,
+					Browser: s.Browser,
+					OS:      s.OS,
+					Device:  s.Device,
+				},
+				LastSeenIP:   s.LastSeenIP,
+				LastActiveAt: s.LastActiveAt,
+				CreatedAt:    s.CreatedAt,
+				UpdatedAt:    s.UpdatedAt,
+			}
+			if s.Location != nil {
+				us.Location = &location{
+					City:        s.Location.City,
+					Country:     s.Location.Country,
+					CountryCode: s.Location.CountryCode,
+				}
 			}
 			userSessions = append(userSessions, us)
 		}
@@ -92,3 +128,31 @@ func EndCurrentSession(ss service.SessionService) http.HandlerFunc {
 		}).JSON()
 	}
 }
+
+type deleteSessionByIDResponse struct {
+	Success bool `json:"success"`
+}
+
+// DeleteSession ends one of the sessions listed by ListSessions, the current
+// one included
+func DeleteSession(ss service.SessionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		sessionID := chi.URLParam(r, "id")
+		err = ss.RemoveSessionByID(ctx, at.UserID, sessionID)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &deleteSessionByIDResponse{
+			Success: true,
+		}).JSON()
+	}
+}
@@ -22,9 +22,12 @@ type deleteAccountResponse struct {
 func validateDeleteAccountRequest(req *deleteAccountRequest) (map[string]string, bool) {
 	fields := map[string]string{}
 
-	errMsg, ok := validator.ValidatePasswordSimple(req.Password, "password")
-	if !ok {
-		fields["password"] = errMsg
+	// accounts created through a social login have no password
+	if req.Password != "" {
+		errMsg, ok := validator.ValidatePasswordSimple(req.Password, "password")
+		if !ok {
+			fields["password"] = errMsg
+		}
 	}
 
 	return fields, len(fields) == 0
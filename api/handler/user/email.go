@@ -136,3 +136,46 @@ func VerifyEmailChange(us service.UserService) http.HandlerFunc {
 		}).JSON()
 	}
 }
+
+type revertEmailChangeRequest struct {
+	Token string
+}
+
+type revertEmailChangeResponse struct {
+	Success bool `json:"success"`
+}
+
+func toRevertEmailChangeRequest(params url.Values) *revertEmailChangeRequest {
+	return &revertEmailChangeRequest{
+		Token: params.Get("token"),
+	}
+}
+
+func validateRevertEmailChangeRequest(req *revertEmailChangeRequest) bool {
+	return req.Token != ""
+}
+
+// RevertEmailChange is opened from the link mailed to the old address after an
+// email change
+func RevertEmailChange(us service.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := toRevertEmailChangeRequest(r.URL.Query())
+
+		ok := validateRevertEmailChangeRequest(req)
+		if !ok {
+			response.Error(w, e.NewBadRequestError("bad request")).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		err := us.RevertEmailChange(ctx, req.Token)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &revertEmailChangeResponse{
+			Success: true,
+		}).JSON()
+	}
+}
@@ -0,0 +1,110 @@
+package user
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/service"
+)
+
+type identity struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type listIdentitiesResponse struct {
+	Success    bool        `json:"success"`
+	Identities []*identity `json:"identities"`
+}
+
+func ListIdentities(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		identities, err := as.ListIdentities(ctx, at.UserID)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		res := &listIdentitiesResponse{
+			Success:    true,
+			Identities: []*identity{},
+		}
+		for _, i := range identities {
+			res.Identities = append(res.Identities, &identity{
+				Provider:  i.Provider,
+				Email:     i.Email,
+				CreatedAt: i.CreatedAt,
+			})
+		}
+
+		response.OK(w, res).JSON()
+	}
+}
+
+type linkIdentityResponse struct {
+	Success bool   `json:"success"`
+	URL     string `json:"url"`
+}
+
+// LinkIdentity returns the provider URL the client should send the user to.
+// the provider redirects back to the social login callback, which does the
+// linking.
+func LinkIdentity(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		provider := chi.URLParam(r, "provider")
+		url, err := as.LinkIdentity(ctx, at.UserID, provider)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &linkIdentityResponse{
+			Success: true,
+			URL:     url,
+		}).JSON()
+	}
+}
+
+type unlinkIdentityResponse struct {
+	Success bool `json:"success"`
+}
+
+func UnlinkIdentity(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		provider := chi.URLParam(r, "provider")
+		err = as.UnlinkIdentity(ctx, at.UserID, provider)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &unlinkIdentityResponse{
+			Success: true,
+		}).JSON()
+	}
+}
@@ -0,0 +1,92 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/api/validator"
+	"github.com/werdna521/userland/service"
+)
+
+type getLocaleResponse struct {
+	Success bool   `json:"success"`
+	Locale  string `json:"locale"`
+}
+
+func GetLocale(us service.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		locale, err := us.GetLocale(ctx, at.UserID)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &getLocaleResponse{
+			Success: true,
+			Locale:  locale,
+		}).JSON()
+	}
+}
+
+type updateLocaleRequest struct {
+	Locale string `json:"locale"`
+}
+
+type updateLocaleResponse struct {
+	Success bool `json:"success"`
+}
+
+func validateUpdateLocaleRequest(req *updateLocaleRequest) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidateLocale(req.Locale)
+	if !ok {
+		fields["locale"] = errMsg
+	}
+
+	return fields, len(fields) == 0
+}
+
+func UpdateLocale(us service.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &updateLocaleRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateUpdateLocaleRequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		err = us.UpdateLocale(ctx, at.UserID, req.Locale)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &updateLocaleResponse{
+			Success: true,
+		}).JSON()
+	}
+}
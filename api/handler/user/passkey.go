@@ -0,0 +1,255 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/api/validator"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/service"
+)
+
+type passkey struct {
+	ID         string     `json:"id"`
+	Nickname   string     `json:"nickname"`
+	Transports []string   `json:"transports"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func toPasskey(p *repository.Passkey) *passkey {
+	res := &passkey{
+		ID:         p.ID,
+		Nickname:   p.Nickname,
+		Transports: p.Transports,
+		CreatedAt:  p.CreatedAt,
+	}
+	if p.LastUsedAt.Valid {
+		res.LastUsedAt = &p.LastUsedAt.Time
+	}
+
+	return res
+}
+
+type listPasskeysResponse struct {
+	Success  bool       `json:"success"`
+	Passkeys []*passkey `json:"passkeys"`
+}
+
+func ListPasskeys(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		passkeys, err := as.ListPasskeys(ctx, at.UserID)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		res := &listPasskeysResponse{
+			Success:  true,
+			Passkeys: []*passkey{},
+		}
+		for _, p := range passkeys {
+			res.Passkeys = append(res.Passkeys, toPasskey(p))
+		}
+
+		response.OK(w, res).JSON()
+	}
+}
+
+type beginPasskeyRegistrationResponse struct {
+	Success    bool        `json:"success"`
+	CeremonyID string      `json:"ceremony_id"`
+	Options    interface{} `json:"options"`
+}
+
+// BeginPasskeyRegistration returns the options the client should pass to
+// navigator.credentials.create()
+func BeginPasskeyRegistration(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		c, err := as.BeginPasskeyRegistration(ctx, at.UserID)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &beginPasskeyRegistrationResponse{
+			Success:    true,
+			CeremonyID: c.CeremonyID,
+			Options:    c.Options,
+		}).JSON()
+	}
+}
+
+type finishPasskeyRegistrationRequest struct {
+	CeremonyID string          `json:"ceremony_id"`
+	Nickname   string          `json:"nickname"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+type finishPasskeyRegistrationResponse struct {
+	Success bool     `json:"success"`
+	Passkey *passkey `json:"passkey"`
+}
+
+func validateFinishPasskeyRegistrationRequest(
+	req *finishPasskeyRegistrationRequest,
+) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidateToken(req.CeremonyID)
+	if !ok {
+		fields["ceremony_id"] = errMsg
+	}
+
+	errMsg, ok = validator.ValidatePasskeyNickname(req.Nickname)
+	if !ok {
+		fields["nickname"] = errMsg
+	}
+
+	if len(req.Credential) == 0 {
+		fields["credential"] = "credential is required"
+	}
+
+	return fields, len(fields) == 0
+}
+
+// FinishPasskeyRegistration takes the result of navigator.credentials.create()
+func FinishPasskeyRegistration(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &finishPasskeyRegistrationRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateFinishPasskeyRegistrationRequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		p, err := as.FinishPasskeyRegistration(
+			ctx,
+			at.UserID,
+			req.CeremonyID,
+			req.Nickname,
+			req.Credential,
+		)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &finishPasskeyRegistrationResponse{
+			Success: true,
+			Passkey: toPasskey(p),
+		}).JSON()
+	}
+}
+
+type renamePasskeyRequest struct {
+	Nickname string `json:"nickname"`
+}
+
+type renamePasskeyResponse struct {
+	Success bool `json:"success"`
+}
+
+func validateRenamePasskeyRequest(req *renamePasskeyRequest) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidatePasskeyNickname(req.Nickname)
+	if !ok {
+		fields["nickname"] = errMsg
+	}
+
+	return fields, len(fields) == 0
+}
+
+func RenamePasskey(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &renamePasskeyRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateRenamePasskeyRequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		id := chi.URLParam(r, "id")
+		err = as.RenamePasskey(ctx, at.UserID, id, req.Nickname)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &renamePasskeyResponse{
+			Success: true,
+		}).JSON()
+	}
+}
+
+type deletePasskeyResponse struct {
+	Success bool `json:"success"`
+}
+
+func DeletePasskey(as service.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		id := chi.URLParam(r, "id")
+		err = as.DeletePasskey(ctx, at.UserID, id)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &deletePasskeyResponse{
+			Success: true,
+		}).JSON()
+	}
+}
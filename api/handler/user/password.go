@@ -8,6 +8,7 @@ import (
 	"github.com/werdna521/userland/api/request"
 	"github.com/werdna521/userland/api/response"
 	"github.com/werdna521/userland/api/validator"
+	"github.com/werdna521/userland/config"
 	"github.com/werdna521/userland/service"
 )
 
@@ -21,15 +22,21 @@ type changePasswordResponse struct {
 	Success bool `json:"success"`
 }
 
-func validateChangePasswordRequest(req *changePasswordRequest) (map[string]string, bool) {
+func validateChangePasswordRequest(
+	req *changePasswordRequest,
+	policy config.Password,
+) (map[string]string, bool) {
 	fields := map[string]string{}
 
-	errMsg, ok := validator.ValidatePasswordSimple(req.PasswordCurrent, "password_current")
-	if !ok {
-		fields["password_current"] = errMsg
+	// accounts created through a social login have no current password
+	if req.PasswordCurrent != "" {
+		errMsg, ok := validator.ValidatePasswordSimple(req.PasswordCurrent, "password_current")
+		if !ok {
+			fields["password_current"] = errMsg
+		}
 	}
 
-	errMsg, ok = validator.ValidatePassword(req.Password)
+	errMsg, ok := validator.ValidatePassword(req.Password, policy)
 	if !ok {
 		fields["password"] = errMsg
 	}
@@ -42,7 +49,7 @@ func validateChangePasswordRequest(req *changePasswordRequest) (map[string]strin
 	return fields, len(fields) == 0
 }
 
-func ChangePassword(us service.UserService) http.HandlerFunc {
+func ChangePassword(us service.UserService, policy config.Password) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &changePasswordRequest{}
 		err := json.NewDecoder(r.Body).Decode(req)
@@ -51,7 +58,7 @@ func ChangePassword(us service.UserService) http.HandlerFunc {
 			return
 		}
 
-		fields, ok := validateChangePasswordRequest(req)
+		fields, ok := validateChangePasswordRequest(req, policy)
 		if !ok {
 			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
 			return
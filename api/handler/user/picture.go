@@ -10,20 +10,21 @@ import (
 	"github.com/werdna521/userland/service"
 )
 
-const fileLimit = 200 * 1024
-
 type setProfilePictureResponse struct {
 	Success bool `json:"success"`
 }
 
-func SetProfilePicture(us service.UserService) http.HandlerFunc {
+// SetProfilePicture accepts pictures of up to maxSize bytes
+func SetProfilePicture(us service.UserService, maxSize int64) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.ContentLength > fileLimit {
+		if r.ContentLength > maxSize {
 			response.Error(w, e.NewRequestEntityTooLargeError("file too large")).JSON()
 			return
 		}
 
-		r.ParseMultipartForm(200 * 1024 * 1024)
+		// the content length can be left out, the body can't go over it either way
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+		r.ParseMultipartForm(maxSize)
 		file, _, err := r.FormFile("file")
 		if err != nil {
 			response.Error(w, e.NewBadRequestError("cannot parse file")).JSON()
@@ -0,0 +1,284 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/api/validator"
+	"github.com/werdna521/userland/service"
+)
+
+type getTFAStatusResponse struct {
+	Success bool `json:"success"`
+	Enabled bool `json:"enabled"`
+}
+
+func GetTFAStatus(us service.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		enabled, err := us.GetTFAStatus(ctx, at.UserID)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &getTFAStatusResponse{
+			Success: true,
+			Enabled: enabled,
+		}).JSON()
+	}
+}
+
+type setupTFAResponse struct {
+	Success bool   `json:"success"`
+	Secret  string `json:"secret"`
+	URI     string `json:"uri"`
+}
+
+func SetupTFA(us service.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		secret, uri, err := us.SetupTFA(ctx, at.UserID)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &setupTFAResponse{
+			Success: true,
+			Secret:  secret,
+			URI:     uri,
+		}).JSON()
+	}
+}
+
+type enableTFARequest struct {
+	Code string `json:"code"`
+}
+
+type enableTFAResponse struct {
+	Success       bool     `json:"success"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func validateEnableTFARequest(req *enableTFARequest) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidateTFACode(req.Code)
+	if !ok {
+		fields["code"] = errMsg
+	}
+
+	return fields, len(fields) == 0
+}
+
+func EnableTFA(us service.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &enableTFARequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateEnableTFARequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		codes, err := us.EnableTFA(ctx, at.UserID, req.Code)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &enableTFAResponse{
+			Success:       true,
+			RecoveryCodes: codes,
+		}).JSON()
+	}
+}
+
+type disableTFARequest struct {
+	Password string `json:"password"`
+	// Code stands in for the password of users who don't have one
+	Code string `json:"code"`
+}
+
+type disableTFAResponse struct {
+	Success bool `json:"success"`
+}
+
+func validateDisableTFARequest(req *disableTFARequest) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	// accounts created through a social login have no password
+	if req.Password != "" || req.Code == "" {
+		errMsg, ok := validator.ValidatePasswordSimple(req.Password, "password")
+		if !ok {
+			fields["password"] = errMsg
+		}
+	}
+
+	if req.Code != "" {
+		errMsg, ok := validator.ValidateTFACode(req.Code)
+		if !ok {
+			fields["code"] = errMsg
+		}
+	}
+
+	return fields, len(fields) == 0
+}
+
+func DisableTFA(us service.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &disableTFARequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateDisableTFARequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		err = us.DisableTFA(ctx, at.UserID, req.Password, req.Code)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &disableTFAResponse{
+			Success: true,
+		}).JSON()
+	}
+}
+
+type getRecoveryCodesCountResponse struct {
+	Success   bool `json:"success"`
+	Remaining int  `json:"remaining"`
+}
+
+func GetRecoveryCodesCount(us service.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		remaining, err := us.GetRecoveryCodesCount(ctx, at.UserID)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &getRecoveryCodesCountResponse{
+			Success:   true,
+			Remaining: remaining,
+		}).JSON()
+	}
+}
+
+type regenerateRecoveryCodesRequest struct {
+	Password string `json:"password"`
+	// Code stands in for the password of users who don't have one
+	Code string `json:"code"`
+}
+
+type regenerateRecoveryCodesResponse struct {
+	Success       bool     `json:"success"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func validateRegenerateRecoveryCodesRequest(
+	req *regenerateRecoveryCodesRequest,
+) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	// accounts created through a social login have no password
+	if req.Password != "" || req.Code == "" {
+		errMsg, ok := validator.ValidatePasswordSimple(req.Password, "password")
+		if !ok {
+			fields["password"] = errMsg
+		}
+	}
+
+	if req.Code != "" {
+		errMsg, ok := validator.ValidateTFACode(req.Code)
+		if !ok {
+			fields["code"] = errMsg
+		}
+	}
+
+	return fields, len(fields) == 0
+}
+
+func RegenerateRecoveryCodes(us service.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &regenerateRecoveryCodesRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateRegenerateRecoveryCodesRequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		codes, err := us.RegenerateRecoveryCodes(ctx, at.UserID, req.Password, req.Code)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &regenerateRecoveryCodesResponse{
+			Success:       true,
+			RecoveryCodes: codes,
+		}).JSON()
+	}
+}
@@ -0,0 +1,178 @@
+package user
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/request"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/api/validator"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/service"
+)
+
+type personalAccessToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func toPersonalAccessToken(t *repository.PersonalAccessToken) *personalAccessToken {
+	res := &personalAccessToken{
+		ID:        t.ID,
+		Name:      t.Name,
+		Scopes:    t.Scopes,
+		CreatedAt: t.CreatedAt,
+	}
+	if t.ExpiresAt.Valid {
+		res.ExpiresAt = &t.ExpiresAt.Time
+	}
+	if t.LastUsedAt.Valid {
+		res.LastUsedAt = &t.LastUsedAt.Time
+	}
+
+	return res
+}
+
+type createPersonalAccessTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is optional, the token never expires without one
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type createPersonalAccessTokenResponse struct {
+	Success bool                 `json:"success"`
+	Token   *personalAccessToken `json:"token"`
+	// Value is only ever shown here
+	Value string `json:"value"`
+}
+
+func validateCreatePersonalAccessTokenRequest(
+	req *createPersonalAccessTokenRequest,
+) (map[string]string, bool) {
+	fields := map[string]string{}
+
+	errMsg, ok := validator.ValidatePATName(req.Name)
+	if !ok {
+		fields["name"] = errMsg
+	}
+
+	errMsg, ok = validator.ValidatePATScopes(req.Scopes)
+	if !ok {
+		fields["scopes"] = errMsg
+	}
+
+	return fields, len(fields) == 0
+}
+
+func CreatePersonalAccessToken(pats service.PersonalAccessTokenService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &createPersonalAccessTokenRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			response.Error(w, e.NewBadRequestError("cannot decode request body")).JSON()
+			return
+		}
+
+		fields, ok := validateCreatePersonalAccessTokenRequest(req)
+		if !ok {
+			response.Error(w, e.NewUnprocessableEntityError(fields)).JSON()
+			return
+		}
+
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		expiresAt := sql.NullTime{}
+		if req.ExpiresAt != nil {
+			expiresAt = sql.NullTime{Time: *req.ExpiresAt, Valid: true}
+		}
+
+		t, value, err := pats.CreatePersonalAccessToken(
+			ctx,
+			at.UserID,
+			req.Name,
+			req.Scopes,
+			expiresAt,
+		)
+		if err != nil {
+			response.Error(w, err.(e.Error)).JSON()
+			return
+		}
+
+		response.OK(w, &createPersonalAccessTokenResponse{
+			Success: true,
+			Token:   toPersonalAccessToken(t),
+			Value:   value,
+		}).JSON()
+	}
+}
+
+type listPersonalAccessTokensResponse struct {
+	Success bool                   `json:"success"`
+	Tokens  []*personalAccessToken `json:"tokens"`
+}
+
+func ListPersonalAccessTokens(pats service.PersonalAccessTokenService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		tokens, err := pats.ListPersonalAccessTokens(ctx, at.UserID)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		res := &listPersonalAccessTokensResponse{
+			Success: true,
+			Tokens:  []*personalAccessToken{},
+		}
+		for _, t := range tokens {
+			res.Tokens = append(res.Tokens, toPersonalAccessToken(t))
+		}
+
+		response.OK(w, res).JSON()
+	}
+}
+
+type revokePersonalAccessTokenResponse struct {
+	Success bool `json:"success"`
+}
+
+func RevokePersonalAccessToken(pats service.PersonalAccessTokenService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		at, err := request.GetAccessTokenFromCtx(ctx)
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		err = pats.RevokePersonalAccessToken(ctx, at.UserID, chi.URLParam(r, "id"))
+		if err != nil {
+			response.Error(w, err).JSON()
+			return
+		}
+
+		response.OK(w, &revokePersonalAccessTokenResponse{
+			Success: true,
+		}).JSON()
+	}
+}
@@ -0,0 +1,17 @@
+package wellknown
+
+import (
+	"net/http"
+
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/security/jwt"
+)
+
+// JWKS publishes the public signing keys, so other services can verify our
+// tokens without sharing any secret
+func JWKS(km *jwt.KeyManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		response.OK(w, km.JWKS()).JSON()
+	}
+}
@@ -0,0 +1,57 @@
+package wellknown
+
+import (
+	"fmt"
+	"net/http"
+
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/service"
+)
+
+type openIDConfigurationResponse struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// OpenIDConfiguration serves the OIDC discovery document of issuer
+func OpenIDConfiguration(issuer string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alg, err := jwt.SigningAlg()
+		if err != nil {
+			response.Error(w, e.NewInternalServerError()).JSON()
+			return
+		}
+
+		response.OK(w, &openIDConfigurationResponse{
+			Issuer:                            issuer,
+			AuthorizationEndpoint:             fmt.Sprintf("%s/oauth/authorize", issuer),
+			TokenEndpoint:                     fmt.Sprintf("%s/oauth/token", issuer),
+			UserInfoEndpoint:                  fmt.Sprintf("%s/oauth/userinfo", issuer),
+			JWKSURI:                           fmt.Sprintf("%s/.well-known/jwks.json", issuer),
+			ScopesSupported:                   service.OIDCSupportedScopes,
+			ResponseTypesSupported:            []string{"code"},
+			GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+			SubjectTypesSupported:             []string{"public"},
+			IDTokenSigningAlgValuesSupported:  []string{alg},
+			TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+			CodeChallengeMethodsSupported:     []string{service.CodeChallengeMethodS256},
+			ClaimsSupported: []string{
+				"sub", "iss", "aud", "exp", "iat", "nonce",
+				"name", "website", "picture", "email", "email_verified",
+			},
+		}).JSON()
+	}
+}
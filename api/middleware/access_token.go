@@ -2,87 +2,309 @@ package middleware
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	e "github.com/werdna521/userland/api/error"
 	"github.com/werdna521/userland/api/response"
 	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/postgres"
 	"github.com/werdna521/userland/repository/redis"
+	"github.com/werdna521/userland/security"
 	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/utils/clientinfo"
+	"github.com/werdna521/userland/utils/slice"
 )
 
 type AccessTokenKey string
 
 const AccessTokenCtxKey AccessTokenKey = "accesstoken"
 
-func ValidateAccessToken(sr redis.SessionRepository) middleware {
+// ValidateAccessToken lets through requests with a valid access token or
+// personal access token. both kinds end up in the context as a
+// jwt.AccessToken. access tokens of sessions granted to OAuth clients are
+// turned away, they only work on the routes behind ValidateOAuthAccessToken.
+func ValidateAccessToken(
+	sr redis.SessionRepository,
+	patr postgres.PersonalAccessTokenRepository,
+	rr postgres.RoleRepository,
+) middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authHeader := r.Header.Get("Authorization")
-
-			if authHeader == "" {
-				log.Error().Msg("No authorization header")
-				response.Error(w, e.NewUnauthorizedError("no token provided")).JSON()
+			token, err := readBearerToken(r)
+			if err != nil {
+				response.Error(w, err).JSON()
 				return
 			}
 
-			bearer := strings.Split(authHeader, " ")
-			if len(bearer) != 2 {
-				log.Error().Msg("Invalid authorization header")
-				response.Error(w, e.NewBadRequestError("bad authorization header format")).JSON()
-				return
+			ctx := r.Context()
+			var at *jwt.AccessToken
+			if strings.HasPrefix(token, security.PersonalAccessTokenPrefix) {
+				at, err = checkPersonalAccessToken(ctx, patr, rr, token, r.Method)
+			} else {
+				at, err = checkAccessToken(ctx, sr, token, false)
 			}
-
-			log.Info().Msg("parsing access token")
-			jwtString := bearer[1]
-			at, isValid, err := jwt.ParseAccessToken(jwtString)
-			if !isValid {
-				log.Error().Msg("Invalid access token")
-				response.Error(w, e.NewUnauthorizedError("invalid token")).JSON()
+			if err != nil {
+				response.Error(w, err).JSON()
 				return
 			}
+
+			ctx = context.WithValue(r.Context(), AccessTokenCtxKey, at)
+			ctx = clientinfo.WithSession(ctx, at.UserID, at.SessionID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ValidateOAuthAccessToken lets through requests with the access token of a
+// session, including the sessions granted to OAuth clients. it is meant for the
+// OAuth resource routes, which check the scope of the session themselves.
+func ValidateOAuthAccessToken(sr redis.SessionRepository) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := readBearerToken(r)
 			if err != nil {
-				log.Error().Err(err).Msg("failed to parse token")
-				response.Error(w, e.NewInternalServerError()).JSON()
+				response.Error(w, err).JSON()
 				return
 			}
 
-			log.Info().Msg("checking if token is valid")
 			ctx := r.Context()
-			tokenExists, err := sr.CheckAccessToken(ctx, &repository.AccessToken{
-				ID:        at.JTI,
-				SessionID: at.SessionID,
-				UserID:    at.UserID,
-			})
+			at, err := checkAccessToken(ctx, sr, token, true)
 			if err != nil {
-				log.Error().Err(err).Msg("failed to retrieve token from redis")
-				response.Error(w, e.NewInternalServerError()).JSON()
+				response.Error(w, err).JSON()
 				return
 			}
 
-			if !tokenExists {
-				log.Error().Msg("token does not exist")
+			ctx = context.WithValue(r.Context(), AccessTokenCtxKey, at)
+			ctx = clientinfo.WithSession(ctx, at.UserID, at.SessionID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func readBearerToken(r *http.Request) (string, e.Error) {
+	authHeader := r.Header.Get("Authorization")
+
+	if authHeader == "" {
+		log.Error().Msg("No authorization header")
+		return "", e.NewUnauthorizedError("no token provided")
+	}
+
+	bearer := strings.Split(authHeader, " ")
+	if len(bearer) != 2 {
+		log.Error().Msg("Invalid authorization header")
+		return "", e.NewBadRequestError("bad authorization header format")
+	}
+
+	return bearer[1], nil
+}
+
+// RequireSession keeps personal access tokens out of the routes that manage
+// the security of the account, only a user who logged in can use those. it has
+// to run after ValidateAccessToken.
+func RequireSession() middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			at, ok := r.Context().Value(AccessTokenCtxKey).(*jwt.AccessToken)
+			if !ok {
+				log.Error().Msg("access token not found in context")
 				response.Error(w, e.NewUnauthorizedError("invalid token")).JSON()
 				return
 			}
 
-			log.Info().Msg("checking session")
-			_, err = sr.GetSession(ctx, at.UserID, at.SessionID)
-			if _, ok := err.(repository.NotFoundError); ok {
-				log.Error().Msg("session does not exist")
-				response.Error(w, e.NewUnauthorizedError("invalid token")).JSON()
+			if at.PersonalAccessTokenID != "" {
+				log.Error().Msg("personal access token used on a session only route")
+				response.Error(w, e.NewForbiddenError("personal access tokens can't be used here")).JSON()
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ReadAccessToken is the lenient version of ValidateAccessToken for routes that
+// work with and without a signed in user
+func ReadAccessToken(sr redis.SessionRepository) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			jwtString := ""
+			if bearer := strings.Split(r.Header.Get("Authorization"), " "); len(bearer) == 2 {
+				jwtString = bearer[1]
+			}
+
+			if jwtString == "" {
+				next.ServeHTTP(w, r)
 				return
 			}
+
+			ctx := r.Context()
+			at, err := checkAccessToken(ctx, sr, jwtString, false)
 			if err != nil {
-				log.Error().Err(err).Msg("failed to retrieve session from redis")
-				response.Error(w, e.NewInternalServerError()).JSON()
+				next.ServeHTTP(w, r)
 				return
 			}
 
-			ctx = context.WithValue(r.Context(), AccessTokenCtxKey, at)
+			ctx = context.WithValue(ctx, AccessTokenCtxKey, at)
+			ctx = clientinfo.WithSession(ctx, at.UserID, at.SessionID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// checkSessionWatermark rejects sessions revoked through the user's session
+// watermark
+func checkSessionWatermark(
+	ctx context.Context,
+	sr redis.SessionRepository,
+	session *repository.Session,
+) e.Error {
+	log.Info().Msg("checking session watermark")
+	w, err := sr.GetSessionWatermark(ctx, session.UserID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		return nil
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve session watermark from redis")
+		return e.NewInternalServerError()
+	}
+
+	if w.Revokes(session) {
+		log.Error().Msg("session has been revoked by the watermark")
+		return e.NewUnauthorizedError("invalid token")
+	}
+
+	return nil
+}
+
+func checkAccessToken(
+	ctx context.Context,
+	sr redis.SessionRepository,
+	jwtString string,
+	allowOAuthGrants bool,
+) (*jwt.AccessToken, e.Error) {
+	log.Info().Msg("parsing access token")
+	at, isValid, err := jwt.ParseAccessToken(jwtString)
+	if !isValid {
+		log.Error().Msg("Invalid access token")
+		return nil, e.NewUnauthorizedError("invalid token")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse token")
+		return nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("checking if token is valid")
+	tokenExists, err := sr.CheckAccessToken(ctx, &repository.AccessToken{
+		ID:        at.JTI,
+		SessionID: at.SessionID,
+		UserID:    at.UserID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve token from redis")
+		return nil, e.NewInternalServerError()
+	}
+
+	if !tokenExists {
+		log.Error().Msg("token does not exist")
+		return nil, e.NewUnauthorizedError("invalid token")
+	}
+
+	log.Info().Msg("checking session")
+	session, err := sr.GetSession(ctx, at.UserID, at.SessionID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Msg("session does not exist")
+		return nil, e.NewUnauthorizedError("invalid token")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve session from redis")
+		return nil, e.NewInternalServerError()
+	}
+
+	if session.IsOAuthGrant() && !allowOAuthGrants {
+		log.Error().Msg("oauth client session used on a first-party route")
+		return nil, e.NewForbiddenError("oauth client tokens can't be used here")
+	}
+
+	checkErr := checkSessionWatermark(ctx, sr, session)
+	if checkErr != nil {
+		return nil, checkErr
+	}
+
+	// losing track of the last activity isn't worth failing the request over
+	log.Info().Msg("touching session")
+	err = sr.TouchSession(ctx, at.UserID, at.SessionID, clientinfo.FromContext(ctx).IP)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to touch session")
+	}
+
+	return at, nil
+}
+
+// checkPersonalAccessToken authenticates a personal access token. the access
+// token it returns has no session, the roles are the current ones of the user.
+func checkPersonalAccessToken(
+	ctx context.Context,
+	patr postgres.PersonalAccessTokenRepository,
+	rr postgres.RoleRepository,
+	token string,
+	method string,
+) (*jwt.AccessToken, e.Error) {
+	log.Info().Msg("retrieving personal access token")
+	pat, err := patr.GetPersonalAccessTokenByHash(ctx, security.HashToken(token))
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Msg("personal access token does not exist")
+		return nil, e.NewUnauthorizedError("invalid token")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve personal access token")
+		return nil, e.NewInternalServerError()
+	}
+
+	now := time.Now()
+	if pat.IsExpired(now) {
+		log.Error().Msg("personal access token has expired")
+		return nil, e.NewUnauthorizedError("invalid token")
+	}
+
+	// write covers read, so a token with write alone can still read
+	log.Info().Msg("checking personal access token scopes")
+	scope := repository.PATScopeWrite
+	if method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+		scope = repository.PATScopeRead
+	}
+	isAllowed := slice.AnyStr(pat.Scopes, func(s string) bool {
+		return s == scope || s == repository.PATScopeWrite
+	})
+	if !isAllowed {
+		log.Error().Msgf("personal access token is missing the %s scope", scope)
+		return nil, e.NewForbiddenError(fmt.Sprintf("token is missing the %s scope", scope))
+	}
+
+	log.Info().Msg("retrieving roles")
+	roles, err := rr.GetRolesByUserID(ctx, pat.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve roles")
+		return nil, e.NewInternalServerError()
+	}
+
+	// losing track of the last use isn't worth failing the request over
+	log.Info().Msg("touching personal access token")
+	err = patr.TouchPersonalAccessToken(ctx, pat.ID, now)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to touch personal access token")
+	}
+
+	return &jwt.AccessToken{
+		Value:                 token,
+		Type:                  "Bearer",
+		ExpiredAt:             pat.ExpiresAt.Time,
+		UserID:                pat.UserID,
+		Roles:                 roles,
+		PersonalAccessTokenID: pat.ID,
+		Scopes:                pat.Scopes,
+	}, nil
+}
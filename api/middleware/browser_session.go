@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/redis"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/utils/clientinfo"
+)
+
+// the cookie of a browser session is scoped to BrowserSessionCookiePath, so
+// browsers only ever send it to the authorization endpoint
+const (
+	BrowserSessionCookieName = "userland_browser_session"
+	BrowserSessionCookiePath = "/oauth/authorize"
+)
+
+// ReadBrowserSession signs the user in with their browser session cookie on
+// the routes a browser gets sent to, where there can't be a bearer token.
+// requests already carrying an access token, or without a valid cookie, go
+// through untouched.
+func ReadBrowserSession(sr redis.SessionRepository) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if _, ok := ctx.Value(AccessTokenCtxKey).(*jwt.AccessToken); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cookie, err := r.Cookie(BrowserSessionCookieName)
+			if err != nil || cookie.Value == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			at, checkErr := checkBrowserSession(ctx, sr, cookie.Value)
+			if checkErr != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx = context.WithValue(ctx, AccessTokenCtxKey, at)
+			ctx = clientinfo.WithSession(ctx, at.UserID, at.SessionID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// checkBrowserSession authenticates a browser session token. the access token
+// it returns only tells who the user is and which session they are on.
+func checkBrowserSession(
+	ctx context.Context,
+	sr redis.SessionRepository,
+	token string,
+) (*jwt.AccessToken, e.Error) {
+	log.Info().Msg("retrieving browser session")
+	bs, err := sr.GetBrowserSession(ctx, token)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Msg("browser session does not exist")
+		return nil, e.NewUnauthorizedError("invalid token")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve browser session from redis")
+		return nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("checking session")
+	session, err := sr.GetSession(ctx, bs.UserID, bs.SessionID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Msg("session does not exist")
+		return nil, e.NewUnauthorizedError("invalid token")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve session from redis")
+		return nil, e.NewInternalServerError()
+	}
+
+	if session.IsOAuthGrant() {
+		log.Error().Msg("browser session bound to an oauth client session")
+		return nil, e.NewForbiddenError("oauth client tokens can't be used here")
+	}
+
+	checkErr := checkSessionWatermark(ctx, sr, session)
+	if checkErr != nil {
+		return nil, checkErr
+	}
+
+	// losing track of the last activity isn't worth failing the request over
+	log.Info().Msg("touching session")
+	err = sr.TouchSession(ctx, bs.UserID, bs.SessionID, clientinfo.FromContext(ctx).IP)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to touch session")
+	}
+
+	return &jwt.AccessToken{
+		UserID:    bs.UserID,
+		SessionID: bs.SessionID,
+	}, nil
+}
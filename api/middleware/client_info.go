@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/werdna521/userland/utils/clientinfo"
+)
+
+// ParseTrustedProxies reads a list of IPs and CIDRs, like "10.0.0.0/8" or
+// "127.0.0.1"
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", p)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			p = fmt.Sprintf("%s/%d", p, bits)
+		}
+
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// ClientInfo attaches where the request came from to its context. the
+// X-Forwarded-For header is only believed when the request comes from one of
+// the trusted proxies, and then only up to the first hop that isn't one.
+func ClientInfo(trustedProxies []*net.IPNet) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				ip = r.RemoteAddr
+			}
+			ip = clientIP(ip, r.Header.Values("X-Forwarded-For"), trustedProxies)
+
+			ctx := clientinfo.NewContext(r.Context(), &clientinfo.ClientInfo{
+				IP:             ip,
+				UserAgent:      r.UserAgent(),
+				AcceptLanguage: r.Header.Get("Accept-Language"),
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// clientIP walks X-Forwarded-For from the right, every proxy appends the
+// address it got the request from. the first address that isn't a trusted
+// proxy is the client, anything left of it could have been made up.
+func clientIP(remoteIP string, forwardedFor []string, trustedProxies []*net.IPNet) string {
+	if !isTrustedProxy(remoteIP, trustedProxies) {
+		return remoteIP
+	}
+
+	hops := []string{}
+	for _, v := range forwardedFor {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	ip := remoteIP
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			break
+		}
+		ip = hops[i]
+		if !isTrustedProxy(ip, trustedProxies) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func isTrustedProxy(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, n := range trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
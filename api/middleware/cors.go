@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowedHeaders = "Authorization, Content-Type, Accept-Language"
+	corsMaxAge         = "600"
+)
+
+// CORS lets browsers on allowedOrigins call the API. "*" allows every origin,
+// and with no origins at all cross-origin requests are left to the browser to
+// block.
+func CORS(allowedOrigins []string) middleware {
+	allowAll := false
+	origins := map[string]bool{}
+	for _, o := range allowedOrigins {
+		if o == "*" {
+			allowAll = true
+		}
+		origins[strings.TrimSuffix(o, "/")] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// the answer depends on the origin, caches have to keep them apart
+			w.Header().Add("Vary", "Origin")
+			if !allowAll && !origins[origin] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+			isPreflight := r.Method == http.MethodOptions &&
+				r.Header.Get("Access-Control-Request-Method") != ""
+			if !isPreflight {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
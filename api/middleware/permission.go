@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/repository/postgres"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/utils/slice"
+)
+
+// RequirePermission only lets through users with a role that grants the
+// permission. it has to run after ValidateAccessToken, the roles are the ones
+// in the access token. a personal access token also needs the permission as
+// one of its scopes.
+func RequirePermission(rr postgres.RoleRepository, permission string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			at, ok := ctx.Value(AccessTokenCtxKey).(*jwt.AccessToken)
+			if !ok {
+				log.Error().Msg("access token not found in context")
+				response.Error(w, e.NewUnauthorizedError("invalid token")).JSON()
+				return
+			}
+
+			if at.PersonalAccessTokenID != "" {
+				hasScope := slice.AnyStr(at.Scopes, func(s string) bool {
+					return s == permission
+				})
+				if !hasScope {
+					log.Error().Msgf("personal access token is missing the %s scope", permission)
+					response.Error(w, e.NewForbiddenError("permission denied")).JSON()
+					return
+				}
+			}
+
+			log.Info().Msgf("checking %s permission", permission)
+			allowed, err := rr.HasPermission(ctx, at.Roles, permission)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to check permission")
+				response.Error(w, e.NewInternalServerError()).JSON()
+				return
+			}
+			if !allowed {
+				log.Error().Msgf("user is missing the %s permission", permission)
+				response.Error(w, e.NewForbiddenError("permission denied")).JSON()
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
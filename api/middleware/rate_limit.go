@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/api/response"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/utils/clientinfo"
+)
+
+// RateLimitStore is implemented by redis.BaseRateLimitRepository, and by
+// memory.RateLimitRepository for tests
+type RateLimitStore interface {
+	Hit(ctx context.Context, key string, limit int, window time.Duration) (*repository.RateLimit, error)
+}
+
+// RateLimitRule allows Limit requests per Window. Name has to be unique per
+// rule, it namespaces the counters.
+type RateLimitRule struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// RateLimitKeyFunc tells which bucket a request falls into. requests that
+// resolve to an empty key are not rate limited by the rule.
+type RateLimitKeyFunc func(r *http.Request) string
+
+func KeyByIP(r *http.Request) string {
+	return clientinfo.FromContext(r.Context()).IP
+}
+
+// KeyByUserID only works on routes behind ValidateAccessToken
+func KeyByUserID(r *http.Request) string {
+	at, ok := r.Context().Value(AccessTokenCtxKey).(*jwt.AccessToken)
+	if !ok {
+		return ""
+	}
+	return at.UserID
+}
+
+// maxKeyBodySize is more than the small JSON bodies KeyByBodyField is used on
+// ever need
+const maxKeyBodySize = 4 << 10
+
+// KeyByBodyField reads a string field from the JSON body, e.g. the email an
+// endpoint is about to send mails to. what has been read is put back in front
+// of the rest of the body, so the handler still gets all of it. bodies too
+// large to read fall back to the IP.
+func KeyByBodyField(field string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		if r.Body == nil {
+			return ""
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxKeyBodySize+1))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		if err != nil || len(body) > maxKeyBodySize {
+			return KeyByIP(r)
+		}
+
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return ""
+		}
+
+		val, _ := fields[field].(string)
+		return strings.ToLower(strings.TrimSpace(val))
+	}
+}
+
+func RateLimit(store RateLimitStore, rule RateLimitRule, keyFn RateLimitKeyFunc) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFn(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			rl, err := store.Hit(ctx, fmt.Sprintf("%s:%s", rule.Name, key), rule.Limit, rule.Window)
+			if err != nil {
+				// fail open, an outage of the rate limiter shouldn't take auth down with it
+				log.Error().Err(err).Msgf("failed to check rate limit %s", rule.Name)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reset := int64(math.Ceil(rl.Reset.Seconds()))
+			w.Header().Set("RateLimit-Limit", fmt.Sprint(rl.Limit))
+			w.Header().Set("RateLimit-Remaining", fmt.Sprint(rl.Remaining))
+			w.Header().Set("RateLimit-Reset", fmt.Sprint(reset))
+
+			if !rl.Allowed {
+				log.Error().Msgf("rate limit %s exceeded", rule.Name)
+				response.Error(w, e.NewTooManyRequestsError("too many requests", rl.Reset)).JSON()
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/werdna521/userland/repository"
 	"github.com/werdna521/userland/repository/redis"
 	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/utils/clientinfo"
 )
 
 type RefreshTokenKey string
@@ -49,24 +50,9 @@ func ValidateRefreshToken(sr redis.SessionRepository) middleware {
 				return
 			}
 
-			log.Info().Msg("checking if token is valid")
+			// whether the token is still the current one is checked by the session
+			// service while rotating it, so that a reused token can be detected
 			ctx := r.Context()
-			tokenExists, err := sr.CheckRefreshToken(ctx, &repository.RefreshToken{
-				ID:        rt.JTI,
-				SessionID: rt.SessionID,
-				UserID:    rt.UserID,
-			})
-			if err != nil {
-				log.Error().Err(err).Msg("failed to retrieve token from redis")
-				response.Error(w, e.NewInternalServerError()).JSON()
-				return
-			}
-
-			if !tokenExists {
-				log.Error().Msg("token does not exist")
-				response.Error(w, e.NewUnauthorizedError("invalid token")).JSON()
-				return
-			}
 
 			log.Info().Msg("checking session")
 			_, err = sr.GetSession(ctx, rt.UserID, rt.SessionID)
@@ -81,7 +67,14 @@ func ValidateRefreshToken(sr redis.SessionRepository) middleware {
 				return
 			}
 
+			log.Info().Msg("touching session")
+			err = sr.TouchSession(ctx, rt.UserID, rt.SessionID, clientinfo.FromContext(ctx).IP)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to touch session")
+			}
+
 			ctx = context.WithValue(r.Context(), RefreshTokenCtxKey, rt)
+			ctx = clientinfo.WithSession(ctx, rt.UserID, rt.SessionID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -0,0 +1,23 @@
+package request
+
+import "strconv"
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// ParseLimit reads the page size of a listing, falling back to the default
+// when it's missing
+func ParseLimit(s string) (int, bool) {
+	if s == "" {
+		return DefaultPageLimit, true
+	}
+
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 || limit > MaxPageLimit {
+		return 0, false
+	}
+
+	return limit, true
+}
@@ -1,6 +1,8 @@
 package response
 
 import (
+	"fmt"
+	"math"
 	"net/http"
 
 	e "github.com/werdna521/userland/api/error"
@@ -12,6 +14,11 @@ type baseErrorResponse struct {
 	Msg     string `json:"message"`
 }
 
+type oauthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
 type unprocessableEntityResponse struct {
 	Success bool              `json:"success"`
 	Fields  map[string]string `json:"fields"`
@@ -36,6 +43,29 @@ func Error(w http.ResponseWriter, err e.Error) httpResponse {
 				Fields:  err.Fields,
 			},
 		)
+	case client.TooManyRequestsError:
+		if err.RetryAfter > 0 {
+			retryAfter := int64(math.Ceil(err.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", fmt.Sprint(retryAfter))
+		}
+		return respondWithError(
+			w,
+			err,
+			baseErrorResponse{
+				Success: false,
+				Msg:     err.Error(),
+			},
+		)
+	case client.OAuthError:
+		w.Header().Set("Cache-Control", "no-store")
+		return respondWithError(
+			w,
+			err,
+			oauthErrorResponse{
+				Error:            err.Code,
+				ErrorDescription: err.Msg,
+			},
+		)
 	default:
 		return respondWithError(
 			w,
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"time"
+
+	"github.com/werdna521/userland/api/middleware"
+)
+
+// per-IP rules are loose enough for a handful of users behind the same NAT,
+// per-email rules protect the inbox (and our sendinblue quota) of a single user,
+// per-user rules keep a stolen access token from being used to guess the
+// password or tfa code it is missing
+var (
+	registerIPRule = middleware.RateLimitRule{
+		Name:   "register:ip",
+		Limit:  10,
+		Window: time.Hour,
+	}
+	registerEmailRule = middleware.RateLimitRule{
+		Name:   "register:email",
+		Limit:  3,
+		Window: time.Hour,
+	}
+	loginIPRule = middleware.RateLimitRule{
+		Name:   "login:ip",
+		Limit:  30,
+		Window: time.Minute,
+	}
+	verificationIPRule = middleware.RateLimitRule{
+		Name:   "verification:ip",
+		Limit:  10,
+		Window: time.Hour,
+	}
+	verificationEmailRule = middleware.RateLimitRule{
+		Name:   "verification:email",
+		Limit:  3,
+		Window: time.Hour,
+	}
+	forgotPasswordIPRule = middleware.RateLimitRule{
+		Name:   "forgotPassword:ip",
+		Limit:  10,
+		Window: time.Hour,
+	}
+	forgotPasswordEmailRule = middleware.RateLimitRule{
+		Name:   "forgotPassword:email",
+		Limit:  3,
+		Window: time.Hour,
+	}
+	resetPasswordIPRule = middleware.RateLimitRule{
+		Name:   "resetPassword:ip",
+		Limit:  10,
+		Window: time.Minute,
+	}
+	tfaIPRule = middleware.RateLimitRule{
+		Name:   "tfa:ip",
+		Limit:  10,
+		Window: time.Minute,
+	}
+	changePasswordUserRule = middleware.RateLimitRule{
+		Name:   "changePassword:user",
+		Limit:  5,
+		Window: time.Minute,
+	}
+	tfaUserRule = middleware.RateLimitRule{
+		Name:   "tfa:user",
+		Limit:  5,
+		Window: time.Minute,
+	}
+	unlockIPRule = middleware.RateLimitRule{
+		Name:   "unlock:ip",
+		Limit:  10,
+		Window: time.Minute,
+	}
+	oauthTokenIPRule = middleware.RateLimitRule{
+		Name:   "oauthToken:ip",
+		Limit:  30,
+		Window: time.Minute,
+	}
+	magicLinkIPRule = middleware.RateLimitRule{
+		Name:   "magicLink:ip",
+		Limit:  10,
+		Window: time.Hour,
+	}
+	magicLinkEmailRule = middleware.RateLimitRule{
+		Name:   "magicLink:email",
+		Limit:  3,
+		Window: time.Hour,
+	}
+	magicLinkRedeemIPRule = middleware.RateLimitRule{
+		Name:   "magicLinkRedeem:ip",
+		Limit:  10,
+		Window: time.Minute,
+	}
+	passkeyLoginIPRule = middleware.RateLimitRule{
+		Name:   "passkeyLogin:ip",
+		Limit:  30,
+		Window: time.Minute,
+	}
+	socialLoginIPRule = middleware.RateLimitRule{
+		Name:   "socialLogin:ip",
+		Limit:  20,
+		Window: time.Minute,
+	}
+	emailRevertIPRule = middleware.RateLimitRule{
+		Name:   "emailRevert:ip",
+		Limit:  10,
+		Window: time.Minute,
+	}
+	invitationIPRule = middleware.RateLimitRule{
+		Name:   "invitation:ip",
+		Limit:  10,
+		Window: time.Minute,
+	}
+)
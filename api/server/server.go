@@ -4,46 +4,100 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-redis/redis/v8"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/api/handler/admin"
+	"github.com/werdna521/userland/api/handler/audit"
 	"github.com/werdna521/userland/api/handler/auth"
+	"github.com/werdna521/userland/api/handler/oauth"
+	"github.com/werdna521/userland/api/handler/organization"
 	"github.com/werdna521/userland/api/handler/session"
 	"github.com/werdna521/userland/api/handler/user"
+	"github.com/werdna521/userland/api/handler/wellknown"
 	"github.com/werdna521/userland/api/middleware"
+	"github.com/werdna521/userland/config"
 	"github.com/werdna521/userland/mailer"
+	"github.com/werdna521/userland/mailer/queue"
+	"github.com/werdna521/userland/repository"
 	"github.com/werdna521/userland/repository/postgres"
 	rds "github.com/werdna521/userland/repository/redis"
+	"github.com/werdna521/userland/security"
+	"github.com/werdna521/userland/security/jwt"
 	"github.com/werdna521/userland/service"
+	"github.com/werdna521/userland/social"
+	"github.com/werdna521/userland/utils/clock"
+	"github.com/werdna521/userland/utils/geoip"
 )
 
+// shutdownTimeout is how long requests in flight get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
 type Server struct {
 	Config
 	mailer       mailer.Mailer
+	encrypter    security.Encrypter
+	keyManager   *jwt.KeyManager
+	providers    []social.Provider
+	webAuthn     *webauthn.WebAuthn
+	locator      geoip.Locator
 	DataSource   *DataSource
 	repositories *repositories
 	services     *services
 }
 
 type repositories struct {
-	ur  postgres.UserRepository
-	phr postgres.PasswordHistoryRepository
-	tr  rds.TokenRepository
-	sr  rds.SessionRepository
+	ur   postgres.UserRepository
+	phr  postgres.PasswordHistoryRepository
+	tfar postgres.TFARepository
+	rcr  postgres.RecoveryCodeRepository
+	ocr  postgres.OAuthClientRepository
+	uir  postgres.UserIdentityRepository
+	pkr  postgres.PasskeyRepository
+	rr   postgres.RoleRepository
+	or   postgres.OrganizationRepository
+	ivr  postgres.InvitationRepository
+	patr postgres.PersonalAccessTokenRepository
+	eor  postgres.EmailOutboxRepository
+	aer  postgres.AuditEventRepository
+	txm  postgres.TxManager
+	tr   rds.TokenRepository
+	sr   rds.SessionRepository
+	lar  rds.LoginAttemptRepository
+	rlr  rds.RateLimitRepository
 }
 
 type services struct {
-	as service.AuthService
-	ss service.SessionService
-	us service.UserService
+	as   service.AuthService
+	ss   service.SessionService
+	us   service.UserService
+	oas  service.OAuthService
+	aus  service.AuditService
+	rs   service.RoleService
+	ads  service.AdminService
+	ors  service.OrganizationService
+	pats service.PersonalAccessTokenService
 }
 
 type Config struct {
-	Port string
+	// App is the configuration the server was started with, the rest is parsed
+	// out of it
+	App *config.Config
+	// TrustedProxies are allowed to tell the client IP with X-Forwarded-For
+	TrustedProxies []*net.IPNet
+	// SessionPolicies decide which sessions survive sensitive account changes
+	SessionPolicies service.SessionPolicies
+	// SessionLimits caps how many sessions a user can have at once
+	SessionLimits service.SessionLimits
 }
 
 type DataSource struct {
@@ -51,10 +105,24 @@ type DataSource struct {
 	Redis    *redis.Client
 }
 
-func NewServer(config Config, mailer mailer.Mailer, dataSource *DataSource) *Server {
+func NewServer(
+	config Config,
+	mailer mailer.Mailer,
+	encrypter security.Encrypter,
+	keyManager *jwt.KeyManager,
+	providers []social.Provider,
+	webAuthn *webauthn.WebAuthn,
+	locator geoip.Locator,
+	dataSource *DataSource,
+) *Server {
 	return &Server{
 		Config:     config,
 		mailer:     mailer,
+		encrypter:  encrypter,
+		keyManager: keyManager,
+		providers:  providers,
+		webAuthn:   webAuthn,
+		locator:    locator,
 		DataSource: dataSource,
 	}
 }
@@ -66,12 +134,55 @@ func (s *Server) Start() {
 	log.Info().Msg("initializing services")
 	s.initServices()
 
+	// a failed bootstrap is tried again on the next start, it doesn't stop this one
+	if email := s.App.Server.BootstrapAdminEmail; email != "" {
+		log.Info().Msg("bootstrapping admin")
+		s.services.rs.BootstrapAdmin(context.Background(), email)
+	}
+
+	// the worker and the server both stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info().Msg("starting email outbox worker")
+	w := queue.NewWorker(s.repositories.eor, s.mailer, queue.WorkerConfig{})
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		w.Run(ctx)
+	}()
+
 	log.Info().Msg("initializing handlers")
-	h := s.initHandlers()
-	port := fmt.Sprintf(":%s", s.Port)
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%s", s.App.Server.Port),
+		Handler: s.initHandlers(),
+	}
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+
+		log.Info().Msg("shutting down server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("failed to shut down server")
+		}
+	}()
+
+	log.Info().Msgf("server running on port %s", srv.Addr)
+	err := srv.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Error().Err(err).Msg("server stopped")
+	}
 
-	log.Info().Msgf("server running on port %s", port)
-	http.ListenAndServe(port, h)
+	// ListenAndServe returns as soon as the shutdown starts, the requests in
+	// flight and the worker are waited for here. the worker also has to stop
+	// when the server couldn't start.
+	stop()
+	<-shutdownDone
+	<-workerDone
+	log.Info().Msg("email outbox worker stopped")
 }
 
 func (s *Server) initRepositories() {
@@ -81,104 +192,393 @@ func (s *Server) initRepositories() {
 	phr := postgres.NewBasePasswordHistoryRepository(s.DataSource.Postgres)
 	phr.PrepareStatements(context.Background())
 
-	tr := rds.NewBaseTokenRepository(s.DataSource.Redis)
+	tfar := postgres.NewBaseTFARepository(s.DataSource.Postgres)
+	tfar.PrepareStatements(context.Background())
+
+	rcr := postgres.NewBaseRecoveryCodeRepository(s.DataSource.Postgres)
+	rcr.PrepareStatements(context.Background())
+
+	ocr := postgres.NewBaseOAuthClientRepository(s.DataSource.Postgres)
+	ocr.PrepareStatements(context.Background())
+
+	uir := postgres.NewBaseUserIdentityRepository(s.DataSource.Postgres)
+	uir.PrepareStatements(context.Background())
+
+	pkr := postgres.NewBasePasskeyRepository(s.DataSource.Postgres)
+	pkr.PrepareStatements(context.Background())
+
+	rr := postgres.NewBaseRoleRepository(s.DataSource.Postgres)
+	rr.PrepareStatements(context.Background())
+
+	or := postgres.NewBaseOrganizationRepository(s.DataSource.Postgres)
+	or.PrepareStatements(context.Background())
+
+	ivr := postgres.NewBaseInvitationRepository(s.DataSource.Postgres)
+	ivr.PrepareStatements(context.Background())
+
+	patr := postgres.NewBasePersonalAccessTokenRepository(s.DataSource.Postgres)
+	patr.PrepareStatements(context.Background())
+
+	eor := postgres.NewBaseEmailOutboxRepository(s.DataSource.Postgres)
+	eor.PrepareStatements(context.Background())
+
+	aer := postgres.NewBaseAuditEventRepository(s.DataSource.Postgres)
+	aer.PrepareStatements(context.Background())
+
+	txm := postgres.NewBaseTxManager(s.DataSource.Postgres)
+
+	tr := rds.NewBaseTokenRepository(s.DataSource.Redis, s.App.Tokens)
 
 	sr := rds.NewBaseSessionRepository(s.DataSource.Redis)
 
+	lar := rds.NewBaseLoginAttemptRepository(s.DataSource.Redis)
+
+	rlr := rds.NewBaseRateLimitRepository(s.DataSource.Redis)
+
 	s.repositories = &repositories{
-		ur:  ur,
-		phr: phr,
-		tr:  tr,
-		sr:  sr,
+		ur:   ur,
+		phr:  phr,
+		tfar: tfar,
+		rcr:  rcr,
+		ocr:  ocr,
+		uir:  uir,
+		pkr:  pkr,
+		rr:   rr,
+		or:   or,
+		ivr:  ivr,
+		patr: patr,
+		eor:  eor,
+		aer:  aer,
+		txm:  txm,
+		tr:   tr,
+		sr:   sr,
+		lar:  lar,
+		rlr:  rlr,
 	}
 }
 
 func (s *Server) initServices() {
+	clk := clock.NewRealClock()
+
+	// services only queue mails, the outbox worker hands them to the transport
+	m := queue.NewMailer(s.repositories.eor)
+
+	hook := service.NewMailEventHook(s.repositories.tr, m, s.App)
+
 	as := service.NewBaseAuthService(
 		s.repositories.ur,
 		s.repositories.phr,
+		s.repositories.tfar,
+		s.repositories.rcr,
+		s.repositories.uir,
+		s.repositories.pkr,
+		s.repositories.rr,
 		s.repositories.tr,
 		s.repositories.sr,
-		s.mailer,
+		s.repositories.patr,
+		s.repositories.lar,
+		s.repositories.aer,
+		s.repositories.txm,
+		m,
+		hook,
+		s.SessionPolicies,
+		s.SessionLimits,
+		s.encrypter,
+		s.providers,
+		s.webAuthn,
+		clk,
+		s.App,
 	)
 
-	ss := service.NewBaseSessionService(s.repositories.sr)
+	ss := service.NewBaseSessionService(
+		s.repositories.sr,
+		s.repositories.rr,
+		s.repositories.or,
+		s.repositories.aer,
+		s.locator,
+		s.App,
+	)
 
 	us := service.NewBaseUserService(
 		s.repositories.ur,
 		s.repositories.phr,
+		s.repositories.tfar,
+		s.repositories.rcr,
+		s.repositories.uir,
+		s.repositories.pkr,
 		s.repositories.tr,
 		s.repositories.sr,
-		s.mailer,
+		s.repositories.patr,
+		s.repositories.aer,
+		m,
+		hook,
+		s.SessionPolicies,
+		s.encrypter,
+		clk,
+		s.App,
+	)
+
+	oas := service.NewBaseOAuthService(
+		s.repositories.ur,
+		s.repositories.ocr,
+		s.repositories.rr,
+		s.repositories.tr,
+		s.repositories.sr,
+		ss,
+		s.SessionLimits,
+		s.App,
+	)
+
+	aus := service.NewBaseAuditService(s.repositories.aer)
+
+	rs := service.NewBaseRoleService(s.repositories.ur, s.repositories.rr, s.repositories.aer)
+
+	ads := service.NewBaseAdminService(
+		s.repositories.ur,
+		s.repositories.rr,
+		s.repositories.aer,
+		s.repositories.sr,
+		s.repositories.patr,
+		as,
+		hook,
+		s.locator,
+		clk,
+	)
+
+	ors := service.NewBaseOrganizationService(
+		s.repositories.or,
+		s.repositories.ivr,
+		s.repositories.ur,
+		s.repositories.aer,
+		s.repositories.txm,
+		as,
+		m,
+		clk,
+		s.App,
+	)
+
+	pats := service.NewBasePersonalAccessTokenService(
+		s.repositories.patr,
+		s.repositories.aer,
+		clk,
 	)
 
 	s.services = &services{
-		as: as,
-		ss: ss,
-		us: us,
+		as:   as,
+		ss:   ss,
+		us:   us,
+		oas:  oas,
+		aus:  aus,
+		rs:   rs,
+		ads:  ads,
+		ors:  ors,
+		pats: pats,
 	}
 }
 
 func (s *Server) initHandlers() http.Handler {
+	validateAccessToken := middleware.ValidateAccessToken(
+		s.repositories.sr,
+		s.repositories.patr,
+		s.repositories.rr,
+	)
+
 	r := chi.NewRouter()
+	r.Use(middleware.CORS(s.App.CORS.AllowedOrigins))
+	r.Use(middleware.ClientInfo(s.TrustedProxies))
+
+	r.Get("/.well-known/jwks.json", wellknown.JWKS(s.keyManager))
+	r.Get("/.well-known/openid-configuration", wellknown.OpenIDConfiguration(s.App.Server.BaseURL))
+
+	r.Route("/oauth", func(r chi.Router) {
+		r.With(
+			middleware.ReadAccessToken(s.repositories.sr),
+			middleware.ReadBrowserSession(s.repositories.sr),
+		).Get("/authorize", oauth.Authorize(s.services.oas))
+		r.With(
+			middleware.RateLimit(s.repositories.rlr, oauthTokenIPRule, middleware.KeyByIP),
+		).Post("/token", oauth.Token(s.services.oas))
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.ValidateOAuthAccessToken(s.repositories.sr))
+			r.Get("/userinfo", oauth.UserInfo(s.services.oas))
+			r.Post("/userinfo", oauth.UserInfo(s.services.oas))
+		})
+	})
 
 	r.Route("/api/v1", func(r chi.Router) {
 		r.Route("/auth", func(r chi.Router) {
-			r.Post("/register", auth.Register(s.services.as))
-			r.Post("/login", auth.Login(s.services.as))
+			rlr := s.repositories.rlr
+
+			r.With(
+				middleware.RateLimit(rlr, registerIPRule, middleware.KeyByIP),
+				middleware.RateLimit(rlr, registerEmailRule, middleware.KeyByBodyField("email")),
+			).Post("/register", auth.Register(s.services.as, s.App.Password))
+			r.With(
+				middleware.RateLimit(rlr, loginIPRule, middleware.KeyByIP),
+			).Post("/login", auth.Login(s.services.as))
+			r.With(
+				middleware.RateLimit(rlr, unlockIPRule, middleware.KeyByIP),
+			).Get("/unlock", auth.UnlockAccount(s.services.as))
 
 			r.Route("/verification", func(r chi.Router) {
 				r.Get("/", auth.VerifyEmail(s.services.as))
-				r.Post("/", auth.SendVerification(s.services.as))
+				r.With(
+					middleware.RateLimit(rlr, verificationIPRule, middleware.KeyByIP),
+					middleware.RateLimit(rlr, verificationEmailRule, middleware.KeyByBodyField("recipient")),
+				).Post("/", auth.SendVerification(s.services.as))
 			})
 
 			r.Route("/password", func(r chi.Router) {
-				r.Post("/forgot", auth.ForgotPassword(s.services.as))
-				r.Post("/reset", auth.ResetPassword(s.services.as))
+				r.With(
+					middleware.RateLimit(rlr, forgotPasswordIPRule, middleware.KeyByIP),
+					middleware.RateLimit(rlr, forgotPasswordEmailRule, middleware.KeyByBodyField("email")),
+				).Post("/forgot", auth.ForgotPassword(s.services.as))
+				r.With(
+					middleware.RateLimit(rlr, resetPasswordIPRule, middleware.KeyByIP),
+				).Post("/reset", auth.ResetPassword(s.services.as, s.App.Password))
+			})
+
+			r.Route("/tfa", func(r chi.Router) {
+				r.With(
+					middleware.RateLimit(rlr, tfaIPRule, middleware.KeyByIP),
+				).Post("/verify", auth.VerifyTFA(s.services.as))
+			})
+
+			r.Route("/magic-link", func(r chi.Router) {
+				r.With(
+					middleware.RateLimit(rlr, magicLinkIPRule, middleware.KeyByIP),
+					middleware.RateLimit(rlr, magicLinkEmailRule, middleware.KeyByBodyField("email")),
+				).Post("/", auth.RequestMagicLink(s.services.as))
+				r.With(
+					middleware.RateLimit(rlr, magicLinkRedeemIPRule, middleware.KeyByIP),
+				).Post("/redeem", auth.RedeemMagicLink(s.services.as))
+			})
+
+			r.Route("/passkey/login", func(r chi.Router) {
+				r.Use(middleware.RateLimit(rlr, passkeyLoginIPRule, middleware.KeyByIP))
+
+				r.Post("/begin", auth.BeginPasskeyLogin(s.services.as))
+				r.Post("/finish", auth.FinishPasskeyLogin(s.services.as))
+			})
+
+			r.Route("/social/{provider}", func(r chi.Router) {
+				r.Use(middleware.RateLimit(rlr, socialLoginIPRule, middleware.KeyByIP))
+
+				r.Post("/", auth.StartSocialLogin(s.services.as))
+				r.Get("/callback", auth.SocialLoginCallback(s.services.as))
 			})
 		})
 
 		r.Route("/me", func(r chi.Router) {
 			r.Group(func(r chi.Router) {
-				r.Use(middleware.ValidateAccessToken(s.repositories.sr))
+				r.Use(validateAccessToken)
 
 				r.Get("/", user.GetInfoDetail(s.services.us))
 				r.Post("/", user.UpdateBasicInfo(s.services.us))
 			})
 
 			r.Route("/email", func(r chi.Router) {
-				r.Use(middleware.ValidateAccessToken(s.repositories.sr))
+				r.Use(validateAccessToken)
+				r.Use(middleware.RequireSession())
 
 				r.Get("/", user.GetCurrentEmailAddress(s.services.us))
 				r.Post("/", user.RequestEmailAddressChange(s.services.us))
 			})
 
+			r.Route("/locale", func(r chi.Router) {
+				r.Use(validateAccessToken)
+
+				r.Get("/", user.GetLocale(s.services.us))
+				r.Post("/", user.UpdateLocale(s.services.us))
+			})
+
 			r.Route("/password", func(r chi.Router) {
-				r.Use(middleware.ValidateAccessToken(s.repositories.sr))
+				r.Use(validateAccessToken)
+				r.Use(middleware.RequireSession())
 
-				r.Post("/", user.ChangePassword(s.services.us))
+				r.With(
+					middleware.RateLimit(s.repositories.rlr, changePasswordUserRule, middleware.KeyByUserID),
+				).Post("/", user.ChangePassword(s.services.us, s.App.Password))
 			})
 
 			r.Route("/picture", func(r chi.Router) {
-				r.Use(middleware.ValidateAccessToken(s.repositories.sr))
+				r.Use(validateAccessToken)
 
-				r.Post("/", user.SetProfilePicture(s.services.us))
+				r.Post("/", user.SetProfilePicture(s.services.us, s.App.Upload.MaxPictureSize))
 				r.Delete("/", user.DeleteProfilePicture(s.services.us))
 			})
 
+			r.Route("/identities", func(r chi.Router) {
+				r.Use(validateAccessToken)
+				r.Use(middleware.RequireSession())
+
+				r.Get("/", user.ListIdentities(s.services.as))
+				r.Post("/{provider}", user.LinkIdentity(s.services.as))
+				r.Delete("/{provider}", user.UnlinkIdentity(s.services.as))
+			})
+
+			r.Route("/passkeys", func(r chi.Router) {
+				r.Use(validateAccessToken)
+				r.Use(middleware.RequireSession())
+
+				r.Get("/", user.ListPasskeys(s.services.as))
+				r.Post("/register/begin", user.BeginPasskeyRegistration(s.services.as))
+				r.Post("/register/finish", user.FinishPasskeyRegistration(s.services.as))
+				r.Patch("/{id}", user.RenamePasskey(s.services.as))
+				r.Delete("/{id}", user.DeletePasskey(s.services.as))
+			})
+
+			r.Route("/tfa", func(r chi.Router) {
+				r.Use(validateAccessToken)
+				r.Use(middleware.RequireSession())
+
+				r.Get("/", user.GetTFAStatus(s.services.us))
+				r.Post("/setup", user.SetupTFA(s.services.us))
+				r.Get("/recovery_codes", user.GetRecoveryCodesCount(s.services.us))
+
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RateLimit(s.repositories.rlr, tfaUserRule, middleware.KeyByUserID))
+
+					r.Post("/enable", user.EnableTFA(s.services.us))
+					r.Post("/disable", user.DisableTFA(s.services.us))
+					r.Post("/recovery_codes", user.RegenerateRecoveryCodes(s.services.us))
+				})
+			})
+
+			r.Route("/activity", func(r chi.Router) {
+				r.Use(validateAccessToken)
+
+				r.Get("/", audit.ListActivity(s.services.aus))
+			})
+
+			r.Route("/tokens", func(r chi.Router) {
+				r.Use(validateAccessToken)
+				r.Use(middleware.RequireSession())
+
+				r.Get("/", user.ListPersonalAccessTokens(s.services.pats))
+				r.Post("/", user.CreatePersonalAccessToken(s.services.pats))
+				r.Delete("/{id}", user.RevokePersonalAccessToken(s.services.pats))
+			})
+
 			r.Route("/delete", func(r chi.Router) {
-				r.Use(middleware.ValidateAccessToken(s.repositories.sr))
+				r.Use(validateAccessToken)
+				r.Use(middleware.RequireSession())
 
 				r.Post("/", user.DeleteAccount(s.services.us))
 			})
 
 			r.Route("/session", func(r chi.Router) {
-				r.Use(middleware.ValidateAccessToken(s.repositories.sr))
+				r.Use(validateAccessToken)
+				r.Use(middleware.RequireSession())
 
 				r.Get("/", session.ListSessions(s.services.ss))
 				r.Delete("/", session.EndCurrentSession(s.services.ss))
 				r.Delete("/other", session.DeleteAllOtherSessions(s.services.ss))
+				r.Delete("/{id}", session.DeleteSession(s.services.ss))
 				r.Post("/refresh_token", session.GenerateRefreshToken(s.services.ss))
+				r.Post("/organization", session.SwitchOrganization(s.services.ss))
+				r.Post("/browser", session.CreateBrowserSession(s.services.ss, s.App.Tokens.BrowserSession))
 			})
 
 			r.Group(func(r chi.Router) {
@@ -188,6 +588,68 @@ func (s *Server) initHandlers() http.Handler {
 
 			r.Group(func(r chi.Router) {
 				r.Get("/email/verification", user.VerifyEmailChange(s.services.us))
+				r.With(
+					middleware.RateLimit(s.repositories.rlr, emailRevertIPRule, middleware.KeyByIP),
+				).Get("/email/revert", user.RevertEmailChange(s.services.us))
+			})
+		})
+
+		r.Route("/organizations", func(r chi.Router) {
+			r.Use(validateAccessToken)
+
+			r.Get("/", organization.ListOrganizations(s.services.ors))
+			r.Post("/", organization.CreateOrganization(s.services.ors))
+			r.Get("/{id}", organization.GetOrganization(s.services.ors))
+			r.Patch("/{id}", organization.UpdateOrganization(s.services.ors))
+			r.Delete("/{id}", organization.DeleteOrganization(s.services.ors))
+
+			r.Get("/{id}/members", organization.ListMembers(s.services.ors))
+			r.Post("/{id}/members", organization.AddMember(s.services.ors))
+			r.Patch("/{id}/members/{userID}", organization.UpdateMemberRole(s.services.ors))
+			r.Delete("/{id}/members/{userID}", organization.RemoveMember(s.services.ors))
+
+			r.Get("/{id}/invitations", organization.ListInvitations(s.services.ors))
+			r.Post("/{id}/invitations", organization.CreateInvitation(s.services.ors))
+			r.Post(
+				"/{id}/invitations/{invitationID}/resend",
+				organization.ResendInvitation(s.services.ors),
+			)
+			r.Delete("/{id}/invitations/{invitationID}", organization.RevokeInvitation(s.services.ors))
+		})
+
+		r.Route("/invitations", func(r chi.Router) {
+			r.Use(middleware.RateLimit(s.repositories.rlr, invitationIPRule, middleware.KeyByIP))
+
+			r.Get("/", organization.GetInvitation(s.services.ors))
+			r.Post("/accept", organization.AcceptInvitation(s.services.ors, s.App.Password))
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(validateAccessToken)
+
+			r.With(
+				middleware.RequirePermission(s.repositories.rr, repository.PermissionAuditRead),
+			).Get("/audit-events", audit.ListAuditEvents(s.services.aus))
+
+			r.Route("/users", func(r chi.Router) {
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RequirePermission(s.repositories.rr, repository.PermissionUsersRead))
+
+					r.Get("/", admin.ListUsers(s.services.ads))
+					r.Get("/{id}", admin.GetUser(s.services.ads))
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RequirePermission(s.repositories.rr, repository.PermissionUsersWrite))
+
+					r.Post("/{id}/deactivate", admin.DeactivateUser(s.services.ads))
+					r.Post("/{id}/reactivate", admin.ReactivateUser(s.services.ads))
+					r.Post("/{id}/verification", admin.ForceEmailVerification(s.services.ads))
+					r.Post("/{id}/password-reset", admin.SendPasswordReset(s.services.ads))
+					r.Delete("/{id}/sessions", admin.EndAllSessions(s.services.ads))
+					r.Delete("/{id}", admin.DeleteUser(s.services.ads))
+					r.Post("/{id}/restore", admin.RestoreUser(s.services.ads))
+				})
 			})
 		})
 	})
@@ -1,6 +1,12 @@
 package validator
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/werdna521/userland/config"
+	"github.com/werdna521/userland/security/totp"
+)
 
 const (
 	emailMaxChars  = 128
@@ -30,32 +36,41 @@ func ValidateEmail(email string) (string, bool) {
 }
 
 const (
-	passwordMinChars  = 8
-	passwordMaxChars  = 128
+	passwordMaxChars  = config.MaxPasswordLength
 	passwordFieldname = "password"
 )
 
+// ValidatePasswordSimple checks an existing password. it doesn't know about
+// the password policy, which may have changed since the password was set.
 func ValidatePasswordSimple(password string, fieldname string) (string, bool) {
 	errMsg, ok := validateStringRequired(password, fieldname)
 	if !ok {
 		return errMsg, false
 	}
 
-	errMsg, ok = validateStringMinChars(password, passwordMinChars, fieldname)
+	errMsg, ok = validateStringMaxChars(password, passwordMaxChars, fieldname)
 	if !ok {
 		return errMsg, false
 	}
 
-	errMsg, ok = validateStringMaxChars(password, passwordMaxChars, fieldname)
+	return "", true
+}
+
+// ValidatePassword checks a new password against the password policy. the
+// current password only goes through ValidatePasswordSimple, it may predate
+// the policy.
+func ValidatePassword(password string, policy config.Password) (string, bool) {
+	errMsg, ok := validateStringRequired(password, passwordFieldname)
 	if !ok {
 		return errMsg, false
 	}
 
-	return "", true
-}
+	errMsg, ok = validateStringMinChars(password, policy.MinLength, passwordFieldname)
+	if !ok {
+		return errMsg, false
+	}
 
-func ValidatePassword(password string) (string, bool) {
-	errMsg, ok := ValidatePasswordSimple(password, passwordFieldname)
+	errMsg, ok = validateStringMaxChars(password, policy.MaxLength, passwordFieldname)
 	if !ok {
 		return errMsg, false
 	}
@@ -63,8 +78,11 @@ func ValidatePassword(password string) (string, bool) {
 	// doing this means we'll have a complexity of O(3n). there are other ways to
 	// do this that would only cost O(n), but I decided to go with this since it's
 	// more readable and easier to follow.
-	if !hasLowercase(password) || !hasUppercase(password) || !hasNumber(password) {
-		return "password should have at least 1 uppercase character, 1 lowercase character and 1 number", false
+	if policy.RequireMixedCase && (!hasLowercase(password) || !hasUppercase(password)) {
+		return "password should have at least 1 uppercase character and 1 lowercase character", false
+	}
+	if policy.RequireNumber && !hasNumber(password) {
+		return "password should have at least 1 number", false
 	}
 
 	return "", true
@@ -75,7 +93,7 @@ const (
 )
 
 func ValidatePasswordConfirm(password string, passwordConfirm string) (string, bool) {
-	errMsg, ok := ValidatePasswordSimple(passwordConfirm, passwordConfirmFieldname)
+	errMsg, ok := validateStringRequired(passwordConfirm, passwordConfirmFieldname)
 	if !ok {
 		return errMsg, false
 	}
@@ -137,3 +155,39 @@ func ValidateToken(token string) (string, bool) {
 
 	return "", true
 }
+
+const (
+	tfaCodeFieldname = "code"
+)
+
+func ValidateTFACode(code string) (string, bool) {
+	errMsg, ok := validateStringRequired(code, tfaCodeFieldname)
+	if !ok {
+		return errMsg, false
+	}
+
+	if len(code) != totp.Digits || !isNumeric(code) {
+		return fmt.Sprintf("%s should be %d digits", tfaCodeFieldname, totp.Digits), false
+	}
+
+	return "", true
+}
+
+const (
+	recoveryCodeFieldname = "recovery_code"
+	recoveryCodeMaxChars  = 32
+)
+
+func ValidateRecoveryCode(code string) (string, bool) {
+	errMsg, ok := validateStringRequired(code, recoveryCodeFieldname)
+	if !ok {
+		return errMsg, false
+	}
+
+	errMsg, ok = validateStringMaxChars(code, recoveryCodeMaxChars, recoveryCodeFieldname)
+	if !ok {
+		return errMsg, false
+	}
+
+	return "", true
+}
@@ -0,0 +1,40 @@
+package validator
+
+import (
+	"github.com/werdna521/userland/repository"
+)
+
+const (
+	organizationNameMaxChars  = 128
+	organizationNameFieldname = "name"
+)
+
+func ValidateOrganizationName(name string) (string, bool) {
+	errMsg, ok := validateStringRequired(name, organizationNameFieldname)
+	if !ok {
+		return errMsg, false
+	}
+
+	errMsg, ok = validateStringMaxChars(name, organizationNameMaxChars, organizationNameFieldname)
+	if !ok {
+		return errMsg, false
+	}
+
+	return "", true
+}
+
+const orgRoleFieldname = "role"
+
+func ValidateOrgRole(role string) (string, bool) {
+	errMsg, ok := validateStringRequired(role, orgRoleFieldname)
+	if !ok {
+		return errMsg, false
+	}
+
+	switch role {
+	case repository.OrgRoleOwner, repository.OrgRoleAdmin, repository.OrgRoleMember:
+		return "", true
+	default:
+		return "role must be one of owner, admin and member", false
+	}
+}
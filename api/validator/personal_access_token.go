@@ -0,0 +1,53 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/utils/slice"
+)
+
+const (
+	patNameMaxChars  = 64
+	patNameFieldname = "name"
+)
+
+func ValidatePATName(name string) (string, bool) {
+	errMsg, ok := validateStringRequired(name, patNameFieldname)
+	if !ok {
+		return errMsg, false
+	}
+
+	errMsg, ok = validateStringMaxChars(name, patNameMaxChars, patNameFieldname)
+	if !ok {
+		return errMsg, false
+	}
+
+	return "", true
+}
+
+func ValidatePATScopes(scopes []string) (string, bool) {
+	if len(scopes) == 0 {
+		return "scopes is required", false
+	}
+
+	seen := map[string]bool{}
+	for _, scope := range scopes {
+		isKnown := slice.AnyStr(repository.PATScopes, func(s string) bool {
+			return s == scope
+		})
+		if !isKnown {
+			return fmt.Sprintf(
+				"scopes must be some of %s",
+				strings.Join(repository.PATScopes, ", "),
+			), false
+		}
+		if seen[scope] {
+			return "scopes must not repeat", false
+		}
+		seen[scope] = true
+	}
+
+	return "", true
+}
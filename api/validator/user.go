@@ -1,5 +1,12 @@
 package validator
 
+import (
+	"fmt"
+	"strings"
+
+	"github.com/werdna521/userland/mailer"
+)
+
 const (
 	fullnameMinChars  = 3
 	fullnameMaxChars  = 128
@@ -66,3 +73,32 @@ func ValidateWeb(web string) (string, bool) {
 
 	return "", true
 }
+
+const (
+	passkeyNicknameMaxChars  = 64
+	passkeyNicknameFieldname = "nickname"
+)
+
+func ValidatePasskeyNickname(nickname string) (string, bool) {
+	errMsg, ok := validateStringRequired(nickname, passkeyNicknameFieldname)
+	if !ok {
+		return errMsg, false
+	}
+
+	errMsg, ok = validateStringMaxChars(nickname, passkeyNicknameMaxChars, passkeyNicknameFieldname)
+	if !ok {
+		return errMsg, false
+	}
+
+	return "", true
+}
+
+// ValidateLocale accepts an empty locale, which means mails follow the
+// language of the request
+func ValidateLocale(locale string) (string, bool) {
+	if locale != "" && !mailer.IsSupportedLocale(locale) {
+		return fmt.Sprintf("locale must be one of %s", strings.Join(mailer.Locales, ", ")), false
+	}
+
+	return "", true
+}
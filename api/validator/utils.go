@@ -26,3 +26,12 @@ func hasNumber(val string) bool {
 	}
 	return false
 }
+
+func isNumeric(val string) bool {
+	for _, v := range val {
+		if v < '0' || v > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+// mailpreview renders every mail template in every locale with sample data,
+// and writes the results out so they can be checked in a browser or a text
+// editor before they go out to users.
+//
+//	go run ./cmd/mailpreview -out mailpreview
+//	go run ./cmd/mailpreview -templates ./my-templates -locale id -template new_login
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/werdna521/userland/mailer"
+)
+
+func main() {
+	templatesDir := flag.String("templates", "", "directory with template overrides, the embedded templates are used when empty")
+	outDir := flag.String("out", "mailpreview", "directory to write the previews to")
+	locale := flag.String("locale", "", "only render this locale")
+	name := flag.String("template", "", "only render this template")
+	flag.Parse()
+
+	err := run(*templatesDir, *outDir, *locale, *name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(templatesDir string, outDir string, locale string, name string) error {
+	t, err := mailer.LoadTemplates(templatesDir)
+	if err != nil {
+		return fmt.Errorf("failed to load templates: %w", err)
+	}
+
+	rendered := 0
+	for _, l := range mailer.Locales {
+		if locale != "" && l != locale {
+			continue
+		}
+
+		for _, n := range mailer.TemplateNames {
+			if name != "" && n != name {
+				continue
+			}
+
+			msg, err := t.Preview(n, l)
+			if err != nil {
+				return fmt.Errorf("failed to render %s/%s: %w", l, n, err)
+			}
+
+			dir := filepath.Join(outDir, l)
+			err = os.MkdirAll(dir, 0o755)
+			if err != nil {
+				return err
+			}
+
+			err = os.WriteFile(filepath.Join(dir, n+".html"), []byte(msg.HTMLContent), 0o644)
+			if err != nil {
+				return err
+			}
+
+			text := fmt.Sprintf("Subject: %s\n\n%s", msg.Subject, msg.TextContent)
+			err = os.WriteFile(filepath.Join(dir, n+".txt"), []byte(text), 0o644)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("%s/%s: %s\n", l, n, msg.Subject)
+			rendered++
+		}
+	}
+
+	if rendered == 0 {
+		return fmt.Errorf("no template matched")
+	}
+
+	return nil
+}
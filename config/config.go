@@ -0,0 +1,239 @@
+package config
+
+import (
+	"time"
+
+	"github.com/werdna521/userland/mailer"
+)
+
+// Config is everything the server can be configured with. every field has a
+// key, used in config files (nested by section, like server.base_url) and as
+// a flag (-server.base_url), and most of them an environment variable too. the
+// env tag of a section is a prefix for the variables of its fields.
+type Config struct {
+	Server   Server   `key:"server"`
+	Postgres Postgres `key:"postgres"`
+	Redis    Redis    `key:"redis"`
+	JWT      JWT      `key:"jwt"`
+	Security Security `key:"security"`
+	Tokens   Tokens   `key:"tokens"`
+	Password Password `key:"password"`
+	Upload   Upload   `key:"upload"`
+	CORS     CORS     `key:"cors"`
+	Mail     Mail     `key:"mail"`
+	Sessions Sessions `key:"sessions"`
+	Social   Social   `key:"social"`
+	WebAuthn WebAuthn `key:"webauthn"`
+	GeoIP    GeoIP    `key:"geoip"`
+}
+
+type Server struct {
+	Port string `key:"port" env:"API_PORT"`
+	// BaseURL is where the API can be reached publicly, links in mails and
+	// the OIDC issuer are built from it
+	BaseURL string `key:"base_url" env:"BASE_URL"`
+	// TrustedProxies are allowed to tell the client IP with X-Forwarded-For
+	TrustedProxies []string `key:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	// BootstrapAdminEmail becomes the first admin once they have registered
+	// and verified the address. it's ignored once there is an admin.
+	BootstrapAdminEmail string `key:"bootstrap_admin_email" env:"BOOTSTRAP_ADMIN_EMAIL"`
+}
+
+type Postgres struct {
+	Username string `key:"username" env:"POSTGRES_USER"`
+	Password string `key:"password" env:"POSTGRES_PASSWORD"`
+	Addr     string `key:"addr" env:"POSTGRES_ADDR"`
+	Database string `key:"database" env:"POSTGRES_DB"`
+}
+
+type Redis struct {
+	Addr     string `key:"addr" env:"REDIS_ADDR"`
+	Password string `key:"password" env:"REDIS_PASSWORD"`
+}
+
+type JWT struct {
+	Secret       string `key:"secret" env:"JWT_SECRET"`
+	KeysDir      string `key:"keys_dir" env:"JWT_KEYS_DIR"`
+	SigningKeyID string `key:"signing_key_id" env:"JWT_SIGNING_KEY_ID"`
+	// AcceptLegacyTokens keeps accepting the tokens signed with Secret once
+	// KeysDir is set, while the tokens issued before the switch run out
+	AcceptLegacyTokens bool `key:"accept_legacy_tokens" env:"JWT_ACCEPT_LEGACY_TOKENS"`
+}
+
+type Security struct {
+	TFASecretKey string `key:"tfa_secret_key" env:"TFA_SECRET_KEY"`
+	LogPath      string `key:"log_path" env:"SECURITY_LOG_PATH"`
+}
+
+// Tokens are how long every kind of token stays valid
+type Tokens struct {
+	AccessToken  time.Duration `key:"access_token" env:"ACCESS_TOKEN_TTL"`
+	RefreshToken time.Duration `key:"refresh_token" env:"REFRESH_TOKEN_TTL"`
+	IDToken      time.Duration `key:"id_token" env:"ID_TOKEN_TTL"`
+	// Verification covers email verification, password reset and email
+	// change tokens
+	Verification time.Duration `key:"verification" env:"VERIFICATION_TOKEN_TTL"`
+	// unlock links are sent by email, so they need to outlive the lockout itself
+	Unlock time.Duration `key:"unlock" env:"UNLOCK_TOKEN_TTL"`
+	// authorization codes are exchanged by the client right after the redirect
+	AuthorizationCode time.Duration `key:"authorization_code" env:"AUTHORIZATION_CODE_TTL"`
+	// users may need to sign in at the provider before they get redirected back
+	SocialLoginState time.Duration `key:"social_login_state" env:"SOCIAL_LOGIN_STATE_TTL"`
+	// magic links are sent by email and only good for a single login
+	MagicLink time.Duration `key:"magic_link" env:"MAGIC_LINK_TTL"`
+	// the browser prompt between the begin and finish steps of a passkey
+	// ceremony
+	PasskeyCeremony time.Duration `key:"passkey_ceremony" env:"PASSKEY_CEREMONY_TTL"`
+	// the old address has to be able to undo an email change for a while, the
+	// owner might not be reading their mail every day
+	EmailChangeRevert time.Duration `key:"email_change_revert" env:"EMAIL_CHANGE_REVERT_TTL"`
+	// invitations wait for someone who may not have an account yet
+	Invitation time.Duration `key:"invitation" env:"INVITATION_TTL"`
+	// the cookie /oauth/authorize reads, it stops working along with its
+	// session anyway
+	BrowserSession time.Duration `key:"browser_session" env:"BROWSER_SESSION_TTL"`
+}
+
+// MaxPasswordLength is the longest password that is ever accepted, whatever
+// the policy was when it was set
+const MaxPasswordLength = 128
+
+// Password is the policy new passwords have to comply with
+type Password struct {
+	MinLength        int  `key:"min_length" env:"PASSWORD_MIN_LENGTH"`
+	MaxLength        int  `key:"max_length" env:"PASSWORD_MAX_LENGTH"`
+	RequireMixedCase bool `key:"require_mixed_case" env:"PASSWORD_REQUIRE_MIXED_CASE"`
+	RequireNumber    bool `key:"require_number" env:"PASSWORD_REQUIRE_NUMBER"`
+	// History is how many of the previous passwords can't be used again
+	History int `key:"history" env:"PASSWORD_HISTORY"`
+}
+
+type Upload struct {
+	// MaxPictureSize is in bytes
+	MaxPictureSize int64 `key:"max_picture_size" env:"UPLOAD_MAX_PICTURE_SIZE"`
+}
+
+type CORS struct {
+	// AllowedOrigins may be "*" to allow any origin
+	AllowedOrigins []string `key:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+}
+
+type Mail struct {
+	Transport string `key:"transport" env:"MAIL_TRANSPORT"`
+	// the sender used to be configured for sendinblue only
+	SenderName       string `key:"sender_name" env:"MAIL_SENDER_NAME,SENDINBLUE_SENDER_NAME"`
+	SenderEmail      string `key:"sender_email" env:"MAIL_SENDER_EMAIL,SENDINBLUE_SENDER_EMAIL"`
+	SendinblueAPIKey string `key:"sendinblue_api_key" env:"SENDINBLUE_API_KEY"`
+	SMTPHost         string `key:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort         string `key:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername     string `key:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword     string `key:"smtp_password" env:"SMTP_PASSWORD"`
+	SMTPRequireTLS   bool   `key:"smtp_require_tls" env:"SMTP_REQUIRE_TLS"`
+	OutboxDir        string `key:"outbox_dir" env:"MAIL_OUTBOX_DIR"`
+	// templates not found in the directory fall back to the embedded ones
+	TemplatesDir string `key:"templates_dir" env:"MAIL_TEMPLATES_DIR"`
+}
+
+// MailerConfig is the config of the mail transport
+func (m Mail) MailerConfig() mailer.Config {
+	return mailer.Config{
+		Transport:      m.Transport,
+		SenderName:     m.SenderName,
+		SenderEmail:    m.SenderEmail,
+		APIKey:         m.SendinblueAPIKey,
+		SMTPHost:       m.SMTPHost,
+		SMTPPort:       m.SMTPPort,
+		SMTPUsername:   m.SMTPUsername,
+		SMTPPassword:   m.SMTPPassword,
+		SMTPRequireTLS: m.SMTPRequireTLS,
+		OutboxDir:      m.OutboxDir,
+	}
+}
+
+// Sessions holds the session policies and limits, the ones left empty keep
+// the defaults of the session service
+type Sessions struct {
+	PolicyOnPasswordChange string `key:"policy_password_change" env:"SESSION_POLICY_PASSWORD_CHANGE"`
+	PolicyOnPasswordReset  string `key:"policy_password_reset" env:"SESSION_POLICY_PASSWORD_RESET"`
+	PolicyOnEmailChange    string `key:"policy_email_change" env:"SESSION_POLICY_EMAIL_CHANGE"`
+	// MaxPerUser of 0 leaves the number of sessions unlimited
+	MaxPerUser  int    `key:"max_per_user" env:"MAX_SESSIONS_PER_USER"`
+	LimitPolicy string `key:"limit_policy" env:"SESSION_LIMIT_POLICY"`
+}
+
+// Social holds the login providers, the ones without a client ID are off
+type Social struct {
+	Google SocialProvider `key:"google" env:"GOOGLE"`
+	GitHub SocialProvider `key:"github" env:"GITHUB"`
+	OIDC   OIDCProvider   `key:"oidc"`
+}
+
+// SocialProvider is read from <PROVIDER>_CLIENT_ID and <PROVIDER>_CLIENT_SECRET
+type SocialProvider struct {
+	ClientID     string `key:"client_id" env:"CLIENT_ID"`
+	ClientSecret string `key:"client_secret" env:"CLIENT_SECRET"`
+}
+
+type OIDCProvider struct {
+	Name         string `key:"name" env:"OIDC_PROVIDER_NAME"`
+	IssuerURL    string `key:"issuer_url" env:"OIDC_ISSUER_URL"`
+	ClientID     string `key:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret string `key:"client_secret" env:"OIDC_CLIENT_SECRET"`
+}
+
+// WebAuthn is the relying party passkeys are registered to. the RP ID has to
+// be the domain (or a parent domain) of every origin.
+type WebAuthn struct {
+	RPID string `key:"rp_id" env:"WEBAUTHN_RP_ID"`
+	// RPOrigins default to the origin of the base URL
+	RPOrigins []string `key:"rp_origins" env:"WEBAUTHN_RP_ORIGINS"`
+}
+
+type GeoIP struct {
+	// sessions just go without a location when there's no database
+	DBPath string `key:"db_path" env:"GEOIP_DB_PATH"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:    "3000",
+			BaseURL: "http://localhost:3000",
+		},
+		Tokens: Tokens{
+			AccessToken:       5 * time.Minute,
+			RefreshToken:      7 * 24 * time.Hour,
+			IDToken:           5 * time.Minute,
+			Verification:      5 * time.Minute,
+			Unlock:            time.Hour,
+			AuthorizationCode: time.Minute,
+			SocialLoginState:  10 * time.Minute,
+			MagicLink:         15 * time.Minute,
+			PasskeyCeremony:   5 * time.Minute,
+			EmailChangeRevert: 7 * 24 * time.Hour,
+			Invitation:        7 * 24 * time.Hour,
+			BrowserSession:    7 * 24 * time.Hour,
+		},
+		Password: Password{
+			MinLength:        8,
+			MaxLength:        128,
+			RequireMixedCase: true,
+			RequireNumber:    true,
+			History:          3,
+		},
+		Upload: Upload{
+			MaxPictureSize: 200 * 1024,
+		},
+		Mail: Mail{
+			Transport: mailer.TransportSendinblue,
+		},
+		Social: Social{
+			OIDC: OIDCProvider{
+				Name: "oidc",
+			},
+		},
+		WebAuthn: WebAuthn{
+			RPID: "localhost",
+		},
+	}
+}
@@ -0,0 +1,260 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.yaml.in/yaml/v3"
+)
+
+// Load builds the config out of, from the highest precedence to the lowest,
+// the flags in args, the environment, the config file and the defaults. the
+// config file is picked with -config or CONFIG_FILE, and is read as YAML or
+// TOML going by its extension. the config is validated before it's returned.
+func Load(args []string) (*Config, error) {
+	c := Default()
+	fields := c.fields()
+
+	fs := flag.NewFlagSet("userland", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flagValues := map[string]*string{}
+	for _, f := range fields {
+		flagValues[f.key] = fs.String(f.key, "", f.usage())
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		err = loadFile(*path, fields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config file %s: %w", *path, err)
+		}
+	}
+
+	// docker compose passes every variable along, set or not, so empty ones
+	// count as unset
+	for _, f := range fields {
+		for _, name := range f.env {
+			v := os.Getenv(name)
+			if v == "" {
+				continue
+			}
+
+			err = f.set(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			break
+		}
+	}
+
+	fs.Visit(func(fl *flag.Flag) {
+		f, ok := findField(fields, fl.Name)
+		if !ok || err != nil {
+			return
+		}
+
+		err = f.set(*flagValues[fl.Name])
+		if err != nil {
+			err = fmt.Errorf("invalid -%s: %w", fl.Name, err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.fillDerived()
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// fillDerived fills the settings that default to something based on another
+// setting
+func (c *Config) fillDerived() {
+	c.Server.BaseURL = strings.TrimSuffix(c.Server.BaseURL, "/")
+
+	if len(c.WebAuthn.RPOrigins) == 0 {
+		c.WebAuthn.RPOrigins = []string{c.Server.BaseURL}
+	}
+}
+
+// field is a single setting of the config, sections aren't fields
+type field struct {
+	key   string
+	env   []string
+	value reflect.Value
+}
+
+func (f *field) usage() string {
+	if len(f.env) == 0 {
+		return f.key
+	}
+
+	return fmt.Sprintf("%s (env %s)", f.key, strings.Join(f.env, ", "))
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses s into the field. lists are comma separated.
+func (f *field) set(s string) error {
+	s = strings.TrimSpace(s)
+
+	if f.value.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(n)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(s, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.value.Type())
+	}
+
+	return nil
+}
+
+// fields lists every setting of c, pointing into c
+func (c *Config) fields() []*field {
+	return collectFields(reflect.ValueOf(c).Elem(), "", "")
+}
+
+func collectFields(v reflect.Value, keyPrefix string, envPrefix string) []*field {
+	fields := []*field{}
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		key := keyPrefix + sf.Tag.Get("key")
+
+		env := []string{}
+		for _, name := range strings.Split(sf.Tag.Get("env"), ",") {
+			if name != "" {
+				env = append(env, envPrefix+name)
+			}
+		}
+
+		if sf.Type.Kind() == reflect.Struct && sf.Type != durationType {
+			sectionEnvPrefix := envPrefix
+			if len(env) > 0 {
+				sectionEnvPrefix = env[0] + "_"
+			}
+			fields = append(fields, collectFields(v.Field(i), key+".", sectionEnvPrefix)...)
+			continue
+		}
+
+		fields = append(fields, &field{
+			key:   key,
+			env:   env,
+			value: v.Field(i),
+		})
+	}
+
+	return fields
+}
+
+func findField(fields []*field, key string) (*field, bool) {
+	for _, f := range fields {
+		if f.key == key {
+			return f, true
+		}
+	}
+
+	return nil, false
+}
+
+func loadFile(path string, fields []*field) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{}
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unknown config file type %q, expected .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return err
+	}
+
+	return setFromMap(values, "", fields)
+}
+
+// setFromMap walks the sections of the file down to the settings, anything
+// that isn't a known setting is refused, it's most likely a typo
+func setFromMap(values map[string]interface{}, keyPrefix string, fields []*field) error {
+	for k, v := range values {
+		key := keyPrefix + k
+
+		if section, ok := v.(map[string]interface{}); ok {
+			err := setFromMap(section, key+".", fields)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		f, ok := findField(fields, key)
+		if !ok {
+			return fmt.Errorf("unknown setting %s", key)
+		}
+
+		s := fmt.Sprint(v)
+		if items, ok := v.([]interface{}); ok {
+			list := []string{}
+			for _, item := range items {
+				list = append(list, fmt.Sprint(item))
+			}
+			s = strings.Join(list, ",")
+		}
+
+		err := f.set(s)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Validate reports every invalid setting at once, so they can all be fixed
+// before the next start
+func (c *Config) Validate() error {
+	errs := []error{}
+	invalid := func(key string, format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s %s", key, fmt.Sprintf(format, a...)))
+	}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	if err != nil || port < 1 || port > 65535 {
+		invalid("server.port", "must be a port number, got %q", c.Server.Port)
+	}
+	if !isOrigin(c.Server.BaseURL, true) {
+		invalid("server.base_url", "must be an absolute http(s) URL, got %q", c.Server.BaseURL)
+	}
+
+	ttls := []struct {
+		key string
+		ttl time.Duration
+	}{
+		{"tokens.access_token", c.Tokens.AccessToken},
+		{"tokens.refresh_token", c.Tokens.RefreshToken},
+		{"tokens.id_token", c.Tokens.IDToken},
+		{"tokens.verification", c.Tokens.Verification},
+		{"tokens.unlock", c.Tokens.Unlock},
+		{"tokens.authorization_code", c.Tokens.AuthorizationCode},
+		{"tokens.social_login_state", c.Tokens.SocialLoginState},
+		{"tokens.magic_link", c.Tokens.MagicLink},
+		{"tokens.passkey_ceremony", c.Tokens.PasskeyCeremony},
+		{"tokens.email_change_revert", c.Tokens.EmailChangeRevert},
+		{"tokens.invitation", c.Tokens.Invitation},
+		{"tokens.browser_session", c.Tokens.BrowserSession},
+	}
+	for _, t := range ttls {
+		if t.ttl <= 0 {
+			invalid(t.key, "must be positive")
+		}
+	}
+	if c.Tokens.RefreshToken < c.Tokens.AccessToken {
+		invalid("tokens.refresh_token", "can't be shorter than tokens.access_token")
+	}
+
+	if c.Password.MinLength < 1 {
+		invalid("password.min_length", "must be at least 1")
+	}
+	if c.Password.MaxLength < c.Password.MinLength {
+		invalid("password.max_length", "can't be less than password.min_length")
+	}
+	if c.Password.MaxLength > MaxPasswordLength {
+		invalid("password.max_length", "can't be more than %d", MaxPasswordLength)
+	}
+	if c.Password.History < 0 {
+		invalid("password.history", "can't be negative")
+	}
+
+	if c.Upload.MaxPictureSize <= 0 {
+		invalid("upload.max_picture_size", "must be positive")
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin != "*" && !isOrigin(origin, false) {
+			invalid("cors.allowed_origins", "must be * or origins like https://example.com, got %q", origin)
+		}
+	}
+
+	if c.Sessions.MaxPerUser < 0 {
+		invalid("sessions.max_per_user", "can't be negative")
+	}
+
+	for _, origin := range c.WebAuthn.RPOrigins {
+		if !isOrigin(origin, false) {
+			invalid("webauthn.rp_origins", "must be origins like https://example.com, got %q", origin)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// isOrigin checks for an absolute http(s) URL, with no path unless allowPath
+func isOrigin(s string, allowPath bool) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	if !allowPath && u.Path != "" && u.Path != "/" {
+		return false
+	}
+
+	return u.RawQuery == "" && u.Fragment == ""
+}
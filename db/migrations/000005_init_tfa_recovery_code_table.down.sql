@@ -0,0 +1 @@
+DROP TABLE IF EXISTS tfa_recovery_code;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS oauth_client_redirect_uri;
+DROP TABLE IF EXISTS oauth_client;
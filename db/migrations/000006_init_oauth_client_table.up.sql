@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS oauth_client (
+  id VARCHAR(64) PRIMARY KEY,
+  name VARCHAR(128) NOT NULL,
+  secret TEXT,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oauth_client_redirect_uri (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  client_id VARCHAR(64) NOT NULL,
+  redirect_uri TEXT NOT NULL,
+
+  CONSTRAINT fk_oauth_client FOREIGN KEY(client_id) REFERENCES oauth_client(id) ON DELETE CASCADE,
+  UNIQUE(client_id, redirect_uri)
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS user_identity (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  user_id UUID NOT NULL,
+  provider VARCHAR(64) NOT NULL,
+  subject TEXT NOT NULL,
+  email TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+
+  CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES "user"(id) ON DELETE CASCADE,
+  UNIQUE(provider, subject),
+  UNIQUE(user_id, provider)
+);
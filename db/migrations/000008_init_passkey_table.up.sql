@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS passkey (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  user_id UUID NOT NULL,
+  credential_id BYTEA NOT NULL UNIQUE,
+  public_key BYTEA NOT NULL,
+  attestation_type VARCHAR(32) NOT NULL,
+  aaguid BYTEA NOT NULL,
+  sign_count BIGINT NOT NULL DEFAULT 0,
+  transports TEXT NOT NULL DEFAULT '',
+  backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+  backup_state BOOLEAN NOT NULL DEFAULT FALSE,
+  nickname VARCHAR(64) NOT NULL,
+  last_used_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+
+  CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES "user"(id) ON DELETE CASCADE
+);
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS audit_event (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  actor_id UUID,
+  user_id UUID,
+  session_id TEXT NOT NULL,
+  ip TEXT NOT NULL,
+  user_agent TEXT NOT NULL,
+  type VARCHAR(64) NOT NULL,
+  metadata JSONB NOT NULL DEFAULT '{}',
+  created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_event_user_id_idx ON audit_event(user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_event_type_idx ON audit_event(type, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_event_created_at_idx ON audit_event(created_at DESC, id DESC);
+
+-- the audit log is append-only, rows can't be changed or removed once written
+CREATE OR REPLACE FUNCTION audit_event_append_only() RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_event_append_only
+BEFORE UPDATE OR DELETE ON audit_event
+FOR EACH ROW EXECUTE PROCEDURE audit_event_append_only();
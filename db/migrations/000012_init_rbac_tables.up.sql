@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS role (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name VARCHAR(64) NOT NULL UNIQUE,
+  description TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS permission (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name VARCHAR(64) NOT NULL UNIQUE,
+  description TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS role_permission (
+  role_id UUID NOT NULL,
+  permission_id UUID NOT NULL,
+
+  PRIMARY KEY(role_id, permission_id),
+  CONSTRAINT fk_role FOREIGN KEY(role_id) REFERENCES role(id) ON DELETE CASCADE,
+  CONSTRAINT fk_permission FOREIGN KEY(permission_id) REFERENCES permission(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_role (
+  user_id UUID NOT NULL,
+  role_id UUID NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+
+  PRIMARY KEY(user_id, role_id),
+  CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES "user"(id) ON DELETE CASCADE,
+  CONSTRAINT fk_role FOREIGN KEY(role_id) REFERENCES role(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS user_role_role_id_idx ON user_role(role_id);
+
+INSERT INTO role(name, description)
+VALUES ('admin', 'Has access to the admin endpoints')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO permission(name, description)
+VALUES ('audit:read', 'Read the audit log of every user')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permission(role_id, permission_id)
+SELECT role.id, permission.id
+FROM role, permission
+WHERE role.name = 'admin' AND permission.name = 'audit:read'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+ALTER TABLE "user"
+ADD COLUMN deactivated_at TIMESTAMP;
@@ -0,0 +1,2 @@
+DELETE FROM permission
+WHERE name IN ('users:read', 'users:write');
@@ -0,0 +1,11 @@
+INSERT INTO permission(name, description)
+VALUES
+  ('users:read', 'List and view every user'),
+  ('users:write', 'Deactivate, delete and restore users and end their sessions')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permission(role_id, permission_id)
+SELECT role.id, permission.id
+FROM role, permission
+WHERE role.name = 'admin' AND permission.name IN ('users:read', 'users:write')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS membership;
+DROP TABLE IF EXISTS organization;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS organization (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name VARCHAR(128) NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS membership (
+  organization_id UUID NOT NULL,
+  user_id UUID NOT NULL,
+  role VARCHAR(16) NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+
+  PRIMARY KEY(organization_id, user_id),
+  CONSTRAINT fk_organization FOREIGN KEY(organization_id) REFERENCES organization(id) ON DELETE CASCADE,
+  CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES "user"(id) ON DELETE CASCADE,
+  CONSTRAINT membership_role_check CHECK (role IN ('owner', 'admin', 'member'))
+);
+CREATE INDEX IF NOT EXISTS membership_user_id_idx ON membership(user_id);
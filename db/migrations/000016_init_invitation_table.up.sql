@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS invitation (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  organization_id UUID NOT NULL,
+  email VARCHAR(128) NOT NULL,
+  role VARCHAR(16) NOT NULL,
+  token_id VARCHAR(64) NOT NULL,
+  invited_by UUID,
+  expires_at TIMESTAMP NOT NULL,
+  accepted_at TIMESTAMP,
+  revoked_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+
+  CONSTRAINT fk_organization FOREIGN KEY(organization_id) REFERENCES organization(id) ON DELETE CASCADE,
+  CONSTRAINT fk_invited_by FOREIGN KEY(invited_by) REFERENCES "user"(id) ON DELETE SET NULL,
+  CONSTRAINT invitation_role_check CHECK (role IN ('owner', 'admin', 'member'))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS invitation_pending_email_idx ON invitation(organization_id, email)
+  WHERE accepted_at IS NULL AND revoked_at IS NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE user_tfa
+DROP COLUMN last_used_step;
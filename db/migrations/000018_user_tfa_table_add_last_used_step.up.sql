@@ -0,0 +1,2 @@
+ALTER TABLE user_tfa
+ADD COLUMN last_used_step BIGINT NOT NULL DEFAULT 0;
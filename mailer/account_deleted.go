@@ -0,0 +1,12 @@
+package mailer
+
+import "context"
+
+func SendAccountDeletedMail(
+	ctx context.Context,
+	m Mailer,
+	to Email,
+	locale string,
+) error {
+	return send(ctx, m, to, locale, TemplateAccountDeleted, nil)
+}
@@ -0,0 +1,19 @@
+package mailer
+
+import "context"
+
+type accountLockedData struct {
+	Link string
+}
+
+func SendAccountLockedMail(
+	ctx context.Context,
+	m Mailer,
+	to Email,
+	locale string,
+	link string,
+) error {
+	return send(ctx, m, to, locale, TemplateAccountLocked, &accountLockedData{
+		Link: link,
+	})
+}
@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"context"
+	"time"
+)
+
+type emailChangedData struct {
+	NewEmail   string
+	RevertLink string
+	RevertDays int
+	Time       time.Time
+}
+
+// SendEmailChangedMail goes to the old address, with a link to undo the change
+func SendEmailChangedMail(
+	ctx context.Context,
+	m Mailer,
+	to Email,
+	locale string,
+	newEmail string,
+	revertLink string,
+	revertLife time.Duration,
+	changedAt time.Time,
+) error {
+	return send(ctx, m, to, locale, TemplateEmailChanged, &emailChangedData{
+		NewEmail:   newEmail,
+		RevertLink: revertLink,
+		RevertDays: int(revertLife.Hours() / 24),
+		Time:       changedAt,
+	})
+}
@@ -1,22 +1,19 @@
 package mailer
 
-import (
-	"context"
-	"fmt"
-)
+import "context"
+
+type emailVerificationData struct {
+	Link string
+}
 
 func SendEmailVerificationMail(
 	ctx context.Context,
 	m Mailer,
 	to Email,
+	locale string,
 	link string,
 ) error {
-	mo := &MailOptions{
-		To:          []Email{to},
-		Subject:     "Verify your email",
-		HTMLContent: fmt.Sprintf(emailVerificationTemplate, link),
-		TextContent: "Hi Userlanders, please verify your email",
-	}
-
-	return m.SendMail(ctx, mo)
+	return send(ctx, m, to, locale, TemplateEmailVerification, &emailVerificationData{
+		Link: link,
+	})
 }
@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"context"
+	"time"
+)
+
+type invitationData struct {
+	Organization string
+	InvitedBy    string
+	Link         string
+	ExpiresDays  int
+}
+
+// SendInvitationMail invites the address into an organization, whether it
+// belongs to a user already or not
+func SendInvitationMail(
+	ctx context.Context,
+	m Mailer,
+	to Email,
+	locale string,
+	organization string,
+	invitedBy string,
+	link string,
+	expiresIn time.Duration,
+) error {
+	return send(ctx, m, to, locale, TemplateInvitation, &invitationData{
+		Organization: organization,
+		InvitedBy:    invitedBy,
+		Link:         link,
+		ExpiresDays:  int(expiresIn.Hours() / 24),
+	})
+}
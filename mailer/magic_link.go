@@ -0,0 +1,26 @@
+package mailer
+
+import (
+	"context"
+	"time"
+)
+
+type magicLinkData struct {
+	Link string
+	// in minutes
+	ExpiresIn int
+}
+
+func SendMagicLinkMail(
+	ctx context.Context,
+	m Mailer,
+	to Email,
+	locale string,
+	link string,
+	expiresIn time.Duration,
+) error {
+	return send(ctx, m, to, locale, TemplateMagicLink, &magicLinkData{
+		Link:      link,
+		ExpiresIn: int(expiresIn.Minutes()),
+	})
+}
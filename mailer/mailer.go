@@ -1,47 +1,38 @@
 package mailer
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"net/http"
-
-	"github.com/rs/zerolog/log"
+	"fmt"
 )
 
-const sendinblueAPIURL = "https://api.sendinblue.com/v3/smtp/email"
+// transports that can be picked with Config.Transport
+const (
+	TransportSendinblue = "sendinblue"
+	TransportSMTP       = "smtp"
+	TransportOutbox     = "outbox"
+	TransportMemory     = "memory"
+)
 
 type Mailer interface {
 	SendMail(ctx context.Context, mo *MailOptions) error
 }
 
-type BaseMailer struct {
-	Sender Email
-	APIKey string
-}
-
-func NewBaseMailer(config Config) *BaseMailer {
-	return &BaseMailer{
-		Sender: Email{
-			Name:  config.SenderName,
-			Email: config.SenderEmail,
-		},
-		APIKey: config.APIKey,
-	}
-}
-
+// Config holds the settings of every transport, only the ones of the selected
+// transport are used. sendinblue is used when no transport is set.
 type Config struct {
+	Transport   string
 	SenderName  string
 	SenderEmail string
-	APIKey      string
-}
 
-type MailerBody struct {
-	Sender      Email   `json:"sender"`
-	To          []Email `json:"to"`
-	HTMLContent string  `json:"htmlContent"`
-	TextContent string  `json:"textContent"`
-	Subject     string  `json:"subject"`
+	APIKey string
+
+	SMTPHost       string
+	SMTPPort       string
+	SMTPUsername   string
+	SMTPPassword   string
+	SMTPRequireTLS bool
+
+	OutboxDir string
 }
 
 type Email struct {
@@ -54,42 +45,44 @@ type MailOptions struct {
 	HTMLContent string
 	TextContent string
 	Subject     string
+	// IdempotencyKey stays the same when a mail is retried, transports use it
+	// to keep the receiving end from delivering the mail twice. optional.
+	IdempotencyKey string
 }
 
-func (m *BaseMailer) SendMail(ctx context.Context, mo *MailOptions) error {
-	body := MailerBody{
-		Sender: Email{
-			Name:  m.Sender.Name,
-			Email: m.Sender.Email,
-		},
-		To:          mo.To,
-		HTMLContent: mo.HTMLContent,
-		TextContent: mo.TextContent,
-		Subject:     mo.Subject,
-	}
-	log.Info().Msg("stringify-ing request body")
-	bodyStr, err := json.Marshal(body)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to marshal body")
-		return err
+// New returns the transport selected in the config
+func New(config Config) (Mailer, error) {
+	sender := Email{
+		Name:  config.SenderName,
+		Email: config.SenderEmail,
 	}
 
-	// TODO: create a simple custom http client
-	log.Info().Msg("creating http request to send email")
-	req, err := http.NewRequestWithContext(
-		ctx,
-		"POST",
-		sendinblueAPIURL,
-		bytes.NewBuffer(bodyStr),
-	)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to create request")
-		return err
+	switch config.Transport {
+	case "", TransportSendinblue:
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("sendinblue transport requires an api key")
+		}
+		return NewSendinblueMailer(sender, config.APIKey), nil
+	case TransportSMTP:
+		if config.SMTPHost == "" || config.SMTPPort == "" {
+			return nil, fmt.Errorf("smtp transport requires a host and a port")
+		}
+		return NewSMTPMailer(
+			sender,
+			config.SMTPHost,
+			config.SMTPPort,
+			config.SMTPUsername,
+			config.SMTPPassword,
+			config.SMTPRequireTLS,
+		), nil
+	case TransportOutbox:
+		if config.OutboxDir == "" {
+			return nil, fmt.Errorf("outbox transport requires a directory")
+		}
+		return NewOutboxMailer(sender, config.OutboxDir), nil
+	case TransportMemory:
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", config.Transport)
 	}
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("api-key", m.APIKey)
-
-	_, err = http.DefaultClient.Do(req)
-	return err
 }
@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryMailer keeps every mail it is given, so that tests can look at what
+// would have been sent
+type MemoryMailer struct {
+	mu    sync.Mutex
+	mails []*MailOptions
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) SendMail(ctx context.Context, mo *MailOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mail := *mo
+	mail.To = append([]Email{}, mo.To...)
+	m.mails = append(m.mails, &mail)
+
+	return nil
+}
+
+// Mails returns everything sent so far, oldest first
+func (m *MemoryMailer) Mails() []*MailOptions {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]*MailOptions{}, m.mails...)
+}
+
+// Reset forgets every mail sent so far
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.mails = nil
+}
@@ -0,0 +1,90 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/werdna521/userland/security"
+)
+
+func (e Email) address() string {
+	return (&mail.Address{Name: e.Name, Address: e.Email}).String()
+}
+
+// buildMessage renders mo as an RFC 5322 message with a text and an html
+// alternative, ready to be sent over SMTP or written to an .eml file
+func buildMessage(sender Email, mo *MailOptions, now time.Time) ([]byte, error) {
+	to := []string{}
+	for _, t := range mo.To {
+		to = append(to, t.address())
+	}
+
+	domain := "localhost"
+	if i := strings.LastIndex(sender.Email, "@"); i >= 0 {
+		domain = sender.Email[i+1:]
+	}
+
+	messageID := string(security.GenerateRandomID())
+	if mo.IdempotencyKey != "" {
+		messageID = mo.IdempotencyKey
+	}
+
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+
+	headers := []string{
+		fmt.Sprintf("From: %s", sender.address()),
+		fmt.Sprintf("To: %s", strings.Join(to, ", ")),
+		fmt.Sprintf("Subject: %s", mime.QEncoding.Encode("utf-8", mo.Subject)),
+		fmt.Sprintf("Date: %s", now.Format(time.RFC1123Z)),
+		fmt.Sprintf("Message-ID: <%s@%s>", messageID, domain),
+		"MIME-Version: 1.0",
+		fmt.Sprintf("Content-Type: multipart/alternative; boundary=%q", mw.Boundary()),
+	}
+	msg := &bytes.Buffer{}
+	msg.WriteString(strings.Join(headers, "\r\n"))
+	msg.WriteString("\r\n\r\n")
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain", mo.TextContent},
+		{"text/html", mo.HTMLContent},
+	}
+	for _, p := range parts {
+		if p.content == "" {
+			continue
+		}
+
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", fmt.Sprintf("%s; charset=utf-8", p.contentType))
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write([]byte(p.content)); err != nil {
+			return nil, err
+		}
+		if err := qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	msg.Write(buf.Bytes())
+
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,28 @@
+package mailer
+
+import (
+	"context"
+	"time"
+)
+
+type newLoginData struct {
+	Time      time.Time
+	IP        string
+	UserAgent string
+}
+
+func SendNewLoginMail(
+	ctx context.Context,
+	m Mailer,
+	to Email,
+	locale string,
+	loggedInAt time.Time,
+	ip string,
+	userAgent string,
+) error {
+	return send(ctx, m, to, locale, TemplateNewLogin, &newLoginData{
+		Time:      loggedInAt,
+		IP:        ip,
+		UserAgent: userAgent,
+	})
+}
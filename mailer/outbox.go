@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/security"
+)
+
+// OutboxMailer drops every mail as an .eml file into a directory instead of
+// sending it, which is handy when running locally without a mail provider
+type OutboxMailer struct {
+	Sender Email
+	Dir    string
+}
+
+func NewOutboxMailer(sender Email, dir string) *OutboxMailer {
+	return &OutboxMailer{
+		Sender: sender,
+		Dir:    dir,
+	}
+}
+
+func (m *OutboxMailer) SendMail(ctx context.Context, mo *MailOptions) error {
+	now := time.Now()
+
+	log.Info().Msg("building mail message")
+	msg, err := buildMessage(m.Sender, mo, now)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to build mail message")
+		return err
+	}
+
+	err = os.MkdirAll(m.Dir, 0o755)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create outbox directory")
+		return err
+	}
+
+	// written under a temporary name first, so that whatever watches the
+	// directory never picks up half a message
+	// a retried mail overwrites the file of the earlier attempt
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), security.GenerateRandomID())
+	if mo.IdempotencyKey != "" {
+		name = fmt.Sprintf("%s.eml", mo.IdempotencyKey)
+	}
+	tmp := filepath.Join(m.Dir, "."+name+".tmp")
+
+	log.Info().Msgf("writing mail to the outbox as %s", name)
+	err = os.WriteFile(tmp, msg, 0o644)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write mail to the outbox")
+		return err
+	}
+
+	err = os.Rename(tmp, filepath.Join(m.Dir, name))
+	if err != nil {
+		os.Remove(tmp)
+		log.Error().Err(err).Msg("failed to move mail into the outbox")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+package mailer
+
+import (
+	"context"
+	"time"
+)
+
+type passwordChangedData struct {
+	Time time.Time
+}
+
+func SendPasswordChangedMail(
+	ctx context.Context,
+	m Mailer,
+	to Email,
+	locale string,
+	changedAt time.Time,
+) error {
+	return send(ctx, m, to, locale, TemplatePasswordChanged, &passwordChangedData{
+		Time: changedAt,
+	})
+}
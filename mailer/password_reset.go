@@ -1,22 +1,19 @@
 package mailer
 
-import (
-	"context"
-	"fmt"
-)
+import "context"
+
+type passwordResetData struct {
+	Token string
+}
 
 func SendPasswordResetMail(
 	ctx context.Context,
 	m Mailer,
 	to Email,
+	locale string,
 	token string,
 ) error {
-	mo := &MailOptions{
-		To:          []Email{to},
-		Subject:     "Reset Password",
-		HTMLContent: fmt.Sprintf(passwordResetTemplate, token),
-		TextContent: "Hi Userlanders, use this token to reset your password",
-	}
-
-	return m.SendMail(ctx, mo)
+	return send(ctx, m, to, locale, TemplatePasswordReset, &passwordResetData{
+		Token: token,
+	})
 }
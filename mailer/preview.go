@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"fmt"
+	"time"
+)
+
+// sample data for every template, used to preview them
+var previewData = map[string]interface{}{
+	TemplateEmailVerification: &emailVerificationData{
+		Link: "http://localhost:3000/api/v1/auth/verification?id=preview&token=preview",
+	},
+	TemplatePasswordReset: &passwordResetData{
+		Token: "4f3c2b1a0e9d8c7b6a5f4e3d2c1b0a99",
+	},
+	TemplateRecoveryCodeUsed: &recoveryCodeUsedData{
+		Remaining: 7,
+	},
+	TemplateAccountLocked: &accountLockedData{
+		Link: "http://localhost:3000/api/v1/auth/unlock?token=preview",
+	},
+	TemplateMagicLink: &magicLinkData{
+		Link:      "http://localhost:3000/login/magic?token=preview",
+		ExpiresIn: 15,
+	},
+	TemplatePasswordChanged: &passwordChangedData{
+		Time: time.Date(2021, 8, 17, 9, 30, 0, 0, time.UTC),
+	},
+	TemplateNewLogin: &newLoginData{
+		Time:      time.Date(2021, 8, 17, 9, 30, 0, 0, time.UTC),
+		IP:        "203.0.113.7",
+		UserAgent: "Mozilla/5.0 (X11; Linux x86_64) Firefox/91.0",
+	},
+	TemplateAccountDeleted: nil,
+	TemplateEmailChanged: &emailChangedData{
+		NewEmail:   "jane@example.org",
+		RevertLink: "http://localhost:3000/api/v1/me/email/revert?token=preview",
+		RevertDays: 7,
+		Time:       time.Date(2021, 8, 17, 9, 30, 0, 0, time.UTC),
+	},
+	TemplateInvitation: &invitationData{
+		Organization: "Userland Labs",
+		InvitedBy:    "John Userlander",
+		Link:         "http://localhost:3000/invitations/accept?token=preview",
+		ExpiresDays:  7,
+	},
+}
+
+// Preview renders the template with sample data
+func (t *Templates) Preview(name string, locale string) (*Message, error) {
+	data, ok := previewData[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown mail template %q", name)
+	}
+
+	return t.Render(name, locale, "Jane Userlander", data)
+}
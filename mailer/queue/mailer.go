@@ -0,0 +1,56 @@
+// Package queue sends mails through the email outbox table instead of
+// talking to the transport while a request is being handled.
+package queue
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/mailer"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/postgres"
+	"github.com/werdna521/userland/security"
+)
+
+// Mailer queues mails in the outbox, to be sent later by a Worker. mails
+// queued with a context carrying a transaction are only sent once that
+// transaction commits.
+type Mailer struct {
+	eor postgres.EmailOutboxRepository
+}
+
+func NewMailer(eor postgres.EmailOutboxRepository) *Mailer {
+	return &Mailer{
+		eor: eor,
+	}
+}
+
+func (m *Mailer) SendMail(ctx context.Context, mo *mailer.MailOptions) error {
+	key := mo.IdempotencyKey
+	if key == "" {
+		key = string(security.GenerateRandomID())
+	}
+
+	recipients := []repository.EmailRecipient{}
+	for _, t := range mo.To {
+		recipients = append(recipients, repository.EmailRecipient{
+			Name:  t.Name,
+			Email: t.Email,
+		})
+	}
+
+	log.Info().Msg("queueing mail in the outbox")
+	err := m.eor.CreateOutboxEmail(ctx, &repository.OutboxEmail{
+		IdempotencyKey: key,
+		Recipients:     recipients,
+		Subject:        mo.Subject,
+		HTMLContent:    mo.HTMLContent,
+		TextContent:    mo.TextContent,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to queue mail in the outbox")
+		return err
+	}
+
+	return nil
+}
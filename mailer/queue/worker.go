@@ -0,0 +1,162 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/mailer"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/postgres"
+)
+
+const (
+	defaultBatchSize    = 20
+	defaultPollInterval = 5 * time.Second
+	defaultLease        = time.Minute
+	defaultBaseBackoff  = 30 * time.Second
+	defaultMaxBackoff   = 6 * time.Hour
+	defaultMaxAttempts  = 10
+)
+
+// WorkerConfig tunes the Worker, zero values fall back to the defaults above
+type WorkerConfig struct {
+	BatchSize    int
+	PollInterval time.Duration
+	// Lease is how long a claimed mail is hidden from other workers. a mail
+	// claimed by a worker that died is picked up again once it runs out.
+	Lease       time.Duration
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	MaxAttempts int
+}
+
+// Worker drains the outbox through the actual transport. failed mails are
+// retried with exponential backoff, and dead-lettered after MaxAttempts.
+type Worker struct {
+	eor    postgres.EmailOutboxRepository
+	m      mailer.Mailer
+	config WorkerConfig
+}
+
+func NewWorker(
+	eor postgres.EmailOutboxRepository,
+	m mailer.Mailer,
+	config WorkerConfig,
+) *Worker {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultPollInterval
+	}
+	if config.Lease <= 0 {
+		config.Lease = defaultLease
+	}
+	if config.BaseBackoff <= 0 {
+		config.BaseBackoff = defaultBaseBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaultMaxBackoff
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultMaxAttempts
+	}
+
+	return &Worker{
+		eor:    eor,
+		m:      m,
+		config: config,
+	}
+}
+
+// Run drains the outbox until ctx is done
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// keep going while there are full batches, so a backlog doesn't
+		// have to wait for the ticker
+		for w.drain(ctx) == w.config.BatchSize {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain sends one batch of due mails and returns how many it claimed
+func (w *Worker) drain(ctx context.Context) int {
+	now := time.Now()
+	emails, err := w.eor.ClaimDueOutboxEmails(ctx, now, now.Add(w.config.Lease), w.config.BatchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to claim outbox emails")
+		return 0
+	}
+
+	for _, oe := range emails {
+		w.send(ctx, oe)
+	}
+
+	return len(emails)
+}
+
+func (w *Worker) send(ctx context.Context, oe *repository.OutboxEmail) {
+	to := []mailer.Email{}
+	for _, r := range oe.Recipients {
+		to = append(to, mailer.Email{
+			Name:  r.Name,
+			Email: r.Email,
+		})
+	}
+
+	log.Info().Str("id", oe.ID).Msg("sending outbox email")
+	err := w.m.SendMail(ctx, &mailer.MailOptions{
+		To:             to,
+		HTMLContent:    oe.HTMLContent,
+		TextContent:    oe.TextContent,
+		Subject:        oe.Subject,
+		IdempotencyKey: oe.IdempotencyKey,
+	})
+	if err == nil {
+		err = w.eor.MarkOutboxEmailSent(ctx, oe.ID, time.Now())
+		if err != nil {
+			// the lease runs out and the mail goes out again, the idempotency
+			// key should keep the transport from delivering it twice
+			log.Error().Err(err).Str("id", oe.ID).Msg("failed to mark outbox email sent")
+		}
+		return
+	}
+
+	attempts := oe.Attempts + 1
+	status := repository.OutboxEmailStatusPending
+	next := time.Now().Add(w.backoff(attempts))
+	if attempts >= w.config.MaxAttempts {
+		status = repository.OutboxEmailStatusDead
+		log.Error().Err(err).Str("id", oe.ID).Msg("outbox email ran out of attempts")
+	} else {
+		log.Warn().Err(err).Str("id", oe.ID).Msgf("failed to send outbox email, retrying at %s", next)
+	}
+
+	err = w.eor.MarkOutboxEmailFailed(ctx, oe.ID, status, attempts, next, err.Error())
+	if err != nil {
+		log.Error().Err(err).Str("id", oe.ID).Msg("failed to mark outbox email failed")
+	}
+}
+
+// backoff doubles the delay with every attempt, up to MaxBackoff
+func (w *Worker) backoff(attempts int) time.Duration {
+	d := w.config.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= w.config.MaxBackoff {
+			return w.config.MaxBackoff
+		}
+	}
+
+	return d
+}
@@ -0,0 +1,19 @@
+package mailer
+
+import "context"
+
+type recoveryCodeUsedData struct {
+	Remaining int
+}
+
+func SendRecoveryCodeUsedMail(
+	ctx context.Context,
+	m Mailer,
+	to Email,
+	locale string,
+	remaining int,
+) error {
+	return send(ctx, m, to, locale, TemplateRecoveryCodeUsed, &recoveryCodeUsedData{
+		Remaining: remaining,
+	})
+}
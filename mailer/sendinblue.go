@@ -0,0 +1,105 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	sendinblueAPIURL  = "https://api.sendinblue.com/v3/smtp/email"
+	sendinblueTimeout = 10 * time.Second
+)
+
+// how much of an error response ends up in the returned error
+const sendinblueMaxErrorBody = 1024
+
+type SendinblueMailer struct {
+	Sender Email
+	APIKey string
+	URL    string
+	Client *http.Client
+}
+
+func NewSendinblueMailer(sender Email, apiKey string) *SendinblueMailer {
+	return &SendinblueMailer{
+		Sender: sender,
+		APIKey: apiKey,
+		URL:    sendinblueAPIURL,
+		Client: &http.Client{
+			Timeout: sendinblueTimeout,
+		},
+	}
+}
+
+type MailerBody struct {
+	Sender      Email             `json:"sender"`
+	To          []Email           `json:"to"`
+	HTMLContent string            `json:"htmlContent"`
+	TextContent string            `json:"textContent"`
+	Subject     string            `json:"subject"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+func (m *SendinblueMailer) SendMail(ctx context.Context, mo *MailOptions) error {
+	body := MailerBody{
+		Sender: Email{
+			Name:  m.Sender.Name,
+			Email: m.Sender.Email,
+		},
+		To:          mo.To,
+		HTMLContent: mo.HTMLContent,
+		TextContent: mo.TextContent,
+		Subject:     mo.Subject,
+	}
+	if mo.IdempotencyKey != "" {
+		// sendinblue drops requests with a key it has already seen
+		body.Headers = map[string]string{
+			"idempotencyKey": mo.IdempotencyKey,
+		}
+	}
+
+	log.Info().Msg("stringify-ing request body")
+	bodyStr, err := json.Marshal(body)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to marshal body")
+		return err
+	}
+
+	log.Info().Msg("creating http request to send email")
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		m.URL,
+		bytes.NewBuffer(bodyStr),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create request")
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("api-key", m.APIKey)
+
+	res, err := m.Client.Do(req)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to send request")
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		resBody, _ := io.ReadAll(io.LimitReader(res.Body, sendinblueMaxErrorBody))
+		err = fmt.Errorf("sendinblue responded with %d: %s", res.StatusCode, resBody)
+		log.Error().Err(err).Msg("failed to send email")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type SMTPMailer struct {
+	Sender     Email
+	Host       string
+	Port       string
+	Username   string
+	Password   string
+	RequireTLS bool
+}
+
+// NewSMTPMailer sends mails through a plain SMTP server. STARTTLS is used
+// whenever the server offers it, and refusing it fails the send if requireTLS
+// is set. credentials are only sent when a username is set.
+func NewSMTPMailer(
+	sender Email,
+	host string,
+	port string,
+	username string,
+	password string,
+	requireTLS bool,
+) *SMTPMailer {
+	return &SMTPMailer{
+		Sender:     sender,
+		Host:       host,
+		Port:       port,
+		Username:   username,
+		Password:   password,
+		RequireTLS: requireTLS,
+	}
+}
+
+func (m *SMTPMailer) SendMail(ctx context.Context, mo *MailOptions) error {
+	log.Info().Msg("building mail message")
+	msg, err := buildMessage(m.Sender, mo, time.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("failed to build mail message")
+		return err
+	}
+
+	log.Info().Msg("connecting to smtp server")
+	d := &net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(m.Host, m.Port))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to connect to smtp server")
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		log.Error().Err(err).Msg("failed to start smtp session")
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		log.Info().Msg("starting tls")
+		err = c.StartTLS(&tls.Config{ServerName: m.Host})
+		if err != nil {
+			log.Error().Err(err).Msg("failed to start tls")
+			return err
+		}
+	} else if m.RequireTLS {
+		err = fmt.Errorf("smtp server %s does not support STARTTLS", m.Host)
+		log.Error().Err(err).Msg("refusing to send mail in plaintext")
+		return err
+	}
+
+	if m.Username != "" {
+		log.Info().Msg("authenticating to smtp server")
+		err = c.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to authenticate to smtp server")
+			return err
+		}
+	}
+
+	log.Info().Msg("sending mail")
+	err = c.Mail(m.Sender.Email)
+	if err != nil {
+		log.Error().Err(err).Msg("smtp server rejected the sender")
+		return err
+	}
+	for _, to := range mo.To {
+		err = c.Rcpt(to.Email)
+		if err != nil {
+			log.Error().Err(err).Msg("smtp server rejected a recipient")
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		log.Error().Err(err).Msg("smtp server refused the message")
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write the message")
+		return err
+	}
+	// the server only accepts or rejects the message once it is closed
+	err = w.Close()
+	if err != nil {
+		log.Error().Err(err).Msg("smtp server rejected the message")
+		return err
+	}
+
+	return c.Quit()
+}
@@ -1,22 +1,296 @@
 package mailer
 
-const emailVerificationTemplate = `
-	Hi Userlanders,
-	<br/>
-	Please verify your Email by clicking <a href="%s">here</a>
-	<br/>
-	Cheers,<br/>
-	Your Userland Team
-`
-
-const passwordResetTemplate = `
-	Hi Userlanders,
-	<br/>
-	Here is your token to reset your password:
-	<p style="font-size: 18px; font-weight: 600;">%s</p>
-	<br/>
-	If you don't request a password reset, please ignore this email.
-	<br/>
-	Cheers,<br/>
-	Your Userland Team
-`
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	texttemplate "text/template"
+
+	"golang.org/x/text/language"
+)
+
+// names of the templates in the registry. every template has an html and a
+// text variant for every locale, the text variant also defines the subject.
+const (
+	TemplateEmailVerification = "email_verification"
+	TemplatePasswordReset     = "password_reset"
+	TemplateRecoveryCodeUsed  = "recovery_code_used"
+	TemplateAccountLocked     = "account_locked"
+	TemplateMagicLink         = "magic_link"
+	TemplatePasswordChanged   = "password_changed"
+	TemplateNewLogin          = "new_login"
+	TemplateAccountDeleted    = "account_deleted"
+	TemplateEmailChanged      = "email_changed"
+	TemplateInvitation        = "invitation"
+)
+
+var TemplateNames = []string{
+	TemplateEmailVerification,
+	TemplatePasswordReset,
+	TemplateRecoveryCodeUsed,
+	TemplateAccountLocked,
+	TemplateMagicLink,
+	TemplatePasswordChanged,
+	TemplateNewLogin,
+	TemplateAccountDeleted,
+	TemplateEmailChanged,
+	TemplateInvitation,
+}
+
+// DefaultLocale is used when neither the user nor the request asks for a
+// locale we have templates for
+const DefaultLocale = "en"
+
+var Locales = []string{DefaultLocale, "id"}
+
+var localeMatcher = language.NewMatcher([]language.Tag{
+	language.English,
+	language.Indonesian,
+})
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// Message is a rendered template
+type Message struct {
+	Subject     string
+	HTMLContent string
+	TextContent string
+}
+
+// the data every template is executed with, Data holds what is specific to the
+// template
+type templateData struct {
+	Name    string
+	Subject string
+	Data    interface{}
+}
+
+type Templates struct {
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+// templates is the registry used by the Send*Mail functions. it starts out
+// with the embedded templates and can be swapped with SetTemplates.
+var templates = mustLoadEmbeddedTemplates()
+
+func mustLoadEmbeddedTemplates() *Templates {
+	t, err := LoadTemplates("")
+	if err != nil {
+		panic(fmt.Sprintf("failed to load embedded mail templates: %s", err))
+	}
+	return t
+}
+
+func SetTemplates(t *Templates) {
+	templates = t
+}
+
+// overlayFS reads files from dir, falling back to the embedded templates for
+// the ones it doesn't have, so a directory only needs to hold the overrides
+type overlayFS struct {
+	dir fs.FS
+}
+
+func (o overlayFS) ReadFile(name string) ([]byte, error) {
+	if o.dir != nil {
+		b, err := fs.ReadFile(o.dir, name)
+		if err == nil {
+			return b, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return embeddedTemplates.ReadFile(path.Join("templates", name))
+}
+
+// LoadTemplates parses every template of every locale from dir. files missing
+// from dir are taken from the embedded templates, and an empty dir means only
+// the embedded ones are used.
+func LoadTemplates(dir string) (*Templates, error) {
+	o := overlayFS{}
+	if dir != "" {
+		o.dir = os.DirFS(dir)
+	}
+
+	t := &Templates{
+		html: map[string]*htmltemplate.Template{},
+		text: map[string]*texttemplate.Template{},
+	}
+	layouts, err := o.readFiles("layout.html", "layout.txt")
+	if err != nil {
+		return nil, err
+	}
+	layoutHTML, layoutText := layouts[0], layouts[1]
+
+	for _, locale := range Locales {
+		for _, name := range TemplateNames {
+			key := templateKey(name, locale)
+
+			files, err := o.readFiles(
+				path.Join(locale, "common.tmpl"),
+				path.Join(locale, name+".html"),
+				path.Join(locale, name+".txt"),
+			)
+			if err != nil {
+				return nil, err
+			}
+			common, htmlContent, textContent := files[0], files[1], files[2]
+
+			ht := htmltemplate.New("layout.html")
+			for _, f := range []string{layoutHTML, common, htmlContent} {
+				if _, err := ht.Parse(f); err != nil {
+					return nil, fmt.Errorf("failed to parse %s/%s.html: %w", locale, name, err)
+				}
+			}
+			t.html[key] = ht
+
+			tt := texttemplate.New("layout.txt")
+			for _, f := range []string{layoutText, common, textContent} {
+				if _, err := tt.Parse(f); err != nil {
+					return nil, fmt.Errorf("failed to parse %s/%s.txt: %w", locale, name, err)
+				}
+			}
+			if tt.Lookup("subject") == nil {
+				return nil, fmt.Errorf("%s/%s.txt doesn't define a subject", locale, name)
+			}
+			t.text[key] = tt
+		}
+	}
+
+	return t, nil
+}
+
+func (o overlayFS) readFiles(names ...string) ([]string, error) {
+	files := []string{}
+	for _, name := range names {
+		b, err := o.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, string(b))
+	}
+
+	return files, nil
+}
+
+func templateKey(name string, locale string) string {
+	return locale + "/" + name
+}
+
+// Render renders the template in the given locale, or in the default locale
+// when there are no templates for it
+func (t *Templates) Render(
+	name string,
+	locale string,
+	recipientName string,
+	data interface{},
+) (*Message, error) {
+	key := templateKey(name, locale)
+	if _, ok := t.text[key]; !ok {
+		key = templateKey(name, DefaultLocale)
+	}
+
+	tt, ok := t.text[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown mail template %q", name)
+	}
+	ht := t.html[key]
+
+	td := &templateData{
+		Name: recipientName,
+		Data: data,
+	}
+
+	subject := &bytes.Buffer{}
+	err := tt.ExecuteTemplate(subject, "subject", td)
+	if err != nil {
+		return nil, err
+	}
+	td.Subject = strings.TrimSpace(subject.String())
+
+	text := &bytes.Buffer{}
+	err = tt.Execute(text, td)
+	if err != nil {
+		return nil, err
+	}
+
+	html := &bytes.Buffer{}
+	err = ht.Execute(html, td)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Subject:     td.Subject,
+		HTMLContent: html.String(),
+		TextContent: strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
+
+// MatchLocale picks the locale to send a mail in, going with the user's own
+// preference first and the Accept-Language of the request after that
+func MatchLocale(preferred string, acceptLanguage string) string {
+	for _, l := range []string{preferred, acceptLanguage} {
+		if l == "" {
+			continue
+		}
+
+		tags, _, err := language.ParseAcceptLanguage(l)
+		if err != nil || len(tags) == 0 {
+			continue
+		}
+
+		_, i, confidence := localeMatcher.Match(tags...)
+		if confidence != language.No {
+			return Locales[i]
+		}
+	}
+
+	return DefaultLocale
+}
+
+// IsSupportedLocale tells whether there are templates for the locale
+func IsSupportedLocale(locale string) bool {
+	for _, l := range Locales {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// send renders the template in the given locale and sends it to a single
+// recipient
+func send(
+	ctx context.Context,
+	m Mailer,
+	to Email,
+	locale string,
+	name string,
+	data interface{},
+) error {
+	msg, err := templates.Render(name, locale, to.Name, data)
+	if err != nil {
+		return err
+	}
+
+	mo := &MailOptions{
+		To:          []Email{to},
+		Subject:     msg.Subject,
+		HTMLContent: msg.HTMLContent,
+		TextContent: msg.TextContent,
+	}
+
+	return m.SendMail(ctx, mo)
+}
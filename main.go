@@ -1,34 +1,71 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/api/middleware"
 	"github.com/werdna521/userland/api/server"
+	"github.com/werdna521/userland/config"
 	"github.com/werdna521/userland/db"
 	"github.com/werdna521/userland/mailer"
+	"github.com/werdna521/userland/security"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/security/seclog"
+	"github.com/werdna521/userland/service"
+	"github.com/werdna521/userland/social"
+	"github.com/werdna521/userland/utils/geoip"
 )
 
 func main() {
+	log.Info().Msg("loading config")
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Error().Err(err).Stack().Msg("failed to load config")
+		return
+	}
+
 	serverConfig := server.Config{
-		Port: os.Getenv("API_PORT"),
+		App: cfg,
 	}
 	postgresConfig := db.PostgresConfig{
-		Username: os.Getenv("POSTGRES_USER"),
-		Password: os.Getenv("POSTGRES_PASSWORD"),
-		Addr:     os.Getenv("POSTGRES_ADDR"),
-		Database: os.Getenv("POSTGRES_DB"),
+		Username: cfg.Postgres.Username,
+		Password: cfg.Postgres.Password,
+		Addr:     cfg.Postgres.Addr,
+		Database: cfg.Postgres.Database,
 	}
 	redisConfig := db.RedisConfig{
-		Addr:     os.Getenv("REDIS_ADDR"),
-		Password: os.Getenv("REDIS_PASSWORD"),
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
 		DB:       0,
 	}
-	mailerConfig := mailer.Config{
-		SenderName:  os.Getenv("SENDINBLUE_SENDER_NAME"),
-		SenderEmail: os.Getenv("SENDINBLUE_SENDER_EMAIL"),
-		APIKey:      os.Getenv("SENDINBLUE_API_KEY"),
+	mailerConfig := cfg.Mail.MailerConfig()
+
+	log.Info().Msg("parsing trusted proxies")
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Error().Err(err).Stack().Msg("failed to parse trusted proxies")
+		return
 	}
+	serverConfig.TrustedProxies = trustedProxies
+
+	log.Info().Msg("parsing session policies")
+	sessionPolicies, err := newSessionPolicies(cfg.Sessions)
+	if err != nil {
+		log.Error().Err(err).Stack().Msg("failed to parse session policies")
+		return
+	}
+	serverConfig.SessionPolicies = sessionPolicies
+
+	log.Info().Msg("parsing session limits")
+	sessionLimits, err := newSessionLimits(cfg.Sessions)
+	if err != nil {
+		log.Error().Err(err).Stack().Msg("failed to parse session limits")
+		return
+	}
+	serverConfig.SessionLimits = sessionLimits
 
 	log.Info().Msg("get connection to postgres")
 	postgresConn, err := db.NewPosgresConn(postgresConfig)
@@ -49,9 +86,184 @@ func main() {
 		Redis:    redisConn,
 	}
 
-	mailer := mailer.NewBaseMailer(mailerConfig)
+	// templates not found in the directory fall back to the embedded ones
+	if dir := cfg.Mail.TemplatesDir; dir != "" {
+		log.Info().Msgf("loading mail templates from %s", dir)
+		templates, err := mailer.LoadTemplates(dir)
+		if err != nil {
+			log.Error().Err(err).Stack().Msg("failed to load mail templates")
+			return
+		}
+		mailer.SetTemplates(templates)
+	}
+
+	log.Info().Msgf("setting up %s mailer", mailerConfig.Transport)
+	mailer, err := mailer.New(mailerConfig)
+	if err != nil {
+		log.Error().Err(err).Stack().Msg("failed to set up mailer")
+		return
+	}
+
+	log.Info().Msg("setting up encrypter")
+	encrypter, err := security.NewAESEncrypter(cfg.Security.TFASecretKey)
+	if err != nil {
+		log.Error().Err(err).Stack().Msg("failed to set up encrypter")
+		return
+	}
+
+	log.Info().Msg("loading jwt keys")
+	keyManager, err := jwt.LoadKeyManager(
+		cfg.JWT.KeysDir,
+		cfg.JWT.SigningKeyID,
+		[]byte(cfg.JWT.Secret),
+		cfg.JWT.AcceptLegacyTokens,
+	)
+	if err != nil {
+		log.Error().Err(err).Stack().Msg("failed to load jwt keys")
+		return
+	}
+	jwt.SetKeyManager(keyManager)
+
+	log.Info().Msg("setting up security log")
+	err = seclog.Init(cfg.Security.LogPath)
+	if err != nil {
+		log.Error().Err(err).Stack().Msg("failed to set up security log")
+		return
+	}
+
+	log.Info().Msg("setting up social login providers")
+	providers := newSocialProviders(cfg)
+
+	log.Info().Msg("setting up webauthn")
+	webAuthn, err := newWebAuthn(cfg.WebAuthn)
+	if err != nil {
+		log.Error().Err(err).Stack().Msg("failed to set up webauthn")
+		return
+	}
+
+	log.Info().Msg("setting up geoip")
+	locator, err := newGeoIPLocator(cfg.GeoIP)
+	if err != nil {
+		log.Error().Err(err).Stack().Msg("failed to set up geoip")
+		return
+	}
 
 	log.Info().Msg("starting api server")
-	server := server.NewServer(serverConfig, mailer, dataSource)
+	server := server.NewServer(
+		serverConfig,
+		mailer,
+		encrypter,
+		keyManager,
+		providers,
+		webAuthn,
+		locator,
+		dataSource,
+	)
 	server.Start()
 }
+
+// newSessionPolicies reads the session policies, the ones that aren't set keep
+// their default
+func newSessionPolicies(c config.Sessions) (service.SessionPolicies, error) {
+	p := service.DefaultSessionPolicies()
+
+	var err error
+	p.OnPasswordChange, err = service.ParseSessionPolicy(
+		c.PolicyOnPasswordChange,
+		p.OnPasswordChange,
+	)
+	if err != nil {
+		return p, err
+	}
+
+	p.OnPasswordReset, err = service.ParseSessionPolicy(
+		c.PolicyOnPasswordReset,
+		p.OnPasswordReset,
+	)
+	if err != nil {
+		return p, err
+	}
+
+	p.OnEmailChange, err = service.ParseSessionPolicy(
+		c.PolicyOnEmailChange,
+		p.OnEmailChange,
+	)
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// newSessionLimits reads the per-user session limit, users can have any number
+// of sessions when it isn't set
+func newSessionLimits(c config.Sessions) (service.SessionLimits, error) {
+	l := service.DefaultSessionLimits()
+	l.MaxSessions = c.MaxPerUser
+
+	var err error
+	l.OnLimit, err = service.ParseSessionLimitPolicy(c.LimitPolicy, l.OnLimit)
+	if err != nil {
+		return l, err
+	}
+
+	return l, nil
+}
+
+// newGeoIPLocator opens the GeoIP database if there is one. sessions just go
+// without a location otherwise.
+func newGeoIPLocator(c config.GeoIP) (geoip.Locator, error) {
+	if c.DBPath == "" {
+		return geoip.NewNopLocator(), nil
+	}
+
+	return geoip.NewMaxMindLocator(c.DBPath)
+}
+
+// newWebAuthn sets up the relying party passkeys are registered to
+func newWebAuthn(c config.WebAuthn) (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          c.RPID,
+		RPDisplayName: "Userland",
+		RPOrigins:     c.RPOrigins,
+	})
+}
+
+// newSocialProviders sets up every provider that has a client ID configured
+func newSocialProviders(cfg *config.Config) []social.Provider {
+	redirectURL := func(provider string) string {
+		return fmt.Sprintf("%s/api/v1/auth/social/%s/callback", cfg.Server.BaseURL, provider)
+	}
+
+	providers := []social.Provider{}
+
+	if google := cfg.Social.Google; google.ClientID != "" {
+		providers = append(providers, social.NewGoogleProvider(social.Config{
+			ClientID:     google.ClientID,
+			ClientSecret: google.ClientSecret,
+			RedirectURL:  redirectURL("google"),
+		}))
+	}
+
+	if github := cfg.Social.GitHub; github.ClientID != "" {
+		providers = append(providers, social.NewGitHubProvider(social.Config{
+			ClientID:     github.ClientID,
+			ClientSecret: github.ClientSecret,
+			RedirectURL:  redirectURL("github"),
+		}))
+	}
+
+	if oidc := cfg.Social.OIDC; oidc.ClientID != "" {
+		providers = append(providers, social.NewOIDCProvider(social.OIDCConfig{
+			Config: social.Config{
+				ClientID:     oidc.ClientID,
+				ClientSecret: oidc.ClientSecret,
+				RedirectURL:  redirectURL(oidc.Name),
+			},
+			Name:      oidc.Name,
+			IssuerURL: oidc.IssuerURL,
+		}))
+	}
+
+	return providers
+}
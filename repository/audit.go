@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+// types of AuditEvent
+const (
+	AuditRegistered             = "registered"
+	AuditEmailVerified          = "email_verified"
+	AuditLoginSucceeded         = "login_succeeded"
+	AuditLoginFailed            = "login_failed"
+	AuditAccountLocked          = "account_locked"
+	AuditAccountUnlocked        = "account_unlocked"
+	AuditTFAChallengeFailed     = "tfa_challenge_failed"
+	AuditRecoveryCodeUsed       = "recovery_code_used"
+	AuditPasswordResetRequested = "password_reset_requested"
+	AuditPasswordReset          = "password_reset"
+	AuditMagicLinkRequested     = "magic_link_requested"
+	AuditIdentityLinked         = "identity_linked"
+	AuditIdentityUnlinked       = "identity_unlinked"
+	AuditPasskeyRegistered      = "passkey_registered"
+	AuditPasskeyDeleted         = "passkey_deleted"
+	AuditSessionEnded           = "session_ended"
+	AuditOtherSessionsEnded     = "other_sessions_ended"
+	AuditRefreshTokenReused     = "refresh_token_reused"
+	AuditProfileUpdated         = "profile_updated"
+	AuditEmailChangeRequested   = "email_change_requested"
+	AuditEmailChanged           = "email_changed"
+	AuditEmailChangeReverted    = "email_change_reverted"
+	AuditPasswordChanged        = "password_changed"
+	AuditTFAEnabled             = "tfa_enabled"
+	AuditTFADisabled            = "tfa_disabled"
+	AuditRecoveryCodesRenewed   = "recovery_codes_regenerated"
+	AuditAccountDeleted         = "account_deleted"
+	AuditRoleAssigned           = "role_assigned"
+	AuditAccountDeactivated     = "account_deactivated"
+	AuditAccountReactivated     = "account_reactivated"
+	AuditAccountRestored        = "account_restored"
+	AuditVerificationForced     = "verification_forced"
+	AuditAllSessionsEnded       = "all_sessions_ended"
+	AuditOrganizationCreated    = "organization_created"
+	AuditOrganizationUpdated    = "organization_updated"
+	AuditOrganizationDeleted    = "organization_deleted"
+	AuditMemberAdded            = "member_added"
+	AuditMemberRoleChanged      = "member_role_changed"
+	AuditMemberRemoved          = "member_removed"
+	AuditInvitationSent         = "invitation_sent"
+	AuditInvitationResent       = "invitation_resent"
+	AuditInvitationRevoked      = "invitation_revoked"
+	AuditInvitationAccepted     = "invitation_accepted"
+	AuditPATCreated             = "personal_access_token_created"
+	AuditPATRevoked             = "personal_access_token_revoked"
+)
+
+// AuditEvent records something that happened to an account. ActorID is who
+// did it, which is empty for requests made without a session, like logins.
+type AuditEvent struct {
+	ID        string
+	ActorID   sql.NullString
+	UserID    sql.NullString
+	SessionID string
+	IP        string
+	UserAgent string
+	Type      string
+	Metadata  map[string]interface{}
+	CreatedAt time.Time
+}
+
+// AuditEventFilter narrows down a listing of audit events. zero values don't
+// filter. events come newest first, Before is the ID of the last event of the
+// previous page.
+type AuditEventFilter struct {
+	UserID string
+	Type   string
+	From   time.Time
+	To     time.Time
+	Before string
+	Limit  int
+}
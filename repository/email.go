@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+// statuses of an OutboxEmail. pending emails are picked up by the outbox
+// worker, dead ones ran out of attempts and need someone to look at them.
+const (
+	OutboxEmailStatusPending = "pending"
+	OutboxEmailStatusSent    = "sent"
+	OutboxEmailStatusDead    = "dead"
+)
+
+type EmailRecipient struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// OutboxEmail is an email waiting to be sent. the idempotency key stays the
+// same across retries, so that transports can recognize a resend.
+type OutboxEmail struct {
+	ID             string
+	IdempotencyKey string
+	Recipients     []EmailRecipient
+	Subject        string
+	HTMLContent    string
+	TextContent    string
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastError      sql.NullString
+	SentAt         sql.NullTime
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
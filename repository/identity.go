@@ -0,0 +1,26 @@
+package repository
+
+import "time"
+
+// UserIdentity links a user to their account at an external provider
+type UserIdentity struct {
+	ID        string
+	UserID    string
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// SocialLoginState is kept between sending the user to a provider and the
+// provider redirecting back. UserID is only set when linking a provider to a
+// signed in user.
+type SocialLoginState struct {
+	State        string
+	Provider     string
+	ClientID     string
+	UserID       string
+	Nonce        string
+	CodeVerifier string
+}
@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/utils/clock"
+)
+
+// RateLimitRepository is a single-process sliding window rate limiter. it is
+// meant for tests and local development, hits aren't shared between api
+// instances.
+type RateLimitRepository struct {
+	mu    sync.Mutex
+	hits  map[string][]time.Time
+	clock clock.Clock
+}
+
+func NewRateLimitRepository(clock clock.Clock) *RateLimitRepository {
+	return &RateLimitRepository{
+		hits:  map[string][]time.Time{},
+		clock: clock,
+	}
+}
+
+func (r *RateLimitRepository) Hit(
+	ctx context.Context,
+	key string,
+	limit int,
+	window time.Duration,
+) (*repository.RateLimit, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+
+	// drop the hits that have slid out of the window
+	hits := r.hits[key]
+	i := 0
+	for i < len(hits) && !hits[i].After(now.Add(-window)) {
+		i++
+	}
+	hits = hits[i:]
+
+	allowed := len(hits) < limit
+	if allowed {
+		hits = append(hits, now)
+	}
+
+	if len(hits) == 0 {
+		delete(r.hits, key)
+	} else {
+		r.hits[key] = hits
+	}
+
+	reset := window
+	if len(hits) > 0 {
+		reset = hits[0].Add(window).Sub(now)
+	}
+
+	remaining := limit - len(hits)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	rl := &repository.RateLimit{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     reset,
+	}
+	return rl, nil
+}
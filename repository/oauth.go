@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+// OAuthClient is an app allowed to sign its users in through userland. public
+// clients (e.g. SPAs and mobile apps) have no secret.
+type OAuthClient struct {
+	ID           string
+	Name         string
+	Secret       sql.NullString
+	RedirectURIs []string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type AuthorizationCode struct {
+	Code                string
+	ClientID            string
+	UserID              string
+	RedirectURI         string
+	Scope               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
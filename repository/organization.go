@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+// roles of a member within an organization, from the most to the least
+// privileged
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+type Organization struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Membership puts a user in an organization. Organization is only filled in
+// when listing the organizations of a user, and User, with the full name of
+// their bio, when listing the members of an organization.
+type Membership struct {
+	OrganizationID string
+	UserID         string
+	Role           string
+	Organization   *Organization
+	User           *User
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Invitation asks someone to join an organization by email, they may not have
+// an account yet. TokenID is the ID of the latest link sent for it, links with
+// another ID are no longer accepted.
+type Invitation struct {
+	ID             string
+	OrganizationID string
+	Email          string
+	Role           string
+	TokenID        string
+	InvitedBy      sql.NullString
+	ExpiresAt      time.Time
+	AcceptedAt     sql.NullTime
+	RevokedAt      sql.NullTime
+	Organization   *Organization
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// IsPending tells whether the invitation can still be accepted
+func (i *Invitation) IsPending(now time.Time) bool {
+	return !i.AcceptedAt.Valid && !i.RevokedAt.Valid && now.Before(i.ExpiresAt)
+}
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Passkey is a WebAuthn credential registered by a user
+type Passkey struct {
+	ID              string
+	UserID          string
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	AAGUID          []byte
+	SignCount       uint32
+	Transports      []string
+	BackupEligible  bool
+	BackupState     bool
+	Nickname        string
+	LastUsedAt      sql.NullTime
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// PasskeyCeremony is kept between the begin and finish steps of a WebAuthn
+// registration or login. UserID is only set when registering, ClientID only
+// when logging in. SessionData is opaque to the repository.
+type PasskeyCeremony struct {
+	ID          string
+	UserID      string
+	ClientID    string
+	SessionData []byte
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+// scopes of a personal access token. read lets the token make safe requests
+// and write any request, permissions have to be granted as scopes of their
+// own on top of the roles of the user.
+const (
+	PATScopeRead  = "read"
+	PATScopeWrite = "write"
+)
+
+var PATScopes = []string{
+	PATScopeRead,
+	PATScopeWrite,
+	PermissionAuditRead,
+	PermissionUsersRead,
+	PermissionUsersWrite,
+}
+
+// PersonalAccessToken lets scripts call the API as the user without logging
+// in. only the hash of the token is stored, the token is shown once.
+type PersonalAccessToken struct {
+	ID         string
+	UserID     string
+	Name       string
+	Scopes     []string
+	TokenHash  string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// IsExpired tells whether the token has an expiry and it has passed
+func (t *PersonalAccessToken) IsExpired(now time.Time) bool {
+	return t.ExpiresAt.Valid && !now.Before(t.ExpiresAt.Time)
+}
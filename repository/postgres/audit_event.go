@@ -0,0 +1,206 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/repository"
+)
+
+const (
+	auditEventTableName             = "audit_event"
+	auditEventTableIDColName        = "id"
+	auditEventTableUserIDColName    = "user_id"
+	auditEventTableTypeColName      = "type"
+	auditEventTableCreatedAtColName = "created_at"
+)
+
+type AuditEventRepository interface {
+	PrepareStatements(context.Context) error
+	CreateAuditEvent(ctx context.Context, ae *repository.AuditEvent) error
+	ListAuditEvents(
+		ctx context.Context,
+		f *repository.AuditEventFilter,
+	) ([]*repository.AuditEvent, error)
+}
+
+type BaseAuditEventRepository struct {
+	db         *sql.DB
+	statements *auditEventStatements
+}
+
+type auditEventStatements struct {
+	createAuditEventStmt *sql.Stmt
+	listAuditEventsStmt  *sql.Stmt
+}
+
+func NewBaseAuditEventRepository(db *sql.DB) *BaseAuditEventRepository {
+	return &BaseAuditEventRepository{
+		db: db,
+	}
+}
+
+func (r *BaseAuditEventRepository) scanAuditEvent(
+	ae *repository.AuditEvent,
+	rows *sql.Rows,
+) error {
+	var metadata []byte
+
+	err := rows.Scan(
+		&ae.ID,
+		&ae.ActorID,
+		&ae.UserID,
+		&ae.SessionID,
+		&ae.IP,
+		&ae.UserAgent,
+		&ae.Type,
+		&metadata,
+		&ae.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(metadata, &ae.Metadata)
+}
+
+func (r *BaseAuditEventRepository) PrepareStatements(ctx context.Context) error {
+	log.Info().Msg("preparing create audit event statement")
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+		 VALUES(DEFAULT, $1, $2, $3, $4, $5, $6, $7, $8)`,
+		auditEventTableName,
+	)
+	createAuditEventStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare create audit event statement")
+		return err
+	}
+
+	// every filter is skipped when its parameter is NULL. pages are cut with
+	// the position of the last event of the previous page, so that events
+	// written in between don't shift them.
+	log.Info().Msg("preparing list audit events statement")
+	query = fmt.Sprintf(
+		`SELECT *
+		 FROM %s
+		 WHERE ($1::uuid IS NULL OR %s = $1)
+		   AND ($2::text IS NULL OR %s = $2)
+		   AND ($3::timestamp IS NULL OR %s >= $3)
+		   AND ($4::timestamp IS NULL OR %s < $4)
+		   AND ($5::uuid IS NULL OR (%s, %s) < (
+			   SELECT %s, %s FROM %s WHERE %s = $5
+		   ))
+		 ORDER BY %s DESC, %s DESC
+		 LIMIT $6`,
+		auditEventTableName,
+		auditEventTableUserIDColName,
+		auditEventTableTypeColName,
+		auditEventTableCreatedAtColName,
+		auditEventTableCreatedAtColName,
+		auditEventTableCreatedAtColName,
+		auditEventTableIDColName,
+		auditEventTableCreatedAtColName,
+		auditEventTableIDColName,
+		auditEventTableName,
+		auditEventTableIDColName,
+		auditEventTableCreatedAtColName,
+		auditEventTableIDColName,
+	)
+	listAuditEventsStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare list audit events statement")
+		return err
+	}
+
+	r.statements = &auditEventStatements{
+		createAuditEventStmt: createAuditEventStmt,
+		listAuditEventsStmt:  listAuditEventsStmt,
+	}
+
+	return nil
+}
+
+func (r *BaseAuditEventRepository) CreateAuditEvent(
+	ctx context.Context,
+	ae *repository.AuditEvent,
+) error {
+	if ae.Metadata == nil {
+		ae.Metadata = map[string]interface{}{}
+	}
+	metadata, err := json.Marshal(ae.Metadata)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msg("running statement to create audit event")
+	_, err = stmt(ctx, r.statements.createAuditEventStmt).ExecContext(
+		ctx,
+		ae.ActorID,
+		ae.UserID,
+		ae.SessionID,
+		ae.IP,
+		ae.UserAgent,
+		ae.Type,
+		string(metadata),
+		time.Now(),
+	)
+	return err
+}
+
+func (r *BaseAuditEventRepository) ListAuditEvents(
+	ctx context.Context,
+	f *repository.AuditEventFilter,
+) ([]*repository.AuditEvent, error) {
+	log.Info().Msg("running statement to list audit events")
+	rows, err := stmt(ctx, r.statements.listAuditEventsStmt).QueryContext(
+		ctx,
+		nullIfZero(f.UserID),
+		nullIfZero(f.Type),
+		nullIfZero(f.From),
+		nullIfZero(f.To),
+		nullIfZero(f.Before),
+		f.Limit,
+	)
+	if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.InvalidTextRepresentation {
+		// a malformed user id or cursor can't match any event
+		return []*repository.AuditEvent{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*repository.AuditEvent{}
+	for rows.Next() {
+		ae := &repository.AuditEvent{}
+		if err := r.scanAuditEvent(ae, rows); err != nil {
+			return nil, err
+		}
+		events = append(events, ae)
+	}
+
+	return events, rows.Err()
+}
+
+// nullIfZero turns the zero value into a NULL parameter
+func nullIfZero(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,269 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/repository"
+)
+
+const (
+	emailOutboxTableName                  = "email_outbox"
+	emailOutboxTableIDColName             = "id"
+	emailOutboxTableIdempotencyKeyColName = "idempotency_key"
+	emailOutboxTableStatusColName         = "status"
+	emailOutboxTableAttemptsColName       = "attempts"
+	emailOutboxTableNextAttemptAtColName  = "next_attempt_at"
+	emailOutboxTableLastErrorColName      = "last_error"
+	emailOutboxTableSentAtColName         = "sent_at"
+	emailOutboxTableUpdatedAtColName      = "updated_at"
+)
+
+type EmailOutboxRepository interface {
+	PrepareStatements(context.Context) error
+	CreateOutboxEmail(ctx context.Context, oe *repository.OutboxEmail) error
+	ClaimDueOutboxEmails(
+		ctx context.Context,
+		now time.Time,
+		leaseUntil time.Time,
+		limit int,
+	) ([]*repository.OutboxEmail, error)
+	MarkOutboxEmailSent(ctx context.Context, id string, sentAt time.Time) error
+	MarkOutboxEmailFailed(
+		ctx context.Context,
+		id string,
+		status string,
+		attempts int,
+		nextAttemptAt time.Time,
+		lastError string,
+	) error
+}
+
+type BaseEmailOutboxRepository struct {
+	db         *sql.DB
+	statements *emailOutboxStatements
+}
+
+type emailOutboxStatements struct {
+	createOutboxEmailStmt     *sql.Stmt
+	claimDueOutboxEmailsStmt  *sql.Stmt
+	markOutboxEmailSentStmt   *sql.Stmt
+	markOutboxEmailFailedStmt *sql.Stmt
+}
+
+func NewBaseEmailOutboxRepository(db *sql.DB) *BaseEmailOutboxRepository {
+	return &BaseEmailOutboxRepository{
+		db: db,
+	}
+}
+
+type outboxEmailScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (r *BaseEmailOutboxRepository) scanOutboxEmail(
+	oe *repository.OutboxEmail,
+	row outboxEmailScanner,
+) error {
+	var recipients []byte
+
+	err := row.Scan(
+		&oe.ID,
+		&oe.IdempotencyKey,
+		&recipients,
+		&oe.Subject,
+		&oe.HTMLContent,
+		&oe.TextContent,
+		&oe.Status,
+		&oe.Attempts,
+		&oe.NextAttemptAt,
+		&oe.LastError,
+		&oe.SentAt,
+		&oe.CreatedAt,
+		&oe.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(recipients, &oe.Recipients)
+}
+
+func (r *BaseEmailOutboxRepository) PrepareStatements(ctx context.Context) error {
+	log.Info().Msg("preparing create outbox email statement")
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+		 VALUES(DEFAULT, $1, $2, $3, $4, $5, $6, 0, $7, NULL, NULL, $7, $7)
+		 ON CONFLICT (%s) DO NOTHING`,
+		emailOutboxTableName,
+		emailOutboxTableIdempotencyKeyColName,
+	)
+	createOutboxEmailStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare create outbox email statement")
+		return err
+	}
+
+	// pushing next_attempt_at forward leases the emails to this worker, so
+	// other workers leave them alone until the lease runs out
+	log.Info().Msg("preparing claim due outbox emails statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET %s = $2, %s = $1
+		 WHERE %s IN (
+			 SELECT %s
+			 FROM %s
+			 WHERE %s = '%s' AND %s <= $1
+			 ORDER BY %s
+			 LIMIT $3
+			 FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING *`,
+		emailOutboxTableName,
+		emailOutboxTableNextAttemptAtColName,
+		emailOutboxTableUpdatedAtColName,
+		emailOutboxTableIDColName,
+		emailOutboxTableIDColName,
+		emailOutboxTableName,
+		emailOutboxTableStatusColName,
+		repository.OutboxEmailStatusPending,
+		emailOutboxTableNextAttemptAtColName,
+		emailOutboxTableNextAttemptAtColName,
+	)
+	claimDueOutboxEmailsStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare claim due outbox emails statement")
+		return err
+	}
+
+	log.Info().Msg("preparing mark outbox email sent statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET %s = '%s', %s = %s + 1, %s = $2, %s = $2
+		 WHERE %s = $1`,
+		emailOutboxTableName,
+		emailOutboxTableStatusColName,
+		repository.OutboxEmailStatusSent,
+		emailOutboxTableAttemptsColName,
+		emailOutboxTableAttemptsColName,
+		emailOutboxTableSentAtColName,
+		emailOutboxTableUpdatedAtColName,
+		emailOutboxTableIDColName,
+	)
+	markOutboxEmailSentStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare mark outbox email sent statement")
+		return err
+	}
+
+	log.Info().Msg("preparing mark outbox email failed statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET %s = $2, %s = $3, %s = $4, %s = $5, %s = NOW()
+		 WHERE %s = $1`,
+		emailOutboxTableName,
+		emailOutboxTableStatusColName,
+		emailOutboxTableAttemptsColName,
+		emailOutboxTableNextAttemptAtColName,
+		emailOutboxTableLastErrorColName,
+		emailOutboxTableUpdatedAtColName,
+		emailOutboxTableIDColName,
+	)
+	markOutboxEmailFailedStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare mark outbox email failed statement")
+		return err
+	}
+
+	r.statements = &emailOutboxStatements{
+		createOutboxEmailStmt:     createOutboxEmailStmt,
+		claimDueOutboxEmailsStmt:  claimDueOutboxEmailsStmt,
+		markOutboxEmailSentStmt:   markOutboxEmailSentStmt,
+		markOutboxEmailFailedStmt: markOutboxEmailFailedStmt,
+	}
+
+	return nil
+}
+
+// CreateOutboxEmail queues an email. an email with the same idempotency key is
+// only queued once. it takes part in the transaction in ctx, if there is one.
+func (r *BaseEmailOutboxRepository) CreateOutboxEmail(
+	ctx context.Context,
+	oe *repository.OutboxEmail,
+) error {
+	recipients, err := json.Marshal(oe.Recipients)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msg("running statement to create outbox email")
+	_, err = stmt(ctx, r.statements.createOutboxEmailStmt).ExecContext(
+		ctx,
+		oe.IdempotencyKey,
+		string(recipients),
+		oe.Subject,
+		oe.HTMLContent,
+		oe.TextContent,
+		repository.OutboxEmailStatusPending,
+		time.Now(),
+	)
+	return err
+}
+
+// ClaimDueOutboxEmails returns up to limit pending emails that are due, and
+// keeps them from being claimed again until leaseUntil
+func (r *BaseEmailOutboxRepository) ClaimDueOutboxEmails(
+	ctx context.Context,
+	now time.Time,
+	leaseUntil time.Time,
+	limit int,
+) ([]*repository.OutboxEmail, error) {
+	log.Debug().Msg("running statement to claim due outbox emails")
+	rows, err := stmt(ctx, r.statements.claimDueOutboxEmailsStmt).
+		QueryContext(ctx, now, leaseUntil, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []*repository.OutboxEmail{}
+	for rows.Next() {
+		oe := &repository.OutboxEmail{}
+		if err := r.scanOutboxEmail(oe, rows); err != nil {
+			return nil, err
+		}
+		emails = append(emails, oe)
+	}
+
+	return emails, rows.Err()
+}
+
+func (r *BaseEmailOutboxRepository) MarkOutboxEmailSent(
+	ctx context.Context,
+	id string,
+	sentAt time.Time,
+) error {
+	log.Info().Msg("running statement to mark outbox email sent")
+	_, err := stmt(ctx, r.statements.markOutboxEmailSentStmt).ExecContext(ctx, id, sentAt)
+	return err
+}
+
+// MarkOutboxEmailFailed records a failed attempt. the status is either pending,
+// to retry at nextAttemptAt, or dead
+func (r *BaseEmailOutboxRepository) MarkOutboxEmailFailed(
+	ctx context.Context,
+	id string,
+	status string,
+	attempts int,
+	nextAttemptAt time.Time,
+	lastError string,
+) error {
+	log.Info().Msg("running statement to mark outbox email failed")
+	_, err := stmt(ctx, r.statements.markOutboxEmailFailedStmt).
+		ExecContext(ctx, id, status, attempts, nextAttemptAt, lastError)
+	return err
+}
@@ -0,0 +1,421 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/repository"
+)
+
+const (
+	invitationTableName                  = "invitation"
+	invitationTableIDColName             = "id"
+	invitationTableOrganizationIDColName = "organization_id"
+	invitationTableEmailColName          = "email"
+	invitationTableTokenIDColName        = "token_id"
+	invitationTableExpiresAtColName      = "expires_at"
+	invitationTableAcceptedAtColName     = "accepted_at"
+	invitationTableRevokedAtColName      = "revoked_at"
+	invitationTableCreatedAtColName      = "created_at"
+	invitationTableUpdatedAtColName      = "updated_at"
+)
+
+// an invitation is pending until it's accepted or revoked, expired ones are
+// pending too until someone renews or revokes them
+type InvitationRepository interface {
+	PrepareStatements(context.Context) error
+	CreateInvitation(
+		ctx context.Context,
+		i *repository.Invitation,
+	) (*repository.Invitation, error)
+	GetInvitationByID(ctx context.Context, id string) (*repository.Invitation, error)
+	GetPendingInvitationByEmail(
+		ctx context.Context,
+		orgID string,
+		email string,
+	) (*repository.Invitation, error)
+	ListPendingInvitationsByOrganizationID(
+		ctx context.Context,
+		orgID string,
+	) ([]*repository.Invitation, error)
+	RenewInvitation(
+		ctx context.Context,
+		id string,
+		tokenID string,
+		expiresAt time.Time,
+	) error
+	RevokeInvitation(ctx context.Context, id string) error
+	AcceptInvitation(ctx context.Context, id string, tokenID string) error
+}
+
+type BaseInvitationRepository struct {
+	db         *sql.DB
+	statements *invitationStatements
+}
+
+type invitationStatements struct {
+	createInvitationStmt                       *sql.Stmt
+	getInvitationByIDStmt                      *sql.Stmt
+	getPendingInvitationByEmailStmt            *sql.Stmt
+	listPendingInvitationsByOrganizationIDStmt *sql.Stmt
+	renewInvitationStmt                        *sql.Stmt
+	revokeInvitationStmt                       *sql.Stmt
+	acceptInvitationStmt                       *sql.Stmt
+}
+
+func NewBaseInvitationRepository(db *sql.DB) *BaseInvitationRepository {
+	return &BaseInvitationRepository{
+		db: db,
+	}
+}
+
+type invitationScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (r *BaseInvitationRepository) scanInvitation(
+	i *repository.Invitation,
+	row invitationScanner,
+) error {
+	return row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Email,
+		&i.Role,
+		&i.TokenID,
+		&i.InvitedBy,
+		&i.ExpiresAt,
+		&i.AcceptedAt,
+		&i.RevokedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+}
+
+func (r *BaseInvitationRepository) PrepareStatements(ctx context.Context) error {
+	log.Info().Msg("preparing create invitation statement")
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+		 VALUES(DEFAULT, $1, $2, $3, $4, $5, $6, NULL, NULL, $7, $8)
+		 RETURNING id`,
+		invitationTableName,
+	)
+	createInvitationStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare create invitation statement")
+		return err
+	}
+
+	log.Info().Msg("preparing get invitation by id statement")
+	query = fmt.Sprintf(
+		`SELECT *
+		 FROM %s
+		 WHERE %s = $1`,
+		invitationTableName,
+		invitationTableIDColName,
+	)
+	getInvitationByIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare get invitation by id statement")
+		return err
+	}
+
+	log.Info().Msg("preparing get pending invitation by email statement")
+	query = fmt.Sprintf(
+		`SELECT *
+		 FROM %s
+		 WHERE %s = $1 AND %s = $2 AND %s IS NULL AND %s IS NULL`,
+		invitationTableName,
+		invitationTableOrganizationIDColName,
+		invitationTableEmailColName,
+		invitationTableAcceptedAtColName,
+		invitationTableRevokedAtColName,
+	)
+	getPendingInvitationByEmailStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare get pending invitation by email statement")
+		return err
+	}
+
+	log.Info().Msg("preparing list pending invitations by organization id statement")
+	query = fmt.Sprintf(
+		`SELECT *
+		 FROM %s
+		 WHERE %s = $1 AND %s IS NULL AND %s IS NULL
+		 ORDER BY %s DESC, %s`,
+		invitationTableName,
+		invitationTableOrganizationIDColName,
+		invitationTableAcceptedAtColName,
+		invitationTableRevokedAtColName,
+		invitationTableCreatedAtColName,
+		invitationTableIDColName,
+	)
+	listPendingInvitationsByOrganizationIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare list pending invitations by organization id statement")
+		return err
+	}
+
+	log.Info().Msg("preparing renew invitation statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET
+		   %s = $1,
+		   %s = $2,
+		   %s = $3
+		 WHERE %s = $4 AND %s IS NULL AND %s IS NULL`,
+		invitationTableName,
+		invitationTableTokenIDColName,
+		invitationTableExpiresAtColName,
+		invitationTableUpdatedAtColName,
+		invitationTableIDColName,
+		invitationTableAcceptedAtColName,
+		invitationTableRevokedAtColName,
+	)
+	renewInvitationStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare renew invitation statement")
+		return err
+	}
+
+	log.Info().Msg("preparing revoke invitation statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET
+		   %s = $1,
+		   %s = $1
+		 WHERE %s = $2 AND %s IS NULL AND %s IS NULL`,
+		invitationTableName,
+		invitationTableRevokedAtColName,
+		invitationTableUpdatedAtColName,
+		invitationTableIDColName,
+		invitationTableAcceptedAtColName,
+		invitationTableRevokedAtColName,
+	)
+	revokeInvitationStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare revoke invitation statement")
+		return err
+	}
+
+	// the token id and the expiry are checked in the same statement, so a link
+	// can't be accepted twice or after it was renewed
+	log.Info().Msg("preparing accept invitation statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET
+		   %s = $1,
+		   %s = $1
+		 WHERE %s = $2 AND %s = $3 AND %s > $1 AND %s IS NULL AND %s IS NULL`,
+		invitationTableName,
+		invitationTableAcceptedAtColName,
+		invitationTableUpdatedAtColName,
+		invitationTableIDColName,
+		invitationTableTokenIDColName,
+		invitationTableExpiresAtColName,
+		invitationTableAcceptedAtColName,
+		invitationTableRevokedAtColName,
+	)
+	acceptInvitationStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare accept invitation statement")
+		return err
+	}
+
+	r.statements = &invitationStatements{
+		createInvitationStmt:                       createInvitationStmt,
+		getInvitationByIDStmt:                      getInvitationByIDStmt,
+		getPendingInvitationByEmailStmt:            getPendingInvitationByEmailStmt,
+		listPendingInvitationsByOrganizationIDStmt: listPendingInvitationsByOrganizationIDStmt,
+		renewInvitationStmt:                        renewInvitationStmt,
+		revokeInvitationStmt:                       revokeInvitationStmt,
+		acceptInvitationStmt:                       acceptInvitationStmt,
+	}
+
+	return nil
+}
+
+// CreateInvitation stores the invitation, it's a UniqueViolationError when
+// the email has a pending invitation to the organization already
+func (r *BaseInvitationRepository) CreateInvitation(
+	ctx context.Context,
+	i *repository.Invitation,
+) (*repository.Invitation, error) {
+	now := time.Now()
+	i.CreatedAt = now
+	i.UpdatedAt = now
+
+	log.Info().Msg("running statement to create invitation")
+	err := stmt(ctx, r.statements.createInvitationStmt).
+		QueryRowContext(
+			ctx,
+			i.OrganizationID,
+			i.Email,
+			i.Role,
+			i.TokenID,
+			i.InvitedBy,
+			i.ExpiresAt,
+			now,
+			now,
+		).
+		Scan(&i.ID)
+	if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.UniqueViolation {
+		log.Error().Err(err).Msg("email is invited already")
+		return nil, repository.NewUniqueViolationError()
+	}
+
+	return i, err
+}
+
+func (r *BaseInvitationRepository) GetInvitationByID(
+	ctx context.Context,
+	id string,
+) (*repository.Invitation, error) {
+	i := &repository.Invitation{}
+
+	log.Info().Msg("running statement to get invitation by id")
+	row := stmt(ctx, r.statements.getInvitationByIDStmt).QueryRowContext(ctx, id)
+	err := r.scanInvitation(i, row)
+	if err == sql.ErrNoRows || isInvalidTextRepresentation(err) {
+		log.Error().Err(err).Msg("failed to find invitation")
+		return nil, repository.NewNotFoundError()
+	}
+
+	return i, err
+}
+
+func (r *BaseInvitationRepository) GetPendingInvitationByEmail(
+	ctx context.Context,
+	orgID string,
+	email string,
+) (*repository.Invitation, error) {
+	i := &repository.Invitation{}
+
+	log.Info().Msg("running statement to get pending invitation by email")
+	row := stmt(ctx, r.statements.getPendingInvitationByEmailStmt).QueryRowContext(ctx, orgID, email)
+	err := r.scanInvitation(i, row)
+	if err == sql.ErrNoRows {
+		log.Error().Err(err).Msg("failed to find invitation")
+		return nil, repository.NewNotFoundError()
+	}
+
+	return i, err
+}
+
+// ListPendingInvitationsByOrganizationID lists the pending invitations of the
+// organization, the latest first
+func (r *BaseInvitationRepository) ListPendingInvitationsByOrganizationID(
+	ctx context.Context,
+	orgID string,
+) ([]*repository.Invitation, error) {
+	log.Info().Msg("running statement to list pending invitations by organization id")
+	rows, err := stmt(ctx, r.statements.listPendingInvitationsByOrganizationIDStmt).
+		QueryContext(ctx, orgID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list invitations")
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*repository.Invitation{}
+	for rows.Next() {
+		i := &repository.Invitation{}
+		err := r.scanInvitation(i, rows)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to scan invitation")
+			return nil, err
+		}
+		invitations = append(invitations, i)
+	}
+
+	return invitations, rows.Err()
+}
+
+// RenewInvitation replaces the token id and the expiry of a pending
+// invitation, it's a NotFoundError when the invitation isn't pending
+func (r *BaseInvitationRepository) RenewInvitation(
+	ctx context.Context,
+	id string,
+	tokenID string,
+	expiresAt time.Time,
+) error {
+	log.Info().Msg("running statement to renew invitation")
+	res, err := stmt(ctx, r.statements.renewInvitationStmt).
+		ExecContext(ctx, tokenID, expiresAt, time.Now(), id)
+	if isInvalidTextRepresentation(err) {
+		return repository.NewNotFoundError()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to renew invitation")
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.NewNotFoundError()
+	}
+
+	return nil
+}
+
+// RevokeInvitation revokes a pending invitation, it's a NotFoundError when the
+// invitation isn't pending
+func (r *BaseInvitationRepository) RevokeInvitation(ctx context.Context, id string) error {
+	log.Info().Msg("running statement to revoke invitation")
+	res, err := stmt(ctx, r.statements.revokeInvitationStmt).ExecContext(ctx, time.Now(), id)
+	if isInvalidTextRepresentation(err) {
+		return repository.NewNotFoundError()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to revoke invitation")
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.NewNotFoundError()
+	}
+
+	return nil
+}
+
+// AcceptInvitation marks the invitation as accepted. it's a NotFoundError when
+// the invitation isn't pending, has expired or was renewed since the token was
+// sent.
+func (r *BaseInvitationRepository) AcceptInvitation(
+	ctx context.Context,
+	id string,
+	tokenID string,
+) error {
+	log.Info().Msg("running statement to accept invitation")
+	res, err := stmt(ctx, r.statements.acceptInvitationStmt).
+		ExecContext(ctx, time.Now(), id, tokenID)
+	if isInvalidTextRepresentation(err) {
+		return repository.NewNotFoundError()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to accept invitation")
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.NewNotFoundError()
+	}
+
+	return nil
+}
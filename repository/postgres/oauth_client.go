@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/repository"
+)
+
+const (
+	oauthClientTableName      = "oauth_client"
+	oauthClientTableIDColName = "id"
+
+	oauthClientRedirectURITableName               = "oauth_client_redirect_uri"
+	oauthClientRedirectURITableClientIDColName    = "client_id"
+	oauthClientRedirectURITableRedirectURIColName = "redirect_uri"
+)
+
+// OAuthClientRepository is read only, clients are registered by inserting rows
+// into the db directly
+type OAuthClientRepository interface {
+	PrepareStatements(context.Context) error
+	GetOAuthClientByID(ctx context.Context, clientID string) (*repository.OAuthClient, error)
+}
+
+type BaseOAuthClientRepository struct {
+	db         *sql.DB
+	statements *oauthClientStatements
+}
+
+type oauthClientStatements struct {
+	getOAuthClientByIDStmt        *sql.Stmt
+	getRedirectURIsByClientIDStmt *sql.Stmt
+}
+
+func NewBaseOAuthClientRepository(db *sql.DB) *BaseOAuthClientRepository {
+	return &BaseOAuthClientRepository{
+		db: db,
+	}
+}
+
+func (r *BaseOAuthClientRepository) PrepareStatements(ctx context.Context) error {
+	log.Info().Msg("preparing get oauth client by id statement")
+	query := fmt.Sprintf(
+		`SELECT *
+		 FROM %s
+		 WHERE %s = $1`,
+		oauthClientTableName,
+		oauthClientTableIDColName,
+	)
+	getOAuthClientByIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare get oauth client by id statement")
+		return err
+	}
+
+	log.Info().Msg("preparing get redirect uris by client id statement")
+	query = fmt.Sprintf(
+		`SELECT %s
+		 FROM %s
+		 WHERE %s = $1`,
+		oauthClientRedirectURITableRedirectURIColName,
+		oauthClientRedirectURITableName,
+		oauthClientRedirectURITableClientIDColName,
+	)
+	getRedirectURIsByClientIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare get redirect uris by client id statement")
+		return err
+	}
+
+	r.statements = &oauthClientStatements{
+		getOAuthClientByIDStmt:        getOAuthClientByIDStmt,
+		getRedirectURIsByClientIDStmt: getRedirectURIsByClientIDStmt,
+	}
+
+	return nil
+}
+
+func (r *BaseOAuthClientRepository) GetOAuthClientByID(
+	ctx context.Context,
+	clientID string,
+) (*repository.OAuthClient, error) {
+	c := &repository.OAuthClient{}
+
+	log.Info().Msg("running statement to get oauth client by id")
+	row := r.statements.getOAuthClientByIDStmt.QueryRowContext(ctx, clientID)
+	err := row.Scan(
+		&c.ID,
+		&c.Name,
+		&c.Secret,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		log.Error().Err(err).Msg("failed to find oauth client")
+		return nil, repository.NewNotFoundError()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Msg("running statement to get redirect uris by client id")
+	rows, err := r.statements.getRedirectURIsByClientIDStmt.QueryContext(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	c.RedirectURIs = []string{}
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			return nil, err
+		}
+		c.RedirectURIs = append(c.RedirectURIs, uri)
+	}
+
+	return c, rows.Err()
+}
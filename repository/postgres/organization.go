@@ -0,0 +1,540 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/repository"
+)
+
+const (
+	organizationTableName             = "organization"
+	organizationTableIDColName        = "id"
+	organizationTableNameColName      = "name"
+	organizationTableUpdatedAtColName = "updated_at"
+
+	membershipTableName                  = "membership"
+	membershipTableOrganizationIDColName = "organization_id"
+	membershipTableUserIDColName         = "user_id"
+	membershipTableRoleColName           = "role"
+	membershipTableCreatedAtColName      = "created_at"
+	membershipTableUpdatedAtColName      = "updated_at"
+)
+
+type OrganizationRepository interface {
+	PrepareStatements(context.Context) error
+	CreateOrganization(
+		ctx context.Context,
+		o *repository.Organization,
+	) (*repository.Organization, error)
+	GetOrganizationByID(ctx context.Context, orgID string) (*repository.Organization, error)
+	UpdateOrganizationByID(
+		ctx context.Context,
+		orgID string,
+		name string,
+	) (*repository.Organization, error)
+	DeleteOrganizationByID(ctx context.Context, orgID string) error
+	CreateMembership(ctx context.Context, m *repository.Membership) error
+	GetMembership(ctx context.Context, orgID string, userID string) (*repository.Membership, error)
+	ListMembershipsByUserID(ctx context.Context, userID string) ([]*repository.Membership, error)
+	ListMembershipsByOrganizationID(
+		ctx context.Context,
+		orgID string,
+	) ([]*repository.Membership, error)
+	UpdateMembershipRole(ctx context.Context, orgID string, userID string, role string) error
+	DeleteMembership(ctx context.Context, orgID string, userID string) error
+	CountMembershipsWithRole(ctx context.Context, orgID string, role string) (int, error)
+}
+
+type BaseOrganizationRepository struct {
+	db         *sql.DB
+	statements *organizationStatements
+}
+
+type organizationStatements struct {
+	createOrganizationStmt              *sql.Stmt
+	getOrganizationByIDStmt             *sql.Stmt
+	updateOrganizationByIDStmt          *sql.Stmt
+	deleteOrganizationByIDStmt          *sql.Stmt
+	createMembershipStmt                *sql.Stmt
+	getMembershipStmt                   *sql.Stmt
+	listMembershipsByUserIDStmt         *sql.Stmt
+	listMembershipsByOrganizationIDStmt *sql.Stmt
+	updateMembershipRoleStmt            *sql.Stmt
+	deleteMembershipStmt                *sql.Stmt
+	countMembershipsWithRoleStmt        *sql.Stmt
+}
+
+func NewBaseOrganizationRepository(db *sql.DB) *BaseOrganizationRepository {
+	return &BaseOrganizationRepository{
+		db: db,
+	}
+}
+
+type organizationScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (r *BaseOrganizationRepository) scanOrganization(
+	o *repository.Organization,
+	row organizationScanner,
+) error {
+	return row.Scan(
+		&o.ID,
+		&o.Name,
+		&o.CreatedAt,
+		&o.UpdatedAt,
+	)
+}
+
+// scanMembership scans the columns of the membership table, followed by the
+// extra columns of the query into dest
+func (r *BaseOrganizationRepository) scanMembership(
+	m *repository.Membership,
+	row organizationScanner,
+	dest ...interface{},
+) error {
+	return row.Scan(append([]interface{}{
+		&m.OrganizationID,
+		&m.UserID,
+		&m.Role,
+		&m.CreatedAt,
+		&m.UpdatedAt,
+	}, dest...)...)
+}
+
+func (r *BaseOrganizationRepository) PrepareStatements(ctx context.Context) error {
+	log.Info().Msg("preparing create organization statement")
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+		 VALUES(DEFAULT, $1, $2, $3)
+		 RETURNING id`,
+		organizationTableName,
+	)
+	createOrganizationStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare create organization statement")
+		return err
+	}
+
+	log.Info().Msg("preparing get organization by id statement")
+	query = fmt.Sprintf(
+		`SELECT *
+		 FROM %s
+		 WHERE %s = $1`,
+		organizationTableName,
+		organizationTableIDColName,
+	)
+	getOrganizationByIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare get organization by id statement")
+		return err
+	}
+
+	log.Info().Msg("preparing update organization by id statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET
+		   %s = $1,
+			 %s = $2
+		 WHERE %s = $3
+		 RETURNING *`,
+		organizationTableName,
+		organizationTableNameColName,
+		organizationTableUpdatedAtColName,
+		organizationTableIDColName,
+	)
+	updateOrganizationByIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare update organization by id statement")
+		return err
+	}
+
+	log.Info().Msg("preparing delete organization by id statement")
+	query = fmt.Sprintf(
+		`DELETE FROM %s
+		 WHERE %s = $1`,
+		organizationTableName,
+		organizationTableIDColName,
+	)
+	deleteOrganizationByIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare delete organization by id statement")
+		return err
+	}
+
+	log.Info().Msg("preparing create membership statement")
+	query = fmt.Sprintf(
+		`INSERT INTO %s
+		 VALUES($1, $2, $3, $4, $5)`,
+		membershipTableName,
+	)
+	createMembershipStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare create membership statement")
+		return err
+	}
+
+	log.Info().Msg("preparing get membership statement")
+	query = fmt.Sprintf(
+		`SELECT *
+		 FROM %s
+		 WHERE %s = $1 AND %s = $2`,
+		membershipTableName,
+		membershipTableOrganizationIDColName,
+		membershipTableUserIDColName,
+	)
+	getMembershipStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare get membership statement")
+		return err
+	}
+
+	log.Info().Msg("preparing list memberships by user id statement")
+	query = fmt.Sprintf(
+		`SELECT m.*, o.*
+		 FROM %s m
+		 JOIN %s o ON o.%s = m.%s
+		 WHERE m.%s = $1
+		 ORDER BY o.%s, o.%s`,
+		membershipTableName,
+		organizationTableName,
+		organizationTableIDColName,
+		membershipTableOrganizationIDColName,
+		membershipTableUserIDColName,
+		organizationTableNameColName,
+		organizationTableIDColName,
+	)
+	listMembershipsByUserIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare list memberships by user id statement")
+		return err
+	}
+
+	// deleted users keep their memberships, in case they are restored, but
+	// they aren't listed
+	log.Info().Msg("preparing list memberships by organization id statement")
+	query = fmt.Sprintf(
+		`SELECT m.*, u.%s, COALESCE(ub.%s, '')
+		 FROM %s m
+		 JOIN %s u ON u.%s = m.%s
+		 LEFT JOIN %s ub ON ub.%s = u.%s
+		 WHERE m.%s = $1 AND u.%s IS NULL
+		 ORDER BY m.%s, m.%s`,
+		userTableEmailColName,
+		userBioTableFullNameColName,
+		membershipTableName,
+		userTableName,
+		userTableIDColName,
+		membershipTableUserIDColName,
+		userBioTableName,
+		userBioTableUserIDColName,
+		userTableIDColName,
+		membershipTableOrganizationIDColName,
+		userTableDeletedAtColName,
+		membershipTableCreatedAtColName,
+		membershipTableUserIDColName,
+	)
+	listMembershipsByOrganizationIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare list memberships by organization id statement")
+		return err
+	}
+
+	log.Info().Msg("preparing update membership role statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET
+		   %s = $1,
+			 %s = $2
+		 WHERE %s = $3 AND %s = $4`,
+		membershipTableName,
+		membershipTableRoleColName,
+		membershipTableUpdatedAtColName,
+		membershipTableOrganizationIDColName,
+		membershipTableUserIDColName,
+	)
+	updateMembershipRoleStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare update membership role statement")
+		return err
+	}
+
+	log.Info().Msg("preparing delete membership statement")
+	query = fmt.Sprintf(
+		`DELETE FROM %s
+		 WHERE %s = $1 AND %s = $2`,
+		membershipTableName,
+		membershipTableOrganizationIDColName,
+		membershipTableUserIDColName,
+	)
+	deleteMembershipStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare delete membership statement")
+		return err
+	}
+
+	log.Info().Msg("preparing count memberships with role statement")
+	query = fmt.Sprintf(
+		`SELECT COUNT(*)
+		 FROM %s
+		 WHERE %s = $1 AND %s = $2`,
+		membershipTableName,
+		membershipTableOrganizationIDColName,
+		membershipTableRoleColName,
+	)
+	countMembershipsWithRoleStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare count memberships with role statement")
+		return err
+	}
+
+	r.statements = &organizationStatements{
+		createOrganizationStmt:              createOrganizationStmt,
+		getOrganizationByIDStmt:             getOrganizationByIDStmt,
+		updateOrganizationByIDStmt:          updateOrganizationByIDStmt,
+		deleteOrganizationByIDStmt:          deleteOrganizationByIDStmt,
+		createMembershipStmt:                createMembershipStmt,
+		getMembershipStmt:                   getMembershipStmt,
+		listMembershipsByUserIDStmt:         listMembershipsByUserIDStmt,
+		listMembershipsByOrganizationIDStmt: listMembershipsByOrganizationIDStmt,
+		updateMembershipRoleStmt:            updateMembershipRoleStmt,
+		deleteMembershipStmt:                deleteMembershipStmt,
+		countMembershipsWithRoleStmt:        countMembershipsWithRoleStmt,
+	}
+
+	return nil
+}
+
+func (r *BaseOrganizationRepository) CreateOrganization(
+	ctx context.Context,
+	o *repository.Organization,
+) (*repository.Organization, error) {
+	now := time.Now()
+	o.CreatedAt = now
+	o.UpdatedAt = now
+
+	log.Info().Msg("running statement to create organization")
+	err := stmt(ctx, r.statements.createOrganizationStmt).
+		QueryRowContext(ctx, o.Name, now, now).
+		Scan(&o.ID)
+
+	return o, err
+}
+
+func (r *BaseOrganizationRepository) GetOrganizationByID(
+	ctx context.Context,
+	orgID string,
+) (*repository.Organization, error) {
+	o := &repository.Organization{}
+
+	log.Info().Msg("running statement to get organization by id")
+	row := stmt(ctx, r.statements.getOrganizationByIDStmt).QueryRowContext(ctx, orgID)
+	err := r.scanOrganization(o, row)
+	if err == sql.ErrNoRows || isInvalidTextRepresentation(err) {
+		log.Error().Err(err).Msg("failed to find organization")
+		return nil, repository.NewNotFoundError()
+	}
+
+	return o, err
+}
+
+func (r *BaseOrganizationRepository) UpdateOrganizationByID(
+	ctx context.Context,
+	orgID string,
+	name string,
+) (*repository.Organization, error) {
+	o := &repository.Organization{}
+
+	log.Info().Msg("running statement to update organization by id")
+	row := stmt(ctx, r.statements.updateOrganizationByIDStmt).
+		QueryRowContext(ctx, name, time.Now(), orgID)
+	err := r.scanOrganization(o, row)
+	if err == sql.ErrNoRows {
+		log.Error().Err(err).Msg("failed to find organization")
+		return nil, repository.NewNotFoundError()
+	}
+
+	return o, err
+}
+
+// DeleteOrganizationByID deletes the organization along with its memberships
+func (r *BaseOrganizationRepository) DeleteOrganizationByID(
+	ctx context.Context,
+	orgID string,
+) error {
+	log.Info().Msg("running statement to delete organization by id")
+	_, err := stmt(ctx, r.statements.deleteOrganizationByIDStmt).ExecContext(ctx, orgID)
+
+	return err
+}
+
+// CreateMembership adds the user to the organization. it's a
+// UniqueViolationError when they are a member already.
+func (r *BaseOrganizationRepository) CreateMembership(
+	ctx context.Context,
+	m *repository.Membership,
+) error {
+	now := time.Now()
+	m.CreatedAt = now
+	m.UpdatedAt = now
+
+	log.Info().Msg("running statement to create membership")
+	_, err := stmt(ctx, r.statements.createMembershipStmt).
+		ExecContext(ctx, m.OrganizationID, m.UserID, m.Role, now, now)
+	if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.UniqueViolation {
+		log.Error().Err(err).Msg("user is a member already")
+		return repository.NewUniqueViolationError()
+	}
+
+	return err
+}
+
+// GetMembership gets the membership of the user in the organization, it's a
+// NotFoundError when they aren't a member
+func (r *BaseOrganizationRepository) GetMembership(
+	ctx context.Context,
+	orgID string,
+	userID string,
+) (*repository.Membership, error) {
+	m := &repository.Membership{}
+
+	log.Info().Msg("running statement to get membership")
+	row := stmt(ctx, r.statements.getMembershipStmt).QueryRowContext(ctx, orgID, userID)
+	err := r.scanMembership(m, row)
+	if err == sql.ErrNoRows || isInvalidTextRepresentation(err) {
+		log.Error().Err(err).Msg("failed to find membership")
+		return nil, repository.NewNotFoundError()
+	}
+
+	return m, err
+}
+
+// ListMembershipsByUserID lists the organizations the user is a member of,
+// sorted by name
+func (r *BaseOrganizationRepository) ListMembershipsByUserID(
+	ctx context.Context,
+	userID string,
+) ([]*repository.Membership, error) {
+	log.Info().Msg("running statement to list memberships by user id")
+	rows, err := stmt(ctx, r.statements.listMembershipsByUserIDStmt).QueryContext(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list memberships")
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []*repository.Membership{}
+	for rows.Next() {
+		m := &repository.Membership{}
+		o := &repository.Organization{}
+		err := r.scanMembership(m, rows, &o.ID, &o.Name, &o.CreatedAt, &o.UpdatedAt)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to scan membership")
+			return nil, err
+		}
+		m.Organization = o
+		memberships = append(memberships, m)
+	}
+
+	return memberships, rows.Err()
+}
+
+// ListMembershipsByOrganizationID lists the members of the organization, the
+// earliest to join first
+func (r *BaseOrganizationRepository) ListMembershipsByOrganizationID(
+	ctx context.Context,
+	orgID string,
+) ([]*repository.Membership, error) {
+	log.Info().Msg("running statement to list memberships by organization id")
+	rows, err := stmt(ctx, r.statements.listMembershipsByOrganizationIDStmt).QueryContext(ctx, orgID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list memberships")
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []*repository.Membership{}
+	for rows.Next() {
+		m := &repository.Membership{}
+		u := &repository.User{
+			UserBio: &repository.UserBio{},
+		}
+		err := r.scanMembership(m, rows, &u.Email, &u.UserBio.Fullname)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to scan membership")
+			return nil, err
+		}
+		u.ID = m.UserID
+		m.User = u
+		memberships = append(memberships, m)
+	}
+
+	return memberships, rows.Err()
+}
+
+func (r *BaseOrganizationRepository) UpdateMembershipRole(
+	ctx context.Context,
+	orgID string,
+	userID string,
+	role string,
+) error {
+	log.Info().Msg("running statement to update membership role")
+	res, err := stmt(ctx, r.statements.updateMembershipRoleStmt).
+		ExecContext(ctx, role, time.Now(), orgID, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update membership role")
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return repository.NewNotFoundError()
+	}
+
+	return nil
+}
+
+func (r *BaseOrganizationRepository) DeleteMembership(
+	ctx context.Context,
+	orgID string,
+	userID string,
+) error {
+	log.Info().Msg("running statement to delete membership")
+	_, err := stmt(ctx, r.statements.deleteMembershipStmt).ExecContext(ctx, orgID, userID)
+
+	return err
+}
+
+func (r *BaseOrganizationRepository) CountMembershipsWithRole(
+	ctx context.Context,
+	orgID string,
+	role string,
+) (int, error) {
+	log.Info().Msg("running statement to count memberships with role")
+	var count int
+	err := stmt(ctx, r.statements.countMembershipsWithRoleStmt).
+		QueryRowContext(ctx, orgID, role).
+		Scan(&count)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count memberships with role")
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// isInvalidTextRepresentation tells whether err comes from a malformed value,
+// like an ID that isn't a UUID, which can't match anything
+func isInvalidTextRepresentation(err error) bool {
+	pgErr, ok := err.(*pgconn.PgError)
+	return ok && pgErr.Code == pgerrcode.InvalidTextRepresentation
+}
@@ -0,0 +1,332 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/repository"
+)
+
+const (
+	passkeyTableName                = "passkey"
+	passkeyTableIDColName           = "id"
+	passkeyTableUserIDColName       = "user_id"
+	passkeyTableCredentialIDColName = "credential_id"
+	passkeyTableSignCountColName    = "sign_count"
+	passkeyTableBackupStateColName  = "backup_state"
+	passkeyTableNicknameColName     = "nickname"
+	passkeyTableLastUsedAtColName   = "last_used_at"
+	passkeyTableCreatedAtColName    = "created_at"
+	passkeyTableUpdatedAtColName    = "updated_at"
+)
+
+// transports are stored as a comma separated list
+const passkeyTransportsSeparator = ","
+
+type PasskeyRepository interface {
+	PrepareStatements(context.Context) error
+	CreatePasskey(ctx context.Context, p *repository.Passkey) (*repository.Passkey, error)
+	GetPasskeysByUserID(ctx context.Context, userID string) ([]*repository.Passkey, error)
+	UpdatePasskeyUsage(ctx context.Context, p *repository.Passkey) error
+	RenamePasskey(ctx context.Context, userID string, id string, nickname string) error
+	DeletePasskey(ctx context.Context, userID string, id string) error
+	DeletePasskeysByUserID(ctx context.Context, userID string) error
+}
+
+type BasePasskeyRepository struct {
+	db         *sql.DB
+	statements *passkeyStatements
+}
+
+type passkeyStatements struct {
+	createPasskeyStmt        *sql.Stmt
+	getPasskeysByUserIDStmt  *sql.Stmt
+	updatePasskeyUsageStmt   *sql.Stmt
+	renamePasskeyStmt        *sql.Stmt
+	deletePasskeyStmt        *sql.Stmt
+	deletePasskeysByUserStmt *sql.Stmt
+}
+
+func NewBasePasskeyRepository(db *sql.DB) *BasePasskeyRepository {
+	return &BasePasskeyRepository{
+		db: db,
+	}
+}
+
+type passkeyScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (r *BasePasskeyRepository) scanPasskey(p *repository.Passkey, row passkeyScanner) error {
+	var signCount int64
+	var transports string
+
+	err := row.Scan(
+		&p.ID,
+		&p.UserID,
+		&p.CredentialID,
+		&p.PublicKey,
+		&p.AttestationType,
+		&p.AAGUID,
+		&signCount,
+		&transports,
+		&p.BackupEligible,
+		&p.BackupState,
+		&p.Nickname,
+		&p.LastUsedAt,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	p.SignCount = uint32(signCount)
+	p.Transports = []string{}
+	if transports != "" {
+		p.Transports = strings.Split(transports, passkeyTransportsSeparator)
+	}
+
+	return nil
+}
+
+func (r *BasePasskeyRepository) PrepareStatements(ctx context.Context) error {
+	log.Info().Msg("preparing create passkey statement")
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+		 VALUES(DEFAULT, $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULL, $11, $12)
+		 RETURNING *`,
+		passkeyTableName,
+	)
+	createPasskeyStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare create passkey statement")
+		return err
+	}
+
+	log.Info().Msg("preparing get passkeys by user id statement")
+	query = fmt.Sprintf(
+		`SELECT *
+		 FROM %s
+		 WHERE %s = $1
+		 ORDER BY %s`,
+		passkeyTableName,
+		passkeyTableUserIDColName,
+		passkeyTableCreatedAtColName,
+	)
+	getPasskeysByUserIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare get passkeys by user id statement")
+		return err
+	}
+
+	log.Info().Msg("preparing update passkey usage statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET %s = $1, %s = $2, %s = $3
+		 WHERE %s = $4`,
+		passkeyTableName,
+		passkeyTableSignCountColName,
+		passkeyTableBackupStateColName,
+		passkeyTableLastUsedAtColName,
+		passkeyTableCredentialIDColName,
+	)
+	updatePasskeyUsageStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare update passkey usage statement")
+		return err
+	}
+
+	log.Info().Msg("preparing rename passkey statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET %s = $1, %s = $2
+		 WHERE %s = $3 AND %s = $4`,
+		passkeyTableName,
+		passkeyTableNicknameColName,
+		passkeyTableUpdatedAtColName,
+		passkeyTableUserIDColName,
+		passkeyTableIDColName,
+	)
+	renamePasskeyStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare rename passkey statement")
+		return err
+	}
+
+	log.Info().Msg("preparing delete passkey statement")
+	query = fmt.Sprintf(
+		`DELETE FROM %s
+		 WHERE %s = $1 AND %s = $2`,
+		passkeyTableName,
+		passkeyTableUserIDColName,
+		passkeyTableIDColName,
+	)
+	deletePasskeyStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare delete passkey statement")
+		return err
+	}
+
+	log.Info().Msg("preparing delete passkeys by user id statement")
+	query = fmt.Sprintf(
+		`DELETE FROM %s
+		 WHERE %s = $1`,
+		passkeyTableName,
+		passkeyTableUserIDColName,
+	)
+	deletePasskeysByUserStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare delete passkeys by user id statement")
+		return err
+	}
+
+	r.statements = &passkeyStatements{
+		createPasskeyStmt:        createPasskeyStmt,
+		getPasskeysByUserIDStmt:  getPasskeysByUserIDStmt,
+		updatePasskeyUsageStmt:   updatePasskeyUsageStmt,
+		renamePasskeyStmt:        renamePasskeyStmt,
+		deletePasskeyStmt:        deletePasskeyStmt,
+		deletePasskeysByUserStmt: deletePasskeysByUserStmt,
+	}
+
+	return nil
+}
+
+func (r *BasePasskeyRepository) CreatePasskey(
+	ctx context.Context,
+	p *repository.Passkey,
+) (*repository.Passkey, error) {
+	now := time.Now()
+
+	log.Info().Msg("running statement to create passkey")
+	row := r.statements.createPasskeyStmt.QueryRowContext(
+		ctx,
+		p.UserID,
+		p.CredentialID,
+		p.PublicKey,
+		p.AttestationType,
+		p.AAGUID,
+		int64(p.SignCount),
+		strings.Join(p.Transports, passkeyTransportsSeparator),
+		p.BackupEligible,
+		p.BackupState,
+		p.Nickname,
+		now,
+		now,
+	)
+	err := r.scanPasskey(p, row)
+	if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.UniqueViolation {
+		log.Error().Err(err).Msg("violated unique passkey constraint")
+		return nil, repository.NewUniqueViolationError()
+	}
+
+	return p, err
+}
+
+func (r *BasePasskeyRepository) GetPasskeysByUserID(
+	ctx context.Context,
+	userID string,
+) ([]*repository.Passkey, error) {
+	log.Info().Msg("running statement to get passkeys by user id")
+	rows, err := r.statements.getPasskeysByUserIDStmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []*repository.Passkey{}
+	for rows.Next() {
+		p := &repository.Passkey{}
+		if err := r.scanPasskey(p, rows); err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, p)
+	}
+
+	return passkeys, rows.Err()
+}
+
+// UpdatePasskeyUsage stores the sign count and backup state reported by the
+// authenticator on its last use
+func (r *BasePasskeyRepository) UpdatePasskeyUsage(
+	ctx context.Context,
+	p *repository.Passkey,
+) error {
+	log.Info().Msg("running statement to update passkey usage")
+	_, err := r.statements.updatePasskeyUsageStmt.ExecContext(
+		ctx,
+		int64(p.SignCount),
+		p.BackupState,
+		p.LastUsedAt,
+		p.CredentialID,
+	)
+	return err
+}
+
+func (r *BasePasskeyRepository) RenamePasskey(
+	ctx context.Context,
+	userID string,
+	id string,
+	nickname string,
+) error {
+	log.Info().Msg("running statement to rename passkey")
+	res, err := r.statements.renamePasskeyStmt.ExecContext(ctx, nickname, time.Now(), userID, id)
+	if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.InvalidTextRepresentation {
+		// not even a valid id, so it can't belong to the user
+		return repository.NewNotFoundError()
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.NewNotFoundError()
+	}
+
+	return nil
+}
+
+func (r *BasePasskeyRepository) DeletePasskey(
+	ctx context.Context,
+	userID string,
+	id string,
+) error {
+	log.Info().Msg("running statement to delete passkey")
+	res, err := r.statements.deletePasskeyStmt.ExecContext(ctx, userID, id)
+	if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.InvalidTextRepresentation {
+		// not even a valid id, so it can't belong to the user
+		return repository.NewNotFoundError()
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.NewNotFoundError()
+	}
+
+	return nil
+}
+
+// DeletePasskeysByUserID deletes every passkey of the user
+func (r *BasePasskeyRepository) DeletePasskeysByUserID(ctx context.Context, userID string) error {
+	log.Info().Msg("running statement to delete passkeys by user id")
+	_, err := r.statements.deletePasskeysByUserStmt.ExecContext(ctx, userID)
+
+	return err
+}
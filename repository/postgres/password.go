@@ -89,7 +89,7 @@ func (r *BasePasswordHistoryRepository) CreatePasswordHistoryRecord(
 	now := time.Now()
 
 	log.Info().Msg("running statement to create forgot password record")
-	err := r.statements.createPasswordHistoryRecordStmt.
+	err := stmt(ctx, r.statements.createPasswordHistoryRecordStmt).
 		QueryRowContext(ctx, fp.UserID, fp.Password, now, now).
 		Scan(&fp.ID)
 
@@ -102,7 +102,7 @@ func (r *BasePasswordHistoryRepository) GetLastNPasswordHashes(
 	n int,
 ) ([]string, error) {
 	log.Info().Msg("running statement to get last n password hashes")
-	rows, err := r.statements.getLastNPasswordHashesStmt.QueryContext(ctx, userID, fmt.Sprint(n))
+	rows, err := stmt(ctx, r.statements.getLastNPasswordHashesStmt).QueryContext(ctx, userID, fmt.Sprint(n))
 	if err != nil {
 		log.Error().Err(err).Msg("failed to get last n password hashes")
 		return nil, err
@@ -0,0 +1,315 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/repository"
+)
+
+const (
+	patTableName              = "personal_access_token"
+	patTableIDColName         = "id"
+	patTableUserIDColName     = "user_id"
+	patTableTokenHashColName  = "token_hash"
+	patTableLastUsedAtColName = "last_used_at"
+	patTableCreatedAtColName  = "created_at"
+)
+
+type PersonalAccessTokenRepository interface {
+	PrepareStatements(context.Context) error
+	CreatePersonalAccessToken(
+		ctx context.Context,
+		t *repository.PersonalAccessToken,
+	) (*repository.PersonalAccessToken, error)
+	ListPersonalAccessTokensByUserID(
+		ctx context.Context,
+		userID string,
+	) ([]*repository.PersonalAccessToken, error)
+	GetPersonalAccessTokenByHash(
+		ctx context.Context,
+		hash string,
+	) (*repository.PersonalAccessToken, error)
+	TouchPersonalAccessToken(ctx context.Context, id string, usedAt time.Time) error
+	DeletePersonalAccessToken(ctx context.Context, userID string, id string) error
+	DeletePersonalAccessTokensByUserID(ctx context.Context, userID string) error
+}
+
+type BasePersonalAccessTokenRepository struct {
+	db         *sql.DB
+	statements *patStatements
+}
+
+type patStatements struct {
+	createPATStmt        *sql.Stmt
+	listPATsByUserIDStmt *sql.Stmt
+	getPATByHashStmt     *sql.Stmt
+	touchPATStmt         *sql.Stmt
+	deletePATStmt        *sql.Stmt
+	deletePATsByUserStmt *sql.Stmt
+}
+
+func NewBasePersonalAccessTokenRepository(db *sql.DB) *BasePersonalAccessTokenRepository {
+	return &BasePersonalAccessTokenRepository{
+		db: db,
+	}
+}
+
+type patScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scopes are stored space separated, like the scope of an OAuth request
+func (r *BasePersonalAccessTokenRepository) scanPAT(
+	t *repository.PersonalAccessToken,
+	row patScanner,
+) error {
+	var scopes string
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&scopes,
+		&t.TokenHash,
+		&t.ExpiresAt,
+		&t.LastUsedAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	t.Scopes = strings.Fields(scopes)
+
+	return err
+}
+
+func (r *BasePersonalAccessTokenRepository) PrepareStatements(ctx context.Context) error {
+	log.Info().Msg("preparing create personal access token statement")
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+		 VALUES(DEFAULT, $1, $2, $3, $4, $5, NULL, $6, $7)
+		 RETURNING id`,
+		patTableName,
+	)
+	createPATStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare create personal access token statement")
+		return err
+	}
+
+	log.Info().Msg("preparing list personal access tokens by user id statement")
+	query = fmt.Sprintf(
+		`SELECT *
+		 FROM %s
+		 WHERE %s = $1
+		 ORDER BY %s DESC, %s`,
+		patTableName,
+		patTableUserIDColName,
+		patTableCreatedAtColName,
+		patTableIDColName,
+	)
+	listPATsByUserIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare list personal access tokens by user id statement")
+		return err
+	}
+
+	// tokens of deleted and deactivated users are kept, in case the user comes
+	// back, but they can't be used
+	log.Info().Msg("preparing get personal access token by hash statement")
+	query = fmt.Sprintf(
+		`SELECT t.*
+		 FROM %s t
+		 JOIN %s u ON u.%s = t.%s
+		 WHERE t.%s = $1 AND u.%s IS NULL AND u.%s IS NULL`,
+		patTableName,
+		userTableName,
+		userTableIDColName,
+		patTableUserIDColName,
+		patTableTokenHashColName,
+		userTableDeletedAtColName,
+		userTableDeactivatedAtColName,
+	)
+	getPATByHashStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare get personal access token by hash statement")
+		return err
+	}
+
+	log.Info().Msg("preparing touch personal access token statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET %s = $1
+		 WHERE %s = $2`,
+		patTableName,
+		patTableLastUsedAtColName,
+		patTableIDColName,
+	)
+	touchPATStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare touch personal access token statement")
+		return err
+	}
+
+	log.Info().Msg("preparing delete personal access token statement")
+	query = fmt.Sprintf(
+		`DELETE FROM %s
+		 WHERE %s = $1 AND %s = $2`,
+		patTableName,
+		patTableIDColName,
+		patTableUserIDColName,
+	)
+	deletePATStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare delete personal access token statement")
+		return err
+	}
+
+	log.Info().Msg("preparing delete personal access tokens by user id statement")
+	query = fmt.Sprintf(
+		`DELETE FROM %s
+		 WHERE %s = $1`,
+		patTableName,
+		patTableUserIDColName,
+	)
+	deletePATsByUserStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare delete personal access tokens by user id statement")
+		return err
+	}
+
+	r.statements = &patStatements{
+		createPATStmt:        createPATStmt,
+		listPATsByUserIDStmt: listPATsByUserIDStmt,
+		getPATByHashStmt:     getPATByHashStmt,
+		touchPATStmt:         touchPATStmt,
+		deletePATStmt:        deletePATStmt,
+		deletePATsByUserStmt: deletePATsByUserStmt,
+	}
+
+	return nil
+}
+
+func (r *BasePersonalAccessTokenRepository) CreatePersonalAccessToken(
+	ctx context.Context,
+	t *repository.PersonalAccessToken,
+) (*repository.PersonalAccessToken, error) {
+	now := time.Now()
+	t.CreatedAt = now
+	t.UpdatedAt = now
+
+	log.Info().Msg("running statement to create personal access token")
+	err := stmt(ctx, r.statements.createPATStmt).
+		QueryRowContext(
+			ctx,
+			t.UserID,
+			t.Name,
+			strings.Join(t.Scopes, " "),
+			t.TokenHash,
+			t.ExpiresAt,
+			now,
+			now,
+		).
+		Scan(&t.ID)
+
+	return t, err
+}
+
+// ListPersonalAccessTokensByUserID lists the tokens of the user, the latest
+// first
+func (r *BasePersonalAccessTokenRepository) ListPersonalAccessTokensByUserID(
+	ctx context.Context,
+	userID string,
+) ([]*repository.PersonalAccessToken, error) {
+	log.Info().Msg("running statement to list personal access tokens by user id")
+	rows, err := stmt(ctx, r.statements.listPATsByUserIDStmt).QueryContext(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list personal access tokens")
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*repository.PersonalAccessToken{}
+	for rows.Next() {
+		t := &repository.PersonalAccessToken{}
+		err := r.scanPAT(t, rows)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to scan personal access token")
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// GetPersonalAccessTokenByHash gets the token with the hash, as long as its
+// user is neither deleted nor deactivated. expired tokens are returned too.
+func (r *BasePersonalAccessTokenRepository) GetPersonalAccessTokenByHash(
+	ctx context.Context,
+	hash string,
+) (*repository.PersonalAccessToken, error) {
+	t := &repository.PersonalAccessToken{}
+
+	log.Info().Msg("running statement to get personal access token by hash")
+	row := stmt(ctx, r.statements.getPATByHashStmt).QueryRowContext(ctx, hash)
+	err := r.scanPAT(t, row)
+	if err == sql.ErrNoRows {
+		log.Error().Err(err).Msg("failed to find personal access token")
+		return nil, repository.NewNotFoundError()
+	}
+
+	return t, err
+}
+
+func (r *BasePersonalAccessTokenRepository) TouchPersonalAccessToken(
+	ctx context.Context,
+	id string,
+	usedAt time.Time,
+) error {
+	log.Info().Msg("running statement to touch personal access token")
+	_, err := stmt(ctx, r.statements.touchPATStmt).ExecContext(ctx, usedAt, id)
+
+	return err
+}
+
+// DeletePersonalAccessToken deletes the token of the user, it's a
+// NotFoundError when the user has no token with the id
+func (r *BasePersonalAccessTokenRepository) DeletePersonalAccessToken(
+	ctx context.Context,
+	userID string,
+	id string,
+) error {
+	log.Info().Msg("running statement to delete personal access token")
+	res, err := stmt(ctx, r.statements.deletePATStmt).ExecContext(ctx, id, userID)
+	if isInvalidTextRepresentation(err) {
+		return repository.NewNotFoundError()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete personal access token")
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.NewNotFoundError()
+	}
+
+	return nil
+}
+
+// DeletePersonalAccessTokensByUserID deletes every token of the user
+func (r *BasePersonalAccessTokenRepository) DeletePersonalAccessTokensByUserID(
+	ctx context.Context,
+	userID string,
+) error {
+	log.Info().Msg("running statement to delete personal access tokens by user id")
+	_, err := stmt(ctx, r.statements.deletePATsByUserStmt).ExecContext(ctx, userID)
+
+	return err
+}
@@ -0,0 +1,222 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/repository"
+)
+
+const (
+	recoveryCodeTableName          = "tfa_recovery_code"
+	recoveryCodeTableIDColName     = "id"
+	recoveryCodeTableUserIDColName = "user_id"
+	recoveryCodeTableUsedAtColName = "used_at"
+	recoveryCodeTableUpdatedAtName = "updated_at"
+)
+
+type RecoveryCodeRepository interface {
+	PrepareStatements(context.Context) error
+	ReplaceRecoveryCodes(ctx context.Context, userID string, codes []string) error
+	GetUnusedRecoveryCodes(ctx context.Context, userID string) ([]*repository.RecoveryCode, error)
+	MarkRecoveryCodeAsUsed(ctx context.Context, id string) error
+	DeleteRecoveryCodesByUserID(ctx context.Context, userID string) error
+}
+
+type BaseRecoveryCodeRepository struct {
+	db         *sql.DB
+	statements *recoveryCodeStatements
+}
+
+type recoveryCodeStatements struct {
+	createRecoveryCodeStmt          *sql.Stmt
+	getUnusedRecoveryCodesStmt      *sql.Stmt
+	markRecoveryCodeAsUsedStmt      *sql.Stmt
+	deleteRecoveryCodesByUserIDStmt *sql.Stmt
+}
+
+func NewBaseRecoveryCodeRepository(db *sql.DB) *BaseRecoveryCodeRepository {
+	return &BaseRecoveryCodeRepository{
+		db: db,
+	}
+}
+
+func (r *BaseRecoveryCodeRepository) PrepareStatements(ctx context.Context) error {
+	log.Info().Msg("preparing create recovery code statement")
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+		 VALUES(DEFAULT, $1, $2, NULL, $3, $4)`,
+		recoveryCodeTableName,
+	)
+	createRecoveryCodeStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare create recovery code statement")
+		return err
+	}
+
+	log.Info().Msg("preparing get unused recovery codes statement")
+	query = fmt.Sprintf(
+		`SELECT *
+		 FROM %s
+		 WHERE %s = $1 AND %s IS NULL`,
+		recoveryCodeTableName,
+		recoveryCodeTableUserIDColName,
+		recoveryCodeTableUsedAtColName,
+	)
+	getUnusedRecoveryCodesStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare get unused recovery codes statement")
+		return err
+	}
+
+	// the used_at check makes sure a code can't be redeemed twice by two
+	// concurrent requests
+	log.Info().Msg("preparing mark recovery code as used statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET
+		   %s = $1,
+		   %s = $1
+		 WHERE %s = $2 AND %s IS NULL`,
+		recoveryCodeTableName,
+		recoveryCodeTableUsedAtColName,
+		recoveryCodeTableUpdatedAtName,
+		recoveryCodeTableIDColName,
+		recoveryCodeTableUsedAtColName,
+	)
+	markRecoveryCodeAsUsedStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare mark recovery code as used statement")
+		return err
+	}
+
+	log.Info().Msg("preparing delete recovery codes by user id statement")
+	query = fmt.Sprintf(
+		`DELETE FROM %s
+		 WHERE %s = $1`,
+		recoveryCodeTableName,
+		recoveryCodeTableUserIDColName,
+	)
+	deleteRecoveryCodesByUserIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare delete recovery codes by user id statement")
+		return err
+	}
+
+	r.statements = &recoveryCodeStatements{
+		createRecoveryCodeStmt:          createRecoveryCodeStmt,
+		getUnusedRecoveryCodesStmt:      getUnusedRecoveryCodesStmt,
+		markRecoveryCodeAsUsedStmt:      markRecoveryCodeAsUsedStmt,
+		deleteRecoveryCodesByUserIDStmt: deleteRecoveryCodesByUserIDStmt,
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes invalidates every existing code of the user and stores
+// the given (already hashed) codes in a single transaction
+func (r *BaseRecoveryCodeRepository) ReplaceRecoveryCodes(
+	ctx context.Context,
+	userID string,
+	codes []string,
+) error {
+	now := time.Now()
+
+	log.Info().Msg("beginning transaction to replace recovery codes")
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to begin transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	log.Info().Msg("running statement to delete recovery codes by user id")
+	_, err = tx.StmtContext(ctx, r.statements.deleteRecoveryCodesByUserIDStmt).
+		ExecContext(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete old recovery codes")
+		return err
+	}
+
+	log.Info().Msg("running statement to create recovery codes")
+	createStmt := tx.StmtContext(ctx, r.statements.createRecoveryCodeStmt)
+	for _, code := range codes {
+		_, err = createStmt.ExecContext(ctx, userID, code, now, now)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to create recovery code")
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *BaseRecoveryCodeRepository) GetUnusedRecoveryCodes(
+	ctx context.Context,
+	userID string,
+) ([]*repository.RecoveryCode, error) {
+	log.Info().Msg("running statement to get unused recovery codes")
+	rows, err := r.statements.getUnusedRecoveryCodesStmt.QueryContext(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get unused recovery codes")
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := []*repository.RecoveryCode{}
+	for rows.Next() {
+		rc := &repository.RecoveryCode{}
+		err := rows.Scan(
+			&rc.ID,
+			&rc.UserID,
+			&rc.Code,
+			&rc.UsedAt,
+			&rc.CreatedAt,
+			&rc.UpdatedAt,
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("fail to scan recovery code")
+			return nil, err
+		}
+		codes = append(codes, rc)
+	}
+
+	return codes, rows.Err()
+}
+
+func (r *BaseRecoveryCodeRepository) MarkRecoveryCodeAsUsed(
+	ctx context.Context,
+	id string,
+) error {
+	now := time.Now()
+
+	log.Info().Msg("running statement to mark recovery code as used")
+	res, err := r.statements.markRecoveryCodeAsUsedStmt.ExecContext(ctx, now, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		log.Error().Msg("recovery code is already used")
+		return repository.NewNotFoundError()
+	}
+
+	return nil
+}
+
+func (r *BaseRecoveryCodeRepository) DeleteRecoveryCodesByUserID(
+	ctx context.Context,
+	userID string,
+) error {
+	log.Info().Msg("running statement to delete recovery codes by user id")
+	_, err := r.statements.deleteRecoveryCodesByUserIDStmt.ExecContext(ctx, userID)
+
+	return err
+}
@@ -0,0 +1,241 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/repository"
+)
+
+const (
+	roleTableName        = "role"
+	roleTableIDColName   = "id"
+	roleTableNameColName = "name"
+
+	permissionTableName        = "permission"
+	permissionTableIDColName   = "id"
+	permissionTableNameColName = "name"
+
+	rolePermissionTableName                = "role_permission"
+	rolePermissionTableRoleIDColName       = "role_id"
+	rolePermissionTablePermissionIDColName = "permission_id"
+
+	userRoleTableName          = "user_role"
+	userRoleTableUserIDColName = "user_id"
+	userRoleTableRoleIDColName = "role_id"
+)
+
+type RoleRepository interface {
+	PrepareStatements(context.Context) error
+	GetRolesByUserID(ctx context.Context, userID string) ([]string, error)
+	HasPermission(ctx context.Context, roles []string, permission string) (bool, error)
+	AssignRole(ctx context.Context, userID string, role string) error
+	CountUsersWithRole(ctx context.Context, role string) (int, error)
+}
+
+type BaseRoleRepository struct {
+	db         *sql.DB
+	statements *roleStatements
+}
+
+type roleStatements struct {
+	getRolesByUserIDStmt   *sql.Stmt
+	hasPermissionStmt      *sql.Stmt
+	assignRoleStmt         *sql.Stmt
+	countUsersWithRoleStmt *sql.Stmt
+}
+
+func NewBaseRoleRepository(db *sql.DB) *BaseRoleRepository {
+	return &BaseRoleRepository{
+		db: db,
+	}
+}
+
+func (r *BaseRoleRepository) PrepareStatements(ctx context.Context) error {
+	log.Info().Msg("preparing get roles by user id statement")
+	query := fmt.Sprintf(
+		`SELECT r.%s
+		 FROM %s ur
+		 JOIN %s r ON r.%s = ur.%s
+		 WHERE ur.%s = $1
+		 ORDER BY r.%s`,
+		roleTableNameColName,
+		userRoleTableName,
+		roleTableName,
+		roleTableIDColName,
+		userRoleTableRoleIDColName,
+		userRoleTableUserIDColName,
+		roleTableNameColName,
+	)
+	getRolesByUserIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare get roles by user id statement")
+		return err
+	}
+
+	log.Info().Msg("preparing has permission statement")
+	query = fmt.Sprintf(
+		`SELECT EXISTS(
+		   SELECT 1
+		   FROM %s r
+		   JOIN %s rp ON rp.%s = r.%s
+		   JOIN %s p ON p.%s = rp.%s
+		   WHERE r.%s = ANY($1::text[]) AND p.%s = $2
+		 )`,
+		roleTableName,
+		rolePermissionTableName,
+		rolePermissionTableRoleIDColName,
+		roleTableIDColName,
+		permissionTableName,
+		permissionTableIDColName,
+		rolePermissionTablePermissionIDColName,
+		roleTableNameColName,
+		permissionTableNameColName,
+	)
+	hasPermissionStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare has permission statement")
+		return err
+	}
+
+	// assigning a role twice is a no-op
+	log.Info().Msg("preparing assign role statement")
+	query = fmt.Sprintf(
+		`INSERT INTO %s
+		 SELECT $1, %s, $3
+		 FROM %s
+		 WHERE %s = $2
+		 ON CONFLICT DO NOTHING`,
+		userRoleTableName,
+		roleTableIDColName,
+		roleTableName,
+		roleTableNameColName,
+	)
+	assignRoleStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare assign role statement")
+		return err
+	}
+
+	log.Info().Msg("preparing count users with role statement")
+	query = fmt.Sprintf(
+		`SELECT COUNT(*)
+		 FROM %s ur
+		 JOIN %s r ON r.%s = ur.%s
+		 WHERE r.%s = $1`,
+		userRoleTableName,
+		roleTableName,
+		roleTableIDColName,
+		userRoleTableRoleIDColName,
+		roleTableNameColName,
+	)
+	countUsersWithRoleStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare count users with role statement")
+		return err
+	}
+
+	r.statements = &roleStatements{
+		getRolesByUserIDStmt:   getRolesByUserIDStmt,
+		hasPermissionStmt:      hasPermissionStmt,
+		assignRoleStmt:         assignRoleStmt,
+		countUsersWithRoleStmt: countUsersWithRoleStmt,
+	}
+
+	return nil
+}
+
+func (r *BaseRoleRepository) GetRolesByUserID(
+	ctx context.Context,
+	userID string,
+) ([]string, error) {
+	log.Info().Msg("running statement to get roles by user id")
+	rows, err := stmt(ctx, r.statements.getRolesByUserIDStmt).QueryContext(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get roles")
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		err := rows.Scan(&role)
+		if err != nil {
+			log.Error().Err(err).Msg("fail to scan role")
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// HasPermission tells whether any of the roles grants the permission
+func (r *BaseRoleRepository) HasPermission(
+	ctx context.Context,
+	roles []string,
+	permission string,
+) (bool, error) {
+	if len(roles) == 0 {
+		return false, nil
+	}
+
+	log.Info().Msg("running statement to check permission")
+	var ok bool
+	err := stmt(ctx, r.statements.hasPermissionStmt).
+		QueryRowContext(ctx, roles, permission).
+		Scan(&ok)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check permission")
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// AssignRole gives the user the role. it's a NotFoundError when there is no
+// such role.
+func (r *BaseRoleRepository) AssignRole(ctx context.Context, userID string, role string) error {
+	log.Info().Msg("running statement to assign role")
+	res, err := stmt(ctx, r.statements.assignRoleStmt).ExecContext(ctx, userID, role, time.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("failed to assign role")
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		// either the role doesn't exist, or the user has it already
+		roles, err := r.GetRolesByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		for _, rl := range roles {
+			if rl == role {
+				return nil
+			}
+		}
+		return repository.NewNotFoundError()
+	}
+
+	return nil
+}
+
+func (r *BaseRoleRepository) CountUsersWithRole(ctx context.Context, role string) (int, error) {
+	log.Info().Msg("running statement to count users with role")
+	var count int
+	err := stmt(ctx, r.statements.countUsersWithRoleStmt).QueryRowContext(ctx, role).Scan(&count)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count users with role")
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,252 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/repository"
+)
+
+const (
+	tfaTableName             = "user_tfa"
+	tfaTableUserIDColName    = "user_id"
+	tfaTableSecretColName    = "secret"
+	tfaTableIsEnabledColName = "is_enabled"
+	tfaTableUpdatedAtColName = "updated_at"
+	tfaTableLastUsedStepCol  = "last_used_step"
+)
+
+type TFARepository interface {
+	PrepareStatements(context.Context) error
+	UpsertTFA(ctx context.Context, t *repository.UserTFA) (*repository.UserTFA, error)
+	GetTFAByUserID(ctx context.Context, userID string) (*repository.UserTFA, error)
+	UpdateTFAStatusByUserID(
+		ctx context.Context,
+		userID string,
+		isEnabled bool,
+	) (*repository.UserTFA, error)
+	UseTFAStep(ctx context.Context, userID string, step int64) error
+	DeleteTFAByUserID(ctx context.Context, userID string) error
+}
+
+type BaseTFARepository struct {
+	db         *sql.DB
+	statements *tfaStatements
+}
+
+type tfaStatements struct {
+	upsertTFAStmt               *sql.Stmt
+	getTFAByUserIDStmt          *sql.Stmt
+	updateTFAStatusByUserIDStmt *sql.Stmt
+	useTFAStepStmt              *sql.Stmt
+	deleteTFAByUserIDStmt       *sql.Stmt
+}
+
+func NewBaseTFARepository(db *sql.DB) *BaseTFARepository {
+	return &BaseTFARepository{
+		db: db,
+	}
+}
+
+func (r *BaseTFARepository) scanTFA(t *repository.UserTFA, row *sql.Row) error {
+	return row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Secret,
+		&t.IsEnabled,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.LastUsedStep,
+	)
+}
+
+func (r *BaseTFARepository) PrepareStatements(ctx context.Context) error {
+	log.Info().Msg("preparing upsert tfa statement")
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+		 VALUES(DEFAULT, $1, $2, $3, $4, $5)
+		 ON CONFLICT (%s) DO UPDATE
+		 SET
+		   %s = EXCLUDED.%s,
+		   %s = EXCLUDED.%s,
+		   %s = EXCLUDED.%s
+		 RETURNING *`,
+		tfaTableName,
+		tfaTableUserIDColName,
+		tfaTableSecretColName,
+		tfaTableSecretColName,
+		tfaTableIsEnabledColName,
+		tfaTableIsEnabledColName,
+		tfaTableUpdatedAtColName,
+		tfaTableUpdatedAtColName,
+	)
+	upsertTFAStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare upsert tfa statement")
+		return err
+	}
+
+	log.Info().Msg("preparing get tfa by user id statement")
+	query = fmt.Sprintf(
+		`SELECT *
+		 FROM %s
+		 WHERE %s = $1`,
+		tfaTableName,
+		tfaTableUserIDColName,
+	)
+	getTFAByUserIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare get tfa by user id statement")
+		return err
+	}
+
+	log.Info().Msg("preparing update tfa status by user id statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET
+		   %s = $1,
+		   %s = $2
+		 WHERE %s = $3
+		 RETURNING *`,
+		tfaTableName,
+		tfaTableIsEnabledColName,
+		tfaTableUpdatedAtColName,
+		tfaTableUserIDColName,
+	)
+	updateTFAStatusByUserIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare update tfa status by user id statement")
+		return err
+	}
+
+	// the step only moves forward, so two requests racing with the same code
+	// can't both use it
+	log.Info().Msg("preparing use tfa step statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET
+		   %s = $1,
+		   %s = $2
+		 WHERE %s = $3 AND %s < $1`,
+		tfaTableName,
+		tfaTableLastUsedStepCol,
+		tfaTableUpdatedAtColName,
+		tfaTableUserIDColName,
+		tfaTableLastUsedStepCol,
+	)
+	useTFAStepStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare use tfa step statement")
+		return err
+	}
+
+	log.Info().Msg("preparing delete tfa by user id statement")
+	query = fmt.Sprintf(
+		`DELETE FROM %s
+		 WHERE %s = $1`,
+		tfaTableName,
+		tfaTableUserIDColName,
+	)
+	deleteTFAByUserIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare delete tfa by user id statement")
+		return err
+	}
+
+	r.statements = &tfaStatements{
+		upsertTFAStmt:               upsertTFAStmt,
+		getTFAByUserIDStmt:          getTFAByUserIDStmt,
+		updateTFAStatusByUserIDStmt: updateTFAStatusByUserIDStmt,
+		useTFAStepStmt:              useTFAStepStmt,
+		deleteTFAByUserIDStmt:       deleteTFAByUserIDStmt,
+	}
+
+	return nil
+}
+
+func (r *BaseTFARepository) UpsertTFA(
+	ctx context.Context,
+	t *repository.UserTFA,
+) (*repository.UserTFA, error) {
+	now := time.Now()
+
+	log.Info().Msg("running statement to upsert tfa")
+	row := r.statements.upsertTFAStmt.
+		QueryRowContext(ctx, t.UserID, t.Secret, t.IsEnabled, now, now)
+	err := r.scanTFA(t, row)
+
+	return t, err
+}
+
+func (r *BaseTFARepository) GetTFAByUserID(
+	ctx context.Context,
+	userID string,
+) (*repository.UserTFA, error) {
+	t := &repository.UserTFA{}
+
+	log.Info().Msg("running statement to get tfa by user id")
+	row := r.statements.getTFAByUserIDStmt.QueryRowContext(ctx, userID)
+	err := r.scanTFA(t, row)
+	if err == sql.ErrNoRows {
+		log.Error().Err(err).Msg("failed to find tfa")
+		return nil, repository.NewNotFoundError()
+	}
+
+	return t, err
+}
+
+func (r *BaseTFARepository) UpdateTFAStatusByUserID(
+	ctx context.Context,
+	userID string,
+	isEnabled bool,
+) (*repository.UserTFA, error) {
+	t := &repository.UserTFA{}
+	now := time.Now()
+
+	log.Info().Msg("running statement to update tfa status by user id")
+	row := r.statements.updateTFAStatusByUserIDStmt.QueryRowContext(ctx, isEnabled, now, userID)
+	err := r.scanTFA(t, row)
+	if err == sql.ErrNoRows {
+		log.Error().Err(err).Msg("failed to find tfa")
+		return nil, repository.NewNotFoundError()
+	}
+
+	return t, err
+}
+
+// UseTFAStep records that the code of step has been used. it's a
+// NotFoundError when a code of step or a later one has been used already.
+func (r *BaseTFARepository) UseTFAStep(
+	ctx context.Context,
+	userID string,
+	step int64,
+) error {
+	log.Info().Msg("running statement to use tfa step")
+	res, err := r.statements.useTFAStepStmt.ExecContext(ctx, step, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.NewNotFoundError()
+	}
+
+	return nil
+}
+
+func (r *BaseTFARepository) DeleteTFAByUserID(
+	ctx context.Context,
+	userID string,
+) error {
+	log.Info().Msg("running statement to delete tfa by user id")
+	_, err := r.statements.deleteTFAByUserIDStmt.ExecContext(ctx, userID)
+
+	return err
+}
@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/rs/zerolog/log"
+)
+
+type txCtxKey struct{}
+
+// TxManager runs a function in a transaction. repositories called with the
+// context passed to the function take part in that transaction, as long as
+// they get their statements through stmt.
+type TxManager interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type BaseTxManager struct {
+	db *sql.DB
+}
+
+func NewBaseTxManager(db *sql.DB) *BaseTxManager {
+	return &BaseTxManager{
+		db: db,
+	}
+}
+
+// WithTx commits when fn returns nil and rolls back otherwise. nested calls
+// join the outer transaction.
+func (m *BaseTxManager) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txCtxKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	log.Info().Msg("beginning transaction")
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to begin transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(context.WithValue(ctx, txCtxKey{}, tx))
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msg("committing transaction")
+	return tx.Commit()
+}
+
+// stmt returns the prepared statement bound to the transaction in ctx, if
+// there is one
+func stmt(ctx context.Context, s *sql.Stmt) *sql.Stmt {
+	if tx, ok := ctx.Value(txCtxKey{}).(*sql.Tx); ok {
+		return tx.StmtContext(ctx, s)
+	}
+	return s
+}
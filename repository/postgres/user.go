@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgconn"
@@ -13,14 +14,16 @@ import (
 )
 
 const (
-	userTableName             = `"user"`
-	userTableIDColName        = "id"
-	userTableEmailColName     = "email"
-	userTablePasswordColName  = "password"
-	userTableIsActiveColName  = "is_active"
-	userTableCreatedAtColName = "created_at"
-	userTableUpdatedAtColName = "updated_at"
-	userTableDeletedAtColName = "deleted_at"
+	userTableName                 = `"user"`
+	userTableIDColName            = "id"
+	userTableEmailColName         = "email"
+	userTablePasswordColName      = "password"
+	userTableIsActiveColName      = "is_active"
+	userTableCreatedAtColName     = "created_at"
+	userTableUpdatedAtColName     = "updated_at"
+	userTableDeletedAtColName     = "deleted_at"
+	userTableLocaleColName        = "locale"
+	userTableDeactivatedAtColName = "deactivated_at"
 
 	userBioTableName             = "user_bio"
 	userBioTableIDColName        = "id"
@@ -55,6 +58,11 @@ type UserRepository interface {
 		userID string,
 		email string,
 	) (*repository.User, error)
+	UpdateLocaleByID(
+		ctx context.Context,
+		userID string,
+		locale string,
+	) (*repository.User, error)
 	UpdateUserBioByID(
 		ctx context.Context,
 		userID string,
@@ -66,6 +74,13 @@ type UserRepository interface {
 		picturePath string,
 	) (*repository.UserBio, error)
 	DeleteUserByID(ctx context.Context, userID string) error
+	ListUsers(ctx context.Context, f *repository.UserFilter) ([]*repository.User, error)
+	UpdateUserDeactivationByID(
+		ctx context.Context,
+		userID string,
+		deactivated bool,
+	) (*repository.User, error)
+	RestoreUserByID(ctx context.Context, userID string) (*repository.User, error)
 }
 
 type BaseUserRepository struct {
@@ -82,9 +97,13 @@ type userStatements struct {
 	updateUserActivationStatusByIDStmt *sql.Stmt
 	updatePasswordByIDStmt             *sql.Stmt
 	updateEmailByIDStmt                *sql.Stmt
+	updateLocaleByIDStmt               *sql.Stmt
 	updateUserBioByIDStmt              *sql.Stmt
 	updatePictureByIDStmt              *sql.Stmt
 	deleteUserByIDStmt                 *sql.Stmt
+	listUsersStmt                      *sql.Stmt
+	updateUserDeactivationByIDStmt     *sql.Stmt
+	restoreUserByIDStmt                *sql.Stmt
 }
 
 func NewBaseUserRepository(db *sql.DB) *BaseUserRepository {
@@ -93,8 +112,18 @@ func NewBaseUserRepository(db *sql.DB) *BaseUserRepository {
 	}
 }
 
-func (r *BaseUserRepository) scanUser(u *repository.User, row *sql.Row) error {
-	return row.Scan(
+type userScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser scans the columns of the user table, followed by the extra columns
+// of the query into dest
+func (r *BaseUserRepository) scanUser(
+	u *repository.User,
+	row userScanner,
+	dest ...interface{},
+) error {
+	return row.Scan(append([]interface{}{
 		&u.ID,
 		&u.Email,
 		&u.Password,
@@ -102,7 +131,9 @@ func (r *BaseUserRepository) scanUser(u *repository.User, row *sql.Row) error {
 		&u.CreatedAt,
 		&u.UpdatedAt,
 		&u.DeletedAt,
-	)
+		&u.Locale,
+		&u.DeactivatedAt,
+	}, dest...)...)
 }
 
 func (r *BaseUserRepository) scanUserBio(ub *repository.UserBio, row *sql.Row) error {
@@ -122,7 +153,7 @@ func (r *BaseUserRepository) PrepareStatements(ctx context.Context) error {
 	log.Info().Msg("preparing create user statement")
 	query := fmt.Sprintf(
 		`INSERT INTO %s
-		 VALUES(DEFAULT, $1, $2, $3, $4, $5)
+		 VALUES(DEFAULT, $1, $2, $3, $4, $5, NULL, $6)
 		 RETURNING id`,
 		userTableName,
 	)
@@ -255,6 +286,26 @@ func (r *BaseUserRepository) PrepareStatements(ctx context.Context) error {
 		return err
 	}
 
+	log.Info().Msg("preparing update locale by id statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET
+		   %s = $1,
+			 %s = $2
+		 WHERE %s = $3 AND %s IS NULL
+		 RETURNING *`,
+		userTableName,
+		userTableLocaleColName,
+		userTableUpdatedAtColName,
+		userTableIDColName,
+		userTableDeletedAtColName,
+	)
+	updateLocaleByIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare update locale by id statement")
+		return err
+	}
+
 	log.Info().Msg("preparing update user bio by id statement")
 	query = fmt.Sprintf(
 		`UPDATE %s
@@ -342,6 +393,98 @@ func (r *BaseUserRepository) PrepareStatements(ctx context.Context) error {
 		return err
 	}
 
+	// the status filter follows repository.User.Status, and pages are cut the
+	// same way as the audit events
+	log.Info().Msg("preparing list users statement")
+	query = fmt.Sprintf(
+		`SELECT u.*, COALESCE(ub.%s, '')
+		 FROM %s u
+		 LEFT JOIN %s ub ON ub.%s = u.%s
+		 WHERE ($1::text IS NULL OR u.%s ILIKE $1 OR ub.%s ILIKE $1)
+		   AND (CASE $2::text
+			   WHEN 'deleted' THEN u.%s IS NOT NULL
+			   WHEN 'deactivated' THEN u.%s IS NULL AND u.%s IS NOT NULL
+			   WHEN 'unverified' THEN u.%s IS NULL AND u.%s IS NULL AND NOT u.%s
+			   WHEN 'active' THEN u.%s IS NULL AND u.%s IS NULL AND u.%s
+			   ELSE u.%s IS NULL
+		   END)
+		   AND ($3::uuid IS NULL OR (u.%s, u.%s) < (
+			   SELECT %s, %s FROM %s WHERE %s = $3
+		   ))
+		 ORDER BY u.%s DESC, u.%s DESC
+		 LIMIT $4`,
+		userBioTableFullNameColName,
+		userTableName,
+		userBioTableName,
+		userBioTableUserIDColName,
+		userTableIDColName,
+		userTableEmailColName,
+		userBioTableFullNameColName,
+		userTableDeletedAtColName,
+		userTableDeletedAtColName,
+		userTableDeactivatedAtColName,
+		userTableDeletedAtColName,
+		userTableDeactivatedAtColName,
+		userTableIsActiveColName,
+		userTableDeletedAtColName,
+		userTableDeactivatedAtColName,
+		userTableIsActiveColName,
+		userTableDeletedAtColName,
+		userTableCreatedAtColName,
+		userTableIDColName,
+		userTableCreatedAtColName,
+		userTableIDColName,
+		userTableName,
+		userTableIDColName,
+		userTableCreatedAtColName,
+		userTableIDColName,
+	)
+	listUsersStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare list users statement")
+		return err
+	}
+
+	log.Info().Msg("preparing update user deactivation by id statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET
+		   %s = $1,
+			 %s = $2
+		 WHERE %s = $3 AND %s IS NULL
+		 RETURNING *`,
+		userTableName,
+		userTableDeactivatedAtColName,
+		userTableUpdatedAtColName,
+		userTableIDColName,
+		userTableDeletedAtColName,
+	)
+	updateUserDeactivationByIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare update user deactivation by id statement")
+		return err
+	}
+
+	log.Info().Msg("preparing restore user by id statement")
+	query = fmt.Sprintf(
+		`UPDATE %s
+		 SET
+		   %s = NULL,
+			 %s = $1
+		 WHERE %s = $2 AND %s IS NOT NULL
+		 RETURNING *`,
+		userTableName,
+		userTableDeletedAtColName,
+		userTableUpdatedAtColName,
+		userTableIDColName,
+		userTableDeletedAtColName,
+	)
+	restoreUserByIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare restore user by id statement")
+		return err
+	}
+
 	r.statements = &userStatements{
 		createUserStmt:                     createUserStmt,
 		createUserBioStmt:                  createUserBioStmt,
@@ -351,9 +494,13 @@ func (r *BaseUserRepository) PrepareStatements(ctx context.Context) error {
 		updateUserActivationStatusByIDStmt: updateUserActivationStatusByIDStmt,
 		updatePasswordByIDStmt:             UpdatePasswordByIDStmt,
 		updateEmailByIDStmt:                updateEmailByIDStmt,
+		updateLocaleByIDStmt:               updateLocaleByIDStmt,
 		updateUserBioByIDStmt:              updateUserBioByIDStmt,
 		updatePictureByIDStmt:              updatePictureByIDStmt,
 		deleteUserByIDStmt:                 deleteUserByIDStmt,
+		listUsersStmt:                      listUsersStmt,
+		updateUserDeactivationByIDStmt:     updateUserDeactivationByIDStmt,
+		restoreUserByIDStmt:                restoreUserByIDStmt,
 	}
 
 	return nil
@@ -366,8 +513,8 @@ func (r *BaseUserRepository) CreateUser(
 	now := time.Now()
 
 	log.Info().Msg("running statement to create user")
-	err := r.statements.createUserStmt.
-		QueryRowContext(ctx, u.Email, u.Password, u.IsActive, now, now).
+	err := stmt(ctx, r.statements.createUserStmt).
+		QueryRowContext(ctx, u.Email, u.Password, u.IsActive, now, now, u.Locale).
 		Scan(&u.ID)
 
 	if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.UniqueViolation {
@@ -376,7 +523,7 @@ func (r *BaseUserRepository) CreateUser(
 	}
 
 	log.Info().Msg("running statement to create user bio")
-	err = r.statements.createUserBioStmt.
+	err = stmt(ctx, r.statements.createUserBioStmt).
 		QueryRowContext(ctx, u.ID, u.UserBio.Fullname, "", "", "", "", now, now).
 		Scan(&u.UserBio.ID)
 
@@ -390,12 +537,17 @@ func (r *BaseUserRepository) GetUserByID(
 	u := &repository.User{}
 
 	log.Info().Msg("running statement to get user by id")
-	row := r.statements.getUserByIDStmt.QueryRowContext(ctx, userID)
+	row := stmt(ctx, r.statements.getUserByIDStmt).QueryRowContext(ctx, userID)
 	err := r.scanUser(u, row)
 	if err == sql.ErrNoRows {
 		log.Error().Err(err).Msg("failed to find user")
 		return nil, repository.NewNotFoundError()
 	}
+	if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.InvalidTextRepresentation {
+		// IDs coming from admins aren't always well-formed
+		log.Error().Err(err).Msg("malformed user id")
+		return nil, repository.NewNotFoundError()
+	}
 
 	return u, err
 }
@@ -407,7 +559,7 @@ func (r *BaseUserRepository) GetUserByEmail(
 	u := &repository.User{}
 
 	log.Info().Msg("running statement to get user by email")
-	row := r.statements.getUserByEmailStmt.QueryRowContext(ctx, email)
+	row := stmt(ctx, r.statements.getUserByEmailStmt).QueryRowContext(ctx, email)
 	err := r.scanUser(u, row)
 	if err == sql.ErrNoRows {
 		log.Error().Err(err).Msg("failed to find a user")
@@ -424,7 +576,7 @@ func (r *BaseUserRepository) GetUserBioByID(
 	ub := &repository.UserBio{}
 
 	log.Info().Msg("running statement to get user bio by id")
-	row := r.statements.getUserBioByIDStmt.QueryRowContext(ctx, userID)
+	row := stmt(ctx, r.statements.getUserBioByIDStmt).QueryRowContext(ctx, userID)
 	err := r.scanUserBio(ub, row)
 	if err == sql.ErrNoRows {
 		log.Error().Err(err).Msg("failed to find user bio")
@@ -443,7 +595,8 @@ func (r *BaseUserRepository) UpdateUserActivationStatusByID(
 	now := time.Now()
 
 	log.Info().Msg("running statement to update user activation status by email")
-	row := r.statements.updateUserActivationStatusByIDStmt.QueryRowContext(ctx, isActive, now, userID)
+	row := stmt(ctx, r.statements.updateUserActivationStatusByIDStmt).
+		QueryRowContext(ctx, isActive, now, userID)
 	err := r.scanUser(u, row)
 
 	return u, err
@@ -458,7 +611,7 @@ func (r *BaseUserRepository) UpdatePasswordByID(
 	now := time.Now()
 
 	log.Info().Msg("running statement to update password by email")
-	row := r.statements.updatePasswordByIDStmt.QueryRowContext(ctx, password, now, userID)
+	row := stmt(ctx, r.statements.updatePasswordByIDStmt).QueryRowContext(ctx, password, now, userID)
 	err := r.scanUser(u, row)
 
 	return u, err
@@ -473,8 +626,27 @@ func (r *BaseUserRepository) UpdateEmailByID(
 	now := time.Now()
 
 	log.Info().Msg("running statement to update email by id")
-	row := r.statements.updateEmailByIDStmt.QueryRowContext(ctx, email, now, userID)
+	row := stmt(ctx, r.statements.updateEmailByIDStmt).QueryRowContext(ctx, email, now, userID)
+	err := r.scanUser(u, row)
+
+	return u, err
+}
+
+func (r *BaseUserRepository) UpdateLocaleByID(
+	ctx context.Context,
+	userID string,
+	locale string,
+) (*repository.User, error) {
+	u := &repository.User{}
+	now := time.Now()
+
+	log.Info().Msg("running statement to update locale by id")
+	row := stmt(ctx, r.statements.updateLocaleByIDStmt).QueryRowContext(ctx, locale, now, userID)
 	err := r.scanUser(u, row)
+	if err == sql.ErrNoRows {
+		log.Error().Err(err).Msg("failed to find user")
+		return nil, repository.NewNotFoundError()
+	}
 
 	return u, err
 }
@@ -487,7 +659,7 @@ func (r *BaseUserRepository) UpdateUserBioByID(
 	now := time.Now()
 
 	log.Info().Msg("running statement to update user bio by id")
-	row := r.statements.updateUserBioByIDStmt.
+	row := stmt(ctx, r.statements.updateUserBioByIDStmt).
 		QueryRowContext(ctx, ub.Fullname, ub.Location, ub.Bio, ub.Web, now, userID)
 	err := r.scanUserBio(ub, row)
 
@@ -503,7 +675,8 @@ func (r *BaseUserRepository) UpdatePictureByID(
 	now := time.Now()
 
 	log.Info().Msg("running statement to update picture by id")
-	row := r.statements.updatePictureByIDStmt.QueryRowContext(ctx, picturePath, now, userID)
+	row := stmt(ctx, r.statements.updatePictureByIDStmt).
+		QueryRowContext(ctx, picturePath, now, userID)
 	err := r.scanUserBio(ub, row)
 
 	return ub, err
@@ -516,7 +689,95 @@ func (r *BaseUserRepository) DeleteUserByID(
 	now := time.Now()
 
 	log.Info().Msg("running statement to soft delete user by id")
-	_, err := r.statements.deleteUserByIDStmt.ExecContext(ctx, now, userID)
+	_, err := stmt(ctx, r.statements.deleteUserByIDStmt).ExecContext(ctx, now, userID)
 
 	return err
 }
+
+// ListUsers lists the users matching f, each with the full name of their bio
+func (r *BaseUserRepository) ListUsers(
+	ctx context.Context,
+	f *repository.UserFilter,
+) ([]*repository.User, error) {
+	var query interface{}
+	if f.Query != "" {
+		query = "%" + escapeLike(f.Query) + "%"
+	}
+
+	log.Info().Msg("running statement to list users")
+	rows, err := stmt(ctx, r.statements.listUsersStmt).QueryContext(
+		ctx,
+		query,
+		nullIfZero(f.Status),
+		nullIfZero(f.Before),
+		f.Limit,
+	)
+	if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.InvalidTextRepresentation {
+		// a malformed cursor can't match any user
+		return []*repository.User{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*repository.User{}
+	for rows.Next() {
+		u := &repository.User{
+			UserBio: &repository.UserBio{},
+		}
+		if err := r.scanUser(u, rows, &u.UserBio.Fullname); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// UpdateUserDeactivationByID deactivates or reactivates a user that isn't
+// deleted
+func (r *BaseUserRepository) UpdateUserDeactivationByID(
+	ctx context.Context,
+	userID string,
+	deactivated bool,
+) (*repository.User, error) {
+	u := &repository.User{}
+	now := time.Now()
+	deactivatedAt := sql.NullTime{Time: now, Valid: deactivated}
+
+	log.Info().Msg("running statement to update user deactivation by id")
+	row := stmt(ctx, r.statements.updateUserDeactivationByIDStmt).
+		QueryRowContext(ctx, deactivatedAt, now, userID)
+	err := r.scanUser(u, row)
+	if err == sql.ErrNoRows {
+		log.Error().Err(err).Msg("failed to find user")
+		return nil, repository.NewNotFoundError()
+	}
+
+	return u, err
+}
+
+// RestoreUserByID undoes the soft delete of a user
+func (r *BaseUserRepository) RestoreUserByID(
+	ctx context.Context,
+	userID string,
+) (*repository.User, error) {
+	u := &repository.User{}
+	now := time.Now()
+
+	log.Info().Msg("running statement to restore user by id")
+	row := stmt(ctx, r.statements.restoreUserByIDStmt).QueryRowContext(ctx, now, userID)
+	err := r.scanUser(u, row)
+	if err == sql.ErrNoRows {
+		log.Error().Err(err).Msg("failed to find deleted user")
+		return nil, repository.NewNotFoundError()
+	}
+
+	return u, err
+}
+
+// escapeLike makes s match literally in a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
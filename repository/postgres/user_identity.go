@@ -0,0 +1,250 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/repository"
+)
+
+const (
+	userIdentityTableName            = "user_identity"
+	userIdentityTableUserIDColName   = "user_id"
+	userIdentityTableProviderColName = "provider"
+	userIdentityTableSubjectColName  = "subject"
+	userIdentityTableCreatedAtName   = "created_at"
+)
+
+type UserIdentityRepository interface {
+	PrepareStatements(context.Context) error
+	CreateUserIdentity(
+		ctx context.Context,
+		i *repository.UserIdentity,
+	) (*repository.UserIdentity, error)
+	GetUserIdentity(
+		ctx context.Context,
+		provider string,
+		subject string,
+	) (*repository.UserIdentity, error)
+	GetUserIdentitiesByUserID(ctx context.Context, userID string) ([]*repository.UserIdentity, error)
+	DeleteUserIdentity(ctx context.Context, userID string, provider string) error
+	DeleteUserIdentitiesByUserID(ctx context.Context, userID string) error
+}
+
+type BaseUserIdentityRepository struct {
+	db         *sql.DB
+	statements *userIdentityStatements
+}
+
+type userIdentityStatements struct {
+	createUserIdentityStmt        *sql.Stmt
+	getUserIdentityStmt           *sql.Stmt
+	getUserIdentitiesByUserIDStmt *sql.Stmt
+	deleteUserIdentityStmt        *sql.Stmt
+	deleteUserIdentitiesStmt      *sql.Stmt
+}
+
+func NewBaseUserIdentityRepository(db *sql.DB) *BaseUserIdentityRepository {
+	return &BaseUserIdentityRepository{
+		db: db,
+	}
+}
+
+type userIdentityScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (r *BaseUserIdentityRepository) scanUserIdentity(
+	i *repository.UserIdentity,
+	row userIdentityScanner,
+) error {
+	return row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Subject,
+		&i.Email,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+}
+
+func (r *BaseUserIdentityRepository) PrepareStatements(ctx context.Context) error {
+	log.Info().Msg("preparing create user identity statement")
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+		 VALUES(DEFAULT, $1, $2, $3, $4, $5, $6)
+		 RETURNING *`,
+		userIdentityTableName,
+	)
+	createUserIdentityStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare create user identity statement")
+		return err
+	}
+
+	log.Info().Msg("preparing get user identity statement")
+	query = fmt.Sprintf(
+		`SELECT *
+		 FROM %s
+		 WHERE %s = $1 AND %s = $2`,
+		userIdentityTableName,
+		userIdentityTableProviderColName,
+		userIdentityTableSubjectColName,
+	)
+	getUserIdentityStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare get user identity statement")
+		return err
+	}
+
+	log.Info().Msg("preparing get user identities by user id statement")
+	query = fmt.Sprintf(
+		`SELECT *
+		 FROM %s
+		 WHERE %s = $1
+		 ORDER BY %s`,
+		userIdentityTableName,
+		userIdentityTableUserIDColName,
+		userIdentityTableCreatedAtName,
+	)
+	getUserIdentitiesByUserIDStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare get user identities by user id statement")
+		return err
+	}
+
+	log.Info().Msg("preparing delete user identity statement")
+	query = fmt.Sprintf(
+		`DELETE FROM %s
+		 WHERE %s = $1 AND %s = $2`,
+		userIdentityTableName,
+		userIdentityTableUserIDColName,
+		userIdentityTableProviderColName,
+	)
+	deleteUserIdentityStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare delete user identity statement")
+		return err
+	}
+
+	log.Info().Msg("preparing delete user identities by user id statement")
+	query = fmt.Sprintf(
+		`DELETE FROM %s
+		 WHERE %s = $1`,
+		userIdentityTableName,
+		userIdentityTableUserIDColName,
+	)
+	deleteUserIdentitiesStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare delete user identities by user id statement")
+		return err
+	}
+
+	r.statements = &userIdentityStatements{
+		createUserIdentityStmt:        createUserIdentityStmt,
+		getUserIdentityStmt:           getUserIdentityStmt,
+		getUserIdentitiesByUserIDStmt: getUserIdentitiesByUserIDStmt,
+		deleteUserIdentityStmt:        deleteUserIdentityStmt,
+		deleteUserIdentitiesStmt:      deleteUserIdentitiesStmt,
+	}
+
+	return nil
+}
+
+func (r *BaseUserIdentityRepository) CreateUserIdentity(
+	ctx context.Context,
+	i *repository.UserIdentity,
+) (*repository.UserIdentity, error) {
+	now := time.Now()
+
+	log.Info().Msg("running statement to create user identity")
+	row := stmt(ctx, r.statements.createUserIdentityStmt).
+		QueryRowContext(ctx, i.UserID, i.Provider, i.Subject, i.Email, now, now)
+	err := r.scanUserIdentity(i, row)
+	if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.UniqueViolation {
+		log.Error().Err(err).Msg("violated unique user identity constraint")
+		return nil, repository.NewUniqueViolationError()
+	}
+
+	return i, err
+}
+
+func (r *BaseUserIdentityRepository) GetUserIdentity(
+	ctx context.Context,
+	provider string,
+	subject string,
+) (*repository.UserIdentity, error) {
+	i := &repository.UserIdentity{}
+
+	log.Info().Msg("running statement to get user identity")
+	row := stmt(ctx, r.statements.getUserIdentityStmt).QueryRowContext(ctx, provider, subject)
+	err := r.scanUserIdentity(i, row)
+	if err == sql.ErrNoRows {
+		log.Error().Err(err).Msg("failed to find user identity")
+		return nil, repository.NewNotFoundError()
+	}
+
+	return i, err
+}
+
+func (r *BaseUserIdentityRepository) GetUserIdentitiesByUserID(
+	ctx context.Context,
+	userID string,
+) ([]*repository.UserIdentity, error) {
+	log.Info().Msg("running statement to get user identities by user id")
+	rows, err := stmt(ctx, r.statements.getUserIdentitiesByUserIDStmt).QueryContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*repository.UserIdentity{}
+	for rows.Next() {
+		i := &repository.UserIdentity{}
+		if err := r.scanUserIdentity(i, rows); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+
+	return identities, rows.Err()
+}
+
+func (r *BaseUserIdentityRepository) DeleteUserIdentity(
+	ctx context.Context,
+	userID string,
+	provider string,
+) error {
+	log.Info().Msg("running statement to delete user identity")
+	res, err := stmt(ctx, r.statements.deleteUserIdentityStmt).ExecContext(ctx, userID, provider)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.NewNotFoundError()
+	}
+
+	return nil
+}
+
+// DeleteUserIdentitiesByUserID unlinks every provider from the user
+func (r *BaseUserIdentityRepository) DeleteUserIdentitiesByUserID(
+	ctx context.Context,
+	userID string,
+) error {
+	log.Info().Msg("running statement to delete user identities by user id")
+	_, err := stmt(ctx, r.statements.deleteUserIdentitiesStmt).ExecContext(ctx, userID)
+
+	return err
+}
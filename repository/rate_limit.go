@@ -0,0 +1,13 @@
+package repository
+
+import "time"
+
+// RateLimit is the state of a rate limit window right after a hit
+type RateLimit struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the oldest hit in the window expires, i.e. when
+	// the next request is guaranteed to be let through
+	Reset time.Duration
+}
@@ -6,17 +6,82 @@ const (
 	forgotPasswordKey          = "forgotPassword"
 	tokenKey                   = "token"
 	emailChangeVerificationKey = "emailChangeVerification"
+	tfaChallengeKey            = "tfaChallenge"
+	accountUnlockKey           = "accountUnlock"
+	authorizationCodeKey       = "authorizationCode"
+	socialLoginKey             = "socialLogin"
+	passkeyCeremonyKey         = "passkeyCeremony"
+	magicLinkKey               = "magicLink"
+	emailChangeRevertKey       = "emailChangeRevert"
 
 	hEmailChangeNewEmailKey = "email"
 	hEmailChangeToken       = "token"
+
+	hTFAChallengeUserIDKey   = "user_id"
+	hTFAChallengeClientIDKey = "client_id"
+	hTFAChallengeAttemptsKey = "attempts"
+
+	hAuthorizationCodeClientIDKey            = "client_id"
+	hAuthorizationCodeUserIDKey              = "user_id"
+	hAuthorizationCodeRedirectURIKey         = "redirect_uri"
+	hAuthorizationCodeScopeKey               = "scope"
+	hAuthorizationCodeNonceKey               = "nonce"
+	hAuthorizationCodeCodeChallengeKey       = "code_challenge"
+	hAuthorizationCodeCodeChallengeMethodKey = "code_challenge_method"
+
+	hSocialLoginProviderKey     = "provider"
+	hSocialLoginClientIDKey     = "client_id"
+	hSocialLoginUserIDKey       = "user_id"
+	hSocialLoginNonceKey        = "nonce"
+	hSocialLoginCodeVerifierKey = "code_verifier"
+
+	hPasskeyCeremonyUserIDKey      = "user_id"
+	hPasskeyCeremonyClientIDKey    = "client_id"
+	hPasskeyCeremonySessionDataKey = "session_data"
+
+	hMagicLinkUserIDKey   = "user_id"
+	hMagicLinkClientIDKey = "client_id"
+
+	hEmailChangeRevertUserIDKey   = "user_id"
+	hEmailChangeRevertOldEmailKey = "old_email"
+)
+
+const (
+	sessionKey        = "session"
+	sessionIndexKey   = "sessions"
+	accessTokenKey    = "accesstoken"
+	refreshTokenKey   = "refreshtoken"
+	rotatedKey        = "rotated"
+	notBeforeKey      = "notBefore"
+	browserSessionKey = "browserSession"
+
+	hSessionClientKey       = "client"
+	hSessionScopeKey        = "scope"
+	hSessionIPKey           = "ip"
+	hSessionUserAgentKey    = "user_agent"
+	hSessionBrowserKey      = "browser"
+	hSessionOSKey           = "os"
+	hSessionDeviceKey       = "device"
+	hSessionLastSeenIPKey   = "last_seen_ip"
+	hSessionLastActiveAtKey = "last_active_at"
+	hSessionCreatedAtKey    = "created_at"
+	hSessionUpdatedAtKey    = "updated_at"
+	hSessionOrganizationKey = "organization_id"
+	hSessionOAuthClientKey  = "oauth_client"
+
+	hSessionWatermarkNotBeforeKey     = "not_before"
+	hSessionWatermarkKeepSessionIDKey = "keep_session_id"
+
+	hBrowserSessionUserIDKey    = "user_id"
+	hBrowserSessionSessionIDKey = "session_id"
 )
 
 const (
-	sessionKey      = "session"
-	accessTokenKey  = "accesstoken"
-	refreshTokenKey = "refreshtoken"
+	ipKey           = "ip"
+	loginAttemptKey = "loginAttempt"
+	loginLockKey    = "loginLock"
+)
 
-	hSessionClientKey    = "client"
-	hSessionCreatedAtKey = "created_at"
-	hSessionUpdatedAtKey = "updated_at"
+const (
+	rateLimitKey = "ratelimit"
 )
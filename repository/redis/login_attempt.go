@@ -0,0 +1,144 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type LoginAttemptRepository interface {
+	IncrementUserLoginAttempts(ctx context.Context, userID string, window time.Duration) (int64, error)
+	ResetUserLoginAttempts(ctx context.Context, userID string) error
+	LockUser(ctx context.Context, userID string, d time.Duration) error
+	GetUserLockTTL(ctx context.Context, userID string) (time.Duration, error)
+	IncrementIPLoginAttempts(ctx context.Context, ip string, window time.Duration) (int64, error)
+	LockIP(ctx context.Context, ip string, d time.Duration) error
+	GetIPLockTTL(ctx context.Context, ip string) (time.Duration, error)
+}
+
+type BaseLoginAttemptRepository struct {
+	rdb *redis.Client
+}
+
+func NewBaseLoginAttemptRepository(rdb *redis.Client) *BaseLoginAttemptRepository {
+	return &BaseLoginAttemptRepository{
+		rdb: rdb,
+	}
+}
+
+func (r *BaseLoginAttemptRepository) getUserLoginAttemptKey(userID string) string {
+	return fmt.Sprintf("%s:%s:%s", userKey, userID, loginAttemptKey)
+}
+
+func (r *BaseLoginAttemptRepository) getUserLoginLockKey(userID string) string {
+	return fmt.Sprintf("%s:%s:%s", userKey, userID, loginLockKey)
+}
+
+func (r *BaseLoginAttemptRepository) getIPLoginAttemptKey(ip string) string {
+	return fmt.Sprintf("%s:%s:%s", ipKey, ip, loginAttemptKey)
+}
+
+func (r *BaseLoginAttemptRepository) getIPLoginLockKey(ip string) string {
+	return fmt.Sprintf("%s:%s:%s", ipKey, ip, loginLockKey)
+}
+
+// increment bumps the counter and (re)starts its window, so the counter only
+// goes away after window has passed without any failed attempt
+func (r *BaseLoginAttemptRepository) increment(
+	ctx context.Context,
+	key string,
+	window time.Duration,
+) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+func (r *BaseLoginAttemptRepository) lockTTL(
+	ctx context.Context,
+	key string,
+) (time.Duration, error) {
+	ttl, err := r.rdb.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// negative values mean the key doesn't exist or has no expiry, neither of
+	// which should happen to a lock
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (r *BaseLoginAttemptRepository) IncrementUserLoginAttempts(
+	ctx context.Context,
+	userID string,
+	window time.Duration,
+) (int64, error) {
+	key := r.getUserLoginAttemptKey(userID)
+	return r.increment(ctx, key, window)
+}
+
+func (r *BaseLoginAttemptRepository) ResetUserLoginAttempts(
+	ctx context.Context,
+	userID string,
+) error {
+	attemptKey := r.getUserLoginAttemptKey(userID)
+	lockKey := r.getUserLoginLockKey(userID)
+
+	return r.rdb.Unlink(ctx, attemptKey, lockKey).Err()
+}
+
+func (r *BaseLoginAttemptRepository) LockUser(
+	ctx context.Context,
+	userID string,
+	d time.Duration,
+) error {
+	key := r.getUserLoginLockKey(userID)
+	return r.rdb.SetEX(ctx, key, time.Now().Add(d), d).Err()
+}
+
+func (r *BaseLoginAttemptRepository) GetUserLockTTL(
+	ctx context.Context,
+	userID string,
+) (time.Duration, error) {
+	key := r.getUserLoginLockKey(userID)
+	return r.lockTTL(ctx, key)
+}
+
+func (r *BaseLoginAttemptRepository) IncrementIPLoginAttempts(
+	ctx context.Context,
+	ip string,
+	window time.Duration,
+) (int64, error) {
+	key := r.getIPLoginAttemptKey(ip)
+	return r.increment(ctx, key, window)
+}
+
+func (r *BaseLoginAttemptRepository) LockIP(
+	ctx context.Context,
+	ip string,
+	d time.Duration,
+) error {
+	key := r.getIPLoginLockKey(ip)
+	return r.rdb.SetEX(ctx, key, time.Now().Add(d), d).Err()
+}
+
+func (r *BaseLoginAttemptRepository) GetIPLockTTL(
+	ctx context.Context,
+	ip string,
+) (time.Duration, error) {
+	key := r.getIPLoginLockKey(ip)
+	return r.lockTTL(ctx, key)
+}
@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/security"
+)
+
+// slidingWindowScript records a hit in a sorted set scored by timestamp (ms),
+// but only if there is room left in the window. doing it in a script keeps the
+// check-then-add atomic across api instances.
+//
+// returns {allowed, remaining, reset in ms}
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local member = ARGV[4]
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, member)
+	redis.call('PEXPIRE', key, window)
+	count = count + 1
+	allowed = 1
+end
+
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if #oldest > 0 then
+	reset = tonumber(oldest[2]) + window - now
+end
+
+return {allowed, limit - count, reset}
+`)
+
+type RateLimitRepository interface {
+	Hit(ctx context.Context, key string, limit int, window time.Duration) (*repository.RateLimit, error)
+}
+
+type BaseRateLimitRepository struct {
+	rdb *redis.Client
+}
+
+func NewBaseRateLimitRepository(rdb *redis.Client) *BaseRateLimitRepository {
+	return &BaseRateLimitRepository{
+		rdb: rdb,
+	}
+}
+
+func (r *BaseRateLimitRepository) getRateLimitKey(key string) string {
+	return fmt.Sprintf("%s:%s", rateLimitKey, key)
+}
+
+func (r *BaseRateLimitRepository) Hit(
+	ctx context.Context,
+	key string,
+	limit int,
+	window time.Duration,
+) (*repository.RateLimit, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	// members of a sorted set must be unique, two hits within the same
+	// millisecond would otherwise count as one
+	member := fmt.Sprintf("%d:%s", now, security.GenerateRandomID())
+
+	res, err := slidingWindowScript.Run(
+		ctx,
+		r.rdb,
+		[]string{r.getRateLimitKey(key)},
+		now,
+		window.Milliseconds(),
+		limit,
+		member,
+	).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := int(res[1])
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	rl := &repository.RateLimit{
+		Allowed:   res[0] == 1,
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     time.Duration(res[2]) * time.Millisecond,
+	}
+	return rl, nil
+}
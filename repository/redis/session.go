@@ -10,12 +10,114 @@ import (
 	"github.com/werdna521/userland/repository"
 )
 
+// rotateRefreshTokenScript swaps the current refresh token JTI for a new one,
+// as long as the presented JTI is still the current one. replaced JTIs are
+// kept around so that presenting one again can be told apart from presenting
+// garbage.
+//
+// returns 1 if rotated, 2 if the JTI has already been rotated, 0 otherwise
+var rotateRefreshTokenScript = redis.NewScript(`
+local key = KEYS[1]
+local rotatedKey = KEYS[2]
+local oldJTI = ARGV[1]
+local newJTI = ARGV[2]
+local ttl = tonumber(ARGV[3])
+
+if redis.call('GET', key) == oldJTI then
+	redis.call('SET', key, newJTI, 'PX', ttl)
+	redis.call('SADD', rotatedKey, oldJTI)
+	redis.call('PEXPIRE', rotatedKey, ttl)
+	return 1
+end
+
+if redis.call('SISMEMBER', rotatedKey, oldJTI) == 1 then
+	return 2
+end
+
+return 0
+`)
+
+// createRefreshTokenScript sets the refresh token JTI of a session. a JTI it
+// replaces goes to the rotated ones, the same as in rotateRefreshTokenScript,
+// so that the replaced token is still caught if it shows up again.
+var createRefreshTokenScript = redis.NewScript(`
+local key = KEYS[1]
+local rotatedKey = KEYS[2]
+local jti = ARGV[1]
+local ttl = tonumber(ARGV[2])
+
+local previousJTI = redis.call('GET', key)
+redis.call('SET', key, jti, 'PX', ttl)
+if previousJTI then
+	redis.call('SADD', rotatedKey, previousJTI)
+	redis.call('PEXPIRE', rotatedKey, ttl)
+end
+
+return 1
+`)
+
+// touchSessionScript records the last activity of a session and moves it up
+// the user's session index, unless the session is gone already. a plain HSET
+// would bring it back without a TTL.
+var touchSessionScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2], ARGV[3], ARGV[4])
+redis.call('ZADD', KEYS[2], 'XX', ARGV[5], ARGV[6])
+return 1
+`)
+
+// setSessionOrganizationScript switches the organization of a session, unless
+// the session is gone already
+//
+// returns 1 if the session was still there, 0 otherwise
+var setSessionOrganizationScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+// revokeSessionScript removes a session along with its tokens and its entry
+// in the user's session index, so a session is never left half revoked
+//
+// returns 1 if the session was still there, 0 otherwise
+var revokeSessionScript = redis.NewScript(`
+local existed = redis.call('UNLINK', KEYS[1])
+redis.call('UNLINK', KEYS[2], KEYS[3], KEYS[4])
+redis.call('ZREM', KEYS[5], ARGV[1])
+return existed
+`)
+
 type SessionRepository interface {
 	CreateSession(ctx context.Context, s *repository.Session, expiresIn time.Duration) error
 	GetSession(ctx context.Context, userID string, sessionID string) (*repository.Session, error)
 	GetAllSessions(ctx context.Context, userID string) ([]*repository.Session, error)
 	DeleteSession(ctx context.Context, s *repository.Session) error
-	AddUserSessionToIndex(ctx context.Context, s *repository.Session) error
+	RevokeSession(ctx context.Context, userID string, sessionID string) (bool, error)
+	TouchSession(ctx context.Context, userID string, sessionID string, ip string) error
+	SetSessionOrganization(
+		ctx context.Context,
+		userID string,
+		sessionID string,
+		orgID string,
+	) (bool, error)
+	SetSessionWatermark(
+		ctx context.Context,
+		userID string,
+		w *repository.SessionWatermark,
+		expiresIn time.Duration,
+	) error
+	GetSessionWatermark(ctx context.Context, userID string) (*repository.SessionWatermark, error)
+	AddUserSessionToIndex(
+		ctx context.Context,
+		s *repository.Session,
+		expiresIn time.Duration,
+	) error
 	RemoveUserSessionFromIndex(ctx context.Context, userID string, sessionID string) error
 	UpdateSessionExpiryTime(
 		ctx context.Context,
@@ -33,7 +135,19 @@ type SessionRepository interface {
 		expiresIn time.Duration,
 	) error
 	CheckRefreshToken(ctx context.Context, rt *repository.RefreshToken) (bool, error)
+	RotateRefreshToken(
+		ctx context.Context,
+		oldRT *repository.RefreshToken,
+		newRT *repository.RefreshToken,
+		expiresIn time.Duration,
+	) (bool, bool, error)
 	DeleteRefreshToken(ctx context.Context, rt *repository.RefreshToken) error
+	CreateBrowserSession(
+		ctx context.Context,
+		bs *repository.BrowserSession,
+		expiresIn time.Duration,
+	) error
+	GetBrowserSession(ctx context.Context, token string) (*repository.BrowserSession, error)
 }
 
 type BaseSessionRepository struct {
@@ -51,10 +165,20 @@ func (r *BaseSessionRepository) getSessionKey(userID string, sessionID string) s
 	return fmt.Sprintf("%s:%s:%s:%s", userKey, userID, sessionKey, sessionID)
 }
 
+// the index is a sorted set of session IDs scored by their last activity
 func (r *BaseSessionRepository) getSessionIndexKey(userID string) string {
+	return fmt.Sprintf("%s:%s:%s", userKey, userID, sessionIndexKey)
+}
+
+// getLegacySessionIndexKey is the plain set sessions used to be indexed in
+func (r *BaseSessionRepository) getLegacySessionIndexKey(userID string) string {
 	return fmt.Sprintf("%s:%s:%s", userKey, userID, sessionKey)
 }
 
+func (r *BaseSessionRepository) getSessionWatermarkKey(userID string) string {
+	return fmt.Sprintf("%s:%s:%s", userKey, userID, notBeforeKey)
+}
+
 func (r *BaseSessionRepository) getAccessTokenKey(at *repository.AccessToken) string {
 	return fmt.Sprintf("%s:%s:%s:%s:%s", userKey, at.UserID, sessionKey, at.SessionID, accessTokenKey)
 }
@@ -63,11 +187,29 @@ func (r *BaseSessionRepository) getRefreshTokenKey(rt *repository.RefreshToken)
 	return fmt.Sprintf("%s:%s:%s:%s:%s", userKey, rt.UserID, sessionKey, rt.SessionID, refreshTokenKey)
 }
 
+func (r *BaseSessionRepository) getRotatedRefreshTokenKey(rt *repository.RefreshToken) string {
+	return fmt.Sprintf("%s:%s", r.getRefreshTokenKey(rt), rotatedKey)
+}
+
+func (r *BaseSessionRepository) getBrowserSessionKey(token string) string {
+	return fmt.Sprintf("%s:%s:%s", browserSessionKey, tokenKey, token)
+}
+
 func (r *BaseSessionRepository) toSessionFields(s *repository.Session) map[string]interface{} {
 	return map[string]interface{}{
-		hSessionClientKey:    s.Client,
-		hSessionCreatedAtKey: s.CreatedAt,
-		hSessionUpdatedAtKey: s.UpdatedAt,
+		hSessionClientKey:       s.Client,
+		hSessionScopeKey:        s.Scope,
+		hSessionIPKey:           s.IP,
+		hSessionUserAgentKey:    s.UserAgent,
+		hSessionBrowserKey:      s.Browser,
+		hSessionOSKey:           s.OS,
+		hSessionDeviceKey:       s.Device,
+		hSessionLastSeenIPKey:   s.LastSeenIP,
+		hSessionLastActiveAtKey: s.LastActiveAt,
+		hSessionCreatedAtKey:    s.CreatedAt,
+		hSessionUpdatedAtKey:    s.UpdatedAt,
+		hSessionOrganizationKey: s.OrganizationID,
+		hSessionOAuthClientKey:  s.OAuthClientID,
 	}
 }
 
@@ -82,6 +224,8 @@ func (r *BaseSessionRepository) CreateSession(
 
 	s.CreatedAt = now
 	s.UpdatedAt = now
+	s.LastSeenIP = s.IP
+	s.LastActiveAt = now
 
 	err := r.rdb.HSet(ctx, key, r.toSessionFields(s)).Err()
 	if err != nil {
@@ -120,27 +264,62 @@ func (r *BaseSessionRepository) GetSession(
 		return nil, err
 	}
 
+	// sessions started before activity was tracked don't have it
+	lastActiveAt := updatedAt
+	if v, ok := res[hSessionLastActiveAtKey]; ok {
+		lastActiveAt, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to parse last_active_at timestamp")
+			return nil, err
+		}
+	}
+
 	session := &repository.Session{
-		ID:        sessionID,
-		UserID:    userID,
-		Client:    res[hSessionClientKey],
-		CreatedAt: createdAt,
-		UpdatedAt: updatedAt,
+		ID:           sessionID,
+		UserID:       userID,
+		Client:       res[hSessionClientKey],
+		Scope:        res[hSessionScopeKey],
+		IP:           res[hSessionIPKey],
+		UserAgent:    res[hSessionUserAgentKey],
+		Browser:      res[hSessionBrowserKey],
+		OS:           res[hSessionOSKey],
+		Device:       res[hSessionDeviceKey],
+		LastSeenIP:   res[hSessionLastSeenIPKey],
+		LastActiveAt: lastActiveAt,
+		// sessions started before organizations existed don't have it, which
+		// reads as no organization
+		OrganizationID: res[hSessionOrganizationKey],
+		OAuthClientID:  res[hSessionOAuthClientKey],
+		CreatedAt:      createdAt,
+		UpdatedAt:      updatedAt,
 	}
 	return session, nil
 }
 
+// GetAllSessions returns the sessions of the user, the most recently active
+// first. IDs of sessions that are gone are pruned from the index on the way.
 func (r *BaseSessionRepository) GetAllSessions(
 	ctx context.Context,
 	userID string,
 ) ([]*repository.Session, error) {
+	err := r.migrateLegacySessionIndex(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to migrate legacy session index")
+		return nil, err
+	}
+
 	sessionIndexKey := r.getSessionIndexKey(userID)
 
-	sessionIDs, err := r.rdb.SMembers(ctx, sessionIndexKey).Result()
+	sessionIDs, err := r.rdb.ZRevRange(ctx, sessionIndexKey, 0, -1).Result()
 	if err != nil {
 		return nil, err
 	}
 
+	w, err := r.GetSessionWatermark(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+		return nil, err
+	}
+
 	sessions := []*repository.Session{}
 	for _, sessionID := range sessionIDs {
 		session, err := r.GetSession(ctx, userID, sessionID)
@@ -159,6 +338,17 @@ func (r *BaseSessionRepository) GetAllSessions(
 			return nil, err
 		}
 
+		// sessions revoked by the watermark are cleaned up lazily, whenever they
+		// turn up here
+		if w != nil && w.Revokes(session) {
+			_, err = r.RevokeSession(ctx, userID, sessionID)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to revoke session")
+				return nil, err
+			}
+			continue
+		}
+
 		// else, we append to the slice
 		sessions = append(sessions, session)
 	}
@@ -166,6 +356,42 @@ func (r *BaseSessionRepository) GetAllSessions(
 	return sessions, nil
 }
 
+// migrateLegacySessionIndex moves the IDs of the legacy index into the sorted
+// one, scored by when they were last used
+func (r *BaseSessionRepository) migrateLegacySessionIndex(ctx context.Context, userID string) error {
+	legacyKey := r.getLegacySessionIndexKey(userID)
+
+	sessionIDs, err := r.rdb.SMembers(ctx, legacyKey).Result()
+	if err != nil || len(sessionIDs) == 0 {
+		return err
+	}
+
+	members := []*redis.Z{}
+	for _, sessionID := range sessionIDs {
+		session, err := r.GetSession(ctx, userID, sessionID)
+		if _, ok := err.(repository.NotFoundError); ok {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		members = append(members, &redis.Z{
+			Score:  float64(session.LastActiveAt.UnixMilli()),
+			Member: sessionID,
+		})
+	}
+
+	_, err = r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		if len(members) > 0 {
+			p.ZAddNX(ctx, r.getSessionIndexKey(userID), members...)
+		}
+		p.Unlink(ctx, legacyKey)
+		return nil
+	})
+	return err
+}
+
 func (r *BaseSessionRepository) DeleteSession(
 	ctx context.Context,
 	s *repository.Session,
@@ -174,14 +400,160 @@ func (r *BaseSessionRepository) DeleteSession(
 	return r.rdb.Unlink(ctx, key).Err()
 }
 
-// a tiny problem with redis: we can't set expiration time for a single element
-// in a set. we'll have to handle deletion manually in the code :(
+// RevokeSession deletes the session, its access and refresh tokens and its
+// index entry in one go. the bool tells whether the session was still there.
+func (r *BaseSessionRepository) RevokeSession(
+	ctx context.Context,
+	userID string,
+	sessionID string,
+) (bool, error) {
+	at := &repository.AccessToken{
+		UserID:    userID,
+		SessionID: sessionID,
+	}
+	rt := &repository.RefreshToken{
+		UserID:    userID,
+		SessionID: sessionID,
+	}
+
+	res, err := revokeSessionScript.Run(
+		ctx,
+		r.rdb,
+		[]string{
+			r.getSessionKey(userID, sessionID),
+			r.getAccessTokenKey(at),
+			r.getRefreshTokenKey(rt),
+			r.getRotatedRefreshTokenKey(rt),
+			r.getSessionIndexKey(userID),
+		},
+		sessionID,
+	).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return res == 1, nil
+}
+
+// SetSessionWatermark replaces the user's watermark. it only has to outlive
+// the sessions it revokes, so expiresIn should be the longest a session can go
+// without being refreshed.
+func (r *BaseSessionRepository) SetSessionWatermark(
+	ctx context.Context,
+	userID string,
+	w *repository.SessionWatermark,
+	expiresIn time.Duration,
+) error {
+	key := r.getSessionWatermarkKey(userID)
+
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, key, map[string]interface{}{
+			hSessionWatermarkNotBeforeKey:     w.NotBefore.Format(time.RFC3339Nano),
+			hSessionWatermarkKeepSessionIDKey: w.KeepSessionID,
+		})
+		p.Expire(ctx, key, expiresIn)
+		return nil
+	})
+	return err
+}
+
+func (r *BaseSessionRepository) GetSessionWatermark(
+	ctx context.Context,
+	userID string,
+) (*repository.SessionWatermark, error) {
+	key := r.getSessionWatermarkKey(userID)
+
+	res, err := r.rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, repository.NewNotFoundError()
+	}
+
+	notBefore, err := time.Parse(time.RFC3339Nano, res[hSessionWatermarkNotBeforeKey])
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse not_before timestamp")
+		return nil, err
+	}
+
+	return &repository.SessionWatermark{
+		NotBefore:     notBefore,
+		KeepSessionID: res[hSessionWatermarkKeepSessionIDKey],
+	}, nil
+}
+
+// TouchSession records that the session has just been used from ip
+func (r *BaseSessionRepository) TouchSession(
+	ctx context.Context,
+	userID string,
+	sessionID string,
+	ip string,
+) error {
+	key := r.getSessionKey(userID, sessionID)
+	indexKey := r.getSessionIndexKey(userID)
+	now := time.Now()
+
+	return touchSessionScript.Run(
+		ctx,
+		r.rdb,
+		[]string{key, indexKey},
+		hSessionLastSeenIPKey,
+		ip,
+		hSessionLastActiveAtKey,
+		now.Format(time.RFC3339Nano),
+		now.UnixMilli(),
+		sessionID,
+	).Err()
+}
+
+// SetSessionOrganization makes orgID the organization of the session, an empty
+// orgID clears it. it returns false when the session is gone.
+func (r *BaseSessionRepository) SetSessionOrganization(
+	ctx context.Context,
+	userID string,
+	sessionID string,
+	orgID string,
+) (bool, error) {
+	key := r.getSessionKey(userID, sessionID)
+
+	res, err := setSessionOrganizationScript.Run(
+		ctx,
+		r.rdb,
+		[]string{key},
+		hSessionOrganizationKey,
+		orgID,
+	).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return res == 1, nil
+}
+
+// AddUserSessionToIndex indexes the session as the most recently active one.
+// elements of a sorted set can't expire on their own, so IDs that haven't been
+// active for expiresIn, which have to be gone by then, are pruned here, and the
+// whole index expires once none of its sessions can be alive anymore.
 func (r *BaseSessionRepository) AddUserSessionToIndex(
 	ctx context.Context,
 	s *repository.Session,
+	expiresIn time.Duration,
 ) error {
 	key := r.getSessionIndexKey(s.UserID)
-	return r.rdb.SAdd(ctx, key, s.ID).Err()
+	now := time.Now()
+	staleBefore := now.Add(-expiresIn).UnixMilli()
+
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.ZAdd(ctx, key, &redis.Z{
+			Score:  float64(now.UnixMilli()),
+			Member: s.ID,
+		})
+		p.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprintf("(%d", staleBefore))
+		p.Expire(ctx, key, expiresIn)
+		return nil
+	})
+	return err
 }
 
 func (r *BaseSessionRepository) RemoveUserSessionFromIndex(
@@ -190,7 +562,7 @@ func (r *BaseSessionRepository) RemoveUserSessionFromIndex(
 	sessionID string,
 ) error {
 	key := r.getSessionIndexKey(userID)
-	return r.rdb.SRem(ctx, key, sessionID).Err()
+	return r.rdb.ZRem(ctx, key, sessionID).Err()
 }
 
 func (r *BaseSessionRepository) UpdateSessionExpiryTime(
@@ -216,7 +588,22 @@ func (r *BaseSessionRepository) UpdateSessionExpiryTime(
 	// we only update the session expiry time if it is less than the new expiry time
 	if exp.Seconds() < expiresIn.Seconds() {
 		err = r.rdb.Expire(ctx, key, expiresIn).Err()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to update session expiry time")
+			return err
+		}
 	}
+
+	// the index has to live at least as long as the session it points to
+	indexKey := r.getSessionIndexKey(s.UserID)
+	_, err = r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.ZAddXX(ctx, indexKey, &redis.Z{
+			Score:  float64(now.UnixMilli()),
+			Member: s.ID,
+		})
+		p.Expire(ctx, indexKey, expiresIn)
+		return nil
+	})
 	return err
 }
 
@@ -254,13 +641,23 @@ func (r *BaseSessionRepository) DeleteAccessToken(
 	return r.rdb.Unlink(ctx, key).Err()
 }
 
+// CreateRefreshToken sets the refresh token of the session, the one it replaces
+// counts as rotated
 func (r *BaseSessionRepository) CreateRefreshToken(
 	ctx context.Context,
 	rt *repository.RefreshToken,
 	expiresIn time.Duration,
 ) error {
 	key := r.getRefreshTokenKey(rt)
-	return r.rdb.SetEX(ctx, key, rt.ID, expiresIn).Err()
+	rotatedKey := r.getRotatedRefreshTokenKey(rt)
+
+	return createRefreshTokenScript.Run(
+		ctx,
+		r.rdb,
+		[]string{key, rotatedKey},
+		rt.ID,
+		expiresIn.Milliseconds(),
+	).Err()
 }
 
 func (r *BaseSessionRepository) CheckRefreshToken(
@@ -280,10 +677,79 @@ func (r *BaseSessionRepository) CheckRefreshToken(
 	return jti == rt.ID, nil
 }
 
+// RotateRefreshToken replaces oldRT with newRT. the first bool tells whether
+// the rotation happened, the second one whether oldRT had already been rotated
+// before, which means the token has been used twice.
+func (r *BaseSessionRepository) RotateRefreshToken(
+	ctx context.Context,
+	oldRT *repository.RefreshToken,
+	newRT *repository.RefreshToken,
+	expiresIn time.Duration,
+) (bool, bool, error) {
+	key := r.getRefreshTokenKey(oldRT)
+	rotatedKey := r.getRotatedRefreshTokenKey(oldRT)
+
+	res, err := rotateRefreshTokenScript.Run(
+		ctx,
+		r.rdb,
+		[]string{key, rotatedKey},
+		oldRT.ID,
+		newRT.ID,
+		expiresIn.Milliseconds(),
+	).Int()
+	if err != nil {
+		return false, false, err
+	}
+
+	return res == 1, res == 2, nil
+}
+
 func (r *BaseSessionRepository) DeleteRefreshToken(
 	ctx context.Context,
 	rt *repository.RefreshToken,
 ) error {
 	key := r.getRefreshTokenKey(rt)
-	return r.rdb.Unlink(ctx, key).Err()
+	rotatedKey := r.getRotatedRefreshTokenKey(rt)
+	return r.rdb.Unlink(ctx, key, rotatedKey).Err()
+}
+
+func (r *BaseSessionRepository) CreateBrowserSession(
+	ctx context.Context,
+	bs *repository.BrowserSession,
+	expiresIn time.Duration,
+) error {
+	key := r.getBrowserSessionKey(bs.Token)
+
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, key, map[string]interface{}{
+			hBrowserSessionUserIDKey:    bs.UserID,
+			hBrowserSessionSessionIDKey: bs.SessionID,
+		})
+		p.Expire(ctx, key, expiresIn)
+		return nil
+	})
+	return err
+}
+
+// GetBrowserSession only tells which session the token is bound to, it's up
+// to the caller to check that the session is still around
+func (r *BaseSessionRepository) GetBrowserSession(
+	ctx context.Context,
+	token string,
+) (*repository.BrowserSession, error) {
+	key := r.getBrowserSessionKey(token)
+
+	res, err := r.rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, repository.NewNotFoundError()
+	}
+
+	return &repository.BrowserSession{
+		Token:     token,
+		UserID:    res[hBrowserSessionUserIDKey],
+		SessionID: res[hBrowserSessionSessionIDKey],
+	}, nil
 }
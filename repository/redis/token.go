@@ -6,8 +6,8 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/config"
 	"github.com/werdna521/userland/repository"
-	"github.com/werdna521/userland/security"
 )
 
 type TokenRepository interface {
@@ -20,15 +20,37 @@ type TokenRepository interface {
 	CreateEmailChangeToken(ctx context.Context, userID string, t *repository.EmailChangeToken) error
 	GetEmailChangeToken(ctx context.Context, userID string) (*repository.EmailChangeToken, error)
 	DeleteEmailChangeToken(ctx context.Context, userID string) error
+	CreateTFAChallenge(ctx context.Context, c *repository.TFAChallenge) error
+	GetTFAChallenge(ctx context.Context, token string) (*repository.TFAChallenge, error)
+	IncrementTFAChallengeAttempts(ctx context.Context, token string) (int64, error)
+	DeleteTFAChallenge(ctx context.Context, token string) error
+	CreateAccountUnlockToken(ctx context.Context, userID string, token string) error
+	GetAccountUnlockToken(ctx context.Context, token string) (string, error)
+	DeleteAccountUnlockToken(ctx context.Context, token string) error
+	CreateAuthorizationCode(ctx context.Context, c *repository.AuthorizationCode) error
+	ConsumeAuthorizationCode(ctx context.Context, code string) (*repository.AuthorizationCode, error)
+	CreateSocialLoginState(ctx context.Context, s *repository.SocialLoginState) error
+	ConsumeSocialLoginState(ctx context.Context, state string) (*repository.SocialLoginState, error)
+	CreatePasskeyCeremony(ctx context.Context, c *repository.PasskeyCeremony) error
+	ConsumePasskeyCeremony(ctx context.Context, id string) (*repository.PasskeyCeremony, error)
+	CreateMagicLinkToken(ctx context.Context, t *repository.MagicLinkToken) error
+	ConsumeMagicLinkToken(ctx context.Context, token string) (*repository.MagicLinkToken, error)
+	CreateEmailChangeRevertToken(ctx context.Context, t *repository.EmailChangeRevertToken) error
+	ConsumeEmailChangeRevertToken(
+		ctx context.Context,
+		token string,
+	) (*repository.EmailChangeRevertToken, error)
 }
 
 type BaseTokenRepository struct {
-	rdb *redis.Client
+	rdb    *redis.Client
+	tokens config.Tokens
 }
 
-func NewBaseTokenRepository(rdb *redis.Client) *BaseTokenRepository {
+func NewBaseTokenRepository(rdb *redis.Client, tokens config.Tokens) *BaseTokenRepository {
 	return &BaseTokenRepository{
-		rdb: rdb,
+		rdb:    rdb,
+		tokens: tokens,
 	}
 }
 
@@ -44,13 +66,41 @@ func (r *BaseTokenRepository) getEmailChangeTokenKey(userID string) string {
 	return fmt.Sprintf("%s:%s:%s:%s", userKey, userID, emailChangeVerificationKey, tokenKey)
 }
 
+func (r *BaseTokenRepository) getTFAChallengeKey(token string) string {
+	return fmt.Sprintf("%s:%s:%s", tfaChallengeKey, tokenKey, token)
+}
+
+func (r *BaseTokenRepository) getAccountUnlockTokenKey(token string) string {
+	return fmt.Sprintf("%s:%s:%s", accountUnlockKey, tokenKey, token)
+}
+
+func (r *BaseTokenRepository) getAuthorizationCodeKey(code string) string {
+	return fmt.Sprintf("%s:%s", authorizationCodeKey, code)
+}
+
+func (r *BaseTokenRepository) getSocialLoginStateKey(state string) string {
+	return fmt.Sprintf("%s:%s", socialLoginKey, state)
+}
+
+func (r *BaseTokenRepository) getPasskeyCeremonyKey(id string) string {
+	return fmt.Sprintf("%s:%s", passkeyCeremonyKey, id)
+}
+
+func (r *BaseTokenRepository) getMagicLinkTokenKey(token string) string {
+	return fmt.Sprintf("%s:%s:%s", magicLinkKey, tokenKey, token)
+}
+
+func (r *BaseTokenRepository) getEmailChangeRevertTokenKey(token string) string {
+	return fmt.Sprintf("%s:%s:%s", emailChangeRevertKey, tokenKey, token)
+}
+
 func (r *BaseTokenRepository) CreateForgotPasswordToken(
 	ctx context.Context,
 	userID string,
 	token string,
 ) error {
 	key := r.getForgotPasswordTokenKey(token)
-	return r.rdb.SetEX(ctx, key, userID, security.TokenLife).Err()
+	return r.rdb.SetEX(ctx, key, userID, r.tokens.Verification).Err()
 }
 
 func (r *BaseTokenRepository) GetForgotPasswordToken(
@@ -87,7 +137,7 @@ func (r *BaseTokenRepository) CreateEmailVerificationToken(
 	token string,
 ) error {
 	key := r.getEmailVerificationTokenKey(userID)
-	return r.rdb.SetEX(ctx, key, token, security.TokenLife).Err()
+	return r.rdb.SetEX(ctx, key, token, r.tokens.Verification).Err()
 }
 
 func (r *BaseTokenRepository) GetEmailVerificationToken(
@@ -131,7 +181,7 @@ func (r *BaseTokenRepository) CreateEmailChangeToken(
 		return err
 	}
 
-	err = r.rdb.Expire(ctx, key, security.TokenLife).Err()
+	err = r.rdb.Expire(ctx, key, r.tokens.Verification).Err()
 	return err
 }
 
@@ -167,3 +217,368 @@ func (r *BaseTokenRepository) DeleteEmailChangeToken(
 
 	return err
 }
+
+func (r *BaseTokenRepository) CreateTFAChallenge(
+	ctx context.Context,
+	c *repository.TFAChallenge,
+) error {
+	key := r.getTFAChallengeKey(c.Token)
+
+	err := r.rdb.HSet(
+		ctx,
+		key,
+		hTFAChallengeUserIDKey, c.UserID,
+		hTFAChallengeClientIDKey, c.ClientID,
+		hTFAChallengeAttemptsKey, 0,
+	).Err()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store tfa challenge")
+		return err
+	}
+
+	err = r.rdb.Expire(ctx, key, r.tokens.Verification).Err()
+	return err
+}
+
+func (r *BaseTokenRepository) GetTFAChallenge(
+	ctx context.Context,
+	token string,
+) (*repository.TFAChallenge, error) {
+	key := r.getTFAChallengeKey(token)
+
+	res, err := r.rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, repository.NewNotFoundError()
+	}
+
+	c := &repository.TFAChallenge{
+		Token:    token,
+		UserID:   res[hTFAChallengeUserIDKey],
+		ClientID: res[hTFAChallengeClientIDKey],
+	}
+
+	return c, nil
+}
+
+func (r *BaseTokenRepository) IncrementTFAChallengeAttempts(
+	ctx context.Context,
+	token string,
+) (int64, error) {
+	key := r.getTFAChallengeKey(token)
+	return r.rdb.HIncrBy(ctx, key, hTFAChallengeAttemptsKey, 1).Result()
+}
+
+func (r *BaseTokenRepository) DeleteTFAChallenge(
+	ctx context.Context,
+	token string,
+) error {
+	key := r.getTFAChallengeKey(token)
+	return r.rdb.Unlink(ctx, key).Err()
+}
+
+func (r *BaseTokenRepository) CreateAccountUnlockToken(
+	ctx context.Context,
+	userID string,
+	token string,
+) error {
+	key := r.getAccountUnlockTokenKey(token)
+	return r.rdb.SetEX(ctx, key, userID, r.tokens.Unlock).Err()
+}
+
+func (r *BaseTokenRepository) GetAccountUnlockToken(
+	ctx context.Context,
+	token string,
+) (string, error) {
+	key := r.getAccountUnlockTokenKey(token)
+
+	userID, err := r.rdb.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", repository.NewNotFoundError()
+	}
+
+	return userID, err
+}
+
+func (r *BaseTokenRepository) DeleteAccountUnlockToken(
+	ctx context.Context,
+	token string,
+) error {
+	key := r.getAccountUnlockTokenKey(token)
+	return r.rdb.Unlink(ctx, key).Err()
+}
+
+func (r *BaseTokenRepository) CreateAuthorizationCode(
+	ctx context.Context,
+	c *repository.AuthorizationCode,
+) error {
+	key := r.getAuthorizationCodeKey(c.Code)
+
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(
+			ctx,
+			key,
+			hAuthorizationCodeClientIDKey, c.ClientID,
+			hAuthorizationCodeUserIDKey, c.UserID,
+			hAuthorizationCodeRedirectURIKey, c.RedirectURI,
+			hAuthorizationCodeScopeKey, c.Scope,
+			hAuthorizationCodeNonceKey, c.Nonce,
+			hAuthorizationCodeCodeChallengeKey, c.CodeChallenge,
+			hAuthorizationCodeCodeChallengeMethodKey, c.CodeChallengeMethod,
+		)
+		p.Expire(ctx, key, r.tokens.AuthorizationCode)
+		return nil
+	})
+	return err
+}
+
+// ConsumeAuthorizationCode reads and deletes the code in one go, so that a code
+// can never be redeemed twice
+func (r *BaseTokenRepository) ConsumeAuthorizationCode(
+	ctx context.Context,
+	code string,
+) (*repository.AuthorizationCode, error) {
+	key := r.getAuthorizationCodeKey(code)
+
+	var get *redis.StringStringMapCmd
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		get = p.HGetAll(ctx, key)
+		p.Unlink(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := get.Val()
+	if len(res) == 0 {
+		return nil, repository.NewNotFoundError()
+	}
+
+	c := &repository.AuthorizationCode{
+		Code:                code,
+		ClientID:            res[hAuthorizationCodeClientIDKey],
+		UserID:              res[hAuthorizationCodeUserIDKey],
+		RedirectURI:         res[hAuthorizationCodeRedirectURIKey],
+		Scope:               res[hAuthorizationCodeScopeKey],
+		Nonce:               res[hAuthorizationCodeNonceKey],
+		CodeChallenge:       res[hAuthorizationCodeCodeChallengeKey],
+		CodeChallengeMethod: res[hAuthorizationCodeCodeChallengeMethodKey],
+	}
+
+	return c, nil
+}
+
+func (r *BaseTokenRepository) CreateSocialLoginState(
+	ctx context.Context,
+	s *repository.SocialLoginState,
+) error {
+	key := r.getSocialLoginStateKey(s.State)
+
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(
+			ctx,
+			key,
+			hSocialLoginProviderKey, s.Provider,
+			hSocialLoginClientIDKey, s.ClientID,
+			hSocialLoginUserIDKey, s.UserID,
+			hSocialLoginNonceKey, s.Nonce,
+			hSocialLoginCodeVerifierKey, s.CodeVerifier,
+		)
+		p.Expire(ctx, key, r.tokens.SocialLoginState)
+		return nil
+	})
+	return err
+}
+
+// ConsumeSocialLoginState reads and deletes the state in one go, so that a
+// provider callback can't be replayed
+func (r *BaseTokenRepository) ConsumeSocialLoginState(
+	ctx context.Context,
+	state string,
+) (*repository.SocialLoginState, error) {
+	key := r.getSocialLoginStateKey(state)
+
+	var get *redis.StringStringMapCmd
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		get = p.HGetAll(ctx, key)
+		p.Unlink(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := get.Val()
+	if len(res) == 0 {
+		return nil, repository.NewNotFoundError()
+	}
+
+	s := &repository.SocialLoginState{
+		State:        state,
+		Provider:     res[hSocialLoginProviderKey],
+		ClientID:     res[hSocialLoginClientIDKey],
+		UserID:       res[hSocialLoginUserIDKey],
+		Nonce:        res[hSocialLoginNonceKey],
+		CodeVerifier: res[hSocialLoginCodeVerifierKey],
+	}
+
+	return s, nil
+}
+
+func (r *BaseTokenRepository) CreatePasskeyCeremony(
+	ctx context.Context,
+	c *repository.PasskeyCeremony,
+) error {
+	key := r.getPasskeyCeremonyKey(c.ID)
+
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(
+			ctx,
+			key,
+			hPasskeyCeremonyUserIDKey, c.UserID,
+			hPasskeyCeremonyClientIDKey, c.ClientID,
+			hPasskeyCeremonySessionDataKey, c.SessionData,
+		)
+		p.Expire(ctx, key, r.tokens.PasskeyCeremony)
+		return nil
+	})
+	return err
+}
+
+// ConsumePasskeyCeremony reads and deletes the ceremony in one go, so that the
+// same challenge can't be answered twice
+func (r *BaseTokenRepository) ConsumePasskeyCeremony(
+	ctx context.Context,
+	id string,
+) (*repository.PasskeyCeremony, error) {
+	key := r.getPasskeyCeremonyKey(id)
+
+	var get *redis.StringStringMapCmd
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		get = p.HGetAll(ctx, key)
+		p.Unlink(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := get.Val()
+	if len(res) == 0 {
+		return nil, repository.NewNotFoundError()
+	}
+
+	c := &repository.PasskeyCeremony{
+		ID:          id,
+		UserID:      res[hPasskeyCeremonyUserIDKey],
+		ClientID:    res[hPasskeyCeremonyClientIDKey],
+		SessionData: []byte(res[hPasskeyCeremonySessionDataKey]),
+	}
+
+	return c, nil
+}
+
+func (r *BaseTokenRepository) CreateMagicLinkToken(
+	ctx context.Context,
+	t *repository.MagicLinkToken,
+) error {
+	key := r.getMagicLinkTokenKey(t.Token)
+
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(
+			ctx,
+			key,
+			hMagicLinkUserIDKey, t.UserID,
+			hMagicLinkClientIDKey, t.ClientID,
+		)
+		p.Expire(ctx, key, r.tokens.MagicLink)
+		return nil
+	})
+	return err
+}
+
+// ConsumeMagicLinkToken reads and deletes the token in one go, so that a link
+// can only be used once
+func (r *BaseTokenRepository) ConsumeMagicLinkToken(
+	ctx context.Context,
+	token string,
+) (*repository.MagicLinkToken, error) {
+	key := r.getMagicLinkTokenKey(token)
+
+	var get *redis.StringStringMapCmd
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		get = p.HGetAll(ctx, key)
+		p.Unlink(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := get.Val()
+	if len(res) == 0 {
+		return nil, repository.NewNotFoundError()
+	}
+
+	t := &repository.MagicLinkToken{
+		Token:    token,
+		UserID:   res[hMagicLinkUserIDKey],
+		ClientID: res[hMagicLinkClientIDKey],
+	}
+
+	return t, nil
+}
+
+func (r *BaseTokenRepository) CreateEmailChangeRevertToken(
+	ctx context.Context,
+	t *repository.EmailChangeRevertToken,
+) error {
+	key := r.getEmailChangeRevertTokenKey(t.Token)
+
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(
+			ctx,
+			key,
+			hEmailChangeRevertUserIDKey, t.UserID,
+			hEmailChangeRevertOldEmailKey, t.OldEmail,
+		)
+		p.Expire(ctx, key, r.tokens.EmailChangeRevert)
+		return nil
+	})
+	return err
+}
+
+// ConsumeEmailChangeRevertToken reads and deletes the token in one go, so
+// that a change can only be reverted once
+func (r *BaseTokenRepository) ConsumeEmailChangeRevertToken(
+	ctx context.Context,
+	token string,
+) (*repository.EmailChangeRevertToken, error) {
+	key := r.getEmailChangeRevertTokenKey(token)
+
+	var get *redis.StringStringMapCmd
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		get = p.HGetAll(ctx, key)
+		p.Unlink(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := get.Val()
+	if len(res) == 0 {
+		return nil, repository.NewNotFoundError()
+	}
+
+	t := &repository.EmailChangeRevertToken{
+		Token:    token,
+		UserID:   res[hEmailChangeRevertUserIDKey],
+		OldEmail: res[hEmailChangeRevertOldEmailKey],
+	}
+
+	return t, nil
+}
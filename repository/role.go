@@ -0,0 +1,13 @@
+package repository
+
+// roles that come with the schema
+const (
+	RoleAdmin = "admin"
+)
+
+// permissions that come with the schema, named <resource>:<action>
+const (
+	PermissionAuditRead  = "audit:read"
+	PermissionUsersRead  = "users:read"
+	PermissionUsersWrite = "users:write"
+)
@@ -1,16 +1,54 @@
 package repository
 
-import "time"
+import (
+	"time"
+
+	"github.com/werdna521/userland/utils/geoip"
+)
 
-// TODO: store IP as well
 type Session struct {
-	ID        string
-	UserID    string
-	Client    string
+	ID     string
+	UserID string
+	Client string
+	Scope  string
+	// where the session was started from
+	IP        string
+	UserAgent string
+	Browser   string
+	OS        string
+	Device    string
+	// where the session was last used from
+	LastSeenIP   string
+	LastActiveAt time.Time
+	// OrganizationID is the organization the session is acting for, if any
+	OrganizationID string
+	// OAuthClientID is the third-party client the session was granted to,
+	// empty for sessions the user started on userland itself
+	OAuthClientID string
+	// Location isn't stored, it is looked up from LastSeenIP when the GeoIP
+	// database is configured
+	Location  *geoip.Location
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// IsOAuthGrant tells whether the session belongs to a third-party OAuth client
+func (s *Session) IsOAuthGrant() bool {
+	return s.OAuthClientID != ""
+}
+
+// SessionWatermark revokes every session of a user started before NotBefore,
+// except for KeepSessionID, without having to go through them one by one
+type SessionWatermark struct {
+	NotBefore     time.Time
+	KeepSessionID string
+}
+
+// Revokes tells whether the session is one of the sessions revoked by w
+func (w *SessionWatermark) Revokes(s *Session) bool {
+	return s.ID != w.KeepSessionID && s.CreatedAt.Before(w.NotBefore)
+}
+
 type AccessToken struct {
 	ID        string
 	SessionID string
@@ -22,3 +60,12 @@ type RefreshToken struct {
 	SessionID string
 	UserID    string
 }
+
+// BrowserSession lets the browser of a signed in user through
+// /oauth/authorize with a cookie. it is only as good as the session it is
+// bound to.
+type BrowserSession struct {
+	Token     string
+	UserID    string
+	SessionID string
+}
@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+type UserTFA struct {
+	ID     string
+	UserID string
+	// Secret is encrypted at rest, use security.Encrypter to read it
+	Secret    string
+	IsEnabled bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// LastUsedStep is the TOTP time step of the last accepted code, codes of
+	// that step or an earlier one can't be used anymore
+	LastUsedStep int64
+}
+
+type RecoveryCode struct {
+	ID     string
+	UserID string
+	// Code is bcrypt-hashed, the plain text is only shown once to the user
+	Code      string
+	UsedAt    sql.NullTime
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TFAChallenge is handed out by login in place of an access token when the
+// user has TFA enabled
+type TFAChallenge struct {
+	Token    string
+	UserID   string
+	ClientID string
+}
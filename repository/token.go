@@ -4,3 +4,19 @@ type EmailChangeToken struct {
 	NewEmail string
 	Token    string
 }
+
+// EmailChangeRevertToken is sent to the old address after an email change, so
+// that its owner can take the account back if the change wasn't theirs
+type EmailChangeRevertToken struct {
+	Token    string
+	UserID   string
+	OldEmail string
+}
+
+// MagicLinkToken is emailed to a user to log in without a password. the
+// session it creates belongs to the client that asked for the link.
+type MagicLinkToken struct {
+	Token    string
+	UserID   string
+	ClientID string
+}
@@ -5,15 +5,43 @@ import (
 	"time"
 )
 
+// what an account can be up to, as far as logging in goes
+const (
+	UserStatusActive      = "active"
+	UserStatusUnverified  = "unverified"
+	UserStatusDeactivated = "deactivated"
+	UserStatusDeleted     = "deleted"
+)
+
 type User struct {
-	ID        string
-	Email     string
-	Password  string
-	IsActive  bool
+	ID       string
+	Email    string
+	Password string
+	IsActive bool
+	// Locale is the language the user wants their mails in, empty means the
+	// language of the request is used
+	Locale    string
 	UserBio   *UserBio
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt sql.NullTime
+	// DeactivatedAt is set while an admin keeps the user from logging in
+	DeactivatedAt sql.NullTime
+}
+
+// Status sums up the user in one of the UserStatus values, the first one that
+// applies of deleted, deactivated, unverified and active
+func (u *User) Status() string {
+	switch {
+	case u.DeletedAt.Valid:
+		return UserStatusDeleted
+	case u.DeactivatedAt.Valid:
+		return UserStatusDeactivated
+	case !u.IsActive:
+		return UserStatusUnverified
+	default:
+		return UserStatusActive
+	}
 }
 
 type UserBio struct {
@@ -26,3 +54,14 @@ type UserBio struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
+
+// UserFilter narrows down a listing of users. zero values don't filter, except
+// that deleted users are only listed with the deleted status. users come newest
+// first, Before is the ID of the last user of the previous page.
+type UserFilter struct {
+	// Query is matched against the email and the full name
+	Query  string
+	Status string
+	Before string
+	Limit  int
+}
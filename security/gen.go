@@ -1,17 +1,40 @@
 package security
 
 import (
-	"time"
+	"fmt"
 
 	"github.com/thanhpk/randstr"
 )
 
 const randomIDBytes = 128 / 8 // 128-bit
 
-const TokenLife = 5 * time.Minute
-
 type RandomID string
 
 func GenerateRandomID() RandomID {
 	return RandomID(randstr.Hex(randomIDBytes))
 }
+
+const codeVerifierBytes = 256 / 8 // 256-bit, hex encoded into 64 chars
+
+// GenerateCodeVerifier returns a PKCE code verifier (RFC 7636)
+func GenerateCodeVerifier() string {
+	return randstr.Hex(codeVerifierBytes)
+}
+
+// PersonalAccessTokenPrefix tells personal access tokens apart from JWTs in
+// the Authorization header
+const PersonalAccessTokenPrefix = "ulpat_"
+
+const personalAccessTokenBytes = 256 / 8 // 256-bit
+
+func GeneratePersonalAccessToken() string {
+	return PersonalAccessTokenPrefix + randstr.Hex(personalAccessTokenBytes)
+}
+
+const recoveryCodeBytes = 5 // 40-bit, hashed and single-use
+
+// GenerateRecoveryCode returns a code in the form of xxxxx-xxxxx
+func GenerateRecoveryCode() string {
+	code := randstr.Hex(recoveryCodeBytes)
+	return fmt.Sprintf("%s-%s", code[:len(code)/2], code[len(code)/2:])
+}
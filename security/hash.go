@@ -1,6 +1,11 @@
 package security
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"golang.org/x/crypto/bcrypt"
+)
 
 func HashPassword(password string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -13,3 +18,10 @@ func HashPassword(password string) (string, error) {
 func CheckPassword(plainPassword string, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(plainPassword))
 }
+
+// HashToken hashes a random token so that it can be looked up by its hash.
+// the token has too much entropy to be guessed, so bcrypt isn't needed.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -15,16 +15,34 @@ type AccessToken struct {
 	JTI       string    `json:"-"`
 	UserID    string    `json:"-"`
 	SessionID string    `json:"-"`
+	Roles     []string  `json:"-"`
+	// OrganizationID is the active organization of the session
+	OrganizationID string `json:"-"`
+	// PersonalAccessTokenID is set instead of SessionID when the request was
+	// made with a personal access token, which is limited to its Scopes
+	PersonalAccessTokenID string   `json:"-"`
+	Scopes                []string `json:"-"`
 }
 
 type AccessTokenClaims struct {
 	*jwt.StandardClaims
 	UserID    string
 	SessionID string
+	// Roles are the roles of the user when the token was issued
+	Roles []string `json:"roles,omitempty"`
+	// OrganizationID is the organization the session was acting for when the
+	// token was issued
+	OrganizationID string `json:"org_id,omitempty"`
 }
 
-func CreateAccessToken(userID string, sessionID string) (*AccessToken, error) {
-	expiresAt := time.Now().Add(AccessTokenLife)
+func CreateAccessToken(
+	userID string,
+	sessionID string,
+	roles []string,
+	orgID string,
+	expiresIn time.Duration,
+) (*AccessToken, error) {
+	expiresAt := time.Now().Add(expiresIn)
 	jti := string(security.GenerateRandomID())
 
 	log.Info().Msg("creating access token claims")
@@ -33,8 +51,10 @@ func CreateAccessToken(userID string, sessionID string) (*AccessToken, error) {
 			ExpiresAt: expiresAt.Unix(),
 			Id:        jti,
 		},
-		UserID:    userID,
-		SessionID: sessionID,
+		UserID:         userID,
+		SessionID:      sessionID,
+		Roles:          roles,
+		OrganizationID: orgID,
 	}
 
 	log.Info().Msg("creating access token")
@@ -45,12 +65,14 @@ func CreateAccessToken(userID string, sessionID string) (*AccessToken, error) {
 	}
 
 	at := &AccessToken{
-		Value:     tokenString,
-		Type:      "Bearer",
-		ExpiredAt: expiresAt,
-		JTI:       jti,
-		UserID:    userID,
-		SessionID: sessionID,
+		Value:          tokenString,
+		Type:           "Bearer",
+		ExpiredAt:      expiresAt,
+		JTI:            jti,
+		UserID:         userID,
+		SessionID:      sessionID,
+		Roles:          roles,
+		OrganizationID: orgID,
 	}
 	return at, nil
 }
@@ -66,12 +88,14 @@ func ParseAccessToken(jwtString string) (*AccessToken, bool, error) {
 	}
 
 	at := &AccessToken{
-		Value:     jwtString,
-		Type:      "Bearer",
-		ExpiredAt: time.Unix(claims.ExpiresAt, 0),
-		JTI:       claims.Id,
-		UserID:    claims.UserID,
-		SessionID: claims.SessionID,
+		Value:          jwtString,
+		Type:           "Bearer",
+		ExpiredAt:      time.Unix(claims.ExpiresAt, 0),
+		JTI:            claims.Id,
+		UserID:         claims.UserID,
+		SessionID:      claims.SessionID,
+		Roles:          claims.Roles,
+		OrganizationID: claims.OrganizationID,
 	}
 
 	return at, t.Valid, nil
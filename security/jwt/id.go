@@ -0,0 +1,67 @@
+package jwt
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/rs/zerolog/log"
+)
+
+// UserClaims are the standard OIDC claims describing a user. which of them are
+// filled depends on the scopes granted to the client.
+type UserClaims struct {
+	Name          string `json:"name,omitempty"`
+	Website       string `json:"website,omitempty"`
+	Picture       string `json:"picture,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+type IDTokenClaims struct {
+	*jwt.StandardClaims
+	*UserClaims
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// CreateIDToken creates an OIDC ID token for the client identified by audience
+func CreateIDToken(
+	issuer string,
+	audience string,
+	userID string,
+	nonce string,
+	uc *UserClaims,
+	expiresIn time.Duration,
+) (string, error) {
+	now := time.Now()
+
+	claims := IDTokenClaims{
+		StandardClaims: &jwt.StandardClaims{
+			Issuer:    issuer,
+			Subject:   userID,
+			Audience:  audience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(expiresIn).Unix(),
+		},
+		UserClaims: uc,
+		Nonce:      nonce,
+	}
+
+	log.Info().Msg("creating id token")
+	tokenString, err := generateJWTToken(claims)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create id token")
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+// SigningAlg returns the algorithm new tokens are signed with
+func SigningAlg() (string, error) {
+	km, err := getKeyManager()
+	if err != nil {
+		return "", err
+	}
+
+	return km.SigningAlg(), nil
+}
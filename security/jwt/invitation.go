@@ -0,0 +1,70 @@
+package jwt
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/rs/zerolog/log"
+)
+
+// InvitationToken is the signed token in an invitation link. the JTI is
+// stored with the invitation, so resending it makes the older links useless.
+type InvitationToken struct {
+	Value        string
+	ExpiredAt    time.Time
+	JTI          string
+	InvitationID string
+}
+
+type InvitationTokenClaims struct {
+	*jwt.StandardClaims
+	InvitationID string `json:"invitation_id"`
+}
+
+func CreateInvitationToken(
+	invitationID string,
+	jti string,
+	expiresAt time.Time,
+) (*InvitationToken, error) {
+	claims := InvitationTokenClaims{
+		StandardClaims: &jwt.StandardClaims{
+			ExpiresAt: expiresAt.Unix(),
+			Id:        jti,
+		},
+		InvitationID: invitationID,
+	}
+
+	log.Info().Msg("creating invitation token")
+	tokenString, err := generateJWTToken(claims)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create invitation token")
+		return nil, err
+	}
+
+	it := &InvitationToken{
+		Value:        tokenString,
+		ExpiredAt:    expiresAt,
+		JTI:          jti,
+		InvitationID: invitationID,
+	}
+	return it, nil
+}
+
+func ParseInvitationToken(jwtString string) (*InvitationToken, bool, error) {
+	claims := &InvitationTokenClaims{}
+
+	t, err := parseJWTToken(jwtString, claims)
+	if err != nil {
+		log.Error().Err(err).Msg("error parsing invitation token")
+		return nil, false, err
+	}
+
+	it := &InvitationToken{
+		Value:        jwtString,
+		ExpiredAt:    time.Unix(claims.ExpiresAt, 0),
+		JTI:          claims.Id,
+		InvitationID: claims.InvitationID,
+	}
+
+	return it, t.Valid, nil
+}
@@ -0,0 +1,283 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const keyFileExt = ".pem"
+
+// Key is a single JWT key identified by its kid. verification only keys (e.g.
+// ones being rotated out) have no signing key.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// KeyManager holds the key used to sign new tokens and every key that tokens
+// are still accepted from. an optional HMAC secret is used for tokens without a
+// kid header, i.e. the ones issued before asymmetric keys were set up.
+type KeyManager struct {
+	mu           sync.RWMutex
+	keys         map[string]*Key
+	signingKeyID string
+	hmacSecret   []byte
+}
+
+func NewKeyManager(hmacSecret []byte) *KeyManager {
+	return &KeyManager{
+		keys:       map[string]*Key{},
+		hmacSecret: hmacSecret,
+	}
+}
+
+// LoadKeyManager reads every <kid>.pem file in dir as a key and signs with the
+// one named signingKeyID. if dir is empty, tokens are signed with hmacSecret.
+// otherwise the tokens signed with hmacSecret are only accepted when
+// acceptLegacy is set, so the secret stops being a way to mint tokens once the
+// keys are in place.
+func LoadKeyManager(
+	dir string,
+	signingKeyID string,
+	hmacSecret []byte,
+	acceptLegacy bool,
+) (*KeyManager, error) {
+	if dir == "" {
+		if len(hmacSecret) == 0 {
+			return nil, errors.New("either a keys directory or a secret is required")
+		}
+		return NewKeyManager(hmacSecret), nil
+	}
+
+	if !acceptLegacy {
+		hmacSecret = nil
+	}
+	km := NewKeyManager(hmacSecret)
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+keyFileExt))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), keyFileExt)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := ParseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s: %w", kid, err)
+		}
+		km.AddKey(key)
+	}
+
+	if err := km.SetSigningKey(signingKeyID); err != nil {
+		return nil, err
+	}
+
+	return km, nil
+}
+
+// ParseKey reads a PEM encoded private key (PKCS#1, PKCS#8 or SEC 1) or a
+// public key (PKIX). the signing method is picked from the key type.
+func ParseKey(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var (
+		k   interface{}
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		k, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		k, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		k, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: kid}
+	if signer, ok := k.(crypto.Signer); ok {
+		key.signKey = k
+		k = signer.Public()
+	}
+
+	switch pub := k.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 ECDSA keys are supported")
+		}
+		key.Method = jwt.SigningMethodES256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", k)
+	}
+	key.verifyKey = k
+
+	return key, nil
+}
+
+func (m *KeyManager) AddKey(key *Key) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys[key.ID] = key
+}
+
+func (m *KeyManager) RemoveKey(kid string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.keys, kid)
+	if m.signingKeyID == kid {
+		m.signingKeyID = ""
+	}
+}
+
+// SetSigningKey switches the key new tokens are signed with. the previous one
+// stays around for verification until it's removed.
+func (m *KeyManager) SetSigningKey(kid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.keys[kid]
+	if !ok {
+		return fmt.Errorf("signing key %q not found", kid)
+	}
+	if !key.CanSign() {
+		return fmt.Errorf("key %q has no private key", kid)
+	}
+
+	m.signingKeyID = kid
+	return nil
+}
+
+func (m *KeyManager) SigningAlg() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.keys[m.signingKeyID]
+	if !ok {
+		return jwt.SigningMethodHS256.Alg()
+	}
+
+	return key.Method.Alg()
+}
+
+func (m *KeyManager) sign(claims jwt.Claims) (string, error) {
+	m.mu.RLock()
+	key, ok := m.keys[m.signingKeyID]
+	secret := m.hmacSecret
+	m.mu.RUnlock()
+
+	if !ok {
+		if len(secret) == 0 {
+			return "", errors.New("no signing key configured")
+		}
+		t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return t.SignedString(secret)
+	}
+
+	t := jwt.NewWithClaims(key.Method, claims)
+	t.Header["kid"] = key.ID
+	return t.SignedString(key.signKey)
+}
+
+func (m *KeyManager) keyFunc(t *jwt.Token) (interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	kid, hasKID := t.Header["kid"].(string)
+	if !hasKID {
+		// check token signing method
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok || len(m.hmacSecret) == 0 {
+			return nil, fmt.Errorf("unexpected signing method: %s", t.Header["alg"])
+		}
+		return m.hmacSecret, nil
+	}
+
+	key, ok := m.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %s", t.Header["alg"])
+	}
+
+	return key.verifyKey, nil
+}
+
+// JWKS returns the public part of every asymmetric key, sorted by kid
+func (m *KeyManager) JWKS() *JWKSet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := &JWKSet{Keys: []*JWK{}}
+	for _, key := range m.keys {
+		set.Keys = append(set.Keys, newJWK(key))
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].KID < set.Keys[j].KID
+	})
+
+	return set
+}
+
+var (
+	keysMu sync.RWMutex
+	keys   *KeyManager
+)
+
+// SetKeyManager sets the key manager used by the token functions in this
+// package
+func SetKeyManager(km *KeyManager) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	keys = km
+}
+
+func getKeyManager() (*KeyManager, error) {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+
+	if keys == nil {
+		return nil, errors.New("key manager is not set up")
+	}
+	return keys, nil
+}
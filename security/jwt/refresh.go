@@ -23,8 +23,12 @@ type RefreshTokenClaims struct {
 	SessionID string
 }
 
-func CreateRefreshToken(userID string, sessionID string) (*RefreshToken, error) {
-	expiresAt := time.Now().Add(RefreshTokenLife)
+func CreateRefreshToken(
+	userID string,
+	sessionID string,
+	expiresIn time.Duration,
+) (*RefreshToken, error) {
+	expiresAt := time.Now().Add(expiresIn)
 	jti := string(security.GenerateRandomID())
 
 	claims := RefreshTokenClaims{
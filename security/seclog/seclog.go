@@ -0,0 +1,38 @@
+package seclog
+
+import (
+	"context"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/werdna521/userland/utils/clientinfo"
+)
+
+// logger writes security relevant events (e.g. token theft signals) apart from
+// the regular logs, so they can be shipped and alerted on separately
+var logger = zerolog.New(os.Stderr).With().Timestamp().Str("log", "security").Logger()
+
+// Init redirects the security log to a file. it's left on stderr if path is
+// empty.
+func Init(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	logger = logger.Output(f)
+	return nil
+}
+
+// Event starts a security log entry carrying the client info of the request
+func Event(ctx context.Context, name string) *zerolog.Event {
+	ci := clientinfo.FromContext(ctx)
+	return logger.Warn().
+		Str("event", name).
+		Str("ip", ci.IP).
+		Str("user_agent", ci.UserAgent)
+}
@@ -0,0 +1,92 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// the SHA1 seed of RFC 6238 appendix B, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// the RFC lists 8-digit codes, these are their last 6 digits
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestGenerateCodeMatchesRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		code, err := GenerateCode(rfcSecret, time.Unix(v.unix, 0))
+		if err != nil {
+			t.Fatalf("failed to generate code at %d: %v", v.unix, err)
+		}
+		if code != v.code {
+			t.Errorf("expected %s at %d, got %s", v.code, v.unix, code)
+		}
+	}
+}
+
+func TestValidateMatchesRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		step, ok := Validate(rfcSecret, v.code, time.Unix(v.unix, 0))
+		if !ok {
+			t.Errorf("expected %s to be valid at %d", v.code, v.unix)
+			continue
+		}
+		if step != v.unix/30 {
+			t.Errorf("expected %s to belong to step %d, got %d", v.code, v.unix/30, step)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	// 1111111110 is the first second of its step
+	now := time.Unix(1111111110, 0)
+	current := now.Unix() / 30
+
+	tests := []struct {
+		name  string
+		at    time.Time
+		step  int64
+		valid bool
+	}{
+		{"two steps before", now.Add(-2 * Period), current - 2, false},
+		{"last second of the step before", now.Add(-time.Second), current - 1, true},
+		{"current step", now, current, true},
+		{"last second of the current step", now.Add(Period - time.Second), current, true},
+		{"step after", now.Add(Period), current + 1, true},
+		{"two steps after", now.Add(2 * Period), current + 2, false},
+	}
+
+	for _, tt := range tests {
+		code, err := GenerateCode(rfcSecret, tt.at)
+		if err != nil {
+			t.Fatalf("%s: failed to generate code: %v", tt.name, err)
+		}
+
+		step, ok := Validate(rfcSecret, code, now)
+		if ok != tt.valid {
+			t.Errorf("%s: expected valid to be %t, got %t", tt.name, tt.valid, ok)
+			continue
+		}
+		if ok && step != tt.step {
+			t.Errorf("%s: expected step %d, got %d", tt.name, tt.step, step)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedCode(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("expected %q to be rejected", code)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/postgres"
+	"github.com/werdna521/userland/repository/redis"
+	"github.com/werdna521/userland/utils/clientinfo"
+	"github.com/werdna521/userland/utils/clock"
+	"github.com/werdna521/userland/utils/geoip"
+)
+
+// UserDetail is everything an admin gets to see about a user
+type UserDetail struct {
+	User     *repository.User
+	Roles    []string
+	Sessions []*repository.Session
+}
+
+// AdminService manages the accounts of other users. the admin is the actor
+// of every audit event recorded here, the user is the one they acted on.
+type AdminService interface {
+	ListUsers(ctx context.Context, f *repository.UserFilter) ([]*repository.User, e.Error)
+	GetUser(ctx context.Context, userID string) (*UserDetail, e.Error)
+	DeactivateUser(ctx context.Context, userID string) e.Error
+	ReactivateUser(ctx context.Context, userID string) e.Error
+	ForceEmailVerification(ctx context.Context, userID string) e.Error
+	SendPasswordReset(ctx context.Context, userID string) e.Error
+	EndAllSessions(ctx context.Context, userID string) e.Error
+	DeleteUser(ctx context.Context, userID string) e.Error
+	RestoreUser(ctx context.Context, userID string) e.Error
+}
+
+type BaseAdminService struct {
+	ur    postgres.UserRepository
+	rr    postgres.RoleRepository
+	aer   postgres.AuditEventRepository
+	sr    redis.SessionRepository
+	patr  postgres.PersonalAccessTokenRepository
+	as    AuthService
+	hook  EventHook
+	geo   geoip.Locator
+	clock clock.Clock
+}
+
+func NewBaseAdminService(
+	ur postgres.UserRepository,
+	rr postgres.RoleRepository,
+	aer postgres.AuditEventRepository,
+	sr redis.SessionRepository,
+	patr postgres.PersonalAccessTokenRepository,
+	as AuthService,
+	hook EventHook,
+	geo geoip.Locator,
+	clock clock.Clock,
+) *BaseAdminService {
+	return &BaseAdminService{
+		ur:    ur,
+		rr:    rr,
+		aer:   aer,
+		sr:    sr,
+		patr:  patr,
+		as:    as,
+		hook:  hook,
+		geo:   geo,
+		clock: clock,
+	}
+}
+
+func (s *BaseAdminService) ListUsers(
+	ctx context.Context,
+	f *repository.UserFilter,
+) ([]*repository.User, e.Error) {
+	log.Info().Msg("listing users")
+	users, err := s.ur.ListUsers(ctx, f)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list users")
+		return nil, e.NewInternalServerError()
+	}
+
+	return users, nil
+}
+
+// getUser gets any user, deleted ones included
+func (s *BaseAdminService) getUser(ctx context.Context, userID string) (*repository.User, e.Error) {
+	log.Info().Msg("retrieving user from database")
+	u, err := s.ur.GetUserByID(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user not found")
+		return nil, e.NewNotFoundError("user not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return nil, e.NewInternalServerError()
+	}
+
+	return u, nil
+}
+
+// checkNotSelf keeps admins from locking themselves out, there might be no one
+// left to undo it
+func checkNotSelf(ctx context.Context, userID string) e.Error {
+	if clientinfo.FromContext(ctx).UserID == userID {
+		log.Error().Msg("admin is acting on their own account")
+		return e.NewBadRequestError("can't do this to your own account")
+	}
+
+	return nil
+}
+
+func (s *BaseAdminService) GetUser(ctx context.Context, userID string) (*UserDetail, e.Error) {
+	u, getErr := s.getUser(ctx, userID)
+	if getErr != nil {
+		return nil, getErr
+	}
+
+	log.Info().Msg("getting user bio from database")
+	ub, err := s.ur.GetUserBioByID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user bio from database")
+		return nil, e.NewInternalServerError()
+	}
+	u.UserBio = ub
+
+	log.Info().Msg("retrieving roles from the db")
+	roles, err := s.rr.GetRolesByUserID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve roles")
+		return nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("getting all active sessions")
+	sessions, err := s.sr.GetAllSessions(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get all active sessions")
+		return nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("looking up session locations")
+	for _, session := range sessions {
+		session.Location = s.geo.Locate(session.LastSeenIP)
+	}
+
+	return &UserDetail{
+		User:     u,
+		Roles:    roles,
+		Sessions: sessions,
+	}, nil
+}
+
+// DeactivateUser keeps the user from logging in until they are reactivated,
+// and ends the sessions they have
+func (s *BaseAdminService) DeactivateUser(ctx context.Context, userID string) e.Error {
+	if err := checkNotSelf(ctx, userID); err != nil {
+		return err
+	}
+
+	u, getErr := s.getUser(ctx, userID)
+	if getErr != nil {
+		return getErr
+	}
+	if u.DeletedAt.Valid {
+		log.Error().Msg("user is deleted")
+		return e.NewBadRequestError("user is deleted")
+	}
+	if u.DeactivatedAt.Valid {
+		log.Error().Msg("user is already deactivated")
+		return e.NewBadRequestError("user is already deactivated")
+	}
+
+	log.Info().Msg("deactivating user")
+	_, err := s.ur.UpdateUserDeactivationByID(ctx, userID, true)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to deactivate user")
+		return e.NewInternalServerError()
+	}
+
+	err = endAllSessions(ctx, s.sr, userID)
+	if err != nil {
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditAccountDeactivated, userID, nil)
+
+	return nil
+}
+
+func (s *BaseAdminService) ReactivateUser(ctx context.Context, userID string) e.Error {
+	u, getErr := s.getUser(ctx, userID)
+	if getErr != nil {
+		return getErr
+	}
+	if u.DeletedAt.Valid {
+		log.Error().Msg("user is deleted")
+		return e.NewBadRequestError("user is deleted")
+	}
+	if !u.DeactivatedAt.Valid {
+		log.Error().Msg("user is not deactivated")
+		return e.NewBadRequestError("user is not deactivated")
+	}
+
+	log.Info().Msg("reactivating user")
+	_, err := s.ur.UpdateUserDeactivationByID(ctx, userID, false)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to reactivate user")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditAccountReactivated, userID, nil)
+
+	return nil
+}
+
+// ForceEmailVerification makes the user verify their email again before they
+// can log in, and sends them the verification mail. their sessions are ended,
+// they were started with an address that is no longer trusted.
+func (s *BaseAdminService) ForceEmailVerification(ctx context.Context, userID string) e.Error {
+	u, getErr := s.getUser(ctx, userID)
+	if getErr != nil {
+		return getErr
+	}
+	if u.DeletedAt.Valid {
+		log.Error().Msg("user is deleted")
+		return e.NewBadRequestError("user is deleted")
+	}
+
+	// an unverified user only needs the mail again
+	if u.IsActive {
+		log.Info().Msg("marking user as unverified")
+		_, err := s.ur.UpdateUserActivationStatusByID(ctx, userID, false)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to mark user as unverified")
+			return e.NewInternalServerError()
+		}
+
+		err = endAllSessions(ctx, s.sr, userID)
+		if err != nil {
+			return e.NewInternalServerError()
+		}
+
+		err = deletePersonalAccessTokens(ctx, s.patr, userID)
+		if err != nil {
+			return e.NewInternalServerError()
+		}
+
+		recordAudit(ctx, s.aer, repository.AuditVerificationForced, userID, nil)
+	}
+
+	return s.as.SendEmailVerification(ctx, u.Email)
+}
+
+// SendPasswordReset sends the user the same mail as a forgotten password does
+func (s *BaseAdminService) SendPasswordReset(ctx context.Context, userID string) e.Error {
+	u, getErr := s.getUser(ctx, userID)
+	if getErr != nil {
+		return getErr
+	}
+	if u.DeletedAt.Valid {
+		log.Error().Msg("user is deleted")
+		return e.NewBadRequestError("user is deleted")
+	}
+
+	return s.as.ForgotPassword(ctx, u.Email)
+}
+
+func (s *BaseAdminService) EndAllSessions(ctx context.Context, userID string) e.Error {
+	_, getErr := s.getUser(ctx, userID)
+	if getErr != nil {
+		return getErr
+	}
+
+	err := endAllSessions(ctx, s.sr, userID)
+	if err != nil {
+		return e.NewInternalServerError()
+	}
+
+	err = deletePersonalAccessTokens(ctx, s.patr, userID)
+	if err != nil {
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditAllSessionsEnded, userID, nil)
+
+	return nil
+}
+
+// DeleteUser soft deletes the user, the same way users delete their own
+// account
+func (s *BaseAdminService) DeleteUser(ctx context.Context, userID string) e.Error {
+	if err := checkNotSelf(ctx, userID); err != nil {
+		return err
+	}
+
+	u, getErr := s.getUser(ctx, userID)
+	if getErr != nil {
+		return getErr
+	}
+	if u.DeletedAt.Valid {
+		log.Error().Msg("user is already deleted")
+		return e.NewBadRequestError("user is already deleted")
+	}
+
+	log.Info().Msg("deleting user from database")
+	err := s.ur.DeleteUserByID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete user from database")
+		return e.NewInternalServerError()
+	}
+
+	err = endAllSessions(ctx, s.sr, userID)
+	if err != nil {
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditAccountDeleted, userID, nil)
+	s.hook.OnEvent(ctx, newEvent(ctx, EventAccountDeleted, u, s.clock.Now()))
+
+	return nil
+}
+
+// RestoreUser undoes a soft delete, unless someone else has registered with
+// the email since
+func (s *BaseAdminService) RestoreUser(ctx context.Context, userID string) e.Error {
+	u, getErr := s.getUser(ctx, userID)
+	if getErr != nil {
+		return getErr
+	}
+	if !u.DeletedAt.Valid {
+		log.Error().Msg("user is not deleted")
+		return e.NewBadRequestError("user is not deleted")
+	}
+
+	log.Info().Msg("checking if the email is taken")
+	_, err := s.ur.GetUserByEmail(ctx, u.Email)
+	if err == nil {
+		log.Error().Msg("email is used by another user")
+		return e.NewConflictError("email is used by another user")
+	}
+	if _, ok := err.(repository.NotFoundError); !ok {
+		log.Error().Err(err).Msg("failed to get user by email")
+		return e.NewInternalServerError()
+	}
+
+	log.Info().Msg("restoring user")
+	_, err = s.ur.RestoreUserByID(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		// restored by someone else in the meantime
+		log.Error().Err(err).Msg("user is not deleted")
+		return e.NewBadRequestError("user is not deleted")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to restore user")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditAccountRestored, userID, nil)
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/rs/zerolog/log"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/postgres"
+	"github.com/werdna521/userland/utils/clientinfo"
+)
+
+// recordAudit appends an event about userID to the audit log. the actor and
+// where the request came from are taken from the client info in ctx. like the
+// EventHook, it can't fail the operation it records, so errors are only
+// logged.
+func recordAudit(
+	ctx context.Context,
+	aer postgres.AuditEventRepository,
+	eventType string,
+	userID string,
+	metadata map[string]interface{},
+) {
+	ci := clientinfo.FromContext(ctx)
+
+	ae := &repository.AuditEvent{
+		ActorID:   sql.NullString{String: ci.UserID, Valid: ci.UserID != ""},
+		UserID:    sql.NullString{String: userID, Valid: userID != ""},
+		SessionID: ci.SessionID,
+		IP:        ci.IP,
+		UserAgent: ci.UserAgent,
+		Type:      eventType,
+		Metadata:  metadata,
+	}
+
+	log.Info().Msgf("recording %s audit event", eventType)
+	err := aer.CreateAuditEvent(ctx, ae)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to record %s audit event", eventType)
+	}
+}
+
+type AuditService interface {
+	ListUserActivity(
+		ctx context.Context,
+		userID string,
+		before string,
+		limit int,
+	) ([]*repository.AuditEvent, e.Error)
+	ListAuditEvents(
+		ctx context.Context,
+		f *repository.AuditEventFilter,
+	) ([]*repository.AuditEvent, e.Error)
+}
+
+type BaseAuditService struct {
+	aer postgres.AuditEventRepository
+}
+
+func NewBaseAuditService(aer postgres.AuditEventRepository) *BaseAuditService {
+	return &BaseAuditService{
+		aer: aer,
+	}
+}
+
+// ListUserActivity lists the events of a single user, newest first
+func (s *BaseAuditService) ListUserActivity(
+	ctx context.Context,
+	userID string,
+	before string,
+	limit int,
+) ([]*repository.AuditEvent, e.Error) {
+	return s.ListAuditEvents(ctx, &repository.AuditEventFilter{
+		UserID: userID,
+		Before: before,
+		Limit:  limit,
+	})
+}
+
+func (s *BaseAuditService) ListAuditEvents(
+	ctx context.Context,
+	f *repository.AuditEventFilter,
+) ([]*repository.AuditEvent, e.Error) {
+	log.Info().Msg("listing audit events")
+	events, err := s.aer.ListAuditEvents(ctx, f)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list audit events")
+		return nil, e.NewInternalServerError()
+	}
+
+	return events, nil
+}
@@ -3,52 +3,186 @@ package service
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/rs/zerolog/log"
 	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/config"
 	"github.com/werdna521/userland/mailer"
 	"github.com/werdna521/userland/repository"
 	"github.com/werdna521/userland/repository/postgres"
 	"github.com/werdna521/userland/repository/redis"
 	"github.com/werdna521/userland/security"
 	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/security/totp"
+	"github.com/werdna521/userland/social"
+	"github.com/werdna521/userland/utils/clientinfo"
+	"github.com/werdna521/userland/utils/clock"
 	"github.com/werdna521/userland/utils/slice"
 )
 
+// number of wrong codes a single tfa challenge accepts before it is thrown away
+const maxTFAChallengeAttempts = 5
+
+// once the number of failed logins within the window reaches the threshold,
+// the account (or IP) gets locked out. every further failure doubles the
+// lockout, starting from baseLoginLockout, up to the max.
+const (
+	loginAttemptWindow     = 24 * time.Hour
+	userLoginAttemptsLimit = 5
+	userMaxLoginLockout    = time.Hour
+	ipLoginAttemptsLimit   = 20
+	ipMaxLoginLockout      = 6 * time.Hour
+	baseLoginLockout       = time.Minute
+)
+
 type AuthService interface {
-	Register(ctx context.Context, user *repository.User) e.Error
+	Register(ctx context.Context, user *repository.User, emailVerified bool) e.Error
 	SendEmailVerification(ctx context.Context, email string) e.Error
 	VerifyEmail(ctx context.Context, email string, token string) e.Error
-	Login(ctx context.Context, user *repository.User, clientID string) (*jwt.AccessToken, e.Error)
+	Login(
+		ctx context.Context,
+		user *repository.User,
+		clientID string,
+	) (*jwt.AccessToken, *repository.TFAChallenge, e.Error)
+	VerifyTFA(
+		ctx context.Context,
+		token string,
+		clientID string,
+		code string,
+	) (*jwt.AccessToken, e.Error)
+	VerifyTFARecoveryCode(
+		ctx context.Context,
+		token string,
+		clientID string,
+		recoveryCode string,
+	) (*jwt.AccessToken, e.Error)
 	ForgotPassword(ctx context.Context, email string) e.Error
 	ResetPassword(ctx context.Context, token string, newPassword string) e.Error
+	UnlockAccount(ctx context.Context, token string) e.Error
+	StartSocialLogin(ctx context.Context, provider string, clientID string) (string, e.Error)
+	CompleteSocialLogin(
+		ctx context.Context,
+		provider string,
+		state string,
+		code string,
+	) (*SocialLoginResult, e.Error)
+	LinkIdentity(ctx context.Context, userID string, provider string) (string, e.Error)
+	ListIdentities(ctx context.Context, userID string) ([]*repository.UserIdentity, e.Error)
+	UnlinkIdentity(ctx context.Context, userID string, provider string) e.Error
+	RequestMagicLink(ctx context.Context, email string, clientID string) e.Error
+	RedeemMagicLink(
+		ctx context.Context,
+		token string,
+		clientID string,
+	) (*jwt.AccessToken, *repository.TFAChallenge, e.Error)
+	BeginPasskeyRegistration(ctx context.Context, userID string) (*PasskeyChallenge, e.Error)
+	FinishPasskeyRegistration(
+		ctx context.Context,
+		userID string,
+		ceremonyID string,
+		nickname string,
+		credential []byte,
+	) (*repository.Passkey, e.Error)
+	BeginPasskeyLogin(ctx context.Context, clientID string) (*PasskeyChallenge, e.Error)
+	FinishPasskeyLogin(
+		ctx context.Context,
+		ceremonyID string,
+		credential []byte,
+	) (*jwt.AccessToken, e.Error)
+	ListPasskeys(ctx context.Context, userID string) ([]*repository.Passkey, e.Error)
+	RenamePasskey(ctx context.Context, userID string, id string, nickname string) e.Error
+	DeletePasskey(ctx context.Context, userID string, id string) e.Error
 }
 
 type BaseAuthService struct {
-	ur  postgres.UserRepository
-	phr postgres.PasswordHistoryRepository
-	tr  redis.TokenRepository
-	sr  redis.SessionRepository
-	m   mailer.Mailer
+	ur        postgres.UserRepository
+	phr       postgres.PasswordHistoryRepository
+	tfar      postgres.TFARepository
+	rcr       postgres.RecoveryCodeRepository
+	uir       postgres.UserIdentityRepository
+	pkr       postgres.PasskeyRepository
+	rr        postgres.RoleRepository
+	tr        redis.TokenRepository
+	sr        redis.SessionRepository
+	patr      postgres.PersonalAccessTokenRepository
+	lar       redis.LoginAttemptRepository
+	aer       postgres.AuditEventRepository
+	txm       postgres.TxManager
+	m         mailer.Mailer
+	hook      EventHook
+	policies  SessionPolicies
+	limits    SessionLimits
+	enc       security.Encrypter
+	providers map[string]social.Provider
+	wa        *webauthn.WebAuthn
+	clock     clock.Clock
+	cfg       *config.Config
 }
 
 func NewBaseAuthService(
 	ur postgres.UserRepository,
 	phr postgres.PasswordHistoryRepository,
+	tfar postgres.TFARepository,
+	rcr postgres.RecoveryCodeRepository,
+	uir postgres.UserIdentityRepository,
+	pkr postgres.PasskeyRepository,
+	rr postgres.RoleRepository,
 	tr redis.TokenRepository,
 	sr redis.SessionRepository,
+	patr postgres.PersonalAccessTokenRepository,
+	lar redis.LoginAttemptRepository,
+	aer postgres.AuditEventRepository,
+	txm postgres.TxManager,
 	m mailer.Mailer,
+	hook EventHook,
+	policies SessionPolicies,
+	limits SessionLimits,
+	enc security.Encrypter,
+	providers []social.Provider,
+	wa *webauthn.WebAuthn,
+	clock clock.Clock,
+	cfg *config.Config,
 ) *BaseAuthService {
+	providersByName := map[string]social.Provider{}
+	for _, p := range providers {
+		providersByName[p.Name()] = p
+	}
+
 	return &BaseAuthService{
-		ur:  ur,
-		phr: phr,
-		tr:  tr,
-		sr:  sr,
-		m:   m,
+		ur:        ur,
+		phr:       phr,
+		tfar:      tfar,
+		rcr:       rcr,
+		uir:       uir,
+		pkr:       pkr,
+		rr:        rr,
+		tr:        tr,
+		sr:        sr,
+		patr:      patr,
+		lar:       lar,
+		aer:       aer,
+		txm:       txm,
+		m:         m,
+		hook:      hook,
+		policies:  policies,
+		limits:    limits,
+		enc:       enc,
+		providers: providersByName,
+		wa:        wa,
+		clock:     clock,
+		cfg:       cfg,
 	}
 }
 
-func (s *BaseAuthService) Register(ctx context.Context, u *repository.User) e.Error {
+// Register creates the user. an emailVerified user is active right away and
+// gets no verification mail, it's for callers that proved the address already.
+func (s *BaseAuthService) Register(
+	ctx context.Context,
+	u *repository.User,
+	emailVerified bool,
+) e.Error {
 	log.Info().Msg("retrieving user from database")
 	_, err := s.ur.GetUserByEmail(ctx, u.Email)
 	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
@@ -68,54 +202,80 @@ func (s *BaseAuthService) Register(ctx context.Context, u *repository.User) e.Er
 	}
 	u.Password = hash
 
-	u.IsActive = false
-
-	log.Info().Msg("creating and registering user")
-	u, err = s.ur.CreateUser(ctx, u)
-	if _, ok := err.(repository.UniqueViolationError); ok {
-		log.Error().Err(err).Msg("user already exists")
-		return e.NewConflictError("user already exists")
+	u.IsActive = emailVerified
+
+	// the user, its password history and the verification mail are written in
+	// one transaction, so a user is never left behind without its mail
+	err = s.txm.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+
+		log.Info().Msg("creating and registering user")
+		u, err = s.ur.CreateUser(ctx, u)
+		if _, ok := err.(repository.UniqueViolationError); ok {
+			log.Error().Err(err).Msg("user already exists")
+			return e.NewConflictError("user already exists")
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("failed to create user")
+			return e.NewInternalServerError()
+		}
+
+		ph := &repository.PasswordHistory{
+			UserID:   u.ID,
+			Password: hash,
+		}
+
+		log.Info().Msg("adding password to history")
+		_, err = s.phr.CreatePasswordHistoryRecord(ctx, ph)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to add password to history")
+			return e.NewInternalServerError()
+		}
+
+		if emailVerified {
+			return nil
+		}
+
+		log.Info().Msg("generating verification token")
+		verificationToken := security.GenerateRandomID()
+
+		log.Info().Msg("storing email verification token")
+		err = s.tr.CreateEmailVerificationToken(ctx, u.ID, string(verificationToken))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to store email verification token")
+			return e.NewInternalServerError()
+		}
+
+		verificationLink := fmt.Sprintf(
+			"%s/api/v1/auth/verification?id=%s&token=%s",
+			s.cfg.Server.BaseURL,
+			u.ID,
+			verificationToken,
+		)
+
+		log.Debug().Msgf("verification link: %s", verificationLink)
+		log.Info().Msg("sending verification link")
+		email := mailer.Email{
+			Name:  u.UserBio.Fullname,
+			Email: u.Email,
+		}
+		err = mailer.SendEmailVerificationMail(ctx, s.m, email, mailLocale(ctx, u), verificationLink)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to send verification link")
+			return e.NewInternalServerError()
+		}
+
+		return nil
+	})
+	if txErr, ok := err.(e.Error); ok {
+		return txErr
 	}
 	if err != nil {
-		log.Error().Err(err).Msg("failed to create user")
+		log.Error().Err(err).Msg("failed to commit registration")
 		return e.NewInternalServerError()
 	}
 
-	ph := &repository.PasswordHistory{
-		UserID:   u.ID,
-		Password: hash,
-	}
-
-	log.Info().Msg("adding password to history")
-	s.phr.CreatePasswordHistoryRecord(ctx, ph)
-
-	log.Info().Msg("generating verification token")
-	verificationToken := security.GenerateRandomID()
-
-	log.Info().Msg("storing email verification token")
-	err = s.tr.CreateEmailVerificationToken(ctx, u.ID, string(verificationToken))
-	if err != nil {
-		log.Error().Err(err).Msg("failed to store email verification token")
-		return e.NewInternalServerError()
-	}
-
-	verificationLink := fmt.Sprintf(
-		"http://localhost:3000/api/v1/auth/verification?id=%s&token=%s",
-		u.ID,
-		verificationToken,
-	)
-
-	log.Debug().Msgf("verification link: %s", verificationLink)
-	log.Info().Msg("sending verification link")
-	email := mailer.Email{
-		Name:  u.UserBio.Fullname,
-		Email: u.Email,
-	}
-	err = mailer.SendEmailVerificationMail(ctx, s.m, email, verificationLink)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to send verification link")
-		return e.NewInternalServerError()
-	}
+	recordAudit(ctx, s.aer, repository.AuditRegistered, u.ID, nil)
 
 	return nil
 }
@@ -151,7 +311,8 @@ func (s *BaseAuthService) SendEmailVerification(
 	}
 
 	verificationLink := fmt.Sprintf(
-		"http://localhost:3000/api/v1/me/email/verification?id=%s&token=%s",
+		"%s/api/v1/me/email/verification?id=%s&token=%s",
+		s.cfg.Server.BaseURL,
 		u.ID,
 		verificationToken,
 	)
@@ -162,7 +323,7 @@ func (s *BaseAuthService) SendEmailVerification(
 		Name:  u.Email,
 		Email: u.Email,
 	}
-	err = mailer.SendEmailVerificationMail(ctx, s.m, em, verificationLink)
+	err = mailer.SendEmailVerificationMail(ctx, s.m, em, mailLocale(ctx, u), verificationLink)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to send verification link")
 		return e.NewInternalServerError()
@@ -208,6 +369,8 @@ func (s *BaseAuthService) VerifyEmail(
 		return e.NewInternalServerError()
 	}
 
+	recordAudit(ctx, s.aer, repository.AuditEmailVerified, userID, nil)
+
 	return nil
 }
 
@@ -215,71 +378,506 @@ func (s *BaseAuthService) Login(
 	ctx context.Context,
 	u *repository.User,
 	clientID string,
-) (*jwt.AccessToken, e.Error) {
+) (*jwt.AccessToken, *repository.TFAChallenge, e.Error) {
+	ip := clientinfo.FromContext(ctx).IP
+
+	if ip != "" {
+		log.Info().Msg("checking if ip is locked out")
+		ttl, err := s.lar.GetIPLockTTL(ctx, ip)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get ip lockout")
+			return nil, nil, e.NewInternalServerError()
+		}
+		if ttl > 0 {
+			log.Error().Msg("ip is locked out")
+			return nil, nil, e.NewTooManyRequestsError("too many failed login attempts, try again later", ttl)
+		}
+	}
+
 	log.Info().Msg("retrieving user from database")
 	userFromDB, err := s.ur.GetUserByEmail(ctx, u.Email)
 	if _, ok := err.(repository.NotFoundError); ok {
 		log.Error().Err(err).Msg("user not found")
-		return nil, e.NewNotFoundError("user not found")
+		recordAudit(ctx, s.aer, repository.AuditLoginFailed, "", map[string]interface{}{
+			"email":  u.Email,
+			"reason": "unknown_user",
+		})
+		err = s.recordFailedLogin(ctx, nil, ip)
+		if err != nil {
+			return nil, nil, e.NewInternalServerError()
+		}
+		return nil, nil, e.NewNotFoundError("user not found")
 	}
 	if err != nil {
 		log.Error().Err(err).Msg("failed to get user")
-		return nil, e.NewInternalServerError()
+		return nil, nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("checking if user is locked out")
+	ttl, err := s.lar.GetUserLockTTL(ctx, userFromDB.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user lockout")
+		return nil, nil, e.NewInternalServerError()
+	}
+	if ttl > 0 {
+		log.Error().Msg("user is locked out")
+		return nil, nil, e.NewTooManyRequestsError("account is temporarily locked, try again later", ttl)
 	}
 
 	log.Info().Msg("checking if user is active")
 	if !userFromDB.IsActive {
 		log.Error().Msg("user is not active")
-		return nil, e.NewForbiddenError("user is not active")
+		return nil, nil, e.NewForbiddenError("user is not active")
 	}
 
 	log.Info().Msg("checking if password is correct")
 	err = security.CheckPassword(u.Password, userFromDB.Password)
 	if err != nil {
 		log.Error().Err(err).Msg("password is incorrect")
-		return nil, e.NewUnauthorizedError("password is incorrect")
+		recordAudit(ctx, s.aer, repository.AuditLoginFailed, userFromDB.ID, map[string]interface{}{
+			"reason": "wrong_password",
+		})
+		err = s.recordFailedLogin(ctx, userFromDB, ip)
+		if err != nil {
+			return nil, nil, e.NewInternalServerError()
+		}
+		return nil, nil, e.NewUnauthorizedError("password is incorrect")
+	}
+
+	log.Info().Msg("resetting failed login attempts")
+	err = s.lar.ResetUserLoginAttempts(ctx, userFromDB.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to reset failed login attempts")
+		return nil, nil, e.NewInternalServerError()
+	}
+
+	return s.finishLogin(ctx, userFromDB.ID, clientID)
+}
+
+// finishLogin is the last step of every login method. it starts a session,
+// unless the user has tfa enabled, in which case a tfa challenge is returned
+// instead.
+func (s *BaseAuthService) finishLogin(
+	ctx context.Context,
+	userID string,
+	clientID string,
+) (*jwt.AccessToken, *repository.TFAChallenge, e.Error) {
+	log.Info().Msg("checking if tfa is enabled")
+	tfa, err := s.tfar.GetTFAByUserID(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+		log.Error().Err(err).Msg("failed to get tfa")
+		return nil, nil, e.NewInternalServerError()
+	}
+	if err == nil && tfa.IsEnabled {
+		log.Info().Msg("storing tfa challenge in redis")
+		c := &repository.TFAChallenge{
+			Token:    string(security.GenerateRandomID()),
+			UserID:   userID,
+			ClientID: clientID,
+		}
+		err = s.tr.CreateTFAChallenge(ctx, c)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to store tfa challenge")
+			return nil, nil, e.NewInternalServerError()
+		}
+
+		return nil, c, nil
+	}
+
+	at, sessionErr := s.createSession(ctx, userID, clientID)
+	if sessionErr != nil {
+		return nil, nil, sessionErr
+	}
+
+	return at, nil, nil
+}
+
+// recordFailedLogin counts a failed login against the user (if known) and the
+// source IP, and locks them out once they go over the limit
+func (s *BaseAuthService) recordFailedLogin(
+	ctx context.Context,
+	u *repository.User,
+	ip string,
+) error {
+	if ip != "" {
+		log.Info().Msg("counting failed login attempts of the ip")
+		attempts, err := s.lar.IncrementIPLoginAttempts(ctx, ip, loginAttemptWindow)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to count failed login attempts of the ip")
+			return err
+		}
+
+		if attempts >= ipLoginAttemptsLimit {
+			d := loginLockoutDuration(attempts, ipLoginAttemptsLimit, ipMaxLoginLockout)
+
+			log.Info().Msgf("locking ip out for %s", d)
+			err = s.lar.LockIP(ctx, ip, d)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to lock ip out")
+				return err
+			}
+		}
+	}
+
+	if u == nil {
+		return nil
+	}
+
+	log.Info().Msg("counting failed login attempts of the user")
+	attempts, err := s.lar.IncrementUserLoginAttempts(ctx, u.ID, loginAttemptWindow)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count failed login attempts of the user")
+		return err
+	}
+
+	if attempts < userLoginAttemptsLimit {
+		return nil
+	}
+
+	d := loginLockoutDuration(attempts, userLoginAttemptsLimit, userMaxLoginLockout)
+
+	log.Info().Msgf("locking user out for %s", d)
+	err = s.lar.LockUser(ctx, u.ID, d)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to lock user out")
+		return err
+	}
+	recordAudit(ctx, s.aer, repository.AuditAccountLocked, u.ID, map[string]interface{}{
+		"failed_attempts": attempts,
+		"duration":        d.String(),
+	})
+
+	// only let the user know the first time, we don't want to flood their inbox
+	// while someone keeps on guessing
+	if attempts > userLoginAttemptsLimit {
+		return nil
+	}
+
+	log.Info().Msg("generating account unlock token")
+	token := security.GenerateRandomID()
+
+	log.Info().Msg("storing account unlock token")
+	err = s.tr.CreateAccountUnlockToken(ctx, u.ID, string(token))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store account unlock token")
+		return err
+	}
+
+	unlockLink := fmt.Sprintf(
+		"%s/api/v1/auth/unlock?token=%s",
+		s.cfg.Server.BaseURL,
+		token,
+	)
+
+	log.Debug().Msgf("unlock link: %s", unlockLink)
+	log.Info().Msg("sending account locked mail")
+	em := mailer.Email{
+		Name:  u.Email,
+		Email: u.Email,
+	}
+	err = mailer.SendAccountLockedMail(ctx, s.m, em, mailLocale(ctx, u), unlockLink)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to send account locked mail")
+		return err
+	}
+
+	return nil
+}
+
+func loginLockoutDuration(attempts int64, limit int64, max time.Duration) time.Duration {
+	// the shift is capped so it can't overflow
+	exp := attempts - limit
+	if exp > 16 {
+		return max
+	}
+
+	d := baseLoginLockout << exp
+	if d > max {
+		return max
+	}
+	return d
+}
+
+func (s *BaseAuthService) UnlockAccount(ctx context.Context, token string) e.Error {
+	log.Info().Msg("retrieving account unlock token from redis")
+	userID, err := s.tr.GetAccountUnlockToken(ctx, token)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("account unlock token not found")
+		return e.NewUnauthorizedError("invalid token")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve account unlock token")
+		return e.NewInternalServerError()
+	}
+
+	log.Info().Msg("resetting failed login attempts")
+	err = s.lar.ResetUserLoginAttempts(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to reset failed login attempts")
+		return e.NewInternalServerError()
+	}
+
+	log.Info().Msg("removing account unlock token")
+	err = s.tr.DeleteAccountUnlockToken(ctx, token)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to remove account unlock token")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditAccountUnlocked, userID, nil)
+
+	return nil
+}
+
+func (s *BaseAuthService) VerifyTFA(
+	ctx context.Context,
+	token string,
+	clientID string,
+	code string,
+) (*jwt.AccessToken, e.Error) {
+	c, challengeErr := s.checkTFAChallenge(ctx, token, clientID)
+	if challengeErr != nil {
+		return nil, challengeErr
 	}
 
-	log.Info().Msg("generating session ID")
-	sessionID := security.GenerateRandomID()
+	log.Info().Msg("retrieving tfa secret from database")
+	tfa, err := s.tfar.GetTFAByUserID(ctx, c.UserID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("tfa is no longer set up")
+		return nil, e.NewUnauthorizedError("invalid tfa token")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get tfa")
+		return nil, e.NewInternalServerError()
+	}
 
-	log.Info().Msg("generating access token")
-	at, err := jwt.CreateAccessToken(userFromDB.ID, string(sessionID))
+	log.Info().Msg("decrypting tfa secret")
+	secret, err := s.enc.Decrypt(tfa.Secret)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to generate access token")
+		log.Error().Err(err).Msg("failed to decrypt tfa secret")
 		return nil, e.NewInternalServerError()
 	}
 
-	log.Info().Msg("storing access token in redis")
-	token := &repository.AccessToken{
-		ID:        at.JTI,
-		UserID:    at.UserID,
-		SessionID: at.SessionID,
+	log.Info().Msg("checking tfa code")
+	ok, err := useTFACode(ctx, s.tfar, tfa, secret, code, s.clock.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("failed to use tfa code")
+		return nil, e.NewInternalServerError()
+	}
+	if !ok {
+		log.Error().Msg("tfa code is incorrect")
+		recordAudit(ctx, s.aer, repository.AuditTFAChallengeFailed, c.UserID, map[string]interface{}{
+			"method": "totp",
+		})
+		return nil, e.NewUnauthorizedError("invalid tfa code")
+	}
+
+	return s.completeTFAChallenge(ctx, c)
+}
+
+// useTFACode checks code against the decrypted secret of tfa and uses its time
+// step up, so that a code can't be replayed while it is still valid
+func useTFACode(
+	ctx context.Context,
+	tfar postgres.TFARepository,
+	tfa *repository.UserTFA,
+	secret string,
+	code string,
+	now time.Time,
+) (bool, error) {
+	step, ok := totp.Validate(secret, code, now)
+	if !ok || step <= tfa.LastUsedStep {
+		return false, nil
+	}
+
+	err := tfar.UseTFAStep(ctx, tfa.UserID, step)
+	if _, ok := err.(repository.NotFoundError); ok {
+		return false, nil
 	}
-	err = s.sr.CreateAccessToken(ctx, token, jwt.AccessTokenLife)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to store access token")
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *BaseAuthService) VerifyTFARecoveryCode(
+	ctx context.Context,
+	token string,
+	clientID string,
+	recoveryCode string,
+) (*jwt.AccessToken, e.Error) {
+	c, challengeErr := s.checkTFAChallenge(ctx, token, clientID)
+	if challengeErr != nil {
+		return nil, challengeErr
+	}
+
+	log.Info().Msg("retrieving unused recovery codes from database")
+	codes, err := s.rcr.GetUnusedRecoveryCodes(ctx, c.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get unused recovery codes")
 		return nil, e.NewInternalServerError()
 	}
 
-	log.Info().Msg("storing session in redis")
-	session := &repository.Session{
-		ID:     at.SessionID,
-		Client: clientID,
-		UserID: at.UserID,
+	log.Info().Msg("checking recovery code")
+	var matched *repository.RecoveryCode
+	for _, rc := range codes {
+		if security.CheckPassword(recoveryCode, rc.Code) == nil {
+			matched = rc
+			break
+		}
+	}
+	if matched == nil {
+		log.Error().Msg("recovery code is incorrect")
+		recordAudit(ctx, s.aer, repository.AuditTFAChallengeFailed, c.UserID, map[string]interface{}{
+			"method": "recovery_code",
+		})
+		return nil, e.NewUnauthorizedError("invalid recovery code")
+	}
+
+	log.Info().Msg("marking recovery code as used")
+	err = s.rcr.MarkRecoveryCodeAsUsed(ctx, matched.ID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("recovery code was used concurrently")
+		return nil, e.NewUnauthorizedError("invalid recovery code")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to mark recovery code as used")
+		return nil, e.NewInternalServerError()
+	}
+	recordAudit(ctx, s.aer, repository.AuditRecoveryCodeUsed, c.UserID, map[string]interface{}{
+		"remaining": len(codes) - 1,
+	})
+
+	log.Info().Msg("retrieving user from database")
+	u, err := s.ur.GetUserByID(ctx, c.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user")
+		return nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("sending recovery code used mail")
+	em := mailer.Email{
+		Name:  u.Email,
+		Email: u.Email,
+	}
+	err = mailer.SendRecoveryCodeUsedMail(ctx, s.m, em, mailLocale(ctx, u), len(codes)-1)
+	if err != nil {
+		// the code is already burnt at this point, failing the login would only
+		// cost the user another code
+		log.Error().Err(err).Msg("failed to send recovery code used mail")
+	}
+
+	return s.completeTFAChallenge(ctx, c)
+}
+
+// checkTFAChallenge makes sure the challenge exists, belongs to the client and
+// hasn't run out of attempts
+func (s *BaseAuthService) checkTFAChallenge(
+	ctx context.Context,
+	token string,
+	clientID string,
+) (*repository.TFAChallenge, e.Error) {
+	log.Info().Msg("retrieving tfa challenge from redis")
+	c, err := s.tr.GetTFAChallenge(ctx, token)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("tfa challenge not found")
+		return nil, e.NewUnauthorizedError("invalid tfa token")
 	}
-	err = s.sr.CreateSession(ctx, session, jwt.AccessTokenLife)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to store session in redis")
+		log.Error().Err(err).Msg("failed to retrieve tfa challenge")
 		return nil, e.NewInternalServerError()
 	}
 
-	log.Info().Msg("adding the session id to a user session index set")
-	err = s.sr.AddUserSessionToIndex(ctx, session)
+	log.Info().Msg("checking client id")
+	if c.ClientID != clientID {
+		log.Error().Msg("tfa challenge was issued to another client")
+		return nil, e.NewUnauthorizedError("invalid tfa token")
+	}
+
+	log.Info().Msg("counting tfa challenge attempts")
+	attempts, err := s.tr.IncrementTFAChallengeAttempts(ctx, token)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to add the session id to the index set")
+		log.Error().Err(err).Msg("failed to count tfa challenge attempts")
 		return nil, e.NewInternalServerError()
 	}
+	if attempts > maxTFAChallengeAttempts {
+		log.Error().Msg("too many tfa challenge attempts")
+		err = s.tr.DeleteTFAChallenge(ctx, token)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to remove tfa challenge")
+		}
+		return nil, e.NewUnauthorizedError("too many attempts, please login again")
+	}
+
+	return c, nil
+}
+
+func (s *BaseAuthService) completeTFAChallenge(
+	ctx context.Context,
+	c *repository.TFAChallenge,
+) (*jwt.AccessToken, e.Error) {
+	log.Info().Msg("removing tfa challenge from redis")
+	err := s.tr.DeleteTFAChallenge(ctx, c.Token)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to remove tfa challenge")
+		return nil, e.NewInternalServerError()
+	}
+
+	at, sessionErr := s.createSession(ctx, c.UserID, c.ClientID)
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+
+	return at, nil
+}
+
+// createSession starts a new session for an already authenticated user, as
+// long as an admin hasn't deactivated them
+func (s *BaseAuthService) createSession(
+	ctx context.Context,
+	userID string,
+	clientID string,
+) (*jwt.AccessToken, e.Error) {
+	log.Info().Msg("retrieving user from database")
+	u, err := s.ur.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user")
+		return nil, e.NewInternalServerError()
+	}
+
+	// deleted users still have their passkeys and linked identities
+	log.Info().Msg("checking if user is deleted")
+	if u.DeletedAt.Valid {
+		log.Error().Msg("user is deleted")
+		return nil, e.NewNotFoundError("user not found")
+	}
+
+	log.Info().Msg("checking if user is deactivated")
+	if u.DeactivatedAt.Valid {
+		log.Error().Msg("user is deactivated")
+		return nil, e.NewForbiddenError("account is deactivated")
+	}
+
+	session := &repository.Session{
+		UserID: userID,
+		Client: clientID,
+	}
+	at, err := startSession(ctx, s.sr, s.rr, s.limits, s.cfg.Tokens, session)
+	if err == errTooManySessions {
+		return nil, e.NewConflictError("too many active sessions")
+	}
+	if err != nil {
+		return nil, e.NewInternalServerError()
+	}
+
+	// the user is the actor of their own login, from the new session onwards
+	ctx = clientinfo.WithSession(ctx, userID, session.ID)
+	recordAudit(ctx, s.aer, repository.AuditLoginSucceeded, userID, map[string]interface{}{
+		"client_id": clientID,
+	})
+
+	s.hook.OnEvent(ctx, newEvent(ctx, EventNewLogin, u, s.clock.Now()))
 
 	return at, nil
 }
@@ -318,12 +916,14 @@ func (s *BaseAuthService) ForgotPassword(ctx context.Context, email string) e.Er
 		Name:  email,
 		Email: email,
 	}
-	err = mailer.SendPasswordResetMail(ctx, s.m, em, string(token))
+	err = mailer.SendPasswordResetMail(ctx, s.m, em, mailLocale(ctx, u), string(token))
 	if err != nil {
 		log.Error().Err(err).Msg("failed to send password reset mail")
 		return e.NewInternalServerError()
 	}
 
+	recordAudit(ctx, s.aer, repository.AuditPasswordResetRequested, u.ID, nil)
+
 	return nil
 }
 
@@ -343,7 +943,7 @@ func (s *BaseAuthService) ResetPassword(
 	}
 
 	log.Info().Msg("retrieving last 3 password hash from db")
-	hashes, err := s.phr.GetLastNPasswordHashes(ctx, userID, 3)
+	hashes, err := s.phr.GetLastNPasswordHashes(ctx, userID, s.cfg.Password.History)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to get the password hashes")
 		return e.NewInternalServerError()
@@ -401,5 +1001,23 @@ func (s *BaseAuthService) ResetPassword(
 		return e.NewInternalServerError()
 	}
 
+	err = applySessionPolicy(
+		ctx,
+		s.sr,
+		s.patr,
+		u.ID,
+		s.policies.OnPasswordReset,
+		s.clock.Now(),
+		s.cfg.Tokens.RefreshToken,
+	)
+	if err != nil {
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditPasswordReset, u.ID, map[string]interface{}{
+		"sessions": s.policies.OnPasswordReset,
+	})
+	s.hook.OnEvent(ctx, newEvent(ctx, EventPasswordChanged, u, s.clock.Now()))
+
 	return nil
 }
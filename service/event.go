@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/werdna521/userland/config"
+	"github.com/werdna521/userland/mailer"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/redis"
+	"github.com/werdna521/userland/security"
+	"github.com/werdna521/userland/utils/clientinfo"
+)
+
+// sensitive account events services report to their EventHook
+const (
+	EventPasswordChanged = "password_changed"
+	EventEmailChanged    = "email_changed"
+	EventNewLogin        = "new_login"
+	EventAccountDeleted  = "account_deleted"
+)
+
+// Event is something that happened to an account that its owner should know
+// about
+type Event struct {
+	Type string
+	User *repository.User
+	Time time.Time
+	// where the request that caused the event came from
+	IP        string
+	UserAgent string
+	// OldEmail is only set for EventEmailChanged, User already has the new one
+	OldEmail string
+}
+
+// EventHook is told about every Event after it happened. it can't fail the
+// operation that caused the event, so it handles its own errors.
+type EventHook interface {
+	OnEvent(ctx context.Context, ev *Event)
+}
+
+func newEvent(ctx context.Context, eventType string, u *repository.User, now time.Time) *Event {
+	ci := clientinfo.FromContext(ctx)
+
+	return &Event{
+		Type:      eventType,
+		User:      u,
+		Time:      now,
+		IP:        ci.IP,
+		UserAgent: ci.UserAgent,
+	}
+}
+
+// the link undoes the change straight away, there's nothing for the client
+// app to do
+const emailChangeRevertURL = "%s/api/v1/me/email/revert?token=%s"
+
+// MailEventHook notifies the user of every event by mail
+type MailEventHook struct {
+	tr  redis.TokenRepository
+	m   mailer.Mailer
+	cfg *config.Config
+}
+
+func NewMailEventHook(
+	tr redis.TokenRepository,
+	m mailer.Mailer,
+	cfg *config.Config,
+) *MailEventHook {
+	return &MailEventHook{
+		tr:  tr,
+		m:   m,
+		cfg: cfg,
+	}
+}
+
+func (h *MailEventHook) OnEvent(ctx context.Context, ev *Event) {
+	em := mailer.Email{
+		Name:  ev.User.Email,
+		Email: ev.User.Email,
+	}
+	locale := mailLocale(ctx, ev.User)
+
+	var err error
+	switch ev.Type {
+	case EventPasswordChanged:
+		log.Info().Msg("sending password changed mail")
+		err = mailer.SendPasswordChangedMail(ctx, h.m, em, locale, ev.Time)
+	case EventNewLogin:
+		log.Info().Msg("sending new login mail")
+		err = mailer.SendNewLoginMail(ctx, h.m, em, locale, ev.Time, ev.IP, ev.UserAgent)
+	case EventAccountDeleted:
+		log.Info().Msg("sending account deleted mail")
+		err = mailer.SendAccountDeletedMail(ctx, h.m, em, locale)
+	case EventEmailChanged:
+		err = h.sendEmailChangedMail(ctx, ev, locale)
+	default:
+		log.Warn().Msgf("no mail for %s events", ev.Type)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to send %s mail", ev.Type)
+	}
+}
+
+// sendEmailChangedMail tells the old address about the change, along with a
+// link to revert it in case the account was taken over
+func (h *MailEventHook) sendEmailChangedMail(
+	ctx context.Context,
+	ev *Event,
+	locale string,
+) error {
+	log.Info().Msg("storing email change revert token")
+	t := &repository.EmailChangeRevertToken{
+		Token:    string(security.GenerateRandomID()),
+		UserID:   ev.User.ID,
+		OldEmail: ev.OldEmail,
+	}
+	err := h.tr.CreateEmailChangeRevertToken(ctx, t)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf(emailChangeRevertURL, h.cfg.Server.BaseURL, t.Token)
+
+	log.Debug().Msgf("email change revert link: %s", link)
+	log.Info().Msg("sending email changed mail")
+	em := mailer.Email{
+		Name:  ev.OldEmail,
+		Email: ev.OldEmail,
+	}
+	return mailer.SendEmailChangedMail(
+		ctx,
+		h.m,
+		em,
+		locale,
+		ev.User.Email,
+		link,
+		h.cfg.Tokens.EmailChangeRevert,
+		ev.Time,
+	)
+}
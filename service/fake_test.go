@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/postgres"
+	"github.com/werdna521/userland/repository/redis"
+	"github.com/werdna521/userland/utils/clock"
+)
+
+// fakeTFAChallenge is a tfa challenge along with what redis keeps next to it
+type fakeTFAChallenge struct {
+	c         *repository.TFAChallenge
+	attempts  int64
+	expiresAt time.Time
+}
+
+type fakeTokenRepository struct {
+	redis.TokenRepository
+	states map[string]*repository.SocialLoginState
+
+	// tfa challenges expire ttl after being created, according to clock
+	clock      clock.Clock
+	ttl        time.Duration
+	challenges map[string]*fakeTFAChallenge
+}
+
+func (r *fakeTokenRepository) CreateSocialLoginState(
+	ctx context.Context,
+	s *repository.SocialLoginState,
+) error {
+	r.states[s.State] = s
+	return nil
+}
+
+func (r *fakeTokenRepository) ConsumeSocialLoginState(
+	ctx context.Context,
+	state string,
+) (*repository.SocialLoginState, error) {
+	s, ok := r.states[state]
+	if !ok {
+		return nil, repository.NewNotFoundError()
+	}
+	delete(r.states, state)
+	return s, nil
+}
+
+func (r *fakeTokenRepository) CreateTFAChallenge(ctx context.Context, c *repository.TFAChallenge) error {
+	r.challenges[c.Token] = &fakeTFAChallenge{
+		c:         c,
+		expiresAt: r.clock.Now().Add(r.ttl),
+	}
+	return nil
+}
+
+func (r *fakeTokenRepository) getTFAChallenge(token string) (*fakeTFAChallenge, bool) {
+	fc, ok := r.challenges[token]
+	if !ok || !r.clock.Now().Before(fc.expiresAt) {
+		return nil, false
+	}
+	return fc, true
+}
+
+func (r *fakeTokenRepository) GetTFAChallenge(
+	ctx context.Context,
+	token string,
+) (*repository.TFAChallenge, error) {
+	fc, ok := r.getTFAChallenge(token)
+	if !ok {
+		return nil, repository.NewNotFoundError()
+	}
+	return fc.c, nil
+}
+
+func (r *fakeTokenRepository) IncrementTFAChallengeAttempts(ctx context.Context, token string) (int64, error) {
+	fc, ok := r.getTFAChallenge(token)
+	if !ok {
+		return 0, repository.NewNotFoundError()
+	}
+	fc.attempts++
+	return fc.attempts, nil
+}
+
+func (r *fakeTokenRepository) DeleteTFAChallenge(ctx context.Context, token string) error {
+	delete(r.challenges, token)
+	return nil
+}
+
+type fakeUserRepository struct {
+	postgres.UserRepository
+	users map[string]*repository.User
+}
+
+func (r *fakeUserRepository) CreateUser(
+	ctx context.Context,
+	u *repository.User,
+) (*repository.User, error) {
+	u.ID = fmt.Sprintf("user-%d", len(r.users)+1)
+	r.users[u.ID] = u
+	return u, nil
+}
+
+func (r *fakeUserRepository) GetUserByID(ctx context.Context, userID string) (*repository.User, error) {
+	u, ok := r.users[userID]
+	if !ok {
+		return nil, repository.NewNotFoundError()
+	}
+	return u, nil
+}
+
+func (r *fakeUserRepository) GetUserByEmail(ctx context.Context, email string) (*repository.User, error) {
+	for _, u := range r.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, repository.NewNotFoundError()
+}
+
+type fakeUserIdentityRepository struct {
+	postgres.UserIdentityRepository
+	identities []*repository.UserIdentity
+}
+
+func (r *fakeUserIdentityRepository) CreateUserIdentity(
+	ctx context.Context,
+	i *repository.UserIdentity,
+) (*repository.UserIdentity, error) {
+	i.ID = fmt.Sprintf("identity-%d", len(r.identities)+1)
+	r.identities = append(r.identities, i)
+	return i, nil
+}
+
+func (r *fakeUserIdentityRepository) GetUserIdentity(
+	ctx context.Context,
+	provider string,
+	subject string,
+) (*repository.UserIdentity, error) {
+	for _, i := range r.identities {
+		if i.Provider == provider && i.Subject == subject {
+			return i, nil
+		}
+	}
+	return nil, repository.NewNotFoundError()
+}
+
+type fakeTFARepository struct {
+	postgres.TFARepository
+	tfas map[string]*repository.UserTFA
+}
+
+func (r *fakeTFARepository) GetTFAByUserID(ctx context.Context, userID string) (*repository.UserTFA, error) {
+	t, ok := r.tfas[userID]
+	if !ok {
+		return nil, repository.NewNotFoundError()
+	}
+	// every read gets its own row, like it would from the database
+	row := *t
+	return &row, nil
+}
+
+func (r *fakeTFARepository) UseTFAStep(ctx context.Context, userID string, step int64) error {
+	t, ok := r.tfas[userID]
+	if !ok || t.LastUsedStep >= step {
+		return repository.NewNotFoundError()
+	}
+	t.LastUsedStep = step
+	return nil
+}
+
+type fakeLoginAttemptRepository struct {
+	redis.LoginAttemptRepository
+}
+
+func (r *fakeLoginAttemptRepository) GetIPLockTTL(ctx context.Context, ip string) (time.Duration, error) {
+	return 0, nil
+}
+
+func (r *fakeLoginAttemptRepository) GetUserLockTTL(ctx context.Context, userID string) (time.Duration, error) {
+	return 0, nil
+}
+
+func (r *fakeLoginAttemptRepository) ResetUserLoginAttempts(ctx context.Context, userID string) error {
+	return nil
+}
+
+type fakeRoleRepository struct {
+	postgres.RoleRepository
+}
+
+func (r *fakeRoleRepository) GetRolesByUserID(ctx context.Context, userID string) ([]string, error) {
+	return []string{}, nil
+}
+
+type fakeAuditEventRepository struct {
+	postgres.AuditEventRepository
+}
+
+func (r *fakeAuditEventRepository) CreateAuditEvent(ctx context.Context, ae *repository.AuditEvent) error {
+	return nil
+}
+
+type fakeTxManager struct{}
+
+func (m *fakeTxManager) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+type fakeSessionRepository struct {
+	redis.SessionRepository
+	sessions map[string]*repository.Session
+}
+
+func (r *fakeSessionRepository) CreateAccessToken(
+	ctx context.Context,
+	at *repository.AccessToken,
+	expiresIn time.Duration,
+) error {
+	return nil
+}
+
+func (r *fakeSessionRepository) CreateSession(
+	ctx context.Context,
+	s *repository.Session,
+	expiresIn time.Duration,
+) error {
+	r.sessions[s.ID] = s
+	return nil
+}
+
+func (r *fakeSessionRepository) AddUserSessionToIndex(
+	ctx context.Context,
+	s *repository.Session,
+	expiresIn time.Duration,
+) error {
+	return nil
+}
+
+type noopEventHook struct{}
+
+func (h noopEventHook) OnEvent(ctx context.Context, ev *Event) {}
@@ -0,0 +1,487 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/mailer"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/security"
+	"github.com/werdna521/userland/security/jwt"
+)
+
+// the link opens the client app, which shows the invitation and posts the
+// token to the accept endpoint, along with the details of a new account if
+// the invitee doesn't have one
+const invitationURL = "%s/invitations/accept?token=%s"
+
+// InvitationDetail is what the invitee sees before accepting, HasAccount tells
+// whether they need to sign up on the way
+type InvitationDetail struct {
+	Invitation *repository.Invitation
+	HasAccount bool
+}
+
+// requireInvitationRole checks that the member can invite people with the
+// role, the same rules as adding members apply
+func requireInvitationRole(m *repository.Membership, role string) e.Error {
+	if err := requireOrgRole(m, repository.OrgRoleOwner, repository.OrgRoleAdmin); err != nil {
+		return err
+	}
+	if role == repository.OrgRoleOwner {
+		return requireOrgRole(m, repository.OrgRoleOwner)
+	}
+
+	return nil
+}
+
+// getPendingInvitation gets an invitation of the organization that was neither
+// accepted nor revoked
+func (s *BaseOrganizationService) getPendingInvitation(
+	ctx context.Context,
+	orgID string,
+	invitationID string,
+) (*repository.Invitation, e.Error) {
+	log.Info().Msg("getting invitation from database")
+	i, err := s.ivr.GetInvitationByID(ctx, invitationID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("invitation not found")
+		return nil, e.NewNotFoundError("invitation not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get invitation from database")
+		return nil, e.NewInternalServerError()
+	}
+
+	if i.OrganizationID != orgID || i.AcceptedAt.Valid || i.RevokedAt.Valid {
+		log.Error().Msg("invitation is not pending in the organization")
+		return nil, e.NewNotFoundError("invitation not found")
+	}
+
+	return i, nil
+}
+
+// sendInvitation mails a link with a token for the current token id of the
+// invitation
+func (s *BaseOrganizationService) sendInvitation(
+	ctx context.Context,
+	i *repository.Invitation,
+	inviterID string,
+) e.Error {
+	log.Info().Msg("getting organization from database")
+	o, err := s.or.GetOrganizationByID(ctx, i.OrganizationID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get organization from database")
+		return e.NewInternalServerError()
+	}
+
+	log.Info().Msg("getting inviter bio from database")
+	bio, err := s.ur.GetUserBioByID(ctx, inviterID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get inviter bio from database")
+		return e.NewInternalServerError()
+	}
+
+	// the invitee may not have an account with a locale yet, in which case
+	// the mail is in the language of the inviter
+	locale := mailLocale(ctx, &repository.User{})
+	u, err := s.ur.GetUserByEmail(ctx, i.Email)
+	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+		log.Error().Err(err).Msg("failed to get invitee from database")
+		return e.NewInternalServerError()
+	}
+	if err == nil {
+		locale = mailLocale(ctx, u)
+	}
+
+	t, err := jwt.CreateInvitationToken(i.ID, i.TokenID, i.ExpiresAt)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create invitation token")
+		return e.NewInternalServerError()
+	}
+
+	link := fmt.Sprintf(invitationURL, s.cfg.Server.BaseURL, t.Value)
+
+	log.Debug().Msgf("invitation link: %s", link)
+	log.Info().Msg("sending invitation mail")
+	em := mailer.Email{
+		Name:  i.Email,
+		Email: i.Email,
+	}
+	err = mailer.SendInvitationMail(
+		ctx,
+		s.m,
+		em,
+		locale,
+		o.Name,
+		bio.Fullname,
+		link,
+		s.cfg.Tokens.Invitation,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to send invitation mail")
+		return e.NewInternalServerError()
+	}
+
+	return nil
+}
+
+// CreateInvitation invites the email into the organization. the invitation and
+// its mail are written in one transaction.
+func (s *BaseOrganizationService) CreateInvitation(
+	ctx context.Context,
+	userID string,
+	orgID string,
+	email string,
+	role string,
+) (*repository.Invitation, e.Error) {
+	m, mErr := s.getMembership(ctx, orgID, userID)
+	if mErr != nil {
+		return nil, mErr
+	}
+	if err := requireInvitationRole(m, role); err != nil {
+		return nil, err
+	}
+
+	log.Info().Msg("retrieving user from database")
+	u, err := s.ur.GetUserByEmail(ctx, email)
+	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return nil, e.NewInternalServerError()
+	}
+	if err == nil {
+		log.Info().Msg("checking membership")
+		_, err = s.or.GetMembership(ctx, orgID, u.ID)
+		if err == nil {
+			log.Error().Msg("user is a member already")
+			return nil, e.NewConflictError("user is a member already")
+		}
+		if _, ok := err.(repository.NotFoundError); !ok {
+			log.Error().Err(err).Msg("failed to get membership")
+			return nil, e.NewInternalServerError()
+		}
+	}
+
+	now := s.clock.Now()
+	i := &repository.Invitation{
+		OrganizationID: orgID,
+		Email:          email,
+		Role:           role,
+		TokenID:        string(security.GenerateRandomID()),
+		InvitedBy:      sql.NullString{String: userID, Valid: true},
+		ExpiresAt:      now.Add(s.cfg.Tokens.Invitation),
+	}
+
+	err = s.txm.WithTx(ctx, func(ctx context.Context) error {
+		log.Info().Msg("checking for a pending invitation")
+		pending, err := s.ivr.GetPendingInvitationByEmail(ctx, orgID, email)
+		if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+			log.Error().Err(err).Msg("failed to get pending invitation")
+			return e.NewInternalServerError()
+		}
+		if err == nil {
+			if pending.IsPending(now) {
+				log.Error().Msg("email is invited already")
+				return e.NewConflictError("email is invited already")
+			}
+
+			// an expired invitation makes way for the new one
+			log.Info().Msg("revoking expired invitation")
+			err = s.ivr.RevokeInvitation(ctx, pending.ID)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to revoke expired invitation")
+				return e.NewInternalServerError()
+			}
+		}
+
+		log.Info().Msg("creating invitation")
+		_, err = s.ivr.CreateInvitation(ctx, i)
+		if _, ok := err.(repository.UniqueViolationError); ok {
+			return e.NewConflictError("email is invited already")
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("failed to create invitation")
+			return e.NewInternalServerError()
+		}
+
+		return s.sendInvitation(ctx, i, userID)
+	})
+	if txErr, ok := err.(e.Error); ok {
+		return nil, txErr
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to commit invitation")
+		return nil, e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditInvitationSent, userID, map[string]interface{}{
+		"organization_id": orgID,
+		"invitation_id":   i.ID,
+		"email":           email,
+		"role":            role,
+	})
+
+	return i, nil
+}
+
+// ListInvitations lists the invitations of the organization that were neither
+// accepted nor revoked, expired ones included
+func (s *BaseOrganizationService) ListInvitations(
+	ctx context.Context,
+	userID string,
+	orgID string,
+) ([]*repository.Invitation, e.Error) {
+	m, mErr := s.getMembership(ctx, orgID, userID)
+	if mErr != nil {
+		return nil, mErr
+	}
+	if err := requireOrgRole(m, repository.OrgRoleOwner, repository.OrgRoleAdmin); err != nil {
+		return nil, err
+	}
+
+	log.Info().Msg("listing invitations")
+	invitations, err := s.ivr.ListPendingInvitationsByOrganizationID(ctx, orgID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list invitations")
+		return nil, e.NewInternalServerError()
+	}
+
+	return invitations, nil
+}
+
+// ResendInvitation sends the invitation again with a new link and a new
+// expiry, the links sent before stop working
+func (s *BaseOrganizationService) ResendInvitation(
+	ctx context.Context,
+	userID string,
+	orgID string,
+	invitationID string,
+) e.Error {
+	m, mErr := s.getMembership(ctx, orgID, userID)
+	if mErr != nil {
+		return mErr
+	}
+	i, iErr := s.getPendingInvitation(ctx, orgID, invitationID)
+	if iErr != nil {
+		return iErr
+	}
+	if err := requireInvitationRole(m, i.Role); err != nil {
+		return err
+	}
+
+	i.TokenID = string(security.GenerateRandomID())
+	i.ExpiresAt = s.clock.Now().Add(s.cfg.Tokens.Invitation)
+
+	err := s.txm.WithTx(ctx, func(ctx context.Context) error {
+		log.Info().Msg("renewing invitation")
+		err := s.ivr.RenewInvitation(ctx, i.ID, i.TokenID, i.ExpiresAt)
+		if _, ok := err.(repository.NotFoundError); ok {
+			log.Error().Err(err).Msg("invitation is no longer pending")
+			return e.NewNotFoundError("invitation not found")
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("failed to renew invitation")
+			return e.NewInternalServerError()
+		}
+
+		return s.sendInvitation(ctx, i, userID)
+	})
+	if txErr, ok := err.(e.Error); ok {
+		return txErr
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to commit invitation")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditInvitationResent, userID, map[string]interface{}{
+		"organization_id": orgID,
+		"invitation_id":   i.ID,
+	})
+
+	return nil
+}
+
+func (s *BaseOrganizationService) RevokeInvitation(
+	ctx context.Context,
+	userID string,
+	orgID string,
+	invitationID string,
+) e.Error {
+	m, mErr := s.getMembership(ctx, orgID, userID)
+	if mErr != nil {
+		return mErr
+	}
+	i, iErr := s.getPendingInvitation(ctx, orgID, invitationID)
+	if iErr != nil {
+		return iErr
+	}
+	if err := requireInvitationRole(m, i.Role); err != nil {
+		return err
+	}
+
+	log.Info().Msg("revoking invitation")
+	err := s.ivr.RevokeInvitation(ctx, i.ID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("invitation is no longer pending")
+		return e.NewNotFoundError("invitation not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to revoke invitation")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditInvitationRevoked, userID, map[string]interface{}{
+		"organization_id": orgID,
+		"invitation_id":   i.ID,
+	})
+
+	return nil
+}
+
+// parseInvitation gets the invitation the token was sent for, as long as it
+// can still be accepted with that token
+func (s *BaseOrganizationService) parseInvitation(
+	ctx context.Context,
+	token string,
+) (*repository.Invitation, e.Error) {
+	log.Info().Msg("parsing invitation token")
+	t, ok, err := jwt.ParseInvitationToken(token)
+	if err != nil || !ok {
+		log.Error().Err(err).Msg("invalid invitation token")
+		return nil, e.NewUnauthorizedError("invalid or expired invitation")
+	}
+
+	log.Info().Msg("getting invitation from database")
+	i, err := s.ivr.GetInvitationByID(ctx, t.InvitationID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("invitation not found")
+		return nil, e.NewUnauthorizedError("invalid or expired invitation")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get invitation from database")
+		return nil, e.NewInternalServerError()
+	}
+
+	if i.TokenID != t.JTI || !i.IsPending(s.clock.Now()) {
+		log.Error().Msg("invitation can't be accepted with the token")
+		return nil, e.NewUnauthorizedError("invalid or expired invitation")
+	}
+
+	return i, nil
+}
+
+// GetInvitation gets the invitation the token was sent for, with the
+// organization it's for
+func (s *BaseOrganizationService) GetInvitation(
+	ctx context.Context,
+	token string,
+) (*InvitationDetail, e.Error) {
+	i, iErr := s.parseInvitation(ctx, token)
+	if iErr != nil {
+		return nil, iErr
+	}
+
+	log.Info().Msg("getting organization from database")
+	o, err := s.or.GetOrganizationByID(ctx, i.OrganizationID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get organization from database")
+		return nil, e.NewInternalServerError()
+	}
+	i.Organization = o
+
+	log.Info().Msg("retrieving user from database")
+	_, err = s.ur.GetUserByEmail(ctx, i.Email)
+	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return nil, e.NewInternalServerError()
+	}
+
+	return &InvitationDetail{
+		Invitation: i,
+		HasAccount: err == nil,
+	}, nil
+}
+
+// AcceptInvitation adds the user with the invited email to the organization.
+// when there is no such user, one is registered from u with the email already
+// verified, since the link could only be opened from that inbox.
+func (s *BaseOrganizationService) AcceptInvitation(
+	ctx context.Context,
+	token string,
+	u *repository.User,
+) e.Error {
+	i, iErr := s.parseInvitation(ctx, token)
+	if iErr != nil {
+		return iErr
+	}
+
+	log.Info().Msg("retrieving user from database")
+	invitee, err := s.ur.GetUserByEmail(ctx, i.Email)
+	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return e.NewInternalServerError()
+	}
+	if err != nil && u == nil {
+		log.Error().Msg("invitee has no account")
+		return e.NewBadRequestError("an account has to be created to accept the invitation")
+	}
+
+	err = s.txm.WithTx(ctx, func(ctx context.Context) error {
+		if invitee == nil {
+			log.Info().Msg("registering invitee")
+			u.Email = i.Email
+			if err := s.as.Register(ctx, u, true); err != nil {
+				return err
+			}
+			invitee = u
+		}
+
+		log.Info().Msg("accepting invitation")
+		err := s.ivr.AcceptInvitation(ctx, i.ID, i.TokenID)
+		if _, ok := err.(repository.NotFoundError); ok {
+			log.Error().Err(err).Msg("invitation is no longer pending")
+			return e.NewUnauthorizedError("invalid or expired invitation")
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("failed to accept invitation")
+			return e.NewInternalServerError()
+		}
+
+		log.Info().Msg("adding member")
+		err = s.or.CreateMembership(ctx, &repository.Membership{
+			OrganizationID: i.OrganizationID,
+			UserID:         invitee.ID,
+			Role:           i.Role,
+		})
+		if _, ok := err.(repository.UniqueViolationError); ok {
+			// added by hand in the meantime, they keep the role they got
+			log.Info().Msg("invitee is a member already")
+			return nil
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("failed to add member")
+			return e.NewInternalServerError()
+		}
+
+		return nil
+	})
+	if txErr, ok := err.(e.Error); ok {
+		return txErr
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to commit invitation acceptance")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditInvitationAccepted, invitee.ID, map[string]interface{}{
+		"organization_id": i.OrganizationID,
+		"invitation_id":   i.ID,
+		"role":            i.Role,
+	})
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/mailer"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/security"
+	"github.com/werdna521/userland/security/jwt"
+)
+
+// the link opens the client app, which posts the token to the redeem endpoint
+// along with its own client ID
+const magicLinkURL = "%s/login/magic?token=%s"
+
+// RequestMagicLink emails the user a single-use link to log in with. only the
+// client that asked for the link can redeem it.
+func (s *BaseAuthService) RequestMagicLink(
+	ctx context.Context,
+	email string,
+	clientID string,
+) e.Error {
+	log.Info().Msg("retrieving user from the db")
+	u, err := s.ur.GetUserByEmail(ctx, email)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user not found")
+		return e.NewNotFoundError("user not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve user")
+		return e.NewInternalServerError()
+	}
+
+	log.Info().Msg("checking user activation status")
+	if !u.IsActive {
+		log.Error().Msg("user is not active")
+		return e.NewBadRequestError("user is not active")
+	}
+
+	log.Info().Msg("storing magic link token")
+	t := &repository.MagicLinkToken{
+		Token:    string(security.GenerateRandomID()),
+		UserID:   u.ID,
+		ClientID: clientID,
+	}
+	err = s.tr.CreateMagicLinkToken(ctx, t)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store magic link token")
+		return e.NewInternalServerError()
+	}
+
+	link := fmt.Sprintf(magicLinkURL, s.cfg.Server.BaseURL, t.Token)
+
+	log.Debug().Msgf("magic link: %s", link)
+	log.Info().Msg("sending magic link mail")
+	em := mailer.Email{
+		Name:  u.Email,
+		Email: u.Email,
+	}
+	err = mailer.SendMagicLinkMail(
+		ctx,
+		s.m,
+		em,
+		mailLocale(ctx, u),
+		link,
+		s.cfg.Tokens.MagicLink,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to send magic link mail")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditMagicLinkRequested, u.ID, map[string]interface{}{
+		"client_id": clientID,
+	})
+
+	return nil
+}
+
+// RedeemMagicLink logs the user in the same way Login does, so users with tfa
+// enabled still get a tfa challenge
+func (s *BaseAuthService) RedeemMagicLink(
+	ctx context.Context,
+	token string,
+	clientID string,
+) (*jwt.AccessToken, *repository.TFAChallenge, e.Error) {
+	log.Info().Msg("consuming magic link token")
+	t, err := s.tr.ConsumeMagicLinkToken(ctx, token)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("magic link token not found")
+		return nil, nil, e.NewUnauthorizedError("invalid or expired link")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to consume magic link token")
+		return nil, nil, e.NewInternalServerError()
+	}
+
+	// the token is gone already, so a link leaked to another client is burnt
+	// rather than left for another try
+	if t.ClientID != clientID {
+		log.Error().Msg("magic link was requested by another client")
+		return nil, nil, e.NewUnauthorizedError("invalid or expired link")
+	}
+
+	log.Info().Msg("getting user from database")
+	u, err := s.ur.GetUserByID(ctx, t.UserID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user not found")
+		return nil, nil, e.NewUnauthorizedError("invalid or expired link")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return nil, nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("checking if user is active")
+	if !u.IsActive {
+		log.Error().Msg("user is not active")
+		return nil, nil, e.NewForbiddenError("user is not active")
+	}
+
+	return s.finishLogin(ctx, u.ID, t.ClientID)
+}
@@ -0,0 +1,15 @@
+package service
+
+import (
+	"context"
+
+	"github.com/werdna521/userland/mailer"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/utils/clientinfo"
+)
+
+// mailLocale picks the locale of a mail sent to the user. the user's own
+// preference wins over the language of the request that triggered the mail.
+func mailLocale(ctx context.Context, u *repository.User) string {
+	return mailer.MatchLocale(u.Locale, clientinfo.FromContext(ctx).AcceptLanguage)
+}
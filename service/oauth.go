@@ -0,0 +1,424 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/config"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/postgres"
+	"github.com/werdna521/userland/repository/redis"
+	"github.com/werdna521/userland/security"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/utils/slice"
+)
+
+const (
+	OIDCScopeOpenID  = "openid"
+	OIDCScopeProfile = "profile"
+	OIDCScopeEmail   = "email"
+
+	CodeChallengeMethodS256 = "S256"
+)
+
+var OIDCSupportedScopes = []string{OIDCScopeOpenID, OIDCScopeProfile, OIDCScopeEmail}
+
+type OAuthTokens struct {
+	AccessToken  *jwt.AccessToken
+	RefreshToken *jwt.RefreshToken
+	IDToken      string
+	Scope        string
+}
+
+type OAuthService interface {
+	GetAuthorizationClient(
+		ctx context.Context,
+		clientID string,
+		redirectURI string,
+	) (*repository.OAuthClient, e.Error)
+	Authorize(
+		ctx context.Context,
+		at *jwt.AccessToken,
+		oc *repository.OAuthClient,
+		c *repository.AuthorizationCode,
+	) (string, e.Error)
+	ExchangeAuthorizationCode(
+		ctx context.Context,
+		clientID string,
+		clientSecret string,
+		code string,
+		redirectURI string,
+		codeVerifier string,
+	) (*OAuthTokens, e.Error)
+	RefreshOAuthTokens(
+		ctx context.Context,
+		clientID string,
+		clientSecret string,
+		refreshToken string,
+	) (*OAuthTokens, e.Error)
+	GetUserInfo(ctx context.Context, at *jwt.AccessToken) (*jwt.UserClaims, e.Error)
+}
+
+type BaseOAuthService struct {
+	ur     postgres.UserRepository
+	ocr    postgres.OAuthClientRepository
+	rr     postgres.RoleRepository
+	tr     redis.TokenRepository
+	sr     redis.SessionRepository
+	ss     SessionService
+	limits SessionLimits
+	cfg    *config.Config
+}
+
+func NewBaseOAuthService(
+	ur postgres.UserRepository,
+	ocr postgres.OAuthClientRepository,
+	rr postgres.RoleRepository,
+	tr redis.TokenRepository,
+	sr redis.SessionRepository,
+	ss SessionService,
+	limits SessionLimits,
+	cfg *config.Config,
+) *BaseOAuthService {
+	return &BaseOAuthService{
+		ur:     ur,
+		ocr:    ocr,
+		rr:     rr,
+		tr:     tr,
+		sr:     sr,
+		ss:     ss,
+		limits: limits,
+		cfg:    cfg,
+	}
+}
+
+// GetAuthorizationClient makes sure the client exists and that redirectURI is
+// one of its registered redirect URIs. until it passes, authorization errors
+// must not be sent to redirectURI.
+func (s *BaseOAuthService) GetAuthorizationClient(
+	ctx context.Context,
+	clientID string,
+	redirectURI string,
+) (*repository.OAuthClient, e.Error) {
+	log.Info().Msg("retrieving oauth client from the db")
+	oc, err := s.ocr.GetOAuthClientByID(ctx, clientID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("oauth client not found")
+		return nil, e.NewOAuthError("invalid_request", "unknown client")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve oauth client")
+		return nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("checking redirect uri")
+	isRegistered := slice.AnyStr(oc.RedirectURIs, func(uri string) bool {
+		return uri == redirectURI
+	})
+	if !isRegistered {
+		log.Error().Msg("redirect uri is not registered")
+		return nil, e.NewOAuthError("invalid_request", "redirect uri is not registered for this client")
+	}
+
+	return oc, nil
+}
+
+// Authorize issues an authorization code to oc on behalf of the user owning at.
+// oc has to come from GetAuthorizationClient.
+func (s *BaseOAuthService) Authorize(
+	ctx context.Context,
+	at *jwt.AccessToken,
+	oc *repository.OAuthClient,
+	c *repository.AuthorizationCode,
+) (string, e.Error) {
+	log.Info().Msg("checking requested scope")
+	for _, scope := range strings.Fields(c.Scope) {
+		isSupported := slice.AnyStr(OIDCSupportedScopes, func(s string) bool {
+			return s == scope
+		})
+		if !isSupported {
+			log.Error().Msgf("unsupported scope %s", scope)
+			return "", e.NewOAuthError("invalid_scope", fmt.Sprintf("unsupported scope %s", scope))
+		}
+	}
+
+	// PKCE is required for every client, confidential or not
+	log.Info().Msg("checking code challenge")
+	if c.CodeChallenge == "" {
+		log.Error().Msg("code challenge is missing")
+		return "", e.NewOAuthError("invalid_request", "code challenge is required")
+	}
+	if c.CodeChallengeMethod != CodeChallengeMethodS256 {
+		log.Error().Msg("unsupported code challenge method")
+		return "", e.NewOAuthError("invalid_request", "only S256 code challenge method is supported")
+	}
+
+	log.Info().Msg("storing authorization code in redis")
+	c.Code = string(security.GenerateRandomID())
+	c.ClientID = oc.ID
+	c.UserID = at.UserID
+	err := s.tr.CreateAuthorizationCode(ctx, c)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store authorization code")
+		return "", e.NewInternalServerError()
+	}
+
+	return c.Code, nil
+}
+
+func (s *BaseOAuthService) ExchangeAuthorizationCode(
+	ctx context.Context,
+	clientID string,
+	clientSecret string,
+	code string,
+	redirectURI string,
+	codeVerifier string,
+) (*OAuthTokens, e.Error) {
+	oc, clientErr := s.authenticateClient(ctx, clientID, clientSecret)
+	if clientErr != nil {
+		return nil, clientErr
+	}
+
+	log.Info().Msg("consuming authorization code")
+	c, err := s.tr.ConsumeAuthorizationCode(ctx, code)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("authorization code not found")
+		return nil, e.NewOAuthError("invalid_grant", "invalid authorization code")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to consume authorization code")
+		return nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("checking authorization code")
+	if c.ClientID != oc.ID || c.RedirectURI != redirectURI {
+		log.Error().Msg("authorization code was issued to another client or redirect uri")
+		return nil, e.NewOAuthError("invalid_grant", "invalid authorization code")
+	}
+	if !verifyCodeChallenge(codeVerifier, c.CodeChallenge) {
+		log.Error().Msg("code verifier does not match the code challenge")
+		return nil, e.NewOAuthError("invalid_grant", "invalid code verifier")
+	}
+
+	log.Info().Msg("starting session for the oauth client")
+	session := &repository.Session{
+		UserID:        c.UserID,
+		Client:        oc.ID,
+		Scope:         c.Scope,
+		OAuthClientID: oc.ID,
+	}
+	at, err := startSession(ctx, s.sr, s.rr, s.limits, s.cfg.Tokens, session)
+	if err == errTooManySessions {
+		return nil, e.NewOAuthError("invalid_grant", "too many active sessions")
+	}
+	if err != nil {
+		return nil, e.NewInternalServerError()
+	}
+
+	rt, rtErr := s.ss.GenerateRefreshToken(ctx, at)
+	if rtErr != nil {
+		return nil, rtErr
+	}
+
+	return s.createOAuthTokens(ctx, oc, at, rt, c.Scope, c.Nonce)
+}
+
+func (s *BaseOAuthService) RefreshOAuthTokens(
+	ctx context.Context,
+	clientID string,
+	clientSecret string,
+	refreshToken string,
+) (*OAuthTokens, e.Error) {
+	oc, clientErr := s.authenticateClient(ctx, clientID, clientSecret)
+	if clientErr != nil {
+		return nil, clientErr
+	}
+
+	log.Info().Msg("parsing refresh token")
+	rt, isValid, err := jwt.ParseRefreshToken(refreshToken)
+	if !isValid {
+		log.Error().Err(err).Msg("invalid refresh token")
+		return nil, e.NewOAuthError("invalid_grant", "invalid refresh token")
+	}
+
+	log.Info().Msg("retrieving session from redis")
+	session, err := s.sr.GetSession(ctx, rt.UserID, rt.SessionID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("session not found")
+		return nil, e.NewOAuthError("invalid_grant", "invalid refresh token")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve session")
+		return nil, e.NewInternalServerError()
+	}
+
+	if session.Client != oc.ID {
+		log.Error().Msg("refresh token was issued to another client")
+		return nil, e.NewOAuthError("invalid_grant", "invalid refresh token")
+	}
+
+	at, newRT, rotateErr := s.ss.GenerateAccessToken(ctx, rt)
+	if rotateErr != nil && rotateErr.StatusCode() == http.StatusUnauthorized {
+		return nil, e.NewOAuthError("invalid_grant", "invalid refresh token")
+	}
+	if rotateErr != nil {
+		return nil, rotateErr
+	}
+
+	return s.createOAuthTokens(ctx, oc, at, newRT, session.Scope, "")
+}
+
+func (s *BaseOAuthService) GetUserInfo(
+	ctx context.Context,
+	at *jwt.AccessToken,
+) (*jwt.UserClaims, e.Error) {
+	log.Info().Msg("retrieving session from redis")
+	session, err := s.sr.GetSession(ctx, at.UserID, at.SessionID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve session")
+		return nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("checking granted scope")
+	if !hasScope(session.Scope, OIDCScopeOpenID) {
+		log.Error().Msg("openid scope has not been granted")
+		return nil, e.NewForbiddenError("insufficient scope")
+	}
+
+	uc, err := s.getUserClaims(ctx, at.UserID, session.Scope)
+	if err != nil {
+		return nil, e.NewInternalServerError()
+	}
+
+	return uc, nil
+}
+
+func (s *BaseOAuthService) authenticateClient(
+	ctx context.Context,
+	clientID string,
+	clientSecret string,
+) (*repository.OAuthClient, e.Error) {
+	log.Info().Msg("retrieving oauth client from the db")
+	oc, err := s.ocr.GetOAuthClientByID(ctx, clientID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("oauth client not found")
+		return nil, e.NewOAuthError("invalid_client", "client authentication failed")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve oauth client")
+		return nil, e.NewInternalServerError()
+	}
+
+	// public clients have no secret and rely on PKCE alone
+	if !oc.Secret.Valid {
+		return oc, nil
+	}
+
+	log.Info().Msg("checking client secret")
+	err = security.CheckPassword(clientSecret, oc.Secret.String)
+	if err != nil {
+		log.Error().Err(err).Msg("client secret does not match")
+		return nil, e.NewOAuthError("invalid_client", "client authentication failed")
+	}
+
+	return oc, nil
+}
+
+func (s *BaseOAuthService) createOAuthTokens(
+	ctx context.Context,
+	oc *repository.OAuthClient,
+	at *jwt.AccessToken,
+	rt *jwt.RefreshToken,
+	scope string,
+	nonce string,
+) (*OAuthTokens, e.Error) {
+	tokens := &OAuthTokens{
+		AccessToken:  at,
+		RefreshToken: rt,
+		Scope:        scope,
+	}
+
+	if !hasScope(scope, OIDCScopeOpenID) {
+		return tokens, nil
+	}
+
+	uc, err := s.getUserClaims(ctx, at.UserID, scope)
+	if err != nil {
+		return nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("generating id token")
+	tokens.IDToken, err = jwt.CreateIDToken(
+		s.cfg.Server.BaseURL,
+		oc.ID,
+		at.UserID,
+		nonce,
+		uc,
+		s.cfg.Tokens.IDToken,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to generate id token")
+		return nil, e.NewInternalServerError()
+	}
+
+	return tokens, nil
+}
+
+func (s *BaseOAuthService) getUserClaims(
+	ctx context.Context,
+	userID string,
+	scope string,
+) (*jwt.UserClaims, error) {
+	uc := &jwt.UserClaims{}
+
+	if hasScope(scope, OIDCScopeProfile) {
+		log.Info().Msg("retrieving user bio from the db")
+		ub, err := s.ur.GetUserBioByID(ctx, userID)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to retrieve user bio")
+			return nil, err
+		}
+
+		uc.Name = ub.Fullname
+		uc.Website = ub.Web
+		if ub.Picture != "" {
+			uc.Picture = fmt.Sprintf("%s/%s", s.cfg.Server.BaseURL, ub.Picture)
+		}
+	}
+
+	if hasScope(scope, OIDCScopeEmail) {
+		log.Info().Msg("retrieving user from the db")
+		u, err := s.ur.GetUserByID(ctx, userID)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to retrieve user")
+			return nil, err
+		}
+
+		// users can only log in after verifying their email
+		isVerified := u.IsActive
+		uc.Email = u.Email
+		uc.EmailVerified = &isVerified
+	}
+
+	return uc, nil
+}
+
+func hasScope(scope string, want string) bool {
+	return slice.AnyStr(strings.Fields(scope), func(s string) bool {
+		return s == want
+	})
+}
+
+// verifyCodeChallenge checks an S256 PKCE code verifier against its challenge
+func verifyCodeChallenge(verifier string, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
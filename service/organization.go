@@ -0,0 +1,503 @@
+package service
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/config"
+	"github.com/werdna521/userland/mailer"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/postgres"
+	"github.com/werdna521/userland/utils/clock"
+)
+
+// OrganizationService manages organizations on behalf of their members. the
+// userID every method takes is the member making the request, what they can
+// do depends on their role in the organization.
+type OrganizationService interface {
+	CreateOrganization(
+		ctx context.Context,
+		userID string,
+		name string,
+	) (*repository.Organization, e.Error)
+	ListOrganizations(ctx context.Context, userID string) ([]*repository.Membership, e.Error)
+	GetOrganization(
+		ctx context.Context,
+		userID string,
+		orgID string,
+	) (*repository.Membership, e.Error)
+	UpdateOrganization(ctx context.Context, userID string, orgID string, name string) e.Error
+	DeleteOrganization(ctx context.Context, userID string, orgID string) e.Error
+	ListMembers(
+		ctx context.Context,
+		userID string,
+		orgID string,
+	) ([]*repository.Membership, e.Error)
+	AddMember(
+		ctx context.Context,
+		userID string,
+		orgID string,
+		email string,
+		role string,
+	) e.Error
+	UpdateMemberRole(
+		ctx context.Context,
+		userID string,
+		orgID string,
+		memberID string,
+		role string,
+	) e.Error
+	RemoveMember(ctx context.Context, userID string, orgID string, memberID string) e.Error
+	CreateInvitation(
+		ctx context.Context,
+		userID string,
+		orgID string,
+		email string,
+		role string,
+	) (*repository.Invitation, e.Error)
+	ListInvitations(
+		ctx context.Context,
+		userID string,
+		orgID string,
+	) ([]*repository.Invitation, e.Error)
+	ResendInvitation(ctx context.Context, userID string, orgID string, invitationID string) e.Error
+	RevokeInvitation(ctx context.Context, userID string, orgID string, invitationID string) e.Error
+	// GetInvitation and AcceptInvitation are called by the invitee, who may
+	// not have an account yet, with the token of the invitation link
+	GetInvitation(ctx context.Context, token string) (*InvitationDetail, e.Error)
+	AcceptInvitation(ctx context.Context, token string, u *repository.User) e.Error
+}
+
+type BaseOrganizationService struct {
+	or    postgres.OrganizationRepository
+	ivr   postgres.InvitationRepository
+	ur    postgres.UserRepository
+	aer   postgres.AuditEventRepository
+	txm   postgres.TxManager
+	as    AuthService
+	m     mailer.Mailer
+	clock clock.Clock
+	cfg   *config.Config
+}
+
+func NewBaseOrganizationService(
+	or postgres.OrganizationRepository,
+	ivr postgres.InvitationRepository,
+	ur postgres.UserRepository,
+	aer postgres.AuditEventRepository,
+	txm postgres.TxManager,
+	as AuthService,
+	m mailer.Mailer,
+	clock clock.Clock,
+	cfg *config.Config,
+) *BaseOrganizationService {
+	return &BaseOrganizationService{
+		or:    or,
+		ivr:   ivr,
+		ur:    ur,
+		aer:   aer,
+		txm:   txm,
+		as:    as,
+		m:     m,
+		clock: clock,
+		cfg:   cfg,
+	}
+}
+
+// getMembership gets the membership of the user, an organization they aren't
+// a member of is treated as one that doesn't exist
+func (s *BaseOrganizationService) getMembership(
+	ctx context.Context,
+	orgID string,
+	userID string,
+) (*repository.Membership, e.Error) {
+	log.Info().Msg("getting membership from database")
+	m, err := s.or.GetMembership(ctx, orgID, userID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user is not a member of the organization")
+		return nil, e.NewNotFoundError("organization not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get membership from database")
+		return nil, e.NewInternalServerError()
+	}
+
+	return m, nil
+}
+
+// getMember gets the membership of another member of the organization
+func (s *BaseOrganizationService) getMember(
+	ctx context.Context,
+	orgID string,
+	memberID string,
+) (*repository.Membership, e.Error) {
+	log.Info().Msg("getting member from database")
+	m, err := s.or.GetMembership(ctx, orgID, memberID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("member not found")
+		return nil, e.NewNotFoundError("member not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get member from database")
+		return nil, e.NewInternalServerError()
+	}
+
+	return m, nil
+}
+
+// requireOrgRole checks that the member has one of the roles
+func requireOrgRole(m *repository.Membership, roles ...string) e.Error {
+	for _, role := range roles {
+		if m.Role == role {
+			return nil
+		}
+	}
+
+	log.Error().Msgf("member has the %s role", m.Role)
+	return e.NewForbiddenError("permission denied")
+}
+
+// checkOwnerLeft makes sure the organization keeps an owner once the owner
+// member stops being one
+func (s *BaseOrganizationService) checkOwnerLeft(
+	ctx context.Context,
+	member *repository.Membership,
+) e.Error {
+	if member.Role != repository.OrgRoleOwner {
+		return nil
+	}
+
+	log.Info().Msg("counting owners")
+	count, err := s.or.CountMembershipsWithRole(ctx, member.OrganizationID, repository.OrgRoleOwner)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count owners")
+		return e.NewInternalServerError()
+	}
+	if count <= 1 {
+		log.Error().Msg("member is the last owner")
+		return e.NewBadRequestError("the organization needs at least one owner")
+	}
+
+	return nil
+}
+
+// CreateOrganization creates an organization with the user as its owner
+func (s *BaseOrganizationService) CreateOrganization(
+	ctx context.Context,
+	userID string,
+	name string,
+) (*repository.Organization, e.Error) {
+	o := &repository.Organization{
+		Name: name,
+	}
+
+	err := s.txm.WithTx(ctx, func(ctx context.Context) error {
+		log.Info().Msg("creating organization")
+		_, err := s.or.CreateOrganization(ctx, o)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to create organization")
+			return err
+		}
+
+		log.Info().Msg("adding owner")
+		err = s.or.CreateMembership(ctx, &repository.Membership{
+			OrganizationID: o.ID,
+			UserID:         userID,
+			Role:           repository.OrgRoleOwner,
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("failed to add owner")
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditOrganizationCreated, userID, map[string]interface{}{
+		"organization_id": o.ID,
+	})
+
+	return o, nil
+}
+
+func (s *BaseOrganizationService) ListOrganizations(
+	ctx context.Context,
+	userID string,
+) ([]*repository.Membership, e.Error) {
+	log.Info().Msg("listing organizations")
+	memberships, err := s.or.ListMembershipsByUserID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list organizations")
+		return nil, e.NewInternalServerError()
+	}
+
+	return memberships, nil
+}
+
+// GetOrganization gets the organization along with the membership of the user
+func (s *BaseOrganizationService) GetOrganization(
+	ctx context.Context,
+	userID string,
+	orgID string,
+) (*repository.Membership, e.Error) {
+	m, mErr := s.getMembership(ctx, orgID, userID)
+	if mErr != nil {
+		return nil, mErr
+	}
+
+	log.Info().Msg("getting organization from database")
+	o, err := s.or.GetOrganizationByID(ctx, orgID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("organization not found")
+		return nil, e.NewNotFoundError("organization not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get organization from database")
+		return nil, e.NewInternalServerError()
+	}
+	m.Organization = o
+
+	return m, nil
+}
+
+func (s *BaseOrganizationService) UpdateOrganization(
+	ctx context.Context,
+	userID string,
+	orgID string,
+	name string,
+) e.Error {
+	m, mErr := s.getMembership(ctx, orgID, userID)
+	if mErr != nil {
+		return mErr
+	}
+	if err := requireOrgRole(m, repository.OrgRoleOwner, repository.OrgRoleAdmin); err != nil {
+		return err
+	}
+
+	log.Info().Msg("updating organization")
+	_, err := s.or.UpdateOrganizationByID(ctx, orgID, name)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("organization not found")
+		return e.NewNotFoundError("organization not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update organization")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditOrganizationUpdated, userID, map[string]interface{}{
+		"organization_id": orgID,
+	})
+
+	return nil
+}
+
+// DeleteOrganization deletes the organization and every membership in it, it
+// takes an owner
+func (s *BaseOrganizationService) DeleteOrganization(
+	ctx context.Context,
+	userID string,
+	orgID string,
+) e.Error {
+	m, mErr := s.getMembership(ctx, orgID, userID)
+	if mErr != nil {
+		return mErr
+	}
+	if err := requireOrgRole(m, repository.OrgRoleOwner); err != nil {
+		return err
+	}
+
+	log.Info().Msg("deleting organization")
+	err := s.or.DeleteOrganizationByID(ctx, orgID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete organization")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditOrganizationDeleted, userID, map[string]interface{}{
+		"organization_id": orgID,
+	})
+
+	return nil
+}
+
+func (s *BaseOrganizationService) ListMembers(
+	ctx context.Context,
+	userID string,
+	orgID string,
+) ([]*repository.Membership, e.Error) {
+	_, mErr := s.getMembership(ctx, orgID, userID)
+	if mErr != nil {
+		return nil, mErr
+	}
+
+	log.Info().Msg("listing members")
+	memberships, err := s.or.ListMembershipsByOrganizationID(ctx, orgID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list members")
+		return nil, e.NewInternalServerError()
+	}
+
+	return memberships, nil
+}
+
+// AddMember adds the registered user with the email to the organization.
+// owners and admins can add members, only owners can add other owners.
+func (s *BaseOrganizationService) AddMember(
+	ctx context.Context,
+	userID string,
+	orgID string,
+	email string,
+	role string,
+) e.Error {
+	m, mErr := s.getMembership(ctx, orgID, userID)
+	if mErr != nil {
+		return mErr
+	}
+	if err := requireOrgRole(m, repository.OrgRoleOwner, repository.OrgRoleAdmin); err != nil {
+		return err
+	}
+	if role == repository.OrgRoleOwner {
+		if err := requireOrgRole(m, repository.OrgRoleOwner); err != nil {
+			return err
+		}
+	}
+
+	log.Info().Msg("retrieving user from database")
+	u, err := s.ur.GetUserByEmail(ctx, email)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user not found")
+		return e.NewNotFoundError("user not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return e.NewInternalServerError()
+	}
+
+	log.Info().Msg("adding member")
+	err = s.or.CreateMembership(ctx, &repository.Membership{
+		OrganizationID: orgID,
+		UserID:         u.ID,
+		Role:           role,
+	})
+	if _, ok := err.(repository.UniqueViolationError); ok {
+		return e.NewConflictError("user is a member already")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to add member")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditMemberAdded, u.ID, map[string]interface{}{
+		"organization_id": orgID,
+		"role":            role,
+	})
+
+	return nil
+}
+
+// UpdateMemberRole changes the role of a member. owners and admins can change
+// roles, only owners can make someone an owner or change the role of an owner.
+func (s *BaseOrganizationService) UpdateMemberRole(
+	ctx context.Context,
+	userID string,
+	orgID string,
+	memberID string,
+	role string,
+) e.Error {
+	m, mErr := s.getMembership(ctx, orgID, userID)
+	if mErr != nil {
+		return mErr
+	}
+	if err := requireOrgRole(m, repository.OrgRoleOwner, repository.OrgRoleAdmin); err != nil {
+		return err
+	}
+
+	member, mErr := s.getMember(ctx, orgID, memberID)
+	if mErr != nil {
+		return mErr
+	}
+	if member.Role == role {
+		return nil
+	}
+	if member.Role == repository.OrgRoleOwner || role == repository.OrgRoleOwner {
+		if err := requireOrgRole(m, repository.OrgRoleOwner); err != nil {
+			return err
+		}
+	}
+	if err := s.checkOwnerLeft(ctx, member); err != nil {
+		return err
+	}
+
+	log.Info().Msg("updating member role")
+	err := s.or.UpdateMembershipRole(ctx, orgID, memberID, role)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("member not found")
+		return e.NewNotFoundError("member not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update member role")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditMemberRoleChanged, memberID, map[string]interface{}{
+		"organization_id": orgID,
+		"old_role":        member.Role,
+		"role":            role,
+	})
+
+	return nil
+}
+
+// RemoveMember takes a member out of the organization. anyone can leave,
+// owners and admins can remove members, only owners can remove owners. the
+// last owner can't go.
+func (s *BaseOrganizationService) RemoveMember(
+	ctx context.Context,
+	userID string,
+	orgID string,
+	memberID string,
+) e.Error {
+	m, mErr := s.getMembership(ctx, orgID, userID)
+	if mErr != nil {
+		return mErr
+	}
+
+	member := m
+	if memberID != userID {
+		if err := requireOrgRole(m, repository.OrgRoleOwner, repository.OrgRoleAdmin); err != nil {
+			return err
+		}
+
+		member, mErr = s.getMember(ctx, orgID, memberID)
+		if mErr != nil {
+			return mErr
+		}
+		if member.Role == repository.OrgRoleOwner {
+			if err := requireOrgRole(m, repository.OrgRoleOwner); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.checkOwnerLeft(ctx, member); err != nil {
+		return err
+	}
+
+	log.Info().Msg("removing member")
+	err := s.or.DeleteMembership(ctx, orgID, memberID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to remove member")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditMemberRemoved, memberID, map[string]interface{}{
+		"organization_id": orgID,
+		"role":            member.Role,
+	})
+
+	return nil
+}
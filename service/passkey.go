@@ -0,0 +1,437 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/rs/zerolog/log"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/security"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/security/seclog"
+	"github.com/werdna521/userland/utils/clientinfo"
+)
+
+// PasskeyChallenge is the first half of a passkey ceremony. Options go to the
+// browser as they are, CeremonyID has to be sent back along with the answer.
+type PasskeyChallenge struct {
+	CeremonyID string
+	Options    interface{}
+}
+
+// passkeyUser adapts a user and their passkeys to what the webauthn library
+// expects. the user handle is the user ID, which is how a discoverable login
+// finds the user again.
+type passkeyUser struct {
+	u        *repository.User
+	passkeys []*repository.Passkey
+}
+
+func (pu *passkeyUser) WebAuthnID() []byte {
+	return []byte(pu.u.ID)
+}
+
+func (pu *passkeyUser) WebAuthnName() string {
+	return pu.u.Email
+}
+
+func (pu *passkeyUser) WebAuthnDisplayName() string {
+	if pu.u.UserBio != nil && pu.u.UserBio.Fullname != "" {
+		return pu.u.UserBio.Fullname
+	}
+	return pu.u.Email
+}
+
+func (pu *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := []webauthn.Credential{}
+	for _, p := range pu.passkeys {
+		transports := []protocol.AuthenticatorTransport{}
+		for _, t := range p.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              p.CredentialID,
+			PublicKey:       p.PublicKey,
+			AttestationType: p.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: p.BackupEligible,
+				BackupState:    p.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    p.AAGUID,
+				SignCount: p.SignCount,
+			},
+		})
+	}
+
+	return credentials
+}
+
+func (pu *passkeyUser) findPasskey(credentialID []byte) *repository.Passkey {
+	for _, p := range pu.passkeys {
+		if bytes.Equal(p.CredentialID, credentialID) {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *BaseAuthService) getPasskeyUser(ctx context.Context, userID string) (*passkeyUser, e.Error) {
+	log.Info().Msg("getting user from database")
+	u, err := s.ur.GetUserByID(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user not found")
+		return nil, e.NewNotFoundError("user not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("retrieving passkeys from the db")
+	passkeys, err := s.pkr.GetPasskeysByUserID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve passkeys")
+		return nil, e.NewInternalServerError()
+	}
+
+	return &passkeyUser{u: u, passkeys: passkeys}, nil
+}
+
+func (s *BaseAuthService) storePasskeyCeremony(
+	ctx context.Context,
+	c *repository.PasskeyCeremony,
+	sd *webauthn.SessionData,
+) e.Error {
+	sessionData, err := json.Marshal(sd)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to encode passkey session data")
+		return e.NewInternalServerError()
+	}
+
+	log.Info().Msg("storing passkey ceremony in redis")
+	c.ID = string(security.GenerateRandomID())
+	c.SessionData = sessionData
+	err = s.tr.CreatePasskeyCeremony(ctx, c)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store passkey ceremony")
+		return e.NewInternalServerError()
+	}
+
+	return nil
+}
+
+func (s *BaseAuthService) consumePasskeyCeremony(
+	ctx context.Context,
+	id string,
+) (*repository.PasskeyCeremony, *webauthn.SessionData, e.Error) {
+	log.Info().Msg("consuming passkey ceremony")
+	c, err := s.tr.ConsumePasskeyCeremony(ctx, id)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("passkey ceremony not found")
+		return nil, nil, e.NewUnauthorizedError("invalid or expired ceremony")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to consume passkey ceremony")
+		return nil, nil, e.NewInternalServerError()
+	}
+
+	sd := &webauthn.SessionData{}
+	err = json.Unmarshal(c.SessionData, sd)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to decode passkey session data")
+		return nil, nil, e.NewInternalServerError()
+	}
+
+	return c, sd, nil
+}
+
+// BeginPasskeyRegistration asks the browser to create a discoverable credential
+// for the user. passkeys the user already has are excluded, so the same
+// authenticator can't be registered twice.
+func (s *BaseAuthService) BeginPasskeyRegistration(
+	ctx context.Context,
+	userID string,
+) (*PasskeyChallenge, e.Error) {
+	pu, getErr := s.getPasskeyUser(ctx, userID)
+	if getErr != nil {
+		return nil, getErr
+	}
+
+	exclusions := []protocol.CredentialDescriptor{}
+	for _, c := range pu.WebAuthnCredentials() {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+
+	log.Info().Msg("beginning passkey registration")
+	creation, sd, err := s.wa.BeginRegistration(
+		pu,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		}),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to begin passkey registration")
+		return nil, e.NewInternalServerError()
+	}
+
+	c := &repository.PasskeyCeremony{UserID: userID}
+	storeErr := s.storePasskeyCeremony(ctx, c, sd)
+	if storeErr != nil {
+		return nil, storeErr
+	}
+
+	return &PasskeyChallenge{CeremonyID: c.ID, Options: creation}, nil
+}
+
+// FinishPasskeyRegistration verifies the credential the browser created and
+// stores it under the given nickname
+func (s *BaseAuthService) FinishPasskeyRegistration(
+	ctx context.Context,
+	userID string,
+	ceremonyID string,
+	nickname string,
+	credential []byte,
+) (*repository.Passkey, e.Error) {
+	c, sd, consumeErr := s.consumePasskeyCeremony(ctx, ceremonyID)
+	if consumeErr != nil {
+		return nil, consumeErr
+	}
+	if c.UserID != userID {
+		log.Error().Msg("passkey ceremony belongs to another user")
+		return nil, e.NewUnauthorizedError("invalid or expired ceremony")
+	}
+
+	pu, getErr := s.getPasskeyUser(ctx, userID)
+	if getErr != nil {
+		return nil, getErr
+	}
+
+	log.Info().Msg("verifying passkey registration")
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(credential)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse passkey credential")
+		return nil, e.NewBadRequestError("invalid credential")
+	}
+	cred, err := s.wa.CreateCredential(pu, *sd, parsed)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to verify passkey registration")
+		return nil, e.NewUnauthorizedError("failed to verify the passkey")
+	}
+
+	transports := []string{}
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+
+	log.Info().Msg("storing passkey in the db")
+	p, err := s.pkr.CreatePasskey(ctx, &repository.Passkey{
+		UserID:          userID,
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+		Nickname:        nickname,
+	})
+	if _, ok := err.(repository.UniqueViolationError); ok {
+		log.Error().Err(err).Msg("passkey is already registered")
+		return nil, e.NewConflictError("passkey is already registered")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store passkey")
+		return nil, e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditPasskeyRegistered, userID, map[string]interface{}{
+		"passkey_id": p.ID,
+		"nickname":   p.Nickname,
+	})
+
+	return p, nil
+}
+
+// BeginPasskeyLogin starts a discoverable login: the browser lets the user
+// pick any passkey they have for this site, so no email is needed
+func (s *BaseAuthService) BeginPasskeyLogin(
+	ctx context.Context,
+	clientID string,
+) (*PasskeyChallenge, e.Error) {
+	log.Info().Msg("beginning passkey login")
+	assertion, sd, err := s.wa.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to begin passkey login")
+		return nil, e.NewInternalServerError()
+	}
+
+	c := &repository.PasskeyCeremony{ClientID: clientID}
+	storeErr := s.storePasskeyCeremony(ctx, c, sd)
+	if storeErr != nil {
+		return nil, storeErr
+	}
+
+	return &PasskeyChallenge{CeremonyID: c.ID, Options: assertion}, nil
+}
+
+// FinishPasskeyLogin verifies the assertion and starts a session for the
+// client the ceremony was started by. user verification is required, so a
+// passkey counts as two factors and no tfa challenge is issued.
+func (s *BaseAuthService) FinishPasskeyLogin(
+	ctx context.Context,
+	ceremonyID string,
+	credential []byte,
+) (*jwt.AccessToken, e.Error) {
+	ip := clientinfo.FromContext(ctx).IP
+
+	if ip != "" {
+		log.Info().Msg("checking if ip is locked out")
+		ttl, err := s.lar.GetIPLockTTL(ctx, ip)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get ip lockout")
+			return nil, e.NewInternalServerError()
+		}
+		if ttl > 0 {
+			log.Error().Msg("ip is locked out")
+			return nil, e.NewTooManyRequestsError("too many failed login attempts, try again later", ttl)
+		}
+	}
+
+	c, sd, consumeErr := s.consumePasskeyCeremony(ctx, ceremonyID)
+	if consumeErr != nil {
+		return nil, consumeErr
+	}
+
+	log.Info().Msg("verifying passkey assertion")
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(credential)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to parse passkey assertion")
+		return nil, e.NewBadRequestError("invalid credential")
+	}
+
+	var pu *passkeyUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		var getErr e.Error
+		pu, getErr = s.getPasskeyUser(ctx, string(userHandle))
+		if getErr != nil {
+			return nil, getErr
+		}
+		return pu, nil
+	}
+	_, cred, err := s.wa.ValidatePasskeyLogin(handler, *sd, parsed)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to verify passkey assertion")
+		err = s.recordFailedLogin(ctx, nil, ip)
+		if err != nil {
+			return nil, e.NewInternalServerError()
+		}
+		return nil, e.NewUnauthorizedError("failed to verify the passkey")
+	}
+
+	// a sign count going backwards means the authenticator may have been cloned
+	if cred.Authenticator.CloneWarning {
+		log.Error().Msg("passkey sign count went backwards")
+		seclog.Event(ctx, "passkey_clone_warning").
+			Str("user_id", pu.u.ID).
+			Bytes("credential_id", cred.ID).
+			Msg("passkey sign count went backwards, login refused")
+		return nil, e.NewUnauthorizedError("failed to verify the passkey")
+	}
+
+	log.Info().Msg("checking if user is active")
+	if !pu.u.IsActive {
+		log.Error().Msg("user is not active")
+		return nil, e.NewForbiddenError("user is not active")
+	}
+
+	log.Info().Msg("updating passkey usage")
+	p := pu.findPasskey(cred.ID)
+	p.SignCount = cred.Authenticator.SignCount
+	p.BackupState = cred.Flags.BackupState
+	p.LastUsedAt = sql.NullTime{Time: s.clock.Now(), Valid: true}
+	err = s.pkr.UpdatePasskeyUsage(ctx, p)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update passkey usage")
+		return nil, e.NewInternalServerError()
+	}
+
+	at, sessionErr := s.createSession(ctx, pu.u.ID, c.ClientID)
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+
+	return at, nil
+}
+
+func (s *BaseAuthService) ListPasskeys(
+	ctx context.Context,
+	userID string,
+) ([]*repository.Passkey, e.Error) {
+	log.Info().Msg("retrieving passkeys from the db")
+	passkeys, err := s.pkr.GetPasskeysByUserID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve passkeys")
+		return nil, e.NewInternalServerError()
+	}
+
+	return passkeys, nil
+}
+
+func (s *BaseAuthService) RenamePasskey(
+	ctx context.Context,
+	userID string,
+	id string,
+	nickname string,
+) e.Error {
+	log.Info().Msg("renaming passkey")
+	err := s.pkr.RenamePasskey(ctx, userID, id, nickname)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("passkey not found")
+		return e.NewNotFoundError("passkey not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to rename passkey")
+		return e.NewInternalServerError()
+	}
+
+	return nil
+}
+
+// DeletePasskey refuses to remove the last way a user without a password has
+// to log in
+func (s *BaseAuthService) DeletePasskey(ctx context.Context, userID string, id string) e.Error {
+	checkErr := s.checkOtherLoginMethods(ctx, userID)
+	if checkErr != nil {
+		return checkErr
+	}
+
+	log.Info().Msg("deleting passkey")
+	err := s.pkr.DeletePasskey(ctx, userID, id)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("passkey not found")
+		return e.NewNotFoundError("passkey not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete passkey")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditPasskeyDeleted, userID, map[string]interface{}{
+		"passkey_id": id,
+	})
+
+	return nil
+}
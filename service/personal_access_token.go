@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/rs/zerolog/log"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/postgres"
+	"github.com/werdna521/userland/security"
+	"github.com/werdna521/userland/utils/clock"
+)
+
+// PersonalAccessTokenService manages the personal access tokens of a user,
+// the tokens themselves are checked by the access token middleware
+type PersonalAccessTokenService interface {
+	// CreatePersonalAccessToken returns the token along with its plaintext,
+	// which is never available again
+	CreatePersonalAccessToken(
+		ctx context.Context,
+		userID string,
+		name string,
+		scopes []string,
+		expiresAt sql.NullTime,
+	) (*repository.PersonalAccessToken, string, e.Error)
+	ListPersonalAccessTokens(
+		ctx context.Context,
+		userID string,
+	) ([]*repository.PersonalAccessToken, e.Error)
+	RevokePersonalAccessToken(ctx context.Context, userID string, id string) e.Error
+}
+
+type BasePersonalAccessTokenService struct {
+	patr  postgres.PersonalAccessTokenRepository
+	aer   postgres.AuditEventRepository
+	clock clock.Clock
+}
+
+func NewBasePersonalAccessTokenService(
+	patr postgres.PersonalAccessTokenRepository,
+	aer postgres.AuditEventRepository,
+	clock clock.Clock,
+) *BasePersonalAccessTokenService {
+	return &BasePersonalAccessTokenService{
+		patr:  patr,
+		aer:   aer,
+		clock: clock,
+	}
+}
+
+func (s *BasePersonalAccessTokenService) CreatePersonalAccessToken(
+	ctx context.Context,
+	userID string,
+	name string,
+	scopes []string,
+	expiresAt sql.NullTime,
+) (*repository.PersonalAccessToken, string, e.Error) {
+	if expiresAt.Valid && !expiresAt.Time.After(s.clock.Now()) {
+		log.Error().Msg("expiry is in the past")
+		return nil, "", e.NewBadRequestError("expiry has to be in the future")
+	}
+
+	log.Info().Msg("generating personal access token")
+	token := security.GeneratePersonalAccessToken()
+
+	t := &repository.PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		TokenHash: security.HashToken(token),
+		ExpiresAt: expiresAt,
+	}
+
+	log.Info().Msg("storing personal access token")
+	_, err := s.patr.CreatePersonalAccessToken(ctx, t)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store personal access token")
+		return nil, "", e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditPATCreated, userID, map[string]interface{}{
+		"token_id": t.ID,
+		"name":     name,
+		"scopes":   scopes,
+	})
+
+	return t, token, nil
+}
+
+func (s *BasePersonalAccessTokenService) ListPersonalAccessTokens(
+	ctx context.Context,
+	userID string,
+) ([]*repository.PersonalAccessToken, e.Error) {
+	log.Info().Msg("listing personal access tokens")
+	tokens, err := s.patr.ListPersonalAccessTokensByUserID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list personal access tokens")
+		return nil, e.NewInternalServerError()
+	}
+
+	return tokens, nil
+}
+
+func (s *BasePersonalAccessTokenService) RevokePersonalAccessToken(
+	ctx context.Context,
+	userID string,
+	id string,
+) e.Error {
+	log.Info().Msg("deleting personal access token")
+	err := s.patr.DeletePersonalAccessToken(ctx, userID, id)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("personal access token not found")
+		return e.NewNotFoundError("token not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete personal access token")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditPATRevoked, userID, map[string]interface{}{
+		"token_id": id,
+	})
+
+	return nil
+}
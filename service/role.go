@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/postgres"
+)
+
+type RoleService interface {
+	BootstrapAdmin(ctx context.Context, email string) e.Error
+}
+
+type BaseRoleService struct {
+	ur  postgres.UserRepository
+	rr  postgres.RoleRepository
+	aer postgres.AuditEventRepository
+}
+
+func NewBaseRoleService(
+	ur postgres.UserRepository,
+	rr postgres.RoleRepository,
+	aer postgres.AuditEventRepository,
+) *BaseRoleService {
+	return &BaseRoleService{
+		ur:  ur,
+		rr:  rr,
+		aer: aer,
+	}
+}
+
+// BootstrapAdmin makes the user with the email the first admin. it does
+// nothing once there is an admin, so the email can stay configured, and
+// nothing until the user has registered and verified the address, so whoever
+// owns the address is the one who gets the role.
+func (s *BaseRoleService) BootstrapAdmin(ctx context.Context, email string) e.Error {
+	log.Info().Msg("counting admins")
+	count, err := s.rr.CountUsersWithRole(ctx, repository.RoleAdmin)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count admins")
+		return e.NewInternalServerError()
+	}
+	if count > 0 {
+		log.Info().Msg("there is an admin already, skipping admin bootstrap")
+		return nil
+	}
+
+	log.Info().Msg("retrieving user from database")
+	u, err := s.ur.GetUserByEmail(ctx, email)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Warn().Msg("the bootstrap admin has not registered yet")
+		return nil
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user")
+		return e.NewInternalServerError()
+	}
+	if !u.IsActive {
+		log.Warn().Msg("the bootstrap admin has not verified their email yet")
+		return nil
+	}
+
+	log.Info().Msg("assigning admin role")
+	err = s.rr.AssignRole(ctx, u.ID, repository.RoleAdmin)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to assign admin role")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditRoleAssigned, u.ID, map[string]interface{}{
+		"role":      repository.RoleAdmin,
+		"bootstrap": true,
+	})
+
+	return nil
+}
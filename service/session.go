@@ -2,39 +2,339 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/config"
 	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/repository/postgres"
 	"github.com/werdna521/userland/repository/redis"
+	"github.com/werdna521/userland/security"
 	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/security/seclog"
+	"github.com/werdna521/userland/utils/clientinfo"
+	"github.com/werdna521/userland/utils/geoip"
 	"github.com/werdna521/userland/utils/slice"
+	"github.com/werdna521/userland/utils/useragent"
 )
 
 type SessionService interface {
 	GenerateRefreshToken(ctx context.Context, at *jwt.AccessToken) (*jwt.RefreshToken, e.Error)
-	GenerateAccessToken(ctx context.Context, rt *jwt.RefreshToken) (*jwt.AccessToken, e.Error)
+	GenerateAccessToken(
+		ctx context.Context,
+		rt *jwt.RefreshToken,
+	) (*jwt.AccessToken, *jwt.RefreshToken, e.Error)
 	ListSessions(ctx context.Context, at *jwt.AccessToken) ([]*repository.Session, e.Error)
 	RemoveSession(ctx context.Context, session *repository.Session) e.Error
+	RemoveSessionByID(ctx context.Context, userID string, sessionID string) e.Error
 	RemoveAllOtherSessions(ctx context.Context, session *repository.Session) e.Error
+	SwitchOrganization(
+		ctx context.Context,
+		at *jwt.AccessToken,
+		orgID string,
+	) (*jwt.AccessToken, e.Error)
+	CreateBrowserSession(ctx context.Context, at *jwt.AccessToken) (*repository.BrowserSession, e.Error)
+}
+
+// what happens to the sessions of a user after a sensitive account change
+const (
+	SessionPolicyKeepAll     = "keep_all"
+	SessionPolicyKeepCurrent = "keep_current"
+	SessionPolicyRevokeAll   = "revoke_all"
+)
+
+// SessionPolicies picks a session policy for every change that can be made by
+// someone who took the account over
+type SessionPolicies struct {
+	OnPasswordChange string
+	OnPasswordReset  string
+	OnEmailChange    string
+}
+
+func DefaultSessionPolicies() SessionPolicies {
+	return SessionPolicies{
+		OnPasswordChange: SessionPolicyKeepCurrent,
+		OnPasswordReset:  SessionPolicyRevokeAll,
+		OnEmailChange:    SessionPolicyRevokeAll,
+	}
+}
+
+// ParseSessionPolicy checks the policy, an empty one is replaced by fallback
+func ParseSessionPolicy(policy string, fallback string) (string, error) {
+	switch policy {
+	case "":
+		return fallback, nil
+	case SessionPolicyKeepAll, SessionPolicyKeepCurrent, SessionPolicyRevokeAll:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown session policy %q", policy)
+	}
+}
+
+// what happens when a user logs in with as many sessions as they're allowed
+const (
+	SessionLimitEvictOldest = "evict_oldest"
+	SessionLimitReject      = "reject"
+)
+
+// SessionLimits caps the number of sessions a user can have at once. a
+// MaxSessions of 0 leaves it unlimited.
+type SessionLimits struct {
+	MaxSessions int
+	OnLimit     string
+}
+
+func DefaultSessionLimits() SessionLimits {
+	return SessionLimits{
+		MaxSessions: 0,
+		OnLimit:     SessionLimitEvictOldest,
+	}
+}
+
+// ParseSessionLimitPolicy checks the policy, an empty one is replaced by
+// fallback
+func ParseSessionLimitPolicy(policy string, fallback string) (string, error) {
+	switch policy {
+	case "":
+		return fallback, nil
+	case SessionLimitEvictOldest, SessionLimitReject:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown session limit policy %q", policy)
+	}
+}
+
+// errTooManySessions is returned by startSession when the user is at their
+// session limit and the limit rejects new sessions
+var errTooManySessions = errors.New("too many active sessions")
+
+// enforceSessionLimit makes room for one more session of the user, either by
+// revoking the least recently active ones or by refusing with
+// errTooManySessions
+func enforceSessionLimit(
+	ctx context.Context,
+	sr redis.SessionRepository,
+	limits SessionLimits,
+	userID string,
+) error {
+	if limits.MaxSessions <= 0 {
+		return nil
+	}
+
+	// the sessions come most recently active first
+	log.Info().Msg("getting all sessions")
+	sessions, err := sr.GetAllSessions(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get all sessions")
+		return err
+	}
+	if len(sessions) < limits.MaxSessions {
+		return nil
+	}
+
+	if limits.OnLimit == SessionLimitReject {
+		log.Error().Msg("user has too many active sessions")
+		return errTooManySessions
+	}
+
+	log.Info().Msg("revoking least recently active sessions")
+	for _, session := range sessions[limits.MaxSessions-1:] {
+		_, err := sr.RevokeSession(ctx, session.UserID, session.ID)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to revoke session")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applySessionPolicy revokes the sessions of the user the policy asks for.
+// the current session is the one in the client info of ctx, changes made
+// without a session, like a password reset, have nothing to keep. personal
+// access tokens are never the current session, so they all go.
+func applySessionPolicy(
+	ctx context.Context,
+	sr redis.SessionRepository,
+	patr postgres.PersonalAccessTokenRepository,
+	userID string,
+	policy string,
+	now time.Time,
+	expiresIn time.Duration,
+) error {
+	if policy == SessionPolicyKeepAll {
+		return nil
+	}
+
+	w := &repository.SessionWatermark{
+		NotBefore: now,
+	}
+	if policy == SessionPolicyKeepCurrent {
+		w.KeepSessionID = clientinfo.FromContext(ctx).SessionID
+	}
+
+	// a session can't outlive its refresh token, so neither does the watermark
+	log.Info().Msg("storing session watermark")
+	err := sr.SetSessionWatermark(ctx, userID, w, expiresIn)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store session watermark")
+		return err
+	}
+
+	return deletePersonalAccessTokens(ctx, patr, userID)
+}
+
+// deletePersonalAccessTokens revokes every personal access token of the user
+func deletePersonalAccessTokens(
+	ctx context.Context,
+	patr postgres.PersonalAccessTokenRepository,
+	userID string,
+) error {
+	log.Info().Msg("deleting personal access tokens")
+	err := patr.DeletePersonalAccessTokensByUserID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete personal access tokens")
+		return err
+	}
+
+	return nil
 }
 
 type BaseSessionService struct {
-	sr redis.SessionRepository
+	sr  redis.SessionRepository
+	rr  postgres.RoleRepository
+	or  postgres.OrganizationRepository
+	aer postgres.AuditEventRepository
+	geo geoip.Locator
+	cfg *config.Config
 }
 
-func NewBaseSessionService(sr redis.SessionRepository) *BaseSessionService {
+func NewBaseSessionService(
+	sr redis.SessionRepository,
+	rr postgres.RoleRepository,
+	or postgres.OrganizationRepository,
+	aer postgres.AuditEventRepository,
+	geo geoip.Locator,
+	cfg *config.Config,
+) *BaseSessionService {
 	return &BaseSessionService{
-		sr: sr,
+		sr:  sr,
+		rr:  rr,
+		or:  or,
+		aer: aer,
+		geo: geo,
+		cfg: cfg,
 	}
 }
 
+// startSession stores a new session for session.UserID and returns its first
+// access token. the session ID is generated here, and where the session is
+// started from is taken from the client info in ctx.
+func startSession(
+	ctx context.Context,
+	sr redis.SessionRepository,
+	rr postgres.RoleRepository,
+	limits SessionLimits,
+	tokens config.Tokens,
+	session *repository.Session,
+) (*jwt.AccessToken, error) {
+	err := enforceSessionLimit(ctx, sr, limits, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Msg("generating session ID")
+	session.ID = string(security.GenerateRandomID())
+
+	ci := clientinfo.FromContext(ctx)
+	ua := useragent.Parse(ci.UserAgent)
+	session.IP = ci.IP
+	session.UserAgent = ci.UserAgent
+	session.Browser = ua.Browser
+	session.OS = ua.OS
+	session.Device = ua.Device
+
+	log.Info().Msg("retrieving roles from the db")
+	roles, err := rr.GetRolesByUserID(ctx, session.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve roles")
+		return nil, err
+	}
+
+	log.Info().Msg("generating access token")
+	at, err := jwt.CreateAccessToken(
+		session.UserID,
+		session.ID,
+		roles,
+		session.OrganizationID,
+		tokens.AccessToken,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to generate access token")
+		return nil, err
+	}
+
+	log.Info().Msg("storing access token in redis")
+	token := &repository.AccessToken{
+		ID:        at.JTI,
+		UserID:    at.UserID,
+		SessionID: at.SessionID,
+	}
+	err = sr.CreateAccessToken(ctx, token, tokens.AccessToken)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store access token")
+		return nil, err
+	}
+
+	log.Info().Msg("storing session in redis")
+	err = sr.CreateSession(ctx, session, tokens.AccessToken)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store session in redis")
+		return nil, err
+	}
+
+	// the session can be kept alive by its refresh token for this long at most
+	log.Info().Msg("adding the session id to a user session index set")
+	err = sr.AddUserSessionToIndex(ctx, session, tokens.RefreshToken)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to add the session id to the index set")
+		return nil, err
+	}
+
+	return at, nil
+}
+
+// endAllSessions revokes every session of the user along with their tokens
+func endAllSessions(ctx context.Context, sr redis.SessionRepository, userID string) error {
+	log.Info().Msg("getting all sessions")
+	sessions, err := sr.GetAllSessions(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get all sessions")
+		return err
+	}
+
+	log.Info().Msg("deleting all sessions")
+	for _, session := range sessions {
+		log.Info().Msg("revoking session")
+		_, err := sr.RevokeSession(ctx, session.UserID, session.ID)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to revoke session")
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (s *BaseSessionService) GenerateRefreshToken(
 	ctx context.Context,
 	at *jwt.AccessToken,
 ) (*jwt.RefreshToken, e.Error) {
 	log.Info().Msg("generating refresh token")
-	rt, err := jwt.CreateRefreshToken(at.UserID, at.SessionID)
+	rt, err := jwt.CreateRefreshToken(at.UserID, at.SessionID, s.cfg.Tokens.RefreshToken)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to generate refresh token")
 		return nil, e.NewInternalServerError()
@@ -46,7 +346,7 @@ func (s *BaseSessionService) GenerateRefreshToken(
 		UserID:    rt.UserID,
 		SessionID: rt.SessionID,
 	}
-	err = s.sr.CreateRefreshToken(ctx, token, jwt.RefreshTokenLife)
+	err = s.sr.CreateRefreshToken(ctx, token, s.cfg.Tokens.RefreshToken)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to store refresh token in redis")
 		return nil, e.NewInternalServerError()
@@ -57,7 +357,7 @@ func (s *BaseSessionService) GenerateRefreshToken(
 		ID:     rt.SessionID,
 		UserID: rt.UserID,
 	}
-	err = s.sr.UpdateSessionExpiryTime(ctx, session, jwt.RefreshTokenLife)
+	err = s.sr.UpdateSessionExpiryTime(ctx, session, s.cfg.Tokens.RefreshToken)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to update session expiry time")
 		return nil, e.NewInternalServerError()
@@ -66,15 +366,96 @@ func (s *BaseSessionService) GenerateRefreshToken(
 	return rt, nil
 }
 
+// GenerateAccessToken trades a refresh token for a new access token and a new
+// refresh token. the presented refresh token can't be used again afterwards;
+// if it does show up again, someone is holding a copy of it, so the whole
+// session gets revoked.
 func (s *BaseSessionService) GenerateAccessToken(
 	ctx context.Context,
 	rt *jwt.RefreshToken,
-) (*jwt.AccessToken, e.Error) {
+) (*jwt.AccessToken, *jwt.RefreshToken, e.Error) {
+	log.Info().Msg("getting session from redis")
+	session, err := s.sr.GetSession(ctx, rt.UserID, rt.SessionID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("session not found")
+		return nil, nil, e.NewUnauthorizedError("invalid token")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get session")
+		return nil, nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("checking session watermark")
+	w, err := s.sr.GetSessionWatermark(ctx, rt.UserID)
+	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+		log.Error().Err(err).Msg("failed to get session watermark")
+		return nil, nil, e.NewInternalServerError()
+	}
+	if w != nil && w.Revokes(session) {
+		log.Error().Msg("session has been revoked by the watermark")
+		return nil, nil, e.NewUnauthorizedError("invalid token")
+	}
+
+	log.Info().Msg("generating new refresh token")
+	newRT, err := jwt.CreateRefreshToken(rt.UserID, rt.SessionID, s.cfg.Tokens.RefreshToken)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to generate new refresh token")
+		return nil, nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("rotating refresh token in redis")
+	oldToken := &repository.RefreshToken{
+		ID:        rt.JTI,
+		UserID:    rt.UserID,
+		SessionID: rt.SessionID,
+	}
+	newToken := &repository.RefreshToken{
+		ID:        newRT.JTI,
+		UserID:    newRT.UserID,
+		SessionID: newRT.SessionID,
+	}
+	rotated, reused, err := s.sr.RotateRefreshToken(
+		ctx,
+		oldToken,
+		newToken,
+		s.cfg.Tokens.RefreshToken,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to rotate refresh token in redis")
+		return nil, nil, e.NewInternalServerError()
+	}
+	if reused {
+		return nil, nil, s.handleRefreshTokenReuse(ctx, rt)
+	}
+	if !rotated {
+		log.Error().Msg("refresh token is not the current one")
+		return nil, nil, e.NewUnauthorizedError("invalid token")
+	}
+
+	// roles are read again, changes to them show up with the next access token
+	log.Info().Msg("retrieving roles from the db")
+	roles, err := s.rr.GetRolesByUserID(ctx, rt.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve roles")
+		return nil, nil, e.NewInternalServerError()
+	}
+
+	orgID, err := s.activeOrganizationID(ctx, session)
+	if err != nil {
+		return nil, nil, e.NewInternalServerError()
+	}
+
 	log.Info().Msg("generating access token")
-	at, err := jwt.CreateAccessToken(rt.UserID, rt.SessionID)
+	at, err := jwt.CreateAccessToken(
+		rt.UserID,
+		rt.SessionID,
+		roles,
+		orgID,
+		s.cfg.Tokens.AccessToken,
+	)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to generate access token")
-		return nil, e.NewInternalServerError()
+		return nil, nil, e.NewInternalServerError()
 	}
 
 	log.Info().Msg("storing access token in redis")
@@ -83,24 +464,164 @@ func (s *BaseSessionService) GenerateAccessToken(
 		UserID:    at.UserID,
 		SessionID: at.SessionID,
 	}
-	err = s.sr.CreateAccessToken(ctx, token, jwt.AccessTokenLife)
+	err = s.sr.CreateAccessToken(ctx, token, s.cfg.Tokens.AccessToken)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to store access token in redis")
-		return nil, e.NewInternalServerError()
+		return nil, nil, e.NewInternalServerError()
 	}
 
 	log.Info().Msg("updating session expiry time")
+	err = s.sr.UpdateSessionExpiryTime(ctx, session, s.cfg.Tokens.RefreshToken)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update session expiry time")
+		return nil, nil, e.NewInternalServerError()
+	}
+
+	return at, newRT, nil
+}
+
+// activeOrganizationID is the organization of the session, as long as the
+// user is still a member. otherwise the session is taken out of it.
+func (s *BaseSessionService) activeOrganizationID(
+	ctx context.Context,
+	session *repository.Session,
+) (string, error) {
+	if session.OrganizationID == "" {
+		return "", nil
+	}
+
+	log.Info().Msg("checking membership of the active organization")
+	_, err := s.or.GetMembership(ctx, session.OrganizationID, session.UserID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Info().Msg("user has left the active organization, clearing it")
+		_, err = s.sr.SetSessionOrganization(ctx, session.UserID, session.ID, "")
+		if err != nil {
+			log.Error().Err(err).Msg("failed to clear the organization of the session")
+			return "", err
+		}
+		return "", nil
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get membership")
+		return "", err
+	}
+
+	return session.OrganizationID, nil
+}
+
+// SwitchOrganization makes orgID the organization the session acts for, an
+// empty orgID leaves the session without one. the access token carries the
+// organization, so a new one is issued.
+func (s *BaseSessionService) SwitchOrganization(
+	ctx context.Context,
+	at *jwt.AccessToken,
+	orgID string,
+) (*jwt.AccessToken, e.Error) {
+	if orgID != "" {
+		log.Info().Msg("checking membership")
+		_, err := s.or.GetMembership(ctx, orgID, at.UserID)
+		if _, ok := err.(repository.NotFoundError); ok {
+			log.Error().Err(err).Msg("user is not a member of the organization")
+			return nil, e.NewNotFoundError("organization not found")
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get membership")
+			return nil, e.NewInternalServerError()
+		}
+	}
+
+	log.Info().Msg("switching the organization of the session")
+	ok, err := s.sr.SetSessionOrganization(ctx, at.UserID, at.SessionID, orgID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to switch the organization of the session")
+		return nil, e.NewInternalServerError()
+	}
+	if !ok {
+		log.Error().Msg("session not found")
+		return nil, e.NewUnauthorizedError("invalid token")
+	}
+
+	log.Info().Msg("retrieving roles from the db")
+	roles, err := s.rr.GetRolesByUserID(ctx, at.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve roles")
+		return nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("generating access token")
+	newAT, err := jwt.CreateAccessToken(
+		at.UserID,
+		at.SessionID,
+		roles,
+		orgID,
+		s.cfg.Tokens.AccessToken,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to generate access token")
+		return nil, e.NewInternalServerError()
+	}
+
+	// a session has a single access token, storing the new one revokes the one
+	// still acting for the previous organization
+	log.Info().Msg("storing access token in redis")
+	token := &repository.AccessToken{
+		ID:        newAT.JTI,
+		UserID:    newAT.UserID,
+		SessionID: newAT.SessionID,
+	}
+	err = s.sr.CreateAccessToken(ctx, token, s.cfg.Tokens.AccessToken)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store access token in redis")
+		return nil, e.NewInternalServerError()
+	}
+
+	return newAT, nil
+}
+
+func (s *BaseSessionService) handleRefreshTokenReuse(
+	ctx context.Context,
+	rt *jwt.RefreshToken,
+) e.Error {
+	log.Error().Msg("rotated refresh token has been reused, revoking session")
+	seclog.Event(ctx, "refresh_token_reuse").
+		Str("user_id", rt.UserID).
+		Str("session_id", rt.SessionID).
+		Str("jti", rt.JTI).
+		Msg("rotated refresh token has been reused, session revoked")
+
 	session := &repository.Session{
-		ID:     at.SessionID,
-		UserID: at.UserID,
+		ID:     rt.SessionID,
+		UserID: rt.UserID,
+	}
+	if err := s.removeSession(ctx, session); err != nil {
+		return err
+	}
+	recordAudit(ctx, s.aer, repository.AuditRefreshTokenReused, rt.UserID, map[string]interface{}{
+		"jti": rt.JTI,
+	})
+
+	return e.NewUnauthorizedError("invalid token")
+}
+
+// CreateBrowserSession binds a new browser session to the session of at, so
+// the browser can go through /oauth/authorize on its own
+func (s *BaseSessionService) CreateBrowserSession(
+	ctx context.Context,
+	at *jwt.AccessToken,
+) (*repository.BrowserSession, e.Error) {
+	log.Info().Msg("storing browser session in redis")
+	bs := &repository.BrowserSession{
+		Token:     string(security.GenerateRandomID()),
+		UserID:    at.UserID,
+		SessionID: at.SessionID,
 	}
-	err = s.sr.UpdateSessionExpiryTime(ctx, session, jwt.AccessTokenLife)
+	err := s.sr.CreateBrowserSession(ctx, bs, s.cfg.Tokens.BrowserSession)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to update session expiry time")
+		log.Error().Err(err).Msg("failed to store browser session in redis")
 		return nil, e.NewInternalServerError()
 	}
 
-	return at, nil
+	return bs, nil
 }
 
 func (s *BaseSessionService) ListSessions(
@@ -114,6 +635,11 @@ func (s *BaseSessionService) ListSessions(
 		return nil, e.NewInternalServerError()
 	}
 
+	log.Info().Msg("looking up session locations")
+	for _, session := range sessions {
+		session.Location = s.geo.Locate(session.LastSeenIP)
+	}
+
 	return sessions, nil
 }
 
@@ -121,39 +647,45 @@ func (s *BaseSessionService) RemoveSession(
 	ctx context.Context,
 	session *repository.Session,
 ) e.Error {
-	log.Info().Msg("removing session from redis")
-	err := s.sr.DeleteSession(ctx, session)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to remove session from redis")
-		return e.NewInternalServerError()
+	if err := s.removeSession(ctx, session); err != nil {
+		return err
 	}
+	recordAudit(ctx, s.aer, repository.AuditSessionEnded, session.UserID, map[string]interface{}{
+		"session_id": session.ID,
+	})
 
-	accessToken := &repository.AccessToken{
-		UserID:    session.UserID,
-		SessionID: session.ID,
-	}
-	log.Info().Msg("revoking access token")
-	err = s.sr.DeleteAccessToken(ctx, accessToken)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to revoke access token")
-		return e.NewInternalServerError()
-	}
+	return nil
+}
 
-	refreshToken := &repository.RefreshToken{
-		UserID:    session.UserID,
-		SessionID: session.ID,
+// RemoveSessionByID ends one of the user's own sessions. sessions are looked up
+// under the user, so the ID of someone else's session is simply not found.
+func (s *BaseSessionService) RemoveSessionByID(
+	ctx context.Context,
+	userID string,
+	sessionID string,
+) e.Error {
+	log.Info().Msg("getting session from redis")
+	session, err := s.sr.GetSession(ctx, userID, sessionID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("session not found")
+		return e.NewNotFoundError("session not found")
 	}
-	log.Info().Msg("revoking refresh token")
-	err = s.sr.DeleteRefreshToken(ctx, refreshToken)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to revoke refresh token")
+		log.Error().Err(err).Msg("failed to get session from redis")
 		return e.NewInternalServerError()
 	}
 
-	log.Info().Msg("removing session id from index")
-	err = s.sr.RemoveUserSessionFromIndex(ctx, session.UserID, session.ID)
+	return s.RemoveSession(ctx, session)
+}
+
+func (s *BaseSessionService) removeSession(
+	ctx context.Context,
+	session *repository.Session,
+) e.Error {
+	log.Info().Msg("revoking session")
+	_, err := s.sr.RevokeSession(ctx, session.UserID, session.ID)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to remove session id from index")
+		log.Error().Err(err).Msg("failed to revoke session")
 		return e.NewInternalServerError()
 	}
 
@@ -178,12 +710,16 @@ func (s *BaseSessionService) RemoveAllOtherSessions(
 
 	log.Info().Msg("removing all other sessions from redis")
 	for _, session := range sessions {
-		err = s.RemoveSession(ctx, session)
+		err = s.removeSession(ctx, session)
 		if err != nil {
 			log.Error().Err(err).Msgf("failed to remove session %s", session.ID)
 			return e.NewInternalServerError()
 		}
 	}
 
+	recordAudit(ctx, s.aer, repository.AuditOtherSessionsEnded, session.UserID, map[string]interface{}{
+		"count": len(sessions),
+	})
+
 	return nil
 }
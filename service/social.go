@@ -0,0 +1,337 @@
+package service
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/security"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/social"
+)
+
+// SocialLoginResult is what a provider callback ends in: either a session, a
+// tfa challenge, or, when linking, the newly linked identity
+type SocialLoginResult struct {
+	AccessToken    *jwt.AccessToken
+	TFAChallenge   *repository.TFAChallenge
+	LinkedIdentity *repository.UserIdentity
+}
+
+// StartSocialLogin returns the provider URL the user has to be sent to
+func (s *BaseAuthService) StartSocialLogin(
+	ctx context.Context,
+	provider string,
+	clientID string,
+) (string, e.Error) {
+	return s.startSocialFlow(ctx, &repository.SocialLoginState{
+		Provider: provider,
+		ClientID: clientID,
+	})
+}
+
+// LinkIdentity works like StartSocialLogin, but the callback links the provider
+// account to userID instead of signing in
+func (s *BaseAuthService) LinkIdentity(
+	ctx context.Context,
+	userID string,
+	provider string,
+) (string, e.Error) {
+	return s.startSocialFlow(ctx, &repository.SocialLoginState{
+		Provider: provider,
+		UserID:   userID,
+	})
+}
+
+func (s *BaseAuthService) startSocialFlow(
+	ctx context.Context,
+	state *repository.SocialLoginState,
+) (string, e.Error) {
+	p, ok := s.providers[state.Provider]
+	if !ok {
+		log.Error().Msgf("unknown provider %s", state.Provider)
+		return "", e.NewNotFoundError("unknown provider")
+	}
+
+	log.Info().Msg("storing social login state in redis")
+	state.State = string(security.GenerateRandomID())
+	state.Nonce = string(security.GenerateRandomID())
+	state.CodeVerifier = security.GenerateCodeVerifier()
+	err := s.tr.CreateSocialLoginState(ctx, state)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store social login state")
+		return "", e.NewInternalServerError()
+	}
+
+	log.Info().Msg("building provider url")
+	url, err := p.AuthCodeURL(ctx, state.State, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to build provider url")
+		return "", e.NewInternalServerError()
+	}
+
+	return url, nil
+}
+
+// CompleteSocialLogin handles the provider redirecting back. unknown identities
+// get a new account, unless the email is already taken: it has to be linked
+// from the existing account first, so that nobody can take over an account by
+// registering its email at some provider.
+func (s *BaseAuthService) CompleteSocialLogin(
+	ctx context.Context,
+	provider string,
+	state string,
+	code string,
+) (*SocialLoginResult, e.Error) {
+	log.Info().Msg("consuming social login state")
+	st, err := s.tr.ConsumeSocialLoginState(ctx, state)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("social login state not found")
+		return nil, e.NewUnauthorizedError("invalid or expired state")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to consume social login state")
+		return nil, e.NewInternalServerError()
+	}
+	if st.Provider != provider {
+		log.Error().Msg("social login state belongs to another provider")
+		return nil, e.NewUnauthorizedError("invalid or expired state")
+	}
+
+	p, ok := s.providers[provider]
+	if !ok {
+		log.Error().Msgf("unknown provider %s", provider)
+		return nil, e.NewNotFoundError("unknown provider")
+	}
+
+	log.Info().Msg("exchanging code with the provider")
+	identity, err := p.Exchange(ctx, code, st.Nonce, st.CodeVerifier)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to exchange code with the provider")
+		return nil, e.NewUnauthorizedError("failed to sign in with the provider")
+	}
+
+	if st.UserID != "" {
+		ui, linkErr := s.linkIdentity(ctx, st.UserID, provider, identity)
+		if linkErr != nil {
+			return nil, linkErr
+		}
+		return &SocialLoginResult{LinkedIdentity: ui}, nil
+	}
+
+	log.Info().Msg("retrieving user identity from the db")
+	ui, err := s.uir.GetUserIdentity(ctx, provider, identity.Subject)
+	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+		log.Error().Err(err).Msg("failed to retrieve user identity")
+		return nil, e.NewInternalServerError()
+	}
+
+	userID := ""
+	if err == nil {
+		userID = ui.UserID
+	} else {
+		u, registerErr := s.registerSocialUser(ctx, provider, identity)
+		if registerErr != nil {
+			return nil, registerErr
+		}
+		userID = u.ID
+	}
+
+	at, c, loginErr := s.finishLogin(ctx, userID, st.ClientID)
+	if loginErr != nil {
+		return nil, loginErr
+	}
+
+	return &SocialLoginResult{AccessToken: at, TFAChallenge: c}, nil
+}
+
+func (s *BaseAuthService) linkIdentity(
+	ctx context.Context,
+	userID string,
+	provider string,
+	identity *social.Identity,
+) (*repository.UserIdentity, e.Error) {
+	log.Info().Msg("retrieving user identity from the db")
+	ui, err := s.uir.GetUserIdentity(ctx, provider, identity.Subject)
+	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+		log.Error().Err(err).Msg("failed to retrieve user identity")
+		return nil, e.NewInternalServerError()
+	}
+	if err == nil {
+		if ui.UserID != userID {
+			log.Error().Msg("identity is linked to another user")
+			return nil, e.NewConflictError("this account is already linked to another user")
+		}
+		return ui, nil
+	}
+
+	log.Info().Msg("linking user identity")
+	ui, err = s.uir.CreateUserIdentity(ctx, &repository.UserIdentity{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	})
+	if _, ok := err.(repository.UniqueViolationError); ok {
+		log.Error().Err(err).Msg("user already has an identity from the provider")
+		return nil, e.NewConflictError("another account of this provider is already linked")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to link user identity")
+		return nil, e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditIdentityLinked, userID, map[string]interface{}{
+		"provider": provider,
+	})
+
+	return ui, nil
+}
+
+func (s *BaseAuthService) registerSocialUser(
+	ctx context.Context,
+	provider string,
+	identity *social.Identity,
+) (*repository.User, e.Error) {
+	if identity.Email == "" || !identity.EmailVerified {
+		log.Error().Msg("provider did not return a verified email")
+		return nil, e.NewBadRequestError("the provider account has no verified email")
+	}
+
+	log.Info().Msg("retrieving user from database")
+	_, err := s.ur.GetUserByEmail(ctx, identity.Email)
+	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+		log.Error().Err(err).Msg("failed to retrieve user from database")
+		return nil, e.NewInternalServerError()
+	}
+	if err == nil {
+		log.Info().Msg("user already exists")
+		return nil, e.NewConflictError("user already exists, log in and link the provider from your account")
+	}
+
+	// the user is written along with its identity, so a failed link doesn't
+	// leave behind a user without a way to log in
+	var u *repository.User
+	err = s.txm.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+
+		// the provider has verified the email already, and the user has no
+		// password until they set one
+		log.Info().Msg("creating and registering user")
+		u, err = s.ur.CreateUser(ctx, &repository.User{
+			Email:    identity.Email,
+			IsActive: true,
+			UserBio: &repository.UserBio{
+				Fullname: identity.Name,
+			},
+		})
+		if _, ok := err.(repository.UniqueViolationError); ok {
+			log.Error().Err(err).Msg("user already exists")
+			return e.NewConflictError("user already exists")
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("failed to create user")
+			return e.NewInternalServerError()
+		}
+
+		recordAudit(ctx, s.aer, repository.AuditRegistered, u.ID, map[string]interface{}{
+			"provider": provider,
+		})
+
+		_, linkErr := s.linkIdentity(ctx, u.ID, provider, identity)
+		if linkErr != nil {
+			return linkErr
+		}
+
+		return nil
+	})
+	if txErr, ok := err.(e.Error); ok {
+		return nil, txErr
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to commit social user")
+		return nil, e.NewInternalServerError()
+	}
+
+	return u, nil
+}
+
+func (s *BaseAuthService) ListIdentities(
+	ctx context.Context,
+	userID string,
+) ([]*repository.UserIdentity, e.Error) {
+	log.Info().Msg("retrieving user identities from the db")
+	identities, err := s.uir.GetUserIdentitiesByUserID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to retrieve user identities")
+		return nil, e.NewInternalServerError()
+	}
+
+	return identities, nil
+}
+
+// UnlinkIdentity refuses to remove the last way a user without a password has
+// to log in
+func (s *BaseAuthService) UnlinkIdentity(
+	ctx context.Context,
+	userID string,
+	provider string,
+) e.Error {
+	checkErr := s.checkOtherLoginMethods(ctx, userID)
+	if checkErr != nil {
+		return checkErr
+	}
+
+	log.Info().Msg("unlinking user identity")
+	err := s.uir.DeleteUserIdentity(ctx, userID, provider)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user identity not found")
+		return e.NewNotFoundError("provider is not linked")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to unlink user identity")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditIdentityUnlinked, userID, map[string]interface{}{
+		"provider": provider,
+	})
+
+	return nil
+}
+
+// checkOtherLoginMethods is called before removing a linked provider or a
+// passkey. users with a password can always log in with it, everyone else needs
+// to keep at least one provider or passkey.
+func (s *BaseAuthService) checkOtherLoginMethods(ctx context.Context, userID string) e.Error {
+	log.Info().Msg("getting user from database")
+	u, err := s.ur.GetUserByID(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user not found")
+		return e.NewNotFoundError("user not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return e.NewInternalServerError()
+	}
+
+	if u.Password != "" {
+		return nil
+	}
+
+	identities, listErr := s.ListIdentities(ctx, userID)
+	if listErr != nil {
+		return listErr
+	}
+	passkeys, listErr := s.ListPasskeys(ctx, userID)
+	if listErr != nil {
+		return listErr
+	}
+	if len(identities)+len(passkeys) <= 1 {
+		log.Error().Msg("user has no other way to log in")
+		return e.NewBadRequestError("set a password before removing your last way to log in")
+	}
+
+	return nil
+}
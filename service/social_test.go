@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt"
+	"github.com/werdna521/userland/api/error/client"
+	"github.com/werdna521/userland/config"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/social"
+	"github.com/werdna521/userland/utils/clock"
+)
+
+const mockOIDCClientID = "userland"
+
+// mockOIDCProvider is an OpenID Connect provider that signs in whoever the
+// test says, as long as the code it hands out comes back
+type mockOIDCProvider struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	codes map[string]gojwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	p := &mockOIDCProvider{
+		codes: map[string]gojwt.MapClaims{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		claims, ok := p.codes[r.PostFormValue("code")]
+		delete(p.codes, r.PostFormValue("code"))
+		p.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		idToken, err := gojwt.NewWithClaims(gojwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		if err != nil {
+			t.Errorf("failed to sign id token: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+// issueCode makes the provider sign the subject in on code, with the nonce the
+// id token is going to carry
+func (p *mockOIDCProvider) issueCode(code string, subject string, email string, nonce string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.codes[code] = gojwt.MapClaims{
+		"iss":            p.URL,
+		"aud":            mockOIDCClientID,
+		"sub":            subject,
+		"email":          email,
+		"email_verified": true,
+		"name":           "John Userlander",
+		"nonce":          nonce,
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+type socialLoginTest struct {
+	as       *BaseAuthService
+	provider *mockOIDCProvider
+	ur       *fakeUserRepository
+	uir      *fakeUserIdentityRepository
+	sr       *fakeSessionRepository
+}
+
+func newSocialLoginTest(t *testing.T) *socialLoginTest {
+	jwt.SetKeyManager(jwt.NewKeyManager([]byte("secret")))
+
+	provider := newMockOIDCProvider(t)
+	oidc := social.NewOIDCProvider(social.OIDCConfig{
+		Config: social.Config{
+			ClientID:     mockOIDCClientID,
+			ClientSecret: "client-secret",
+			RedirectURL:  "http://localhost:3000/api/v1/auth/social/mock/callback",
+			HTTPClient:   provider.Client(),
+		},
+		Name:      "mock",
+		IssuerURL: provider.URL,
+	})
+
+	st := &socialLoginTest{
+		provider: provider,
+		ur:       &fakeUserRepository{users: map[string]*repository.User{}},
+		uir:      &fakeUserIdentityRepository{},
+		sr:       &fakeSessionRepository{sessions: map[string]*repository.Session{}},
+	}
+	st.as = NewBaseAuthService(
+		st.ur,
+		nil,
+		&fakeTFARepository{},
+		nil,
+		st.uir,
+		nil,
+		&fakeRoleRepository{},
+		&fakeTokenRepository{states: map[string]*repository.SocialLoginState{}},
+		st.sr,
+		nil,
+		nil,
+		&fakeAuditEventRepository{},
+		&fakeTxManager{},
+		nil,
+		noopEventHook{},
+		SessionPolicies{},
+		SessionLimits{},
+		nil,
+		[]social.Provider{oidc},
+		nil,
+		clock.NewRealClock(),
+		&config.Config{Tokens: config.Tokens{AccessToken: time.Minute, RefreshToken: time.Hour}},
+	)
+
+	return st
+}
+
+// start begins a social login and returns the state and nonce the provider
+// was sent
+func (st *socialLoginTest) start(t *testing.T) (string, string) {
+	authURL, err := st.as.StartSocialLogin(context.Background(), "mock", "web")
+	if err != nil {
+		t.Fatalf("failed to start social login: %v", err)
+	}
+
+	u, parseErr := url.Parse(authURL)
+	if parseErr != nil {
+		t.Fatalf("failed to parse provider url: %v", parseErr)
+	}
+
+	return u.Query().Get("state"), u.Query().Get("nonce")
+}
+
+func (st *socialLoginTest) login(t *testing.T, code string, subject string) *SocialLoginResult {
+	state, nonce := st.start(t)
+	st.provider.issueCode(code, subject, "john@example.com", nonce)
+
+	res, err := st.as.CompleteSocialLogin(context.Background(), "mock", state, code)
+	if err != nil {
+		t.Fatalf("failed to complete social login: %v", err)
+	}
+	if res.AccessToken == nil {
+		t.Fatal("social login did not start a session")
+	}
+
+	return res
+}
+
+func TestCompleteSocialLoginRejectsUnknownState(t *testing.T) {
+	st := newSocialLoginTest(t)
+	_, nonce := st.start(t)
+	st.provider.issueCode("code", "subject", "john@example.com", nonce)
+
+	_, err := st.as.CompleteSocialLogin(context.Background(), "mock", "forged-state", "code")
+	if _, ok := err.(client.UnauthorizedError); !ok {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+	if len(st.ur.users) != 0 {
+		t.Fatalf("expected no user, got %d", len(st.ur.users))
+	}
+}
+
+func TestCompleteSocialLoginRejectsReplayedState(t *testing.T) {
+	st := newSocialLoginTest(t)
+	state, nonce := st.start(t)
+	st.provider.issueCode("code", "subject", "john@example.com", nonce)
+
+	_, err := st.as.CompleteSocialLogin(context.Background(), "mock", state, "code")
+	if err != nil {
+		t.Fatalf("failed to complete social login: %v", err)
+	}
+
+	st.provider.issueCode("code", "subject", "john@example.com", nonce)
+	_, err = st.as.CompleteSocialLogin(context.Background(), "mock", state, "code")
+	if _, ok := err.(client.UnauthorizedError); !ok {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+}
+
+func TestCompleteSocialLoginRejectsWrongNonce(t *testing.T) {
+	st := newSocialLoginTest(t)
+	state, _ := st.start(t)
+	st.provider.issueCode("code", "subject", "john@example.com", "another-nonce")
+
+	_, err := st.as.CompleteSocialLogin(context.Background(), "mock", state, "code")
+	if _, ok := err.(client.UnauthorizedError); !ok {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+	if len(st.ur.users) != 0 {
+		t.Fatalf("expected no user, got %d", len(st.ur.users))
+	}
+}
+
+func TestCompleteSocialLoginRegistersThenLogsIn(t *testing.T) {
+	st := newSocialLoginTest(t)
+
+	first := st.login(t, "first-code", "subject")
+	if len(st.ur.users) != 1 {
+		t.Fatalf("expected the first login to create a user, got %d users", len(st.ur.users))
+	}
+	u := st.ur.users[first.AccessToken.UserID]
+	if u == nil || u.Email != "john@example.com" || !u.IsActive {
+		t.Fatalf("expected an active user for john@example.com, got %+v", u)
+	}
+	if len(st.uir.identities) != 1 || st.uir.identities[0].UserID != u.ID {
+		t.Fatalf("expected the identity to be linked to %s, got %+v", u.ID, st.uir.identities)
+	}
+
+	second := st.login(t, "second-code", "subject")
+	if second.AccessToken.UserID != first.AccessToken.UserID {
+		t.Fatalf("expected the second login to be %s, got %s", first.AccessToken.UserID, second.AccessToken.UserID)
+	}
+	if second.AccessToken.SessionID == first.AccessToken.SessionID {
+		t.Fatal("expected the second login to start another session")
+	}
+	if len(st.ur.users) != 1 || len(st.uir.identities) != 1 {
+		t.Fatalf("expected no new user nor identity, got %d users and %d identities", len(st.ur.users), len(st.uir.identities))
+	}
+	if len(st.sr.sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(st.sr.sessions))
+	}
+}
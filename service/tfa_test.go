@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/werdna521/userland/api/error/client"
+	"github.com/werdna521/userland/config"
+	"github.com/werdna521/userland/repository"
+	"github.com/werdna521/userland/security"
+	"github.com/werdna521/userland/security/jwt"
+	"github.com/werdna521/userland/security/totp"
+	"github.com/werdna521/userland/social"
+	"github.com/werdna521/userland/utils/clock"
+)
+
+const (
+	tfaTestEmail    = "john@example.com"
+	tfaTestPassword = "password"
+	tfaTestClientID = "web"
+	tfaTestTTL      = 5 * time.Minute
+)
+
+type tfaLoginTest struct {
+	as     *BaseAuthService
+	clock  *clock.FakeClock
+	secret string
+}
+
+func newTFALoginTest(t *testing.T) *tfaLoginTest {
+	jwt.SetKeyManager(jwt.NewKeyManager([]byte("secret")))
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("failed to generate tfa secret: %v", err)
+	}
+	enc, err := security.NewAESEncrypter("secret")
+	if err != nil {
+		t.Fatalf("failed to create encrypter: %v", err)
+	}
+	encrypted, err := enc.Encrypt(secret)
+	if err != nil {
+		t.Fatalf("failed to encrypt tfa secret: %v", err)
+	}
+	password, err := security.HashPassword(tfaTestPassword)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	// the start of a time step, so that advancing by totp.Period always lands
+	// on the next one
+	c := clock.NewFakeClock(time.Unix(1111111110, 0))
+
+	ur := &fakeUserRepository{users: map[string]*repository.User{
+		"user-1": {ID: "user-1", Email: tfaTestEmail, Password: password, IsActive: true},
+	}}
+	tfar := &fakeTFARepository{tfas: map[string]*repository.UserTFA{
+		"user-1": {ID: "tfa-1", UserID: "user-1", Secret: encrypted, IsEnabled: true},
+	}}
+	tr := &fakeTokenRepository{
+		clock:      c,
+		ttl:        tfaTestTTL,
+		challenges: map[string]*fakeTFAChallenge{},
+	}
+
+	lt := &tfaLoginTest{
+		clock:  c,
+		secret: secret,
+	}
+	lt.as = NewBaseAuthService(
+		ur,
+		nil,
+		tfar,
+		nil,
+		nil,
+		nil,
+		&fakeRoleRepository{},
+		tr,
+		&fakeSessionRepository{sessions: map[string]*repository.Session{}},
+		nil,
+		&fakeLoginAttemptRepository{},
+		&fakeAuditEventRepository{},
+		&fakeTxManager{},
+		nil,
+		noopEventHook{},
+		SessionPolicies{},
+		SessionLimits{},
+		enc,
+		[]social.Provider{},
+		nil,
+		c,
+		&config.Config{Tokens: config.Tokens{
+			AccessToken:  time.Minute,
+			RefreshToken: time.Hour,
+			Verification: tfaTestTTL,
+		}},
+	)
+
+	return lt
+}
+
+// login signs in with the right password and returns the tfa challenge token
+func (lt *tfaLoginTest) login(t *testing.T) string {
+	at, c, err := lt.as.Login(context.Background(), &repository.User{
+		Email:    tfaTestEmail,
+		Password: tfaTestPassword,
+	}, tfaTestClientID)
+	if err != nil {
+		t.Fatalf("failed to login: %v", err)
+	}
+	if at != nil || c == nil {
+		t.Fatal("expected a tfa challenge instead of a session")
+	}
+	return c.Token
+}
+
+// code returns the tfa code the user's device shows at t
+func (lt *tfaLoginTest) code(t *testing.T, at time.Time) string {
+	code, err := totp.GenerateCode(lt.secret, at)
+	if err != nil {
+		t.Fatalf("failed to generate tfa code: %v", err)
+	}
+	return code
+}
+
+func (lt *tfaLoginTest) verify(token string, code string) (*jwt.AccessToken, error) {
+	at, err := lt.as.VerifyTFA(context.Background(), token, tfaTestClientID, code)
+	if err != nil {
+		return nil, err
+	}
+	return at, nil
+}
+
+func TestVerifyTFAStartsSession(t *testing.T) {
+	lt := newTFALoginTest(t)
+	token := lt.login(t)
+
+	at, err := lt.verify(token, lt.code(t, lt.clock.Now()))
+	if err != nil {
+		t.Fatalf("failed to verify tfa: %v", err)
+	}
+	if at.UserID != "user-1" {
+		t.Fatalf("expected a session for user-1, got %s", at.UserID)
+	}
+
+	_, err = lt.verify(token, lt.code(t, lt.clock.Now()))
+	if _, ok := err.(client.UnauthorizedError); !ok {
+		t.Fatalf("expected the challenge to be used up, got %v", err)
+	}
+}
+
+func TestVerifyTFARejectsWrongCode(t *testing.T) {
+	lt := newTFALoginTest(t)
+	token := lt.login(t)
+
+	_, err := lt.verify(token, lt.code(t, lt.clock.Now().Add(2*totp.Period)))
+	if _, ok := err.(client.UnauthorizedError); !ok {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+
+	_, err = lt.verify(token, lt.code(t, lt.clock.Now()))
+	if err != nil {
+		t.Fatalf("expected the challenge to survive a wrong code, got %v", err)
+	}
+}
+
+func TestVerifyTFARejectsReplayedCode(t *testing.T) {
+	lt := newTFALoginTest(t)
+	code := lt.code(t, lt.clock.Now())
+
+	_, err := lt.verify(lt.login(t), code)
+	if err != nil {
+		t.Fatalf("failed to verify tfa: %v", err)
+	}
+
+	// the code is still within its window, but has been used already
+	_, err = lt.verify(lt.login(t), code)
+	if _, ok := err.(client.UnauthorizedError); !ok {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+
+	lt.clock.Advance(totp.Period)
+	_, err = lt.verify(lt.login(t), lt.code(t, lt.clock.Now()))
+	if err != nil {
+		t.Fatalf("expected the code of the next step to be accepted, got %v", err)
+	}
+}
+
+func TestVerifyTFARejectsEarlierStep(t *testing.T) {
+	lt := newTFALoginTest(t)
+	earlier := lt.code(t, lt.clock.Now())
+
+	lt.clock.Advance(totp.Period)
+	_, err := lt.verify(lt.login(t), lt.code(t, lt.clock.Now()))
+	if err != nil {
+		t.Fatalf("failed to verify tfa: %v", err)
+	}
+
+	// still within the skew, but older than the step that was just used
+	_, err = lt.verify(lt.login(t), earlier)
+	if _, ok := err.(client.UnauthorizedError); !ok {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+}
+
+func TestVerifyTFARejectsExpiredChallenge(t *testing.T) {
+	lt := newTFALoginTest(t)
+	token := lt.login(t)
+
+	// a wrong code right before the expiry is turned down for the code only
+	lt.clock.Advance(tfaTestTTL - time.Second)
+	_, err := lt.verify(token, lt.code(t, lt.clock.Now().Add(2*totp.Period)))
+	if uErr, ok := err.(client.UnauthorizedError); !ok || uErr.Msg != "invalid tfa code" {
+		t.Fatalf("expected the code to be rejected, got %v", err)
+	}
+
+	lt.clock.Advance(time.Second)
+	_, err = lt.verify(token, lt.code(t, lt.clock.Now()))
+	if uErr, ok := err.(client.UnauthorizedError); !ok || uErr.Msg != "invalid tfa token" {
+		t.Fatalf("expected the challenge to have expired, got %v", err)
+	}
+}
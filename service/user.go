@@ -11,20 +11,33 @@ import (
 
 	"github.com/rs/zerolog/log"
 	e "github.com/werdna521/userland/api/error"
+	"github.com/werdna521/userland/config"
 	"github.com/werdna521/userland/mailer"
 	"github.com/werdna521/userland/repository"
 	"github.com/werdna521/userland/repository/postgres"
 	"github.com/werdna521/userland/repository/redis"
 	"github.com/werdna521/userland/security"
+	"github.com/werdna521/userland/security/seclog"
+	"github.com/werdna521/userland/security/totp"
+	"github.com/werdna521/userland/utils/clock"
 	"github.com/werdna521/userland/utils/slice"
 )
 
+const (
+	// shown as the account issuer in authenticator apps
+	tfaIssuer         = "Userland"
+	recoveryCodeCount = 10
+)
+
 type UserService interface {
 	GetInfoDetail(ctx context.Context, userID string) (*repository.UserBio, e.Error)
 	UpdateBasicInfo(ctx context.Context, userID string, ub *repository.UserBio) e.Error
 	GetCurrentEmail(ctx context.Context, userID string) (string, e.Error)
+	GetLocale(ctx context.Context, userID string) (string, e.Error)
+	UpdateLocale(ctx context.Context, userID string, locale string) e.Error
 	RequestEmailChange(ctx context.Context, userID string, newEmail string) e.Error
 	VerifyEmailChange(ctx context.Context, userID string, token string) e.Error
+	RevertEmailChange(ctx context.Context, token string) e.Error
 	ChangePassword(
 		ctx context.Context,
 		userID string,
@@ -38,29 +51,73 @@ type UserService interface {
 	) e.Error
 	DeleteProfilePicture(ctx context.Context, userID string) e.Error
 	DeleteAccount(ctx context.Context, userID string, password string) e.Error
+	GetTFAStatus(ctx context.Context, userID string) (bool, e.Error)
+	SetupTFA(ctx context.Context, userID string) (string, string, e.Error)
+	EnableTFA(ctx context.Context, userID string, code string) ([]string, e.Error)
+	DisableTFA(ctx context.Context, userID string, password string, code string) e.Error
+	GetRecoveryCodesCount(ctx context.Context, userID string) (int, e.Error)
+	RegenerateRecoveryCodes(
+		ctx context.Context,
+		userID string,
+		password string,
+		code string,
+	) ([]string, e.Error)
 }
 
 type BaseUserService struct {
-	ur  postgres.UserRepository
-	phr postgres.PasswordHistoryRepository
-	tr  redis.TokenRepository
-	sr  redis.SessionRepository
-	m   mailer.Mailer
+	ur       postgres.UserRepository
+	phr      postgres.PasswordHistoryRepository
+	tfar     postgres.TFARepository
+	rcr      postgres.RecoveryCodeRepository
+	tr       redis.TokenRepository
+	uir      postgres.UserIdentityRepository
+	pkr      postgres.PasskeyRepository
+	sr       redis.SessionRepository
+	patr     postgres.PersonalAccessTokenRepository
+	aer      postgres.AuditEventRepository
+	m        mailer.Mailer
+	hook     EventHook
+	policies SessionPolicies
+	enc      security.Encrypter
+	clock    clock.Clock
+	cfg      *config.Config
 }
 
 func NewBaseUserService(
 	ur postgres.UserRepository,
 	phr postgres.PasswordHistoryRepository,
+	tfar postgres.TFARepository,
+	rcr postgres.RecoveryCodeRepository,
+	uir postgres.UserIdentityRepository,
+	pkr postgres.PasskeyRepository,
 	tr redis.TokenRepository,
 	sr redis.SessionRepository,
+	patr postgres.PersonalAccessTokenRepository,
+	aer postgres.AuditEventRepository,
 	m mailer.Mailer,
+	hook EventHook,
+	policies SessionPolicies,
+	enc security.Encrypter,
+	clock clock.Clock,
+	cfg *config.Config,
 ) *BaseUserService {
 	return &BaseUserService{
-		ur:  ur,
-		phr: phr,
-		tr:  tr,
-		sr:  sr,
-		m:   m,
+		ur:       ur,
+		phr:      phr,
+		tfar:     tfar,
+		rcr:      rcr,
+		uir:      uir,
+		pkr:      pkr,
+		tr:       tr,
+		sr:       sr,
+		patr:     patr,
+		aer:      aer,
+		m:        m,
+		hook:     hook,
+		policies: policies,
+		enc:      enc,
+		clock:    clock,
+		cfg:      cfg,
 	}
 }
 
@@ -103,6 +160,49 @@ func (s *BaseUserService) GetCurrentEmail(
 	return u.Email, nil
 }
 
+func (s *BaseUserService) GetLocale(
+	ctx context.Context,
+	userID string,
+) (string, e.Error) {
+	log.Info().Msg("getting user from the database")
+	u, err := s.ur.GetUserByID(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user not found")
+		return "", e.NewNotFoundError("user not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user from the database")
+		return "", e.NewInternalServerError()
+	}
+
+	return u.Locale, nil
+}
+
+// UpdateLocale sets the language the user gets their mails in. an empty locale
+// goes back to using the language of the request.
+func (s *BaseUserService) UpdateLocale(
+	ctx context.Context,
+	userID string,
+	locale string,
+) e.Error {
+	log.Info().Msg("updating user locale in database")
+	_, err := s.ur.UpdateLocaleByID(ctx, userID, locale)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user not found")
+		return e.NewNotFoundError("user not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update user locale in the database")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditProfileUpdated, userID, map[string]interface{}{
+		"field": "locale",
+	})
+
+	return nil
+}
+
 func (s *BaseUserService) UpdateBasicInfo(
 	ctx context.Context,
 	userID string,
@@ -115,6 +215,10 @@ func (s *BaseUserService) UpdateBasicInfo(
 		return e.NewInternalServerError()
 	}
 
+	recordAudit(ctx, s.aer, repository.AuditProfileUpdated, userID, map[string]interface{}{
+		"field": "bio",
+	})
+
 	return nil
 }
 
@@ -158,7 +262,8 @@ func (s *BaseUserService) RequestEmailChange(
 	}
 
 	verificationLink := fmt.Sprintf(
-		"http://localhost:3000/api/v1/me/email/verification?id=%s&token=%s",
+		"%s/api/v1/me/email/verification?id=%s&token=%s",
+		s.cfg.Server.BaseURL,
 		u.ID,
 		token,
 	)
@@ -169,12 +274,16 @@ func (s *BaseUserService) RequestEmailChange(
 		Name:  newEmail,
 		Email: newEmail,
 	}
-	err = mailer.SendEmailVerificationMail(ctx, s.m, em, verificationLink)
+	err = mailer.SendEmailVerificationMail(ctx, s.m, em, mailLocale(ctx, u), verificationLink)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to send verification link")
 		return e.NewInternalServerError()
 	}
 
+	recordAudit(ctx, s.aer, repository.AuditEmailChangeRequested, userID, map[string]interface{}{
+		"new_email": newEmail,
+	})
+
 	return nil
 }
 
@@ -200,8 +309,19 @@ func (s *BaseUserService) VerifyEmailChange(
 		return e.NewBadRequestError("token is invalid")
 	}
 
+	log.Info().Msg("getting user from database")
+	old, err := s.ur.GetUserByID(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user not found")
+		return e.NewNotFoundError("user not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return e.NewInternalServerError()
+	}
+
 	log.Info().Msg("updating user email")
-	_, err = s.ur.UpdateEmailByID(ctx, userID, t.NewEmail)
+	u, err := s.ur.UpdateEmailByID(ctx, userID, t.NewEmail)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to update user email")
 		return e.NewInternalServerError()
@@ -214,6 +334,107 @@ func (s *BaseUserService) VerifyEmailChange(
 		return e.NewInternalServerError()
 	}
 
+	err = applySessionPolicy(
+		ctx,
+		s.sr,
+		s.patr,
+		userID,
+		s.policies.OnEmailChange,
+		s.clock.Now(),
+		s.cfg.Tokens.RefreshToken,
+	)
+	if err != nil {
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditEmailChanged, userID, map[string]interface{}{
+		"old_email": old.Email,
+		"new_email": u.Email,
+		"sessions":  s.policies.OnEmailChange,
+	})
+
+	ev := newEvent(ctx, EventEmailChanged, u, s.clock.Now())
+	ev.OldEmail = old.Email
+	s.hook.OnEvent(ctx, ev)
+
+	return nil
+}
+
+// RevertEmailChange puts back the email address a change was made from, and
+// logs out every session in case the account was taken over. it's reached
+// through the link mailed to the old address, so there's no session to check.
+func (s *BaseUserService) RevertEmailChange(ctx context.Context, token string) e.Error {
+	log.Info().Msg("consuming email change revert token")
+	t, err := s.tr.ConsumeEmailChangeRevertToken(ctx, token)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("email change revert token not found")
+		return e.NewUnauthorizedError("invalid or expired link")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to consume email change revert token")
+		return e.NewInternalServerError()
+	}
+
+	log.Info().Msg("getting user from database")
+	u, err := s.ur.GetUserByID(ctx, t.UserID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user not found")
+		return e.NewUnauthorizedError("invalid or expired link")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return e.NewInternalServerError()
+	}
+	if u.DeletedAt.Valid {
+		log.Error().Msg("user has been deleted")
+		return e.NewUnauthorizedError("invalid or expired link")
+	}
+
+	log.Info().Msg("checking if the old email is still available")
+	other, err := s.ur.GetUserByEmail(ctx, t.OldEmail)
+	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return e.NewInternalServerError()
+	}
+	if err == nil && other.ID != u.ID {
+		log.Error().Msg("old email has been registered by another user")
+		return e.NewConflictError("email is already registered")
+	}
+
+	log.Info().Msg("reverting user email")
+	_, err = s.ur.UpdateEmailByID(ctx, u.ID, t.OldEmail)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to revert user email")
+		return e.NewInternalServerError()
+	}
+
+	// a change still waiting to be verified was most likely made by whoever
+	// took the account over
+	log.Info().Msg("deleting pending email change token")
+	err = s.tr.DeleteEmailChangeToken(ctx, t.UserID)
+	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+		log.Error().Err(err).Msg("failed to delete pending email change token")
+		return e.NewInternalServerError()
+	}
+
+	err = endAllSessions(ctx, s.sr, t.UserID)
+	if err != nil {
+		return e.NewInternalServerError()
+	}
+
+	err = deletePersonalAccessTokens(ctx, s.patr, t.UserID)
+	if err != nil {
+		return e.NewInternalServerError()
+	}
+
+	seclog.Event(ctx, "email_change_reverted").
+		Str("user_id", t.UserID).
+		Msg("email change reverted from the old address, all sessions ended")
+	recordAudit(ctx, s.aer, repository.AuditEmailChangeReverted, t.UserID, map[string]interface{}{
+		"old_email": u.Email,
+		"new_email": t.OldEmail,
+	})
+
 	return nil
 }
 
@@ -234,15 +455,19 @@ func (s *BaseUserService) ChangePassword(
 		return e.NewInternalServerError()
 	}
 
-	log.Info().Msg("checking current password")
-	err = security.CheckPassword(currentPassword, u.Password)
-	if err != nil {
-		log.Error().Err(err).Msg("wrong password")
-		return e.NewUnauthorizedError("wrong password")
+	// users who signed up through a provider have no password, they set their
+	// first one without a current one
+	if u.Password != "" {
+		log.Info().Msg("checking current password")
+		err = security.CheckPassword(currentPassword, u.Password)
+		if err != nil {
+			log.Error().Err(err).Msg("wrong password")
+			return e.NewUnauthorizedError("wrong password")
+		}
 	}
 
 	log.Info().Msg("retrieving last 3 passwords")
-	hashes, err := s.phr.GetLastNPasswordHashes(ctx, userID, 3)
+	hashes, err := s.phr.GetLastNPasswordHashes(ctx, userID, s.cfg.Password.History)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to retrieve last 3 passwords")
 		return e.NewInternalServerError()
@@ -283,8 +508,23 @@ func (s *BaseUserService) ChangePassword(
 		return e.NewInternalServerError()
 	}
 
-	// TODO: not in the requirement, but it'll be nice to invalidate all other
-	// sessions after changing the password
+	err = applySessionPolicy(
+		ctx,
+		s.sr,
+		s.patr,
+		userID,
+		s.policies.OnPasswordChange,
+		s.clock.Now(),
+		s.cfg.Tokens.RefreshToken,
+	)
+	if err != nil {
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditPasswordChanged, userID, map[string]interface{}{
+		"sessions": s.policies.OnPasswordChange,
+	})
+	s.hook.OnEvent(ctx, newEvent(ctx, EventPasswordChanged, u, s.clock.Now()))
 
 	return nil
 }
@@ -345,6 +585,10 @@ func (s *BaseUserService) SetProfilePicture(
 		return e.NewInternalServerError()
 	}
 
+	recordAudit(ctx, s.aer, repository.AuditProfileUpdated, userID, map[string]interface{}{
+		"field": "picture",
+	})
+
 	return nil
 }
 
@@ -369,6 +613,9 @@ func (s *BaseUserService) DeleteProfilePicture(
 		log.Error().Err(err).Msg("failed to delete picture path from database")
 		return e.NewInternalServerError()
 	}
+	recordAudit(ctx, s.aer, repository.AuditProfileUpdated, userID, map[string]interface{}{
+		"field": "picture",
+	})
 
 	log.Info().Msg("deleting picture from storage")
 	err = os.Remove(ub.Picture)
@@ -396,11 +643,14 @@ func (s *BaseUserService) DeleteAccount(
 		return e.NewInternalServerError()
 	}
 
-	log.Info().Msg("checking password")
-	err = security.CheckPassword(password, u.Password)
-	if err != nil {
-		log.Error().Err(err).Msg("password is wrong")
-		return e.NewBadRequestError("wrong password")
+	// users without a password have nothing to confirm with but their session
+	if u.Password != "" {
+		log.Info().Msg("checking password")
+		err = security.CheckPassword(password, u.Password)
+		if err != nil {
+			log.Error().Err(err).Msg("password is wrong")
+			return e.NewBadRequestError("wrong password")
+		}
 	}
 
 	log.Info().Msg("deleting user from database")
@@ -410,51 +660,339 @@ func (s *BaseUserService) DeleteAccount(
 		return e.NewInternalServerError()
 	}
 
-	log.Info().Msg("getting all sessions")
-	sessions, err := s.sr.GetAllSessions(ctx, userID)
+	err = endAllSessions(ctx, s.sr, userID)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to get all sessions")
 		return e.NewInternalServerError()
 	}
 
-	log.Info().Msg("deleting all sessions")
-	for _, session := range sessions {
-		log.Info().Msg("removing session from redis")
-		err := s.sr.DeleteSession(ctx, session)
-		if err != nil {
-			log.Error().Err(err).Msg("failed to remove session from redis")
-			return e.NewInternalServerError()
-		}
+	log.Info().Msg("deleting passkeys")
+	err = s.pkr.DeletePasskeysByUserID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete passkeys")
+		return e.NewInternalServerError()
+	}
 
-		accessToken := &repository.AccessToken{
-			UserID:    session.UserID,
-			SessionID: session.ID,
-		}
-		log.Info().Msg("revoking access token")
-		err = s.sr.DeleteAccessToken(ctx, accessToken)
-		if err != nil {
-			log.Error().Err(err).Msg("failed to revoke access token")
-			return e.NewInternalServerError()
-		}
+	log.Info().Msg("unlinking identities")
+	err = s.uir.DeleteUserIdentitiesByUserID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to unlink identities")
+		return e.NewInternalServerError()
+	}
 
-		refreshToken := &repository.RefreshToken{
-			UserID:    session.UserID,
-			SessionID: session.ID,
-		}
-		log.Info().Msg("revoking refresh token")
-		err = s.sr.DeleteRefreshToken(ctx, refreshToken)
+	recordAudit(ctx, s.aer, repository.AuditAccountDeleted, userID, nil)
+	s.hook.OnEvent(ctx, newEvent(ctx, EventAccountDeleted, u, s.clock.Now()))
+
+	return nil
+}
+
+func (s *BaseUserService) GetTFAStatus(
+	ctx context.Context,
+	userID string,
+) (bool, e.Error) {
+	log.Info().Msg("getting tfa from database")
+	tfa, err := s.tfar.GetTFAByUserID(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		return false, nil
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get tfa from database")
+		return false, e.NewInternalServerError()
+	}
+
+	return tfa.IsEnabled, nil
+}
+
+// SetupTFA generates a new secret and returns it along with its provisioning
+// URI. TFA only gets enabled after the first code is confirmed with EnableTFA.
+func (s *BaseUserService) SetupTFA(
+	ctx context.Context,
+	userID string,
+) (string, string, e.Error) {
+	log.Info().Msg("getting user from database")
+	u, err := s.ur.GetUserByID(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user not found")
+		return "", "", e.NewNotFoundError("user not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return "", "", e.NewInternalServerError()
+	}
+
+	log.Info().Msg("checking if tfa is already enabled")
+	tfa, err := s.tfar.GetTFAByUserID(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); !ok && err != nil {
+		log.Error().Err(err).Msg("failed to get tfa from database")
+		return "", "", e.NewInternalServerError()
+	}
+	if err == nil && tfa.IsEnabled {
+		log.Error().Msg("tfa is already enabled")
+		return "", "", e.NewConflictError("tfa is already enabled")
+	}
+
+	log.Info().Msg("generating tfa secret")
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to generate tfa secret")
+		return "", "", e.NewInternalServerError()
+	}
+
+	log.Info().Msg("encrypting tfa secret")
+	encrypted, err := s.enc.Encrypt(secret)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to encrypt tfa secret")
+		return "", "", e.NewInternalServerError()
+	}
+
+	log.Info().Msg("storing pending tfa in database")
+	_, err = s.tfar.UpsertTFA(ctx, &repository.UserTFA{
+		UserID:    userID,
+		Secret:    encrypted,
+		IsEnabled: false,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store pending tfa")
+		return "", "", e.NewInternalServerError()
+	}
+
+	return secret, totp.ProvisioningURI(tfaIssuer, u.Email, secret), nil
+}
+
+func (s *BaseUserService) EnableTFA(
+	ctx context.Context,
+	userID string,
+	code string,
+) ([]string, e.Error) {
+	log.Info().Msg("getting tfa from database")
+	tfa, err := s.tfar.GetTFAByUserID(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("tfa has not been set up")
+		return nil, e.NewBadRequestError("tfa has not been set up")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get tfa from database")
+		return nil, e.NewInternalServerError()
+	}
+
+	if tfa.IsEnabled {
+		log.Error().Msg("tfa is already enabled")
+		return nil, e.NewConflictError("tfa is already enabled")
+	}
+
+	log.Info().Msg("decrypting tfa secret")
+	secret, err := s.enc.Decrypt(tfa.Secret)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to decrypt tfa secret")
+		return nil, e.NewInternalServerError()
+	}
+
+	log.Info().Msg("checking tfa code")
+	ok, err := useTFACode(ctx, s.tfar, tfa, secret, code, s.clock.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("failed to use tfa code")
+		return nil, e.NewInternalServerError()
+	}
+	if !ok {
+		log.Error().Msg("tfa code is incorrect")
+		return nil, e.NewBadRequestError("invalid tfa code")
+	}
+
+	log.Info().Msg("enabling tfa")
+	_, err = s.tfar.UpdateTFAStatusByUserID(ctx, userID, true)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to enable tfa")
+		return nil, e.NewInternalServerError()
+	}
+
+	codes, err := s.generateRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditTFAEnabled, userID, nil)
+
+	return codes, nil
+}
+
+func (s *BaseUserService) DisableTFA(
+	ctx context.Context,
+	userID string,
+	password string,
+	code string,
+) e.Error {
+	log.Info().Msg("getting user from database")
+	u, err := s.ur.GetUserByID(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user not found")
+		return e.NewNotFoundError("user not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return e.NewInternalServerError()
+	}
+
+	confirmErr := s.confirmTFAChange(ctx, u, password, code)
+	if confirmErr != nil {
+		return confirmErr
+	}
+
+	log.Info().Msg("deleting tfa from database")
+	err = s.tfar.DeleteTFAByUserID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete tfa from database")
+		return e.NewInternalServerError()
+	}
+
+	log.Info().Msg("deleting recovery codes from database")
+	err = s.rcr.DeleteRecoveryCodesByUserID(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete recovery codes from database")
+		return e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditTFADisabled, userID, nil)
+
+	return nil
+}
+
+func (s *BaseUserService) GetRecoveryCodesCount(
+	ctx context.Context,
+	userID string,
+) (int, e.Error) {
+	log.Info().Msg("getting unused recovery codes from database")
+	codes, err := s.rcr.GetUnusedRecoveryCodes(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get unused recovery codes from database")
+		return 0, e.NewInternalServerError()
+	}
+
+	return len(codes), nil
+}
+
+func (s *BaseUserService) RegenerateRecoveryCodes(
+	ctx context.Context,
+	userID string,
+	password string,
+	code string,
+) ([]string, e.Error) {
+	log.Info().Msg("getting user from database")
+	u, err := s.ur.GetUserByID(ctx, userID)
+	if _, ok := err.(repository.NotFoundError); ok {
+		log.Error().Err(err).Msg("user not found")
+		return nil, e.NewNotFoundError("user not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get user from database")
+		return nil, e.NewInternalServerError()
+	}
+
+	confirmErr := s.confirmTFAChange(ctx, u, password, code)
+	if confirmErr != nil {
+		return nil, confirmErr
+	}
+
+	log.Info().Msg("checking if tfa is enabled")
+	enabled, tfaErr := s.GetTFAStatus(ctx, userID)
+	if tfaErr != nil {
+		return nil, tfaErr
+	}
+	if !enabled {
+		log.Error().Msg("tfa is not enabled")
+		return nil, e.NewBadRequestError("tfa is not enabled")
+	}
+
+	codes, err := s.generateRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, e.NewInternalServerError()
+	}
+
+	recordAudit(ctx, s.aer, repository.AuditRecoveryCodesRenewed, userID, nil)
+
+	return codes, nil
+}
+
+// confirmTFAChange checks the password of the user before their tfa is changed.
+// users who signed up through a provider have no password, they confirm with a
+// current tfa code instead.
+func (s *BaseUserService) confirmTFAChange(
+	ctx context.Context,
+	u *repository.User,
+	password string,
+	code string,
+) e.Error {
+	if u.Password != "" {
+		log.Info().Msg("checking password")
+		err := security.CheckPassword(password, u.Password)
 		if err != nil {
-			log.Error().Err(err).Msg("failed to revoke refresh token")
-			return e.NewInternalServerError()
+			log.Error().Err(err).Msg("wrong password")
+			return e.NewUnauthorizedError("wrong password")
 		}
+		return nil
+	}
+
+	if code == "" {
+		log.Error().Msg("no tfa code for a user without a password")
+		return e.NewBadRequestError("tfa code is required")
+	}
 
-		log.Info().Msg("removing session id from index")
-		err = s.sr.RemoveUserSessionFromIndex(ctx, session.UserID, session.ID)
+	log.Info().Msg("getting tfa from database")
+	tfa, err := s.tfar.GetTFAByUserID(ctx, u.ID)
+	if _, ok := err.(repository.NotFoundError); ok || (err == nil && !tfa.IsEnabled) {
+		log.Error().Msg("tfa is not enabled")
+		return e.NewBadRequestError("tfa is not enabled")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get tfa from database")
+		return e.NewInternalServerError()
+	}
+
+	log.Info().Msg("decrypting tfa secret")
+	secret, err := s.enc.Decrypt(tfa.Secret)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to decrypt tfa secret")
+		return e.NewInternalServerError()
+	}
+
+	log.Info().Msg("checking tfa code")
+	ok, err := useTFACode(ctx, s.tfar, tfa, secret, code, s.clock.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("failed to use tfa code")
+		return e.NewInternalServerError()
+	}
+	if !ok {
+		log.Error().Msg("tfa code is incorrect")
+		return e.NewUnauthorizedError("invalid tfa code")
+	}
+
+	return nil
+}
+
+// generateRecoveryCodes replaces every recovery code of the user with a new
+// batch and returns them in plain text
+func (s *BaseUserService) generateRecoveryCodes(
+	ctx context.Context,
+	userID string,
+) ([]string, error) {
+	log.Info().Msg("generating recovery codes")
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i] = security.GenerateRecoveryCode()
+
+		hash, err := security.HashPassword(codes[i])
 		if err != nil {
-			log.Error().Err(err).Msg("failed to remove session id from index")
-			return e.NewInternalServerError()
+			log.Error().Err(err).Msg("failed to hash recovery code")
+			return nil, err
 		}
+		hashes[i] = hash
 	}
 
-	return nil
+	log.Info().Msg("storing recovery codes in database")
+	err := s.rcr.ReplaceRecoveryCodes(ctx, userID, hashes)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store recovery codes")
+		return nil, err
+	}
+
+	return codes, nil
 }
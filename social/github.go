@@ -0,0 +1,97 @@
+package social
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+const (
+	githubAuthorizationEndpoint = "https://github.com/login/oauth/authorize"
+	githubTokenEndpoint         = "https://github.com/login/oauth/access_token"
+	githubAPIURL                = "https://api.github.com"
+)
+
+var githubScopes = []string{"read:user", "user:email"}
+
+type githubUser struct {
+	ID    int64  `json:"id"`
+	Login string `json:"login"`
+	Name  string `json:"name"`
+}
+
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// GitHubProvider signs users in with GitHub, which speaks plain OAuth 2.0
+// rather than OIDC
+type GitHubProvider struct {
+	cfg Config
+}
+
+func NewGitHubProvider(cfg Config) *GitHubProvider {
+	return &GitHubProvider{
+		cfg: cfg,
+	}
+}
+
+func (p *GitHubProvider) Name() string {
+	return "github"
+}
+
+func (p *GitHubProvider) AuthCodeURL(
+	ctx context.Context,
+	state string,
+	nonce string,
+	codeVerifier string,
+) (string, error) {
+	return authCodeURL(githubAuthorizationEndpoint, p.cfg, githubScopes, state, codeVerifier, nil)
+}
+
+func (p *GitHubProvider) Exchange(
+	ctx context.Context,
+	code string,
+	nonce string,
+	codeVerifier string,
+) (*Identity, error) {
+	t, err := exchangeCode(ctx, githubTokenEndpoint, p.cfg, code, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &githubUser{}
+	err = getJSON(ctx, p.cfg.httpClient(), githubAPIURL+"/user", t.AccessToken, u)
+	if err != nil {
+		return nil, err
+	}
+	if u.ID == 0 {
+		return nil, errors.New("github returned no user id")
+	}
+
+	// the email on the profile is the public one, which may be unverified or
+	// missing altogether
+	emails := []*githubEmail{}
+	err = getJSON(ctx, p.cfg.httpClient(), githubAPIURL+"/user/emails", t.AccessToken, &emails)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Subject: strconv.FormatInt(u.ID, 10),
+		Name:    u.Name,
+	}
+	if identity.Name == "" {
+		identity.Name = u.Login
+	}
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = email.Email
+			identity.EmailVerified = email.Verified
+		}
+	}
+
+	return identity, nil
+}
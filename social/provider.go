@@ -0,0 +1,40 @@
+package social
+
+import (
+	"context"
+	"net/http"
+)
+
+// Identity is a user as seen by an external provider
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is an external OAuth 2.0 / OIDC identity provider users can sign in
+// with
+type Provider interface {
+	Name() string
+	// AuthCodeURL returns the URL of the provider's consent page
+	AuthCodeURL(ctx context.Context, state string, nonce string, codeVerifier string) (string, error)
+	// Exchange trades the code the provider redirected back with for the
+	// identity of the user
+	Exchange(ctx context.Context, code string, nonce string, codeVerifier string) (*Identity, error)
+}
+
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+func (c Config) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
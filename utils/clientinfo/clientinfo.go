@@ -0,0 +1,41 @@
+package clientinfo
+
+import "context"
+
+// ClientInfo describes where a request came from. it is attached to the
+// request context by middleware.ClientInfo so services can read it without
+// every method having to take it as a parameter.
+type ClientInfo struct {
+	IP             string
+	UserAgent      string
+	AcceptLanguage string
+	// UserID and SessionID are only set once the request's token has been
+	// validated
+	UserID    string
+	SessionID string
+}
+
+type ctxKey struct{}
+
+func NewContext(ctx context.Context, ci *ClientInfo) context.Context {
+	return context.WithValue(ctx, ctxKey{}, ci)
+}
+
+// FromContext never returns nil, an empty ClientInfo is returned when the
+// context doesn't carry one
+func FromContext(ctx context.Context) *ClientInfo {
+	ci, ok := ctx.Value(ctxKey{}).(*ClientInfo)
+	if !ok {
+		return &ClientInfo{}
+	}
+	return ci
+}
+
+// WithSession returns a copy of the context whose ClientInfo carries the
+// session the request was made with
+func WithSession(ctx context.Context, userID string, sessionID string) context.Context {
+	ci := *FromContext(ctx)
+	ci.UserID = userID
+	ci.SessionID = sessionID
+	return NewContext(ctx, &ci)
+}
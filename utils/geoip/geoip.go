@@ -0,0 +1,70 @@
+package geoip
+
+import (
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/rs/zerolog/log"
+)
+
+// Location is only a hint, an offline database is never exact and knows
+// nothing about VPNs
+type Location struct {
+	City        string
+	Country     string
+	CountryCode string
+}
+
+// Locator looks IPs up. nil is returned when the location is unknown.
+type Locator interface {
+	Locate(ip string) *Location
+}
+
+// NopLocator is used when no GeoIP database is configured
+type NopLocator struct{}
+
+func NewNopLocator() NopLocator {
+	return NopLocator{}
+}
+
+func (NopLocator) Locate(string) *Location {
+	return nil
+}
+
+// MaxMindLocator reads a MaxMind City database, like the free GeoLite2 one
+type MaxMindLocator struct {
+	db *geoip2.Reader
+}
+
+func NewMaxMindLocator(path string) (*MaxMindLocator, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MaxMindLocator{
+		db: db,
+	}, nil
+}
+
+func (l *MaxMindLocator) Locate(ip string) *Location {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil
+	}
+
+	city, err := l.db.City(parsed)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to look ip up in the geoip database")
+		return nil
+	}
+	if city.Country.IsoCode == "" {
+		return nil
+	}
+
+	return &Location{
+		City:        city.City.Names["en"],
+		Country:     city.Country.Names["en"],
+		CountryCode: city.Country.IsoCode,
+	}
+}
@@ -0,0 +1,50 @@
+package useragent
+
+import (
+	"strings"
+
+	"github.com/mssola/useragent"
+)
+
+// kinds of Device
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceBot     = "bot"
+)
+
+// UserAgent is the part of a User-Agent header worth showing to a user
+// looking at their sessions
+type UserAgent struct {
+	Browser string
+	OS      string
+	Device  string
+}
+
+// Parse never fails, whatever can't be made out of ua is left empty
+func Parse(ua string) *UserAgent {
+	if ua == "" {
+		return &UserAgent{}
+	}
+
+	p := useragent.New(ua)
+
+	device := DeviceDesktop
+	if p.Bot() {
+		device = DeviceBot
+	} else if p.Mobile() {
+		device = DeviceMobile
+	}
+
+	name, version := p.Browser()
+	browser := strings.TrimSpace(name + " " + version)
+
+	osInfo := p.OSInfo()
+	osName := strings.TrimSpace(osInfo.Name + " " + osInfo.Version)
+
+	return &UserAgent{
+		Browser: browser,
+		OS:      osName,
+		Device:  device,
+	}
+}